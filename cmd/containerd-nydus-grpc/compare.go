@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/internal/flags"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+)
+
+func compareChunksCommand(args *flags.Args) *cli.Command {
+	return &cli.Command{
+		Name:      "compare-chunks",
+		Usage:     "report chunks shared by two nydus images and transfer they save, in JSON",
+		ArgsUsage: "<base bootstrap or image> <target bootstrap or image>",
+		Description: "Images are given by bootstrap files, or by references whose bootstraps are pulled " +
+			"from registry with credentials and TLS settings of the configuration given by global options.",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return cli.Exit("expect bootstraps or references of the base image and the target image", 1)
+			}
+
+			workDir, err := os.MkdirTemp("", "nydus-compare-chunks-")
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			defer os.RemoveAll(workDir)
+
+			var bootstraps []string
+			configLoaded := false
+			for i, arg := range c.Args().Slice() {
+				if _, err := os.Stat(arg); err == nil {
+					bootstraps = append(bootstraps, arg)
+					continue
+				}
+				if !configLoaded {
+					if _, err := loadConfig(args); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					configLoaded = true
+				}
+				bootstrap := filepath.Join(workDir, []string{"base.boot", "target.boot"}[i])
+				if err := fetchBootstrap(c.Context, arg, bootstrap); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				bootstraps = append(bootstraps, bootstrap)
+			}
+
+			report, err := converter.CompareChunks(bootstraps[0], bootstraps[1])
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		},
+	}
+}
+
+func fetchBootstrap(ctx context.Context, ref, target string) error {
+	keyChain, err := auth.GetKeyChainByRef(ref, nil)
+	if err != nil {
+		return errors.Wrapf(err, "get credentials of image %s", ref)
+	}
+	r := remote.New(keyChain, config.GetSkipSSLVerify())
+	if err := r.FetchBootstrap(ctx, ref, target); err != nil {
+		return errors.Wrapf(err, "fetch bootstrap of image %s", ref)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/internal/flags"
+	"github.com/containerd/nydus-snapshotter/pkg/doctor"
+)
+
+func doctorCommand(args *flags.Args) *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "diagnose the environment for the configuration given by global options, exiting with 1 if any check fails",
+		Action: func(c *cli.Context) error {
+			cfg, cfgErr := loadConfig(args)
+			if cfgErr != nil {
+				// Go on with defaults to diagnose the rest of the environment.
+				cfg = &config.SnapshotterConfig{}
+				if err := cfg.FillUpWithDefaults(); err != nil {
+					return err
+				}
+				_ = config.ProcessConfigurations(cfg)
+			}
+
+			results := doctor.Diagnose(c.Context, cfg, cfgErr)
+			doctor.Print(os.Stdout, results)
+			if doctor.Failed(results) {
+				return cli.Exit("", 1)
+			}
+			return nil
+		},
+	}
+}
@@ -30,6 +30,7 @@ func main() {
 		Version:     version.Version,
 		Flags:       flags.F,
 		HideVersion: true,
+		Commands:    []*cli.Command{doctorCommand(flags.Args), compareChunksCommand(flags.Args)},
 		Action: func(_ *cli.Context) error {
 			if flags.Args.PrintVersion {
 				fmt.Println("Version:    ", version.Version)
@@ -39,44 +40,12 @@ func main() {
 				return nil
 			}
 
-			snapshotterConfigPath := flags.Args.SnapshotterConfigPath
-			var defaultSnapshotterConfig config.SnapshotterConfig
-			var snapshotterConfig config.SnapshotterConfig
-
-			if err := defaultSnapshotterConfig.FillUpWithDefaults(); err != nil {
-				return errors.New("failed to generate nydus default configuration")
-			}
-
-			// Once snapshotter's configuration file is provided, parse it and let command line parameters override it.
-			if snapshotterConfigPath != "" {
-				if c, err := config.LoadSnapshotterConfig(snapshotterConfigPath); err == nil {
-					// Command line parameters override the snapshotter's configurations for backwards compatibility
-					if err := config.ParseParameters(flags.Args, c); err != nil {
-						return errors.Wrap(err, "failed to parse commandline options")
-					}
-					snapshotterConfig = *c
-				} else {
-					return errors.Wrapf(err, "failed to load snapshotter configuration from %q", snapshotterConfigPath)
-				}
-			} else {
-				if err := config.ParseParameters(flags.Args, &snapshotterConfig); err != nil {
-					return errors.Wrap(err, "failed to parse commandline options")
-				}
-			}
-
-			if err := config.MergeConfig(&snapshotterConfig, &defaultSnapshotterConfig); err != nil {
-				return errors.Wrap(err, "failed to merge configurations")
-			}
-
-			if err := config.ValidateConfig(&snapshotterConfig); err != nil {
-				return errors.Wrapf(err, "failed to validate configurations")
+			snapshotterConfig, err := loadConfig(flags.Args)
+			if err != nil {
+				return err
 			}
 
-			if err := config.ProcessConfigurations(&snapshotterConfig); err != nil {
-				return errors.Wrap(err, "failed to process configurations")
-			}
-
-			if err := config.SetUpEnvironment(&snapshotterConfig); err != nil {
+			if err := config.SetUpEnvironment(snapshotterConfig); err != nil {
 				return errors.Wrap(err, "failed to setup environment")
 			}
 
@@ -97,7 +66,7 @@ func main() {
 			log.L.Infof("Start nydus-snapshotter. Version: %s, PID: %d, FsDriver: %s, DaemonMode: %s",
 				version.Version, os.Getpid(), config.GetFsDriver(), snapshotterConfig.DaemonMode)
 
-			return Start(ctx, &snapshotterConfig)
+			return Start(ctx, snapshotterConfig)
 		},
 	}
 	if err := app.Run(os.Args); err != nil {
@@ -108,3 +77,46 @@ func main() {
 		}
 	}
 }
+
+// Loads the snapshotter configuration from the configuration file and command line
+// parameters, filled up with defaults.
+func loadConfig(args *flags.Args) (*config.SnapshotterConfig, error) {
+	snapshotterConfigPath := args.SnapshotterConfigPath
+	var defaultSnapshotterConfig config.SnapshotterConfig
+	var snapshotterConfig config.SnapshotterConfig
+
+	if err := defaultSnapshotterConfig.FillUpWithDefaults(); err != nil {
+		return nil, errors.New("failed to generate nydus default configuration")
+	}
+
+	// Once snapshotter's configuration file is provided, parse it and let command line parameters override it.
+	if snapshotterConfigPath != "" {
+		if c, err := config.LoadSnapshotterConfig(snapshotterConfigPath); err == nil {
+			// Command line parameters override the snapshotter's configurations for backwards compatibility
+			if err := config.ParseParameters(args, c); err != nil {
+				return nil, errors.Wrap(err, "failed to parse commandline options")
+			}
+			snapshotterConfig = *c
+		} else {
+			return nil, errors.Wrapf(err, "failed to load snapshotter configuration from %q", snapshotterConfigPath)
+		}
+	} else {
+		if err := config.ParseParameters(args, &snapshotterConfig); err != nil {
+			return nil, errors.Wrap(err, "failed to parse commandline options")
+		}
+	}
+
+	if err := config.MergeConfig(&snapshotterConfig, &defaultSnapshotterConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to merge configurations")
+	}
+
+	if err := config.ValidateConfig(&snapshotterConfig); err != nil {
+		return nil, errors.Wrapf(err, "failed to validate configurations")
+	}
+
+	if err := config.ProcessConfigurations(&snapshotterConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to process configurations")
+	}
+
+	return &snapshotterConfig, nil
+}
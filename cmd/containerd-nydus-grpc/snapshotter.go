@@ -17,10 +17,15 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/differ"
+	"github.com/containerd/nydus-snapshotter/pkg/health"
+	"github.com/containerd/nydus-snapshotter/pkg/tracing"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/signals"
 	"github.com/containerd/nydus-snapshotter/snapshot"
 
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
 	api "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/v2/contrib/diffservice"
 	"github.com/containerd/containerd/v2/contrib/snapshotservice"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/log"
@@ -30,6 +35,19 @@ import (
 func Start(ctx context.Context, cfg *config.SnapshotterConfig) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+
+	if endpoint := cfg.TracingConfig.OTLPEndpoint; endpoint != "" {
+		provider, err := tracing.Register(tracing.ExporterOpt{Endpoint: endpoint, ServiceName: cfg.TracingConfig.ServiceName})
+		if err != nil {
+			return errors.Wrap(err, "register trace exporter")
+		}
+		defer func() {
+			if err := provider.Shutdown(context.Background()); err != nil {
+				log.L.WithError(err).Warn("failed to export remaining traces")
+			}
+		}()
+	}
+
 	rs, err := snapshot.NewSnapshotter(ctx, cfg)
 	if err != nil {
 		return errors.Wrap(err, "failed to initialize snapshotter")
@@ -42,6 +60,18 @@ func Start(ctx context.Context, cfg *config.SnapshotterConfig) error {
 		ImageServiceAddress: cfg.RemoteConfig.AuthConfig.ImageServiceAddress,
 	}
 
+	if cfg.Experimental.DiffService.EnableDiffService {
+		store, err := differ.NewContentStore(cfg.Experimental.DiffService.ContainerdAddress)
+		if err != nil {
+			return errors.Wrap(err, "connect to containerd content store")
+		}
+		comparer, err := differ.NewComparer(store, cfg.DaemonConfig.NydusImagePath, filepath.Join(config.GetWorkDir(), "differ"))
+		if err != nil {
+			return errors.Wrap(err, "create nydus differ")
+		}
+		opt.DiffServer = diffservice.FromApplierAndComparer(nil, comparer)
+	}
+
 	if cfg.RemoteConfig.AuthConfig.EnableKubeconfigKeychain {
 		if err := auth.InitKubeSecretListener(ctx, cfg.RemoteConfig.AuthConfig.KubeconfigPath); err != nil {
 			return err
@@ -55,6 +85,7 @@ type ServeOptions struct {
 	ListeningSocketPath string
 	EnableCRIKeychain   bool
 	ImageServiceAddress string
+	DiffServer          diffapi.DiffServer
 }
 
 func Serve(ctx context.Context, sn snapshots.Snapshotter, options ServeOptions, stop <-chan struct{}) error {
@@ -62,11 +93,15 @@ func Serve(ctx context.Context, sn snapshots.Snapshotter, options ServeOptions,
 	if err != nil {
 		return err
 	}
-	rpc := grpc.NewServer()
+	rpc := grpc.NewServer(grpc.UnaryInterceptor(tracing.UnaryServerInterceptor))
 	if rpc == nil {
 		return errors.New("start gRPC server")
 	}
 	api.RegisterSnapshotsServer(rpc, snapshotservice.FromSnapshotter(sn))
+	health.RegisterServer(rpc)
+	if options.DiffServer != nil {
+		diffapi.RegisterDiffServer(rpc, options.DiffServer)
+	}
 	listener, err := net.Listen("unix", options.ListeningSocketPath)
 	if err != nil {
 		return errors.Wrapf(err, "listen socket %q", options.ListeningSocketPath)
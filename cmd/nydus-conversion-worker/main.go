@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/pkg/conversion/worker"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/signals"
+	"github.com/containerd/nydus-snapshotter/version"
+)
+
+func main() {
+	var opt worker.ServerOpt
+	var address, logLevel string
+
+	app := &cli.App{
+		Name:    "nydus-conversion-worker",
+		Usage:   "Convert OCI layers into nydus blobs for remote snapshotters over gRPC",
+		Version: version.Version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "address",
+				Value:       ":9090",
+				Usage:       "TCP address to listen for conversion requests",
+				Destination: &address,
+			},
+			&cli.StringFlag{
+				Name:        "nydus-image",
+				Value:       "nydus-image",
+				Usage:       "path to nydus-image binary",
+				Destination: &opt.NydusImagePath,
+			},
+			&cli.StringFlag{
+				Name:        "work-dir",
+				Value:       "/var/lib/nydus-conversion-worker",
+				Usage:       "directory for temporary files of conversion",
+				Destination: &opt.WorkDir,
+			},
+			&cli.IntFlag{
+				Name:        "max-concurrent-proc",
+				Usage:       "maximum number of layers converted in parallel, 0 means default",
+				Destination: &opt.MaxConcurrentProcess,
+			},
+			&cli.StringFlag{
+				Name:        "tls-cert",
+				Usage:       "certificate to serve TLS with, plaintext if not set",
+				Destination: &opt.TLS.CertFile,
+			},
+			&cli.StringFlag{
+				Name:        "tls-key",
+				Usage:       "private key of the TLS certificate",
+				Destination: &opt.TLS.KeyFile,
+			},
+			&cli.StringFlag{
+				Name:        "tls-ca",
+				Usage:       "CA certificate to verify snapshotters, which must present certificates if set",
+				Destination: &opt.TLS.CAFile,
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Value:       "info",
+				Usage:       "logging level, possible values \"trace\", \"debug\", \"info\", \"warn\", \"error\"",
+				Destination: &logLevel,
+			},
+		},
+		Action: func(_ *cli.Context) error {
+			if err := log.SetLevel(logLevel); err != nil {
+				return errors.Wrapf(err, "set log level %s", logLevel)
+			}
+
+			s, err := worker.NewServer(opt)
+			if err != nil {
+				return err
+			}
+			l, err := net.Listen("tcp", address)
+			if err != nil {
+				return errors.Wrapf(err, "listen on %s", address)
+			}
+
+			go func() {
+				<-signals.SetupSignalHandler()
+				log.L.Info("shutting down, waiting for ongoing conversions")
+				s.Stop()
+			}()
+
+			log.L.Infof("conversion worker listening on %s", address)
+			return s.Serve(l)
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
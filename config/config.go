@@ -9,8 +9,10 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"dario.cat/mergo"
+	"github.com/containerd/log"
 	"github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
 
@@ -108,10 +110,17 @@ const (
 )
 
 type Experimental struct {
-	EnableStargz         bool        `toml:"enable_stargz"`
-	EnableReferrerDetect bool        `toml:"enable_referrer_detect"`
-	TarfsConfig          TarfsConfig `toml:"tarfs"`
-	EnableBackendSource  bool        `toml:"enable_backend_source"`
+	EnableStargz          bool                   `toml:"enable_stargz"`
+	EnableReferrerDetect  bool                   `toml:"enable_referrer_detect"`
+	TarfsConfig           TarfsConfig            `toml:"tarfs"`
+	EnableBackendSource   bool                   `toml:"enable_backend_source"`
+	EnableFaultInjection  bool                   `toml:"enable_fault_injection"`
+	LocalConversionConfig LocalConversionConfig  `toml:"local_conversion"`
+	AdaptivePrefetch      AdaptivePrefetchConfig `toml:"adaptive_prefetch"`
+	DiffService           DiffServiceConfig      `toml:"diff_service"`
+	DedupIndex            DedupIndexConfig       `toml:"dedup_index"`
+	DownloadDetach        DownloadDetachConfig   `toml:"download_detach"`
+	CascadeCleanup        CascadeCleanupConfig   `toml:"cascade_cleanup"`
 }
 
 type TarfsConfig struct {
@@ -122,9 +131,72 @@ type TarfsConfig struct {
 	ExportMode        string `toml:"export_mode"`
 }
 
+type LocalConversionConfig struct {
+	EnableLocalConversion bool `toml:"enable_local_conversion"`
+	MaxConcurrentProc     int  `toml:"max_concurrent_proc"`
+	// Conversion of a single layer is aborted after it, e.g. "10m"
+	JobTimeout string `toml:"job_timeout"`
+	// Layers whose compressed size exceeds it are not converted, e.g. "2Gi"
+	MaxLayerSize string `toml:"max_layer_size"`
+	// Address of remote converter worker, e.g. "converter.svc:9090", empty means converting locally
+	WorkerAddress string `toml:"worker_address"`
+	// TLS of connections to the worker, the CA verifies the worker and the certificate
+	// authenticates the snapshotter, plaintext if none is set
+	WorkerTLSCA   string `toml:"worker_tls_ca"`
+	WorkerTLSCert string `toml:"worker_tls_cert"`
+	WorkerTLSKey  string `toml:"worker_tls_key"`
+	// Keep converted artifacts in content store of the containerd at the address,
+	// empty means keeping them in the snapshotter work directory
+	ContentStoreAddress   string `toml:"content_store_address"`
+	ContentStoreNamespace string `toml:"content_store_namespace"`
+	// Root directory of the containerd content store, where nydusd reads blobs from
+	ContentStoreRoot string `toml:"content_store_root"`
+}
+
+type AdaptivePrefetchConfig struct {
+	EnableAdaptivePrefetch bool `toml:"enable_adaptive_prefetch"`
+	// Images whose blob cache hit ratio is below it prefetch all data eagerly
+	LowHitRatio float64 `toml:"low_hit_ratio"`
+	// Images whose blob cache hit ratio reaches it have prefetch throttled
+	HighHitRatio float64 `toml:"high_hit_ratio"`
+	// Minimum of on-demand requests before an image's hit ratio is trusted
+	MinRequests uint64 `toml:"min_requests"`
+	// Interval to sample blob cache metrics from nydusd, e.g. "1m"
+	SamplePeriod string `toml:"sample_period"`
+}
+
+type DiffServiceConfig struct {
+	EnableDiffService bool `toml:"enable_diff_service"`
+	// Containerd socket whose content store receives the generated nydus layers
+	ContainerdAddress string `toml:"containerd_address"`
+}
+
+type DedupIndexConfig struct {
+	// Address of the cluster chunk dedup index service, empty means disabled
+	Address string `toml:"address"`
+	// Address peers fetch chunks stored by this node from, e.g. its P2P proxy
+	NodeAddress string `toml:"node_address"`
+}
+
+type DownloadDetachConfig struct {
+	Enable bool `toml:"enable"`
+	// Images downloaded in parallel, 0 means 2
+	MaxConcurrent int `toml:"max_concurrent"`
+}
+
+type CascadeCleanupConfig struct {
+	Enable bool `toml:"enable"`
+	// Containerd socket to subscribe to events from, empty means "/run/containerd/containerd.sock"
+	ContainerdAddress string `toml:"containerd_address"`
+	// Name of the snapshotter in containerd configuration, empty means "nydus"
+	Snapshotter string `toml:"snapshotter"`
+}
+
 type CgroupConfig struct {
 	Enable      bool   `toml:"enable"`
 	MemoryLimit string `toml:"memory_limit"`
+	// Size worker pools and nydusd threads by limits of the cgroup snapshotter runs in
+	AutoSizing bool `toml:"auto_sizing"`
 }
 
 // Configure how to start and recover nydusd daemons
@@ -136,6 +208,20 @@ type DaemonConfig struct {
 	FsDriver         string `toml:"fs_driver"`
 	ThreadsNumber    int    `toml:"threads_number"`
 	LogRotationSize  int    `toml:"log_rotation_size"`
+	// Serve images of containers in the same pod by one nydusd in dedicated daemon mode
+	ShareBySandbox bool `toml:"share_by_sandbox"`
+	// FUSE session of fusedev nydusd, overridden per image by labels of the meta layer
+	Fuse FuseSessionConfig `toml:"fuse"`
+}
+
+type FuseSessionConfig struct {
+	// Maximum of pending background requests, 0 means the kernel default
+	MaxBackground int `toml:"max_background"`
+	// Pending background requests beyond it mark the session congested, 0 means the kernel default
+	CongestionThreshold int  `toml:"congestion_threshold"`
+	WritebackCache      bool `toml:"writeback_cache"`
+	// Maximum readahead of the session, e.g. "1Mi", empty means the kernel default
+	ReadaheadSize string `toml:"readahead_size"`
 }
 
 type LoggingConfig struct {
@@ -149,10 +235,31 @@ type LoggingConfig struct {
 	RotateLogCompress   bool   `toml:"log_rotation_compress"`
 }
 
+// Directories where snapshotter writes, each defaults to a location under `root` if empty.
+// Together with `cache_manager.cache_dir` and `log.dir`, they allow running on hosts whose
+// root filesystem is read-only.
+type PathsConfig struct {
+	// Snapshotter's database of nydusd daemons and RAFS instances
+	StateDir string `toml:"state_dir"`
+	// Nydusd API sockets
+	SocketDir string `toml:"socket_dir"`
+	// Nydusd configuration files
+	DaemonConfigDir string `toml:"daemon_config_dir"`
+	// Supervisor sockets holding nydusd states for failover and live-upgrade
+	SupervisorDir string `toml:"supervisor_dir"`
+	// Parent directory of nydusd mountpoints
+	MountpointDir string `toml:"mountpoint_dir"`
+	// Working files, e.g. intermediates of image conversion
+	WorkDir string `toml:"work_dir"`
+}
+
 // Nydus image layers additional process
 type ImageConfig struct {
 	PublicKeyFile     string `toml:"public_key_file"`
 	ValidateSignature bool   `toml:"validate_signature"`
+	// Only images from these registries are served by nydus, others are unpacked
+	// by containerd and mounted as overlayfs. Empty means all registries.
+	ClaimRegistries []string `toml:"claim_registries"`
 }
 
 // Configure containerd snapshots interfaces and how to process the snapshots
@@ -162,6 +269,20 @@ type SnapshotConfig struct {
 	NydusOverlayFSPath   string `toml:"nydus_overlayfs_path"`
 	EnableKataVolume     bool   `toml:"enable_kata_volume"`
 	SyncRemove           bool   `toml:"sync_remove"`
+	// Size limit of each container's writable layer enforced by project quota, e.g. "10Gi",
+	// only supported with the root on XFS or ext4 with project quota enabled.
+	WritableLayerQuota string `toml:"writable_layer_quota"`
+	// Fetch bootstraps from registry on first mount instead of unpacking meta layers on pull,
+	// by the snapshotter since nydusd only mounts local bootstraps.
+	LazyBootstrap bool `toml:"lazy_bootstrap"`
+	// Mount rootfs ID mapped for containers in user namespaces as requested by containerd
+	EnableIDMappedMounts bool `toml:"enable_idmapped_mounts"`
+	// Publish images of containers in labeled sandboxes as kata direct assigned volumes
+	// under the directory, empty disables it.
+	KataDirectVolumeDir string `toml:"kata_direct_volume_dir"`
+	// State directory of containerd holding rootfs of containers, which name their
+	// direct volumes. Empty means "/run/containerd".
+	ContainerdStateDir string `toml:"containerd_state_dir"`
 }
 
 // Configure cache manager that manages the cache files lifecycle
@@ -169,8 +290,45 @@ type CacheManagerConfig struct {
 	Disable bool `toml:"disable"`
 	// Trigger GC gc_period after the specified period.
 	// Example format: 24h, 120min
-	GCPeriod string `toml:"gc_period"`
-	CacheDir string `toml:"cache_dir"`
+	GCPeriod   string                `toml:"gc_period"`
+	CacheDir   string                `toml:"cache_dir"`
+	Encryption CacheEncryptionConfig `toml:"encryption"`
+	Tier       CacheTierConfig       `toml:"tier"`
+	Retention  CacheRetentionConfig  `toml:"retention"`
+	// Keep locally converted blobs in `cache_dir` with reference counts, shared by
+	// local conversion and nydusd, instead of the conversion work directory.
+	SharedBlobStore bool `toml:"shared_blob_store"`
+}
+
+// Keep hot blob caches on fast storage, which is `cache_dir`, and demote cold ones to slow storage.
+// Tiering works per blob cache file, and skips blobs of mounted images.
+type CacheTierConfig struct {
+	Enable bool `toml:"enable"`
+	// Size limit of the fast tier. Acceptable values include "209715200", "200MiB" and "200Mi".
+	FastTierSize string `toml:"fast_tier_size"`
+	SlowTierDir  string `toml:"slow_tier_dir"`
+	// Size limit of the slow tier, coldest blob caches are evicted beyond it. Empty means no limit.
+	SlowTierSize string `toml:"slow_tier_size"`
+	// How often to demote and promote blob caches between tiers, 10m by default.
+	RebalancePeriod string `toml:"rebalance_period"`
+}
+
+// Keep blob caches of important images from eviction by the tiered cache and GC.
+type CacheRetentionConfig struct {
+	// Images whose layer snapshots have any of the labels, as `key=value` or `key`.
+	KeepLabels []string `toml:"keep_labels"`
+	// Always keep the most recently pulled N tags of each repository.
+	KeepLastTags int `toml:"keep_last_tags"`
+}
+
+// Encrypt blob cache files on local disk, nydusd decrypts them on read.
+type CacheEncryptionConfig struct {
+	Enable bool `toml:"enable"`
+	// File containing the per-node encryption key in hex.
+	KeyFile string `toml:"key_file"`
+	// Command printing the encryption key in hex to stdout, e.g. a KMS client.
+	// It takes precedence over `key_file`.
+	KeyCommand string `toml:"key_command"`
 }
 
 // Configure how nydus-snapshotter receive auth information
@@ -189,6 +347,33 @@ type RemoteConfig struct {
 	ConvertVpcRegistry bool          `toml:"convert_vpc_registry"`
 	SkipSSLVerify      bool          `toml:"skip_ssl_verify"`
 	MirrorsConfig      MirrorsConfig `toml:"mirrors_config"`
+	// Copies blobs downloaded to the shared blob store into a nearby registry
+	WriteThroughMirror WriteThroughMirrorConfig `toml:"write_through_mirror"`
+	// Credentials of buckets served by the s3 backend of nydusd, which are also the
+	// only buckets images may choose by annotations
+	S3Buckets []S3BucketConfig `toml:"s3_buckets"`
+}
+
+// Credentials of a bucket of AWS S3 or S3-compatible services like MinIO, Ceph RGW and
+// Tencent COS, filled into nydusd configurations reading images from the bucket.
+type S3BucketConfig struct {
+	Bucket string `toml:"bucket"`
+	// Endpoint of the service, empty matches the bucket at any endpoint
+	Endpoint        string `toml:"endpoint"`
+	Region          string `toml:"region"`
+	AccessKeyID     string `toml:"access_key_id"`
+	AccessKeySecret string `toml:"access_key_secret"`
+	// Address the bucket by path instead of host, unset keeps the nydusd configuration
+	PathStyle *bool `toml:"path_style"`
+	// "v4" or "v2", empty keeps the nydusd configuration
+	SignatureVersion string `toml:"signature_version"`
+}
+
+type WriteThroughMirrorConfig struct {
+	// Registry host blobs are copied into, empty disables write-through mirroring
+	Host          string `toml:"host"`
+	PlainHTTP     bool   `toml:"plain_http"`
+	MaxConcurrent int    `toml:"max_concurrent"`
 }
 
 type MirrorsConfig struct {
@@ -199,15 +384,37 @@ type MetricsConfig struct {
 	Address string `toml:"address"`
 }
 
+// Export spans of snapshot operations to an OpenTelemetry collector.
+type TracingConfig struct {
+	// OTLP/HTTP endpoint of traces, e.g. "http://localhost:4318/v1/traces", empty means disabled.
+	OTLPEndpoint string `toml:"otlp_endpoint"`
+	// Service name of the exported spans, "nydus-snapshotter" by default.
+	ServiceName string `toml:"service_name"`
+}
+
 type DebugConfig struct {
 	ProfileDuration int64  `toml:"daemon_cpu_profile_duration_secs"`
 	PprofAddress    string `toml:"pprof_address"`
 }
 
+type PreloadConfig struct {
+	// Containerd socket to pull images through, empty means "/run/containerd/containerd.sock"
+	ContainerdAddress string `toml:"containerd_address"`
+	// Containerd namespace to pull images into, empty means "k8s.io"
+	Namespace string `toml:"namespace"`
+	// Name of the snapshotter in containerd configuration, empty means "nydus"
+	Snapshotter string `toml:"snapshotter"`
+	// Maximum number of images preloaded in parallel per request, 0 means 2
+	MaxConcurrentImages int `toml:"max_concurrent_images"`
+}
+
 type SystemControllerConfig struct {
-	Enable      bool        `toml:"enable"`
-	Address     string      `toml:"address"`
-	DebugConfig DebugConfig `toml:"debug"`
+	Enable  bool   `toml:"enable"`
+	Address string `toml:"address"`
+	// Unix domain socket serving the system API over gRPC, empty disables it
+	GRPCAddress   string        `toml:"grpc_address"`
+	DebugConfig   DebugConfig   `toml:"debug"`
+	PreloadConfig PreloadConfig `toml:"preload"`
 }
 
 type SnapshotterConfig struct {
@@ -222,6 +429,7 @@ type SnapshotterConfig struct {
 
 	SystemControllerConfig SystemControllerConfig `toml:"system"`
 	MetricsConfig          MetricsConfig          `toml:"metrics"`
+	TracingConfig          TracingConfig          `toml:"tracing"`
 	DaemonConfig           DaemonConfig           `toml:"daemon"`
 	SnapshotsConfig        SnapshotConfig         `toml:"snapshot"`
 	RemoteConfig           RemoteConfig           `toml:"remote"`
@@ -229,6 +437,7 @@ type SnapshotterConfig struct {
 	CacheManagerConfig     CacheManagerConfig     `toml:"cache_manager"`
 	LoggingConfig          LoggingConfig          `toml:"log"`
 	CgroupConfig           CgroupConfig           `toml:"cgroup"`
+	PathsConfig            PathsConfig            `toml:"paths"`
 	Experimental           Experimental           `toml:"experimental"`
 }
 
@@ -289,12 +498,61 @@ func ValidateConfig(c *SnapshotterConfig) error {
 	if c.DaemonConfig.ThreadsNumber > 1024 {
 		return errors.Errorf("nydusd worker thread number %d is too big, max 1024", c.DaemonConfig.ThreadsNumber)
 	}
+	if fc := c.DaemonConfig.Fuse; fc.MaxBackground < 0 || fc.CongestionThreshold < 0 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "negative FUSE max background or congestion threshold")
+	}
+	if size := c.DaemonConfig.Fuse.ReadaheadSize; size != "" {
+		if _, err := parser.MemoryConfigToBytes(size, 0); err != nil {
+			return errors.Wrapf(err, "invalid FUSE readahead size %q", size)
+		}
+	}
 
 	if c.RemoteConfig.AuthConfig.EnableCRIKeychain && c.RemoteConfig.AuthConfig.EnableKubeconfigKeychain {
 		return errors.Wrapf(errdefs.ErrInvalidArgument,
 			"\"enable_cri_keychain\" and \"enable_kubeconfig_keychain\" can't be set at the same time")
 	}
 
+	if c.CacheManagerConfig.Encryption.Enable {
+		if c.CacheManagerConfig.Encryption.KeyFile == "" && c.CacheManagerConfig.Encryption.KeyCommand == "" {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "key file or key command for cache encryption is not provided")
+		}
+		if c.DaemonConfig.FsDriver != FsDriverFusedev {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "cache encryption is only supported by fusedev driver")
+		}
+	}
+
+	if c.CacheManagerConfig.Tier.Enable {
+		if c.CacheManagerConfig.Tier.SlowTierDir == "" || c.CacheManagerConfig.Tier.FastTierSize == "" {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "slow tier directory and fast tier size must be provided for tiered cache")
+		}
+		if c.DaemonConfig.FsDriver != FsDriverFusedev {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "tiered cache is only supported by fusedev driver")
+		}
+	}
+
+	if ap := c.Experimental.AdaptivePrefetch; ap.EnableAdaptivePrefetch {
+		if ap.LowHitRatio < 0 || ap.HighHitRatio > 1 || (ap.HighHitRatio > 0 && ap.LowHitRatio >= ap.HighHitRatio) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid hit ratio thresholds [%v, %v] for adaptive prefetch",
+				ap.LowHitRatio, ap.HighHitRatio)
+		}
+		if c.DaemonConfig.FsDriver != FsDriverFusedev {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "adaptive prefetch is only supported by fusedev driver")
+		}
+		if ap.SamplePeriod != "" {
+			if _, err := time.ParseDuration(ap.SamplePeriod); err != nil {
+				return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid sample period %q for adaptive prefetch", ap.SamplePeriod)
+			}
+		}
+	}
+
+	if lc := c.Experimental.LocalConversionConfig; lc.EnableLocalConversion {
+		if lc.JobTimeout != "" {
+			if _, err := time.ParseDuration(lc.JobTimeout); err != nil {
+				return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid job timeout %q for local conversion", lc.JobTimeout)
+			}
+		}
+	}
+
 	if c.RemoteConfig.MirrorsConfig.Dir != "" {
 		dirExisted, err := file.IsDirExisted(c.RemoteConfig.MirrorsConfig.Dir)
 		if err != nil {
@@ -374,6 +632,16 @@ func ParseCgroupConfig(config CgroupConfig) (cgroup.Config, error) {
 		return cgroup.Config{}, errors.Wrap(err, "Failed  to get total memory bytes")
 	}
 
+	// Percentage of memory is relative to what is available to snapshotter.
+	if config.AutoSizing {
+		limits, err := cgroup.DetectLimits()
+		if err != nil {
+			log.L.WithError(err).Warn("failed to detect cgroup limits of snapshotter")
+		} else if limits.MemoryBytes > 0 && limits.MemoryBytes < int64(totalMemory) {
+			totalMemory = int(limits.MemoryBytes)
+		}
+	}
+
 	memoryLimitInBytes, err := parser.MemoryConfigToBytes(config.MemoryLimit, totalMemory)
 	if err != nil {
 		return cgroup.Config{}, err
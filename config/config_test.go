@@ -72,6 +72,9 @@ func TestLoadSnapshotterTOMLConfig(t *testing.T) {
 			Disable:  false,
 			GCPeriod: "24h",
 			CacheDir: "",
+			Tier: CacheTierConfig{
+				RebalancePeriod: "10m",
+			},
 		},
 		LoggingConfig: LoggingConfig{
 			LogLevel:            "info",
@@ -242,6 +245,10 @@ func TestProcessConfigurations(t *testing.T) {
 
 	A.Equal(snapshotterConfig2.LoggingConfig.LogDir, filepath.Join(snapshotterConfig2.Root, "logs"))
 	A.Equal(snapshotterConfig2.CacheManagerConfig.CacheDir, filepath.Join(snapshotterConfig2.Root, "cache"))
+	A.Equal(GetStateDir(), snapshotterConfig2.Root)
+	A.Equal(GetSocketRoot(), filepath.Join(snapshotterConfig2.Root, "socket"))
+	A.Equal(GetSupervisorDir(), filepath.Join(snapshotterConfig2.Root, "supervisor"))
+	A.Equal(GetRootMountpoint(), filepath.Join(snapshotterConfig2.Root, "mnt"))
 
 	var snapshotterConfig3 SnapshotterConfig
 	snapshotterConfig3.Root = "./snapshotter/root"
@@ -253,4 +260,25 @@ func TestProcessConfigurations(t *testing.T) {
 
 	err = ProcessConfigurations(&snapshotterConfig3)
 	A.NoError(err)
+
+	// Writable paths are individually configurable for read-only root filesystems.
+	var snapshotterConfig4 SnapshotterConfig
+	snapshotterConfig4.Root = "/usr/lib/nydus"
+	snapshotterConfig4.PathsConfig = PathsConfig{
+		StateDir:      "/var/lib/nydus",
+		SocketDir:     "/run/nydus/socket",
+		SupervisorDir: "/run/nydus/supervisor",
+		WorkDir:       "/var/tmp/nydus",
+	}
+
+	err = MergeConfig(&snapshotterConfig4, &defaultSnapshotterConfig)
+	A.NoError(err)
+	err = ProcessConfigurations(&snapshotterConfig4)
+	A.NoError(err)
+
+	A.Equal(GetStateDir(), "/var/lib/nydus")
+	A.Equal(GetSocketRoot(), "/run/nydus/socket")
+	A.Equal(GetSupervisorDir(), "/run/nydus/supervisor")
+	A.Equal(GetWorkDir(), "/var/tmp/nydus")
+	A.Equal(GetConfigRoot(), filepath.Join(snapshotterConfig4.Root, "config"))
 }
@@ -13,10 +13,12 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/containerd/log"
 	"github.com/pkg/errors"
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/registry"
 )
 
@@ -26,6 +28,7 @@ const (
 	backendTypeLocalfs  StorageBackendType = "localfs"
 	backendTypeOss      StorageBackendType = "oss"
 	backendTypeRegistry StorageBackendType = "registry"
+	backendTypeS3       StorageBackendType = "s3"
 )
 
 type DaemonConfig interface {
@@ -82,13 +85,18 @@ type BackendConfig struct {
 	BlobRedirectedHost string         `json:"blob_redirected_host,omitempty"`
 	Mirrors            []MirrorConfig `json:"mirrors,omitempty"`
 
-	// OSS backend configs
+	// Shared by OSS and S3 backend
 	EndPoint        string `json:"endpoint,omitempty"`
 	AccessKeyID     string `json:"access_key_id,omitempty" secret:"true"`
 	AccessKeySecret string `json:"access_key_secret,omitempty" secret:"true"`
 	BucketName      string `json:"bucket_name,omitempty"`
 	ObjectPrefix    string `json:"object_prefix,omitempty"`
 
+	// S3 backend configs
+	Region           string `json:"region,omitempty"`
+	PathStyle        *bool  `json:"path_style,omitempty"`
+	SignatureVersion string `json:"signature_version,omitempty"`
+
 	// Shared by registry and oss backend
 	Scheme     string `json:"scheme,omitempty"`
 	SkipVerify bool   `json:"skip_verify,omitempty"`
@@ -117,6 +125,8 @@ type DeviceConfig struct {
 		Config     struct {
 			WorkDir           string `json:"work_dir"`
 			DisableIndexedMap bool   `json:"disable_indexed_map"`
+			EnableEncryption  bool   `json:"enable_encryption,omitempty"`
+			EncryptionKey     string `json:"encryption_key,omitempty" secret:"true"`
 		} `json:"config"`
 	} `json:"cache"`
 }
@@ -147,7 +157,7 @@ func SupplementDaemonConfig(c DaemonConfig, info SupplementInfoInterface) error
 		return errors.Wrapf(err, "parse image %s", info.GetImageID())
 	}
 
-	backendType, _ := c.StorageBackend()
+	backendType, backendConfig := c.StorageBackend()
 
 	switch backendType {
 	case backendTypeRegistry:
@@ -170,6 +180,9 @@ func SupplementDaemonConfig(c DaemonConfig, info SupplementInfoInterface) error
 		c.Supplement(registryHost, image.Repo, info.GetSnapshotID(), info.GetParams())
 		c.FillAuth(keyChain)
 
+	case backendTypeS3:
+		supplementS3Backend(backendConfig, info.GetLabels())
+
 	// Localfs and OSS backends don't need any update,
 	// just use the provided config in template
 	case backendTypeLocalfs:
@@ -181,6 +194,110 @@ func SupplementDaemonConfig(c DaemonConfig, info SupplementInfoInterface) error
 	return nil
 }
 
+// Images may be in buckets other than the one of the template, whose credentials are
+// configured per bucket in snapshotter's configuration. Images are untrusted, so they
+// only choose buckets configured there, never reading others with node credentials.
+func supplementS3Backend(c *BackendConfig, labels map[string]string) {
+	name := c.BucketName
+	if bucket := labels[label.NydusS3Bucket]; bucket != "" {
+		name = bucket
+	}
+	prefix, hasPrefix := labels[label.NydusS3ObjectPrefix]
+
+	bucket, ok := config.GetS3Bucket(c.EndPoint, name)
+	if !ok {
+		if name != c.BucketName || hasPrefix {
+			log.L.Warnf("ignore s3 bucket %q and object prefix %q of image not in s3_buckets", name, prefix)
+		}
+		return
+	}
+	c.BucketName = name
+	if hasPrefix {
+		c.ObjectPrefix = prefix
+	}
+	if bucket.Region != "" {
+		c.Region = bucket.Region
+	}
+	if bucket.AccessKeyID != "" {
+		c.AccessKeyID = bucket.AccessKeyID
+		c.AccessKeySecret = bucket.AccessKeySecret
+	}
+	if bucket.PathStyle != nil {
+		c.PathStyle = bucket.PathStyle
+	}
+	if bucket.SignatureVersion != "" {
+		c.SignatureVersion = bucket.SignatureVersion
+	}
+}
+
+// UseLocalfsBackend switches the storage backend to localfs, reading blobs from `dir`.
+func UseLocalfsBackend(c DaemonConfig, dir string) error {
+	configRWMutex.Lock()
+	defer configRWMutex.Unlock()
+
+	switch cfg := c.(type) {
+	case *FuseDaemonConfig:
+		cfg.Device.Backend.BackendType = backendTypeLocalfs
+		cfg.Device.Backend.Config = BackendConfig{Dir: dir}
+	case *FscacheDaemonConfig:
+		cfg.Config.BackendType = backendTypeLocalfs
+		cfg.Config.BackendConfig = BackendConfig{Dir: dir}
+	default:
+		return errors.Errorf("unsupported daemon configuration %T", c)
+	}
+
+	return nil
+}
+
+// EnableCacheEncryption lets nydusd encrypt blob cache files with `key`.
+func EnableCacheEncryption(c DaemonConfig, key string) error {
+	configRWMutex.Lock()
+	defer configRWMutex.Unlock()
+
+	cfg, ok := c.(*FuseDaemonConfig)
+	if !ok {
+		return errors.Errorf("cache encryption is not supported by daemon configuration %T", c)
+	}
+	cfg.Device.Cache.Config.EnableEncryption = true
+	cfg.Device.Cache.Config.EncryptionKey = key
+
+	return nil
+}
+
+// TunePrefetch overrides the prefetch policy of nydusd, `threads` is applied only if positive.
+func TunePrefetch(c DaemonConfig, prefetchAll bool, threads int) error {
+	configRWMutex.Lock()
+	defer configRWMutex.Unlock()
+
+	cfg, ok := c.(*FuseDaemonConfig)
+	if !ok {
+		return errors.Errorf("prefetch tuning is not supported by daemon configuration %T", c)
+	}
+	cfg.FSPrefetch.PrefetchAll = prefetchAll
+	if prefetchAll {
+		cfg.FSPrefetch.Enable = true
+	}
+	if threads > 0 {
+		cfg.FSPrefetch.ThreadsCount = threads
+	}
+
+	return nil
+}
+
+// TuneFuseSession overrides the FUSE session parameters of nydusd.
+func TuneFuseSession(c DaemonConfig, s FuseSession) error {
+	configRWMutex.Lock()
+	defer configRWMutex.Unlock()
+
+	cfg, ok := c.(*FuseDaemonConfig)
+	if !ok {
+		return errors.Errorf("FUSE session tuning is not supported by daemon configuration %T", c)
+	}
+	cfg.FuseSession = &s
+
+	return nil
+}
+
 func serializeWithSecretFilter(obj interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
 	value := reflect.ValueOf(obj)
@@ -11,6 +11,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -153,3 +156,102 @@ func TestSerializeWithSecretFilter(t *testing.T) {
 	require.Equal(t, newCfg.Device.Backend.Config.Auth, "")
 	require.NotEqual(t, newCfg.Device.Backend.Config.Auth, cfg.Device.Backend.Config.Auth)
 }
+
+func TestEnableCacheEncryption(t *testing.T) {
+	cfg := FuseDaemonConfig{Device: &DeviceConfig{}}
+	require.NoError(t, EnableCacheEncryption(&cfg, "secret_key"))
+	require.True(t, cfg.Device.Cache.Config.EnableEncryption)
+	require.Equal(t, "secret_key", cfg.Device.Cache.Config.EncryptionKey)
+
+	jsonData, err := json.Marshal(serializeWithSecretFilter(&cfg))
+	require.NoError(t, err)
+	require.Contains(t, string(jsonData), `"enable_encryption":true`)
+	require.NotContains(t, string(jsonData), "secret_key")
+
+	require.Error(t, EnableCacheEncryption(&FscacheDaemonConfig{}, "secret_key"))
+}
+
+func TestTunePrefetch(t *testing.T) {
+	cfg := FuseDaemonConfig{FSPrefetch: FSPrefetch{ThreadsCount: 4}}
+	require.NoError(t, TunePrefetch(&cfg, true, 8))
+	require.Equal(t, FSPrefetch{Enable: true, PrefetchAll: true, ThreadsCount: 8}, cfg.FSPrefetch)
+
+	require.NoError(t, TunePrefetch(&cfg, false, 0))
+	require.Equal(t, FSPrefetch{Enable: true, ThreadsCount: 8}, cfg.FSPrefetch)
+
+	require.Error(t, TunePrefetch(&FscacheDaemonConfig{}, true, 8))
+}
+
+type fakeSupplementInfo struct {
+	labels map[string]string
+}
+
+func (i *fakeSupplementInfo) GetImageID() string           { return "docker.io/library/busybox:latest" }
+func (i *fakeSupplementInfo) GetSnapshotID() string        { return "1" }
+func (i *fakeSupplementInfo) IsVPCRegistry() bool          { return false }
+func (i *fakeSupplementInfo) GetLabels() map[string]string { return i.labels }
+func (i *fakeSupplementInfo) GetParams() map[string]string { return nil }
+
+func TestSupplementS3Backend(t *testing.T) {
+	pathStyle := false
+	snapshotterConfig := config.SnapshotterConfig{Root: t.TempDir(), DaemonMode: "dedicated"}
+	snapshotterConfig.RemoteConfig.S3Buckets = []config.S3BucketConfig{
+		{Bucket: "images", AccessKeyID: "any", AccessKeySecret: "any-secret"},
+		{Bucket: "images", Endpoint: "minio:9000", Region: "cn-north-1", AccessKeyID: "minio", AccessKeySecret: "minio123",
+			PathStyle: &pathStyle, SignatureVersion: "v2"},
+	}
+	require.NoError(t, config.ProcessConfigurations(&snapshotterConfig))
+
+	newConfig := func() *FuseDaemonConfig {
+		cfg := FuseDaemonConfig{Device: &DeviceConfig{}}
+		cfg.Device.Backend.BackendType = backendTypeS3
+		cfg.Device.Backend.Config = BackendConfig{
+			EndPoint:        "minio:9000",
+			BucketName:      "default",
+			ObjectPrefix:    "nydus/",
+			Region:          "us-east-1",
+			AccessKeyID:     "template",
+			AccessKeySecret: "template-secret",
+		}
+		return &cfg
+	}
+
+	cfg := newConfig()
+	require.NoError(t, SupplementDaemonConfig(cfg, &fakeSupplementInfo{}))
+	require.Equal(t, "template", cfg.Device.Backend.Config.AccessKeyID)
+
+	cfg = newConfig()
+	require.NoError(t, SupplementDaemonConfig(cfg, &fakeSupplementInfo{labels: map[string]string{
+		label.NydusS3Bucket:       "images",
+		label.NydusS3ObjectPrefix: "",
+	}}))
+	backend := cfg.Device.Backend.Config
+	require.Equal(t, "images", backend.BucketName)
+	require.Equal(t, "", backend.ObjectPrefix)
+	require.Equal(t, "cn-north-1", backend.Region)
+	require.Equal(t, "minio", backend.AccessKeyID)
+	require.Equal(t, "minio123", backend.AccessKeySecret)
+	require.Equal(t, &pathStyle, backend.PathStyle)
+	require.Equal(t, "v2", backend.SignatureVersion)
+
+	cfg = newConfig()
+	cfg.Device.Backend.Config.EndPoint = "cos.ap-guangzhou.myqcloud.com"
+	require.NoError(t, SupplementDaemonConfig(cfg, &fakeSupplementInfo{labels: map[string]string{label.NydusS3Bucket: "images"}}))
+	backend = cfg.Device.Backend.Config
+	require.Equal(t, "nydus/", backend.ObjectPrefix)
+	require.Equal(t, "us-east-1", backend.Region)
+	require.Equal(t, "any", backend.AccessKeyID)
+	require.Equal(t, "any-secret", backend.AccessKeySecret)
+	require.Nil(t, backend.PathStyle)
+	require.Empty(t, backend.SignatureVersion)
+
+	// Images can't choose buckets not configured, nor prefixes in them.
+	for _, labels := range []map[string]string{
+		{label.NydusS3Bucket: "private", label.NydusS3ObjectPrefix: ""},
+		{label.NydusS3ObjectPrefix: "other/"},
+	} {
+		cfg = newConfig()
+		require.NoError(t, SupplementDaemonConfig(cfg, &fakeSupplementInfo{labels: labels}))
+		require.Equal(t, newConfig().Device.Backend.Config, cfg.Device.Backend.Config)
+	}
+}
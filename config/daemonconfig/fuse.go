@@ -30,6 +30,17 @@ type FuseDaemonConfig struct {
 	FSPrefetch      `json:"fs_prefetch,omitempty"`
 	// (experimental) The nydus daemon could cache more data to increase hit ratio when enabled the warmup feature.
 	Warmup uint64 `json:"warmup,omitempty"`
+	// FUSE session parameters of nydusd
+	FuseSession *FuseSession `json:"fuse,omitempty"`
+}
+
+// Zero values leave the kernel defaults
+type FuseSession struct {
+	MaxBackground       int  `json:"max_background,omitempty"`
+	CongestionThreshold int  `json:"congestion_threshold,omitempty"`
+	WritebackCache      bool `json:"writeback_cache,omitempty"`
+	// In bytes
+	ReadaheadSize int64 `json:"readahead_size,omitempty"`
 }
 
 // Control how to perform prefetch from file system layer
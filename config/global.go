@@ -19,6 +19,7 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/internal/logging"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/mount"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/registry"
 )
 
 var (
@@ -32,9 +33,12 @@ type GlobalConfig struct {
 	origin           *SnapshotterConfig
 	SnapshotsDir     string
 	DaemonMode       DaemonMode
+	StateDir         string
 	SocketRoot       string
 	ConfigRoot       string
+	SupervisorDir    string
 	RootMountpoint   string
+	WorkDir          string
 	DaemonThreadsNum int
 	CacheGCPeriod    time.Duration
 	MirrorsConfig    MirrorsConfig
@@ -64,6 +68,28 @@ func GetConfigRoot() string {
 	return globalConfig.ConfigRoot
 }
 
+// IsImageClaimed checks if the image is served by nydus snapshotter, images without
+// a reference are always claimed.
+func IsImageClaimed(imageRef string) bool {
+	registries := globalConfig.origin.ImageConfig.ClaimRegistries
+	if len(registries) == 0 || imageRef == "" {
+		return true
+	}
+	return registry.MatchRegistry(imageRef, registries)
+}
+
+func GetStateDir() string {
+	return globalConfig.StateDir
+}
+
+func GetSupervisorDir() string {
+	return globalConfig.SupervisorDir
+}
+
+func GetWorkDir() string {
+	return globalConfig.WorkDir
+}
+
 func GetMirrorsConfigDir() string {
 	return globalConfig.MirrorsConfig.Dir
 }
@@ -92,6 +118,14 @@ func GetDaemonThreadsNumber() int {
 	return globalConfig.origin.DaemonConfig.ThreadsNumber
 }
 
+func IsSandboxSharingEnabled() bool {
+	return globalConfig.origin.DaemonConfig.ShareBySandbox
+}
+
+func GetFuseSessionConfig() FuseSessionConfig {
+	return globalConfig.origin.DaemonConfig.Fuse
+}
+
 func GetLogToStdout() bool {
 	return globalConfig.origin.LoggingConfig.LogToStdout
 }
@@ -100,6 +134,10 @@ func IsBackendSourceEnabled() bool {
 	return globalConfig.origin.Experimental.EnableBackendSource && globalConfig.origin.SystemControllerConfig.Enable
 }
 
+func IsLazyBootstrapEnabled() bool {
+	return globalConfig.origin.SnapshotsConfig.LazyBootstrap
+}
+
 func IsSystemControllerEnabled() bool {
 	return globalConfig.origin.SystemControllerConfig.Enable
 }
@@ -108,6 +146,14 @@ func SystemControllerAddress() string {
 	return globalConfig.origin.SystemControllerConfig.Address
 }
 
+func SystemControllerGRPCAddress() string {
+	return globalConfig.origin.SystemControllerConfig.GRPCAddress
+}
+
+func GetPreloadConfig() PreloadConfig {
+	return globalConfig.origin.SystemControllerConfig.PreloadConfig
+}
+
 func SystemControllerPprofAddress() string {
 	return globalConfig.origin.SystemControllerConfig.DebugConfig.PprofAddress
 }
@@ -120,6 +166,30 @@ func GetSkipSSLVerify() bool {
 	return globalConfig.origin.RemoteConfig.SkipSSLVerify
 }
 
+// GetS3Bucket returns credentials of `bucket` at `endpoint`, preferring the ones
+// configured for the endpoint to the ones for any endpoint.
+func GetS3Bucket(endpoint, bucket string) (S3BucketConfig, bool) {
+	if globalConfig.origin == nil {
+		return S3BucketConfig{}, false
+	}
+	var found *S3BucketConfig
+	for i, b := range globalConfig.origin.RemoteConfig.S3Buckets {
+		if b.Bucket != bucket {
+			continue
+		}
+		if b.Endpoint == endpoint {
+			return b, true
+		}
+		if b.Endpoint == "" && found == nil {
+			found = &globalConfig.origin.RemoteConfig.S3Buckets[i]
+		}
+	}
+	if found == nil {
+		return S3BucketConfig{}, false
+	}
+	return *found, true
+}
+
 const (
 	TarfsLayerVerityOnly      string = "layer_verity_only"
 	TarfsImageVerityOnly      string = "image_verity_only"
@@ -175,12 +245,32 @@ func ProcessConfigurations(c *SnapshotterConfig) error {
 		c.CacheManagerConfig.CacheDir = filepath.Join(c.Root, "cache")
 	}
 
+	paths := &c.PathsConfig
+	for _, p := range []struct {
+		dir *string
+		def string
+	}{
+		{&paths.StateDir, c.Root},
+		{&paths.SocketDir, filepath.Join(c.Root, "socket")},
+		{&paths.DaemonConfigDir, filepath.Join(c.Root, "config")},
+		{&paths.SupervisorDir, filepath.Join(c.Root, "supervisor")},
+		{&paths.MountpointDir, filepath.Join(c.Root, "mnt")},
+		{&paths.WorkDir, c.Root},
+	} {
+		if *p.dir == "" {
+			*p.dir = p.def
+		}
+	}
+
 	globalConfig.origin = c
 
 	globalConfig.SnapshotsDir = filepath.Join(c.Root, "snapshots")
-	globalConfig.ConfigRoot = filepath.Join(c.Root, "config")
-	globalConfig.SocketRoot = filepath.Join(c.Root, "socket")
-	globalConfig.RootMountpoint = filepath.Join(c.Root, "mnt")
+	globalConfig.StateDir = paths.StateDir
+	globalConfig.ConfigRoot = paths.DaemonConfigDir
+	globalConfig.SocketRoot = paths.SocketDir
+	globalConfig.SupervisorDir = paths.SupervisorDir
+	globalConfig.RootMountpoint = paths.MountpointDir
+	globalConfig.WorkDir = paths.WorkDir
 
 	globalConfig.MirrorsConfig = c.RemoteConfig.MirrorsConfig
 
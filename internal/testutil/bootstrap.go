@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package testutil shares fixtures among tests of packages.
+package testutil
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Path of the bootstrap in nydus meta layers, not taken from package layout whose
+// tests use this package too.
+const bootstrapFile = "image/image.boot"
+
+// ExtractBootstrap extracts the bootstrap of the nydus meta layer `archive`, a gzipped
+// tarball, into a temporary directory of `t`, and returns its path.
+func ExtractBootstrap(t testing.TB, archive string) string {
+	f, err := os.Open(archive)
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		if hdr.Name == bootstrapFile {
+			target := filepath.Join(t.TempDir(), "image.boot")
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(target, data, 0644))
+			return target
+		}
+	}
+}
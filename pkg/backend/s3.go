@@ -37,6 +37,8 @@ type S3Backend struct {
 	region             string
 	accessKeySecret    string
 	accessKeyID        string
+	pathStyle          bool
+	signatureVersion   string
 	forcePush          bool
 }
 
@@ -48,6 +50,12 @@ type S3Config struct {
 	BucketName      string `json:"bucket_name,omitempty"`
 	Region          string `json:"region,omitempty"`
 	ObjectPrefix    string `json:"object_prefix,omitempty"`
+	// Address buckets by path like `<endpoint>/<bucket>/<key>` instead of host like
+	// `<bucket>.<endpoint>/<key>`, true by default as most S3-compatible services like
+	// MinIO require.
+	PathStyle *bool `json:"path_style,omitempty"`
+	// "v4" by default, or "v2".
+	SignatureVersion string `json:"signature_version,omitempty"`
 }
 
 func newS3Backend(rawConfig []byte, forcePush bool) (*S3Backend, error) {
@@ -62,6 +70,13 @@ func newS3Backend(rawConfig []byte, forcePush bool) (*S3Backend, error) {
 		cfg.Scheme = "https"
 	}
 	endpointWithScheme := fmt.Sprintf("%s://%s", cfg.Scheme, cfg.Endpoint)
+	pathStyle := cfg.PathStyle == nil || *cfg.PathStyle
+	if cfg.SignatureVersion == "" {
+		cfg.SignatureVersion = SignatureVersionV4
+	}
+	if cfg.SignatureVersion != SignatureVersionV4 && cfg.SignatureVersion != SignatureVersionV2 {
+		return nil, fmt.Errorf("invalid S3 configuration: unsupported 'signature_version' %q", cfg.SignatureVersion)
+	}
 
 	if cfg.BucketName == "" || cfg.Region == "" {
 		return nil, fmt.Errorf("invalid S3 configuration: missing 'bucket_name' or 'region'")
@@ -74,6 +89,8 @@ func newS3Backend(rawConfig []byte, forcePush bool) (*S3Backend, error) {
 		endpointWithScheme: endpointWithScheme,
 		accessKeySecret:    cfg.AccessKeySecret,
 		accessKeyID:        cfg.AccessKeyID,
+		pathStyle:          pathStyle,
+		signatureVersion:   cfg.SignatureVersion,
 		forcePush:          forcePush,
 	}, nil
 }
@@ -87,11 +104,13 @@ func (b *S3Backend) client() (*s3.Client, error) {
 	client := s3.NewFromConfig(s3AWSConfig, func(o *s3.Options) {
 		o.BaseEndpoint = &b.endpointWithScheme
 		o.Region = b.region
-		o.UsePathStyle = true
+		o.UsePathStyle = b.pathStyle
 		if len(b.accessKeySecret) > 0 && len(b.accessKeyID) > 0 {
 			o.Credentials = credentials.NewStaticCredentialsProvider(b.accessKeyID, b.accessKeySecret, "")
 		}
-		o.UsePathStyle = true
+		if b.signatureVersion == SignatureVersionV2 {
+			o.HTTPSignerV4 = &v2Signer{bucket: b.bucketName, pathStyle: b.pathStyle}
+		}
 	})
 	return client, nil
 }
@@ -140,12 +159,16 @@ func (b *S3Backend) Push(ctx context.Context, cs content.Store, desc ocispec.Des
 	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
 		u.PartSize = MultipartChunkSize
 	})
-	if _, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:            aws.String(b.bucketName),
-		Key:               aws.String(blobObjectKey),
-		Body:              reader,
-		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
-	}); err != nil {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(blobObjectKey),
+		Body:   reader,
+	}
+	// Services only supporting signature version 2 don't know flexible checksums.
+	if b.signatureVersion == SignatureVersionV4 {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+	if _, err := uploader.Upload(ctx, input); err != nil {
 		return errors.Wrap(err, "push blob to s3 backend")
 	}
 
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	SignatureVersionV4 = "v4"
+	// Legacy signature of S3, still required by some S3-compatible services like old
+	// Ceph RGW and Tencent COS deployments.
+	SignatureVersionV2 = "v2"
+)
+
+// Query parameters signed by signature version 2 as part of the resource.
+var v2SubResources = map[string]bool{
+	"acl": true, "delete": true, "lifecycle": true, "location": true, "logging": true,
+	"notification": true, "partNumber": true, "policy": true, "requestPayment": true,
+	"tagging": true, "torrent": true, "uploadId": true, "uploads": true, "versionId": true,
+	"versioning": true, "versions": true, "website": true,
+	"response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "response-content-language": true,
+	"response-content-type": true, "response-expires": true,
+}
+
+// v2Signer signs requests by signature version 2 in place of the signature version 4
+// signer of the S3 client.
+type v2Signer struct {
+	bucket    string
+	pathStyle bool
+}
+
+func (s *v2Signer) SignHTTP(_ context.Context, credentials aws.Credentials, r *http.Request, _ string, _ string, _ string,
+	signingTime time.Time, _ ...func(*v4.SignerOptions)) error {
+	// Anonymous requests are not signed.
+	if credentials.AccessKeyID == "" {
+		return nil
+	}
+
+	r.Header.Del("X-Amz-Date")
+	r.Header.Set("Date", signingTime.UTC().Format(http.TimeFormat))
+	if credentials.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	signature := signV2(credentials.SecretAccessKey, s.stringToSign(r))
+	r.Header.Set("Authorization", "AWS "+credentials.AccessKeyID+":"+signature)
+	return nil
+}
+
+func signV2(secret, stringToSign string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// In format of `<method>\n<Content-MD5>\n<Content-Type>\n<Date>\n<x-amz-* headers><resource>`.
+func (s *v2Signer) stringToSign(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(r.Header.Get("Content-MD5") + "\n")
+	b.WriteString(r.Header.Get("Content-Type") + "\n")
+	b.WriteString(r.Header.Get("Date") + "\n")
+
+	var amzHeaders []string
+	for name := range r.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			amzHeaders = append(amzHeaders, lower)
+		}
+	}
+	sort.Strings(amzHeaders)
+	for _, name := range amzHeaders {
+		var values []string
+		for _, v := range r.Header.Values(name) {
+			values = append(values, strings.TrimSpace(v))
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	// The bucket is in the path already with path-style addressing.
+	if !s.pathStyle {
+		b.WriteString("/" + s.bucket)
+	}
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+
+	query := r.URL.Query()
+	var subResources []string
+	for name := range query {
+		if v2SubResources[name] {
+			subResources = append(subResources, name)
+		}
+	}
+	sort.Strings(subResources)
+	for i, name := range subResources {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(name)
+		if v := query.Get(name); v != "" {
+			b.WriteString("=" + v)
+		}
+	}
+	return b.String()
+}
@@ -42,9 +42,41 @@ func Test_newS3Backend(t *testing.T) {
 				region:             "us-east-1",
 				accessKeySecret:    "minio123",
 				accessKeyID:        "minio",
+				pathStyle:          true,
+				signatureVersion:   SignatureVersionV4,
 			},
 			wantErr: false,
 		},
+		{
+			name: "test2, virtual-hosted style with signature version 2",
+			args: args{
+				rawConfig: []byte(`{
+					"endpoint": "cos.ap-guangzhou.myqcloud.com",
+					"bucket_name": "nydus-1250000000",
+					"region": "ap-guangzhou",
+					"path_style": false,
+					"signature_version": "v2"
+				}`),
+			},
+			want: &S3Backend{
+				bucketName:         "nydus-1250000000",
+				endpointWithScheme: "https://cos.ap-guangzhou.myqcloud.com",
+				region:             "ap-guangzhou",
+				signatureVersion:   SignatureVersionV2,
+			},
+			wantErr: false,
+		},
+		{
+			name: "test3, unsupported signature version",
+			args: args{
+				rawConfig: []byte(`{
+					"bucket_name": "nydus",
+					"region": "us-east-1",
+					"signature_version": "v3"
+				}`),
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
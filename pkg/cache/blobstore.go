@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+)
+
+// BlobStore is a content addressable store of whole nydus blobs in the cache directory,
+// shared by local conversion, which puts converted blobs into it, and nydusd, which
+// reads them with the localfs backend, so they are never fetched or cached twice.
+//
+// Blobs are named by blob ID in `blobs`. Each holder of a blob has a reference, which
+// is an empty file `refs/<blob ID>/<holder>`, so references survive restarts of
+// snapshotter. A blob is removed along with its last reference.
+type BlobStore struct {
+	mutex sync.Mutex
+	root  string
+}
+
+func NewBlobStore(root string) (*BlobStore, error) {
+	s := &BlobStore{root: root}
+	for _, dir := range []string{s.BlobDir(), s.refsDir()} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, errors.Wrapf(err, "create directory %s", dir)
+		}
+	}
+	return s, nil
+}
+
+// Directory hosting blobs named by blob ID, usable as the localfs backend of nydusd.
+func (s *BlobStore) BlobDir() string {
+	return filepath.Join(s.root, "blobs")
+}
+
+func (s *BlobStore) refsDir() string {
+	return filepath.Join(s.root, "refs")
+}
+
+func (s *BlobStore) blobPath(blobID string) string {
+	return filepath.Join(s.BlobDir(), blobID)
+}
+
+func validateBlobID(blobID string) error {
+	if err := digest.NewDigestFromEncoded(digest.SHA256, blobID).Validate(); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "blob ID %q", blobID)
+	}
+	return nil
+}
+
+func validateHolder(holder string) error {
+	if holder == "" || holder == "." || holder == ".." || filepath.Base(holder) != holder {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "blob holder %q", holder)
+	}
+	return nil
+}
+
+// Has tells whether the blob is in the store.
+func (s *BlobStore) Has(blobID string) bool {
+	if validateBlobID(blobID) != nil {
+		return false
+	}
+	_, err := os.Stat(s.blobPath(blobID))
+	return err == nil
+}
+
+// Add moves `file` into the store as blob `blobID` held by `holder`. The file is dropped
+// if the blob is in the store already, e.g. converted from the same layer content.
+func (s *BlobStore) Add(blobID, file, holder string) error {
+	if err := validateBlobID(blobID); err != nil {
+		return err
+	}
+	if err := validateHolder(holder); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(s.blobPath(blobID)); err == nil {
+		log.L.Debugf("blob %s is in store already", blobID)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove duplicated blob file %s", file)
+		}
+	} else if err := os.Rename(file, s.blobPath(blobID)); err != nil {
+		return errors.Wrapf(err, "rename file %s to %s", file, s.blobPath(blobID))
+	}
+
+	return s.acquireLocked(blobID, holder)
+}
+
+// Acquire adds a reference to the blob for `holder`, errdefs.ErrNotFound if the blob
+// is not in the store. Acquiring the same blob again by a holder has no effect.
+func (s *BlobStore) Acquire(blobID, holder string) error {
+	if err := validateBlobID(blobID); err != nil {
+		return err
+	}
+	if err := validateHolder(holder); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(s.blobPath(blobID)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrapf(errdefs.ErrNotFound, "blob %s", blobID)
+		}
+		return err
+	}
+	return s.acquireLocked(blobID, holder)
+}
+
+func (s *BlobStore) acquireLocked(blobID, holder string) error {
+	dir := filepath.Join(s.refsDir(), blobID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return errors.Wrapf(err, "create directory %s", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, holder), nil, 0640); err != nil {
+		return errors.Wrapf(err, "reference blob %s by %s", blobID, holder)
+	}
+	return nil
+}
+
+// Release drops the reference of `holder` to the blob, which is removed if it was
+// the last one.
+func (s *BlobStore) Release(blobID, holder string) error {
+	if err := validateBlobID(blobID); err != nil {
+		return err
+	}
+	if err := validateHolder(holder); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(filepath.Join(s.refsDir(), blobID, holder)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "release blob %s by %s", blobID, holder)
+	}
+	holders, err := s.holdersLocked(blobID)
+	if err != nil || len(holders) > 0 {
+		return err
+	}
+	return s.removeLocked(blobID)
+}
+
+// ReleaseHolder drops references of `holder` to all the blobs, e.g. when the holder
+// goes away after snapshotter is restarted and its blobs are not known any more.
+func (s *BlobStore) ReleaseHolder(holder string) error {
+	if err := validateHolder(holder); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.refsDir())
+	if err != nil {
+		return errors.Wrapf(err, "read directory %s", s.refsDir())
+	}
+	for _, e := range entries {
+		blobID := e.Name()
+		ref := filepath.Join(s.refsDir(), blobID, holder)
+		if _, err := os.Stat(ref); err != nil {
+			continue
+		}
+		if err := os.Remove(ref); err != nil {
+			return errors.Wrapf(err, "release blob %s by %s", blobID, holder)
+		}
+		holders, err := s.holdersLocked(blobID)
+		if err != nil {
+			return err
+		}
+		if len(holders) == 0 {
+			if err := s.removeLocked(blobID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Holders returns holders referencing the blob.
+func (s *BlobStore) Holders(blobID string) ([]string, error) {
+	if err := validateBlobID(blobID); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.holdersLocked(blobID)
+}
+
+func (s *BlobStore) holdersLocked(blobID string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.refsDir(), blobID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read references of blob %s", blobID)
+	}
+	holders := make([]string, 0, len(entries))
+	for _, e := range entries {
+		holders = append(holders, e.Name())
+	}
+	return holders, nil
+}
+
+func (s *BlobStore) removeLocked(blobID string) error {
+	if err := os.Remove(s.blobPath(blobID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove blob %s", blobID)
+	}
+	if err := os.RemoveAll(filepath.Join(s.refsDir(), blobID)); err != nil {
+		return errors.Wrapf(err, "remove references of blob %s", blobID)
+	}
+	events.Publish(events.TopicGC, map[string]string{"action": "remove_blob", "blob_id": blobID})
+	return nil
+}
+
+// Sweep removes blobs without any reference, which are left if snapshotter is
+// interrupted in the middle of adding or releasing them.
+func (s *BlobStore) Sweep() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.BlobDir())
+	if err != nil {
+		return nil, errors.Wrapf(err, "read directory %s", s.BlobDir())
+	}
+
+	var removed []string
+	for _, e := range entries {
+		blobID := e.Name()
+		if validateBlobID(blobID) != nil {
+			continue
+		}
+		holders, err := s.holdersLocked(blobID)
+		if err != nil {
+			return removed, err
+		}
+		if len(holders) > 0 {
+			continue
+		}
+		if err := s.removeLocked(blobID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, blobID)
+	}
+	return removed, nil
+}
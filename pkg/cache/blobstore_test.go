@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func TestBlobStore(t *testing.T) {
+	s, err := NewBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	blobID := digest.FromString("blob").Hex()
+	newFile := func() string {
+		f := filepath.Join(t.TempDir(), "blob")
+		require.NoError(t, os.WriteFile(f, []byte("blob"), 0640))
+		return f
+	}
+
+	require.ErrorIs(t, s.Acquire(blobID, "rafs-1"), errdefs.ErrNotFound)
+	require.NoError(t, s.Add(blobID, newFile(), "layer-a"))
+	require.True(t, s.Has(blobID))
+	require.FileExists(t, filepath.Join(s.BlobDir(), blobID))
+
+	// Duplicated blobs are dropped.
+	dup := newFile()
+	require.NoError(t, s.Add(blobID, dup, "layer-b"))
+	require.NoFileExists(t, dup)
+	require.NoError(t, s.Acquire(blobID, "rafs-1"))
+	require.NoError(t, s.Acquire(blobID, "rafs-1"))
+	holders, err := s.Holders(blobID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"layer-a", "layer-b", "rafs-1"}, holders)
+
+	require.NoError(t, s.Release(blobID, "layer-a"))
+	require.NoError(t, s.Release(blobID, "layer-b"))
+	require.True(t, s.Has(blobID))
+	require.NoError(t, s.Release(blobID, "rafs-1"))
+	require.False(t, s.Has(blobID))
+	require.NoError(t, s.Release(blobID, "rafs-1"))
+
+	require.Error(t, s.Add("invalid", newFile(), "layer-a"))
+	require.Error(t, s.Acquire(blobID, "../layer"))
+
+	// Blobs left without references are swept.
+	orphan := digest.FromString("orphan").Hex()
+	require.NoError(t, os.WriteFile(filepath.Join(s.BlobDir(), orphan), nil, 0640))
+	require.NoError(t, s.Add(blobID, newFile(), "layer-a"))
+	removed, err := s.Sweep()
+	require.NoError(t, err)
+	require.Equal(t, []string{orphan}, removed)
+	require.True(t, s.Has(blobID))
+
+	// All the references of a holder are released at once.
+	other := digest.FromString("other").Hex()
+	require.NoError(t, s.Add(other, newFile(), "detach-1"))
+	require.NoError(t, s.Acquire(blobID, "detach-1"))
+	require.NoError(t, s.ReleaseHolder("detach-1"))
+	require.False(t, s.Has(other))
+	holders, err = s.Holders(blobID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"layer-a"}, holders)
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Nydusd encrypts blob cache with AES-128-XTS, which takes a 32 bytes key.
+const encryptionKeySize = 32
+
+// LoadEncryptionKey retrieves the blob cache encryption key in hex, by running
+// `keyCommand` if provided, otherwise by reading `keyFile`.
+func LoadEncryptionKey(keyFile, keyCommand string) (string, error) {
+	var raw []byte
+	if keyCommand != "" {
+		var stderr bytes.Buffer
+		cmd := exec.Command("/bin/sh", "-c", keyCommand)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrapf(err, "run key command, %s", strings.TrimSpace(stderr.String()))
+		}
+		raw = out
+	} else {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "read key file %s", keyFile)
+		}
+		raw = data
+	}
+
+	key := strings.TrimSpace(string(raw))
+	decoded, err := hex.DecodeString(key)
+	if err != nil {
+		return "", errors.Wrap(err, "decode cache encryption key")
+	}
+	if len(decoded) != encryptionKeySize {
+		return "", errors.Errorf("cache encryption key must be %d bytes, got %d", encryptionKeySize, len(decoded))
+	}
+
+	return key, nil
+}
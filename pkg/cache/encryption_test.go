@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEncryptionKey(t *testing.T) {
+	key := strings.Repeat("0a", encryptionKeySize)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(key+"\n"), 0600))
+
+	k, err := LoadEncryptionKey(keyFile, "")
+	require.NoError(t, err)
+	require.Equal(t, key, k)
+
+	// Key command takes precedence over key file.
+	other := strings.Repeat("0b", encryptionKeySize)
+	k, err = LoadEncryptionKey(keyFile, "echo "+other)
+	require.NoError(t, err)
+	require.Equal(t, other, k)
+
+	_, err = LoadEncryptionKey(keyFile, "exit 1")
+	require.Error(t, err)
+
+	_, err = LoadEncryptionKey("", "echo 0a0b")
+	require.Error(t, err)
+
+	_, err = LoadEncryptionKey(filepath.Join(t.TempDir(), "missing"), "")
+	require.Error(t, err)
+}
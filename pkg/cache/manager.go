@@ -10,6 +10,7 @@ import (
 	"context"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,6 +18,7 @@ import (
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/continuity/fs"
 	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/store"
 )
 
@@ -34,6 +36,20 @@ type Manager struct {
 	cacheDir string
 	period   time.Duration
 	eventCh  chan struct{}
+
+	tier      *TierOpt
+	tierMutex sync.Mutex
+	// Blob caches of mounted images by holder, and those being moved between tiers.
+	pinned map[string]map[string]struct{}
+	// Holders of mounted images whose blobs are unknown.
+	pinnedAll map[string]struct{}
+	moving    map[string]struct{}
+	pinCond   *sync.Cond
+	stopCh    chan struct{}
+	stopped   sync.Once
+
+	blobStore *BlobStore
+	retention *RetentionPolicy
 }
 
 type Opt struct {
@@ -41,6 +57,12 @@ type Opt struct {
 	CacheDir string
 	Period   time.Duration
 	Database *store.Database
+	// Enable tiered cache when not nil
+	Tier *TierOpt
+	// Keep whole blobs in a content addressable store shared with local conversion.
+	SharedBlobStore bool
+	// Blob caches never evicted from the tiered cache, nil means pure LRU eviction.
+	Retention *RetentionPolicy
 }
 
 func NewManager(opt Opt) (*Manager, error) {
@@ -51,18 +73,60 @@ func NewManager(opt Opt) (*Manager, error) {
 
 	eventCh := make(chan struct{})
 	m := &Manager{
-		cacheDir: opt.CacheDir,
-		period:   opt.Period,
-		eventCh:  eventCh,
+		cacheDir:  opt.CacheDir,
+		period:    opt.Period,
+		eventCh:   eventCh,
+		tier:      opt.Tier,
+		retention: opt.Retention,
+		pinned:    map[string]map[string]struct{}{},
+		pinnedAll: map[string]struct{}{},
+		moving:    map[string]struct{}{},
+		pinCond:   sync.NewCond(&sync.Mutex{}),
+		stopCh:    make(chan struct{}),
+	}
+
+	if opt.SharedBlobStore {
+		bs, err := NewBlobStore(path.Join(opt.CacheDir, "cas"))
+		if err != nil {
+			return nil, errors.Wrap(err, "create shared blob store")
+		}
+		m.blobStore = bs
+	}
+
+	if m.tier != nil {
+		if err := os.MkdirAll(m.tier.SlowDir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "failed to create slow tier cache dir %s", m.tier.SlowDir)
+		}
+		go m.runTiering()
 	}
 
 	return m, nil
 }
 
+// Close stops moving blob caches between tiers in background.
+func (m *Manager) Close() {
+	m.stopped.Do(func() { close(m.stopCh) })
+}
+
 func (m *Manager) CacheDir() string {
 	return m.cacheDir
 }
 
+// CheckHealth tells whether blob cache files can be created in the cache directory.
+func (m *Manager) CheckHealth() error {
+	f, err := os.CreateTemp(m.cacheDir, ".health-")
+	if err != nil {
+		return errors.Wrapf(err, "create file in cache directory %s", m.cacheDir)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// BlobStore returns nil if the shared blob store is not enabled.
+func (m *Manager) BlobStore() *BlobStore {
+	return m.blobStore
+}
+
 // Report each blob disk usage
 // TODO: For fscache cache files, the cache files are managed by nydusd and Linux kernel
 // We don't know how it manages cache files. A method to address this is to query nydusd.
@@ -80,8 +144,16 @@ func (m *Manager) CacheUsage(ctx context.Context, blobID string) (snapshots.Usag
 	layerDisk := path.Join(m.cacheDir, blobID+layerDiskFileSuffix)
 
 	stuffs := []string{blobCachePath, blobChunkMap, blobCacheSuffixedPath, blobChunkMapSuffixedPath, blobMeta, imageDisk, layerDisk}
+	// Whole blobs in the shared store are fully cached.
+	if m.blobStore != nil {
+		stuffs = append(stuffs, m.blobStore.blobPath(blobID))
+	}
 
 	for _, f := range stuffs {
+		// Blob cache demoted to the slow tier is linked from the cache directory.
+		if target, err := os.Readlink(f); err == nil {
+			f = target
+		}
 		du, err := fs.DiskUsage(ctx, f)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
@@ -109,6 +181,11 @@ func (m *Manager) RemoveBlobCache(blobID string) error {
 	stuffs := []string{blobChunkMap, blobChunkMapSuffixedPath, blobMeta, blobCachePath, blobCacheSuffixedPath, imageDisk, layerDisk}
 
 	for _, f := range stuffs {
+		if target, err := os.Readlink(f); err == nil {
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
 		err := os.Remove(f)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
@@ -118,5 +195,6 @@ func (m *Manager) RemoveBlobCache(blobID string) error {
 			return err
 		}
 	}
+	events.Publish(events.TopicGC, map[string]string{"action": "remove_cache", "blob_id": blobID})
 	return nil
 }
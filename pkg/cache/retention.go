@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy keeps blob caches of images from eviction whatever their recency.
+// Images are known by labels of their layer snapshots set by containerd at pull.
+type RetentionPolicy struct {
+	// Images with any of the labels on their layer snapshots are kept, in format of
+	// `key=value`, or `key` for any value.
+	KeepLabels []string
+	// The most recently pulled tags of each repository are kept, 0 means none.
+	KeepLastTags int
+	// Snapshots to find images in.
+	WalkSnapshots func(ctx context.Context, fn snapshots.WalkFunc) error
+}
+
+type retentionImage struct {
+	repository string
+	pulled     time.Time
+	blobs      []string
+	keep       bool
+}
+
+func (p *RetentionPolicy) matchLabels(labels map[string]string) bool {
+	for _, l := range p.KeepLabels {
+		key, value, hasValue := strings.Cut(l, "=")
+		if v, ok := labels[key]; ok && (!hasValue || v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// retainedBlobs returns IDs of blobs of the images kept by the policy.
+func (p *RetentionPolicy) retainedBlobs(infos []snapshots.Info) map[string]bool {
+	images := map[string]*retentionImage{}
+	for _, info := range infos {
+		ref := info.Labels[snpkg.TargetRefLabel]
+		if ref == "" {
+			continue
+		}
+		img, ok := images[ref]
+		if !ok {
+			img = &retentionImage{repository: ref}
+			if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+				img.repository = reference.TrimNamed(named).String()
+			}
+			images[ref] = img
+		}
+
+		// Layers shared with images pulled before are labeled with the first image only,
+		// but all layers of an image are listed on each of its layer snapshots.
+		layers := strings.Split(info.Labels[snpkg.TargetImageLayersLabel], ",")
+		layers = append(layers, info.Labels[snpkg.TargetLayerDigestLabel])
+		for _, l := range layers {
+			if d, err := digest.Parse(l); err == nil {
+				img.blobs = append(img.blobs, d.Encoded())
+			}
+		}
+		if info.Created.After(img.pulled) {
+			img.pulled = info.Created
+		}
+		img.keep = img.keep || p.matchLabels(info.Labels)
+	}
+
+	if p.KeepLastTags > 0 {
+		repositories := map[string][]*retentionImage{}
+		for _, img := range images {
+			repositories[img.repository] = append(repositories[img.repository], img)
+		}
+		for _, imgs := range repositories {
+			sort.Slice(imgs, func(i, j int) bool { return imgs[i].pulled.After(imgs[j].pulled) })
+			for i := 0; i < len(imgs) && i < p.KeepLastTags; i++ {
+				imgs[i].keep = true
+			}
+		}
+	}
+
+	retained := map[string]bool{}
+	for _, img := range images {
+		if img.keep {
+			for _, blob := range img.blobs {
+				retained[blob] = true
+			}
+		}
+	}
+	return retained
+}
+
+// RetainedBlobs returns IDs of blobs kept by the policy, nil without retention policy.
+func (m *Manager) RetainedBlobs(ctx context.Context) (map[string]bool, error) {
+	p := m.retention
+	if p == nil || p.WalkSnapshots == nil || (len(p.KeepLabels) == 0 && p.KeepLastTags <= 0) {
+		return nil, nil
+	}
+
+	var infos []snapshots.Info
+	if err := p.WalkSnapshots(ctx, func(_ context.Context, info snapshots.Info) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "walk snapshots for retention policy")
+	}
+	return p.retainedBlobs(infos), nil
+}
+
+// IsRetained tells if the retention policy keeps blob `blobID` of any image.
+func (m *Manager) IsRetained(ctx context.Context, blobID string) (bool, error) {
+	retained, err := m.RetainedBlobs(ctx)
+	if err != nil {
+		return false, err
+	}
+	return retained[blobID], nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/stretchr/testify/require"
+)
+
+func layerInfo(name, ref, blobID string, created time.Time, labels map[string]string) snapshots.Info {
+	info := snapshots.Info{
+		Name:    name,
+		Created: created,
+		Labels: map[string]string{
+			snpkg.TargetRefLabel:         ref,
+			snpkg.TargetLayerDigestLabel: "sha256:" + blobID,
+		},
+	}
+	for k, v := range labels {
+		info.Labels[k] = v
+	}
+	return info
+}
+
+func TestRetainedBlobs(t *testing.T) {
+	blob := func(c string) string { return strings.Repeat(c, 64) }
+	now := time.Now()
+	infos := []snapshots.Info{
+		layerInfo("1", "docker.io/library/app:v1", blob("a"), now.Add(-3*time.Hour), nil),
+		layerInfo("2", "docker.io/library/app:v2", blob("b"), now.Add(-2*time.Hour), map[string]string{
+			// Layer a is shared with v1.
+			snpkg.TargetImageLayersLabel: "sha256:" + blob("a") + ",sha256:" + blob("b"),
+		}),
+		layerInfo("3", "docker.io/library/app:v3", blob("c"), now.Add(-time.Hour), nil),
+		layerInfo("4", "docker.io/library/db:v1", blob("d"), now.Add(-4*time.Hour), map[string]string{"critical": "true"}),
+		layerInfo("5", "docker.io/library/tool:v1", blob("e"), now, map[string]string{"critical": "false"}),
+	}
+
+	p := RetentionPolicy{KeepLastTags: 2}
+	require.Equal(t, map[string]bool{blob("a"): true, blob("b"): true, blob("c"): true, blob("d"): true, blob("e"): true}, p.retainedBlobs(infos))
+
+	p = RetentionPolicy{KeepLastTags: 1}
+	require.Equal(t, map[string]bool{blob("c"): true, blob("d"): true, blob("e"): true}, p.retainedBlobs(infos))
+
+	p = RetentionPolicy{KeepLabels: []string{"critical=true"}}
+	require.Equal(t, map[string]bool{blob("d"): true}, p.retainedBlobs(infos))
+
+	p = RetentionPolicy{KeepLabels: []string{"critical"}}
+	require.Equal(t, map[string]bool{blob("d"): true, blob("e"): true}, p.retainedBlobs(infos))
+}
+
+func TestRebalanceTiersRetention(t *testing.T) {
+	cacheDir, slowDir := t.TempDir(), t.TempDir()
+	kept, evicted := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	m, err := NewManager(Opt{
+		CacheDir: cacheDir,
+		Tier:     &TierOpt{SlowDir: slowDir, FastLimit: 1, SlowLimit: 1},
+		Retention: &RetentionPolicy{
+			KeepLabels: []string{"critical=true"},
+			WalkSnapshots: func(ctx context.Context, fn snapshots.WalkFunc) error {
+				return fn(ctx, layerInfo("1", "docker.io/library/db:v1", kept, time.Now(), map[string]string{"critical": "true"}))
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte{1}, 8<<10)
+	for _, name := range []string{kept + dataFileSuffix, evicted + dataFileSuffix} {
+		require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), data, 0644))
+	}
+
+	// Both are demoted as the fast tier is full, while only the retained one survives.
+	require.NoError(t, m.RebalanceTiers())
+	require.FileExists(t, filepath.Join(slowDir, kept+dataFileSuffix))
+	require.NoFileExists(t, filepath.Join(slowDir, evicted+dataFileSuffix))
+	content, err := os.ReadFile(filepath.Join(cacheDir, kept+dataFileSuffix))
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+}
+
+func TestIsRetained(t *testing.T) {
+	kept, removed := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	m, err := NewManager(Opt{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	retained, err := m.IsRetained(context.TODO(), kept)
+	require.NoError(t, err)
+	require.False(t, retained)
+
+	m, err = NewManager(Opt{
+		CacheDir: t.TempDir(),
+		Retention: &RetentionPolicy{
+			KeepLabels: []string{"critical=true"},
+			WalkSnapshots: func(ctx context.Context, fn snapshots.WalkFunc) error {
+				return fn(ctx, layerInfo("1", "docker.io/library/db:v1", kept, time.Now(), map[string]string{"critical": "true"}))
+			},
+		},
+	})
+	require.NoError(t, err)
+	retained, err = m.IsRetained(context.TODO(), kept)
+	require.NoError(t, err)
+	require.True(t, retained)
+	retained, err = m.IsRetained(context.TODO(), removed)
+	require.NoError(t, err)
+	require.False(t, retained)
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRebalancePeriod = 10 * time.Minute
+	tierTmpSuffix          = ".tier.tmp"
+	copyBlockSize          = 1 << 20
+)
+
+// Blob cache data files are moved between the fast tier, which is the cache directory,
+// and the slow tier. A demoted file is replaced by a symlink to the slow tier so that
+// nydusd keeps finding it, while chunk maps and meta files always stay on the fast tier.
+// Whole files are moved, and only those of blobs no mounted image reads, since writes of
+// nydusd to a file being copied would be lost while its chunk map marks them cached.
+type TierOpt struct {
+	SlowDir string
+	// Size limit of the fast tier in bytes.
+	FastLimit int64
+	// Size limit of the slow tier in bytes, non-positive means no limit.
+	SlowLimit int64
+	Period    time.Duration
+}
+
+type blobCacheFile struct {
+	name string
+	// Allocated bytes on disk since blob cache files are sparse.
+	size    int64
+	recency time.Time
+	ctime   time.Time
+}
+
+func isBlobDataFile(name string) bool {
+	id := strings.TrimSuffix(name, dataFileSuffix)
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Symlinks are skipped so only files hosted by the tier itself are returned.
+func scanTier(dir string) ([]blobCacheFile, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "read cache tier %s", dir)
+	}
+
+	var files []blobCacheFile
+	var usage int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !isBlobDataFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+
+		atime := time.Unix(st.Atim.Unix())
+		recency := info.ModTime()
+		if atime.After(recency) {
+			recency = atime
+		}
+		f := blobCacheFile{
+			name:    e.Name(),
+			size:    st.Blocks * 512,
+			recency: recency,
+			ctime:   time.Unix(st.Ctim.Unix()),
+		}
+		files = append(files, f)
+		usage += f.size
+	}
+
+	return files, usage, nil
+}
+
+// Files opened by any process, nydusd must not lose its opened cache files.
+func openedFiles() map[string]bool {
+	opened := map[string]bool{}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil {
+			opened[target] = true
+		}
+	}
+	return opened
+}
+
+func (m *Manager) runTiering() {
+	period := m.tier.Period
+	if period <= 0 {
+		period = defaultRebalancePeriod
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.RebalanceTiers(); err != nil {
+				log.L.WithError(err).Warn("failed to rebalance blob cache tiers")
+			}
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// Tiered tells if blob caches are moved between tiers, so that mounted images must pin
+// the blob caches they read.
+func (m *Manager) Tiered() bool {
+	return m.tier != nil
+}
+
+// PinBlobCaches keeps blob caches `blobIDs` on their tiers until UnpinBlobCaches is
+// called with `holder`. It waits for the blob caches being moved, so nydusd opens them
+// only once they're in place. Nil `blobIDs` pins all, for images of unknown blobs.
+func (m *Manager) PinBlobCaches(holder string, blobIDs []string) {
+	m.pinCond.L.Lock()
+	defer m.pinCond.L.Unlock()
+
+	if blobIDs == nil {
+		for len(m.moving) > 0 {
+			m.pinCond.Wait()
+		}
+		m.pinnedAll[holder] = struct{}{}
+		return
+	}
+	for _, id := range blobIDs {
+		for {
+			if _, ok := m.moving[id]; !ok {
+				break
+			}
+			m.pinCond.Wait()
+		}
+		if m.pinned[id] == nil {
+			m.pinned[id] = map[string]struct{}{}
+		}
+		m.pinned[id][holder] = struct{}{}
+	}
+}
+
+func (m *Manager) UnpinBlobCaches(holder string) {
+	m.pinCond.L.Lock()
+	defer m.pinCond.L.Unlock()
+
+	delete(m.pinnedAll, holder)
+	for id, holders := range m.pinned {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(m.pinned, id)
+		}
+	}
+}
+
+// Run `move` on blob cache `name` unless it's pinned, returning false if skipped.
+func (m *Manager) moveUnpinned(name string, move func() error) (bool, error) {
+	id := blobIDOf(name)
+	m.pinCond.L.Lock()
+	if _, ok := m.pinned[id]; ok || len(m.pinnedAll) > 0 {
+		m.pinCond.L.Unlock()
+		return false, nil
+	}
+	m.moving[id] = struct{}{}
+	m.pinCond.L.Unlock()
+
+	defer func() {
+		m.pinCond.L.Lock()
+		delete(m.moving, id)
+		m.pinCond.L.Unlock()
+		m.pinCond.Broadcast()
+	}()
+	return true, move()
+}
+
+// RebalanceTiers promotes slow tier blob caches accessed since their demotion,
+// then demotes the least recently used ones until the fast tier fits its limit,
+// and finally evicts the coldest blob caches beyond the slow tier limit. Blob caches
+// retained by the retention policy are demoted last and never evicted.
+func (m *Manager) RebalanceTiers() error {
+	if m.tier == nil {
+		return nil
+	}
+	m.tierMutex.Lock()
+	defer m.tierMutex.Unlock()
+
+	// Nothing is evicted if retained blobs are unknown.
+	retained, err := m.RetainedBlobs(context.Background())
+	if err != nil {
+		return err
+	}
+	opened := openedFiles()
+	fast, fastUsage, err := scanTier(m.cacheDir)
+	if err != nil {
+		return err
+	}
+	slow, _, err := scanTier(m.tier.SlowDir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(slow, func(i, j int) bool { return slow[i].recency.After(slow[j].recency) })
+	for _, f := range slow {
+		slowPath := filepath.Join(m.tier.SlowDir, f.name)
+		if !f.recency.After(f.ctime) || opened[slowPath] || fastUsage+f.size > m.tier.FastLimit {
+			continue
+		}
+		name := f.name
+		moved, err := m.moveUnpinned(name, func() error { return m.promote(name) })
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to promote blob cache %s", name)
+			continue
+		}
+		if moved {
+			fastUsage += f.size
+		}
+	}
+
+	sort.Slice(fast, func(i, j int) bool {
+		if ri, rj := retained[blobIDOf(fast[i].name)], retained[blobIDOf(fast[j].name)]; ri != rj {
+			return rj
+		}
+		return fast[i].recency.Before(fast[j].recency)
+	})
+	for _, f := range fast {
+		if fastUsage <= m.tier.FastLimit {
+			break
+		}
+		if opened[filepath.Join(m.cacheDir, f.name)] {
+			continue
+		}
+		name := f.name
+		moved, err := m.moveUnpinned(name, func() error { return m.demote(name) })
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to demote blob cache %s", name)
+			continue
+		}
+		if moved {
+			fastUsage -= f.size
+		}
+	}
+
+	return m.evictSlowTier(opened, retained)
+}
+
+func blobIDOf(name string) string {
+	return strings.TrimSuffix(name, dataFileSuffix)
+}
+
+func (m *Manager) evictSlowTier(opened, retained map[string]bool) error {
+	slow, slowUsage, err := scanTier(m.tier.SlowDir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(slow, func(i, j int) bool { return slow[i].recency.Before(slow[j].recency) })
+	for _, f := range slow {
+		slowPath := filepath.Join(m.tier.SlowDir, f.name)
+		// Orphans are left when the blob cache is removed from the fast tier.
+		orphan := false
+		if target, err := os.Readlink(filepath.Join(m.cacheDir, f.name)); err != nil || target != slowPath {
+			orphan = true
+		}
+		if !orphan && (m.tier.SlowLimit <= 0 || slowUsage <= m.tier.SlowLimit) {
+			continue
+		}
+		if opened[slowPath] || (!orphan && retained[blobIDOf(f.name)]) {
+			continue
+		}
+
+		evicted, err := m.moveUnpinned(f.name, func() error {
+			if !orphan {
+				// Chunk maps must go with the data, otherwise nydusd believes the chunks cached.
+				if err := m.RemoveBlobCache(blobIDOf(f.name)); err != nil {
+					return errors.Wrapf(err, "evict blob cache %s", f.name)
+				}
+			}
+			if err := os.Remove(slowPath); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "remove blob cache %s", slowPath)
+			}
+			return nil
+		})
+		if err != nil {
+			log.L.WithError(err).Warn("failed to evict slow tier blob cache")
+			continue
+		}
+		if evicted {
+			slowUsage -= f.size
+		}
+	}
+
+	return nil
+}
+
+// Files are always copied rather than renamed, so the original file is replaced
+// atomically and nydusd never sees a missing cache file.
+func (m *Manager) demote(name string) error {
+	fastPath := filepath.Join(m.cacheDir, name)
+	slowPath := filepath.Join(m.tier.SlowDir, name)
+
+	if err := copyFileAtomic(fastPath, slowPath); err != nil {
+		return err
+	}
+
+	link := fastPath + tierTmpSuffix
+	_ = os.Remove(link)
+	if err := os.Symlink(slowPath, link); err != nil {
+		return errors.Wrapf(err, "create symlink to %s", slowPath)
+	}
+	if err := os.Rename(link, fastPath); err != nil {
+		os.Remove(link)
+		return errors.Wrapf(err, "replace %s with symlink", fastPath)
+	}
+
+	log.L.Debugf("demoted blob cache %s to %s", fastPath, slowPath)
+	return nil
+}
+
+func (m *Manager) promote(name string) error {
+	fastPath := filepath.Join(m.cacheDir, name)
+	slowPath := filepath.Join(m.tier.SlowDir, name)
+
+	if target, err := os.Readlink(fastPath); err != nil || target != slowPath {
+		return errors.Errorf("blob cache %s is not demoted to %s", fastPath, slowPath)
+	}
+	if err := copyFileAtomic(slowPath, fastPath); err != nil {
+		return err
+	}
+	if err := os.Remove(slowPath); err != nil {
+		return errors.Wrapf(err, "remove demoted blob cache %s", slowPath)
+	}
+
+	log.L.Debugf("promoted blob cache %s from %s", fastPath, slowPath)
+	return nil
+}
+
+// Copy `src` to a temporary file then rename it to `dst`, keeping holes of the sparse file.
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", src)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", src)
+	}
+
+	tmp := dst + tierTmpSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return errors.Wrapf(err, "create %s", tmp)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	buf := make([]byte, copyBlockSize)
+	zero := make([]byte, copyBlockSize)
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zero[:n]) {
+				if _, err := out.Seek(int64(n), io.SeekCurrent); err != nil {
+					return errors.Wrapf(err, "seek %s", tmp)
+				}
+			} else if _, err := out.Write(buf[:n]); err != nil {
+				return errors.Wrapf(err, "write %s", tmp)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read %s", src)
+		}
+	}
+
+	if err := out.Truncate(info.Size()); err != nil {
+		return errors.Wrapf(err, "truncate %s", tmp)
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %s", tmp)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return errors.Wrapf(err, "rename %s to %s", tmp, dst)
+	}
+
+	return nil
+}
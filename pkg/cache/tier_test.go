@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebalanceTiers(t *testing.T) {
+	cacheDir, slowDir := t.TempDir(), t.TempDir()
+	m, err := NewManager(Opt{
+		CacheDir: cacheDir,
+		Tier:     &TierOpt{SlowDir: slowDir, FastLimit: 12 << 10},
+	})
+	require.NoError(t, err)
+
+	cold, hot := strings.Repeat("a", 64), strings.Repeat("b", 64)+dataFileSuffix
+	data := bytes.Repeat([]byte{1}, 8<<10)
+	for _, name := range []string{cold, hot, cold + chunkMapFileSuffix} {
+		require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), data, 0644))
+	}
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(cacheDir, cold), past, past))
+
+	// The least recently used blob cache is demoted.
+	require.NoError(t, m.RebalanceTiers())
+	target, err := os.Readlink(filepath.Join(cacheDir, cold))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(slowDir, cold), target)
+	content, err := os.ReadFile(filepath.Join(cacheDir, cold))
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+	require.FileExists(t, filepath.Join(cacheDir, cold+chunkMapFileSuffix))
+
+	// Accessed after demotion and the fast tier has room.
+	m.tier.FastLimit = 32 << 10
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(slowDir, cold), future, future))
+	require.NoError(t, m.RebalanceTiers())
+	info, err := os.Lstat(filepath.Join(cacheDir, cold))
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+	require.NoFileExists(t, filepath.Join(slowDir, cold))
+
+	// Blob caches beyond the slow tier limit are evicted with their chunk maps.
+	m.tier.FastLimit = 12 << 10
+	m.tier.SlowLimit = 1
+	require.NoError(t, os.Chtimes(filepath.Join(cacheDir, cold), past, past))
+	require.NoError(t, m.RebalanceTiers())
+	require.NoFileExists(t, filepath.Join(slowDir, cold))
+	_, err = os.Lstat(filepath.Join(cacheDir, cold))
+	require.True(t, os.IsNotExist(err))
+	require.NoFileExists(t, filepath.Join(cacheDir, cold+chunkMapFileSuffix))
+	require.FileExists(t, filepath.Join(cacheDir, hot))
+}
+
+func TestRebalanceTiersSkipsPinned(t *testing.T) {
+	cacheDir, slowDir := t.TempDir(), t.TempDir()
+	m, err := NewManager(Opt{
+		CacheDir: cacheDir,
+		Tier:     &TierOpt{SlowDir: slowDir, FastLimit: 1},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	id := strings.Repeat("a", 64)
+	name := id + dataFileSuffix
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), bytes.Repeat([]byte{1}, 8<<10), 0644))
+
+	// Blob caches of mounted images stay where nydusd writes them.
+	m.PinBlobCaches("1", []string{id})
+	m.PinBlobCaches("2", []string{id})
+	require.NoError(t, m.RebalanceTiers())
+	info, err := os.Lstat(filepath.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+
+	m.UnpinBlobCaches("1")
+	require.NoError(t, m.RebalanceTiers())
+	info, err = os.Lstat(filepath.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+
+	m.UnpinBlobCaches("2")
+	require.NoError(t, m.RebalanceTiers())
+	target, err := os.Readlink(filepath.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(slowDir, name), target)
+}
+
+func TestPinAllBlobCaches(t *testing.T) {
+	cacheDir, slowDir := t.TempDir(), t.TempDir()
+	m, err := NewManager(Opt{
+		CacheDir: cacheDir,
+		Tier:     &TierOpt{SlowDir: slowDir, FastLimit: 1},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	name := strings.Repeat("a", 64) + dataFileSuffix
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), bytes.Repeat([]byte{1}, 8<<10), 0644))
+
+	// Images of unknown blobs may read any blob cache.
+	m.PinBlobCaches("1", nil)
+	require.NoError(t, m.RebalanceTiers())
+	info, err := os.Lstat(filepath.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+
+	m.UnpinBlobCaches("1")
+	require.NoError(t, m.RebalanceTiers())
+	_, err = os.Readlink(filepath.Join(cacheDir, name))
+	require.NoError(t, err)
+}
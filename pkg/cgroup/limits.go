@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cgroup
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups/v3"
+	"github.com/pkg/errors"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Memory limits of cgroup v1 beyond it mean no limit, which is rounded down to pages.
+const unlimitedMemoryV1 = int64(1) << 62
+
+// Limits are resources available to a cgroup, i.e. the tightest limits of the cgroup
+// and its ancestors.
+type Limits struct {
+	// CPUs by CFS bandwidth control, 0 means no limit.
+	CPUs float64
+	// Memory in bytes, 0 means no limit.
+	MemoryBytes int64
+}
+
+// AvailableCPUs returns the number of CPUs available to the cgroup, at least 1.
+func (l Limits) AvailableCPUs() int {
+	cpus := runtime.NumCPU()
+	if l.CPUs > 0 {
+		cpus = min(cpus, int(math.Ceil(l.CPUs)))
+	}
+	return max(cpus, 1)
+}
+
+// DetectLimits returns limits of the cgroup the snapshotter itself runs in, e.g. when
+// deployed as a pod with resource limits.
+func DetectLimits() (Limits, error) {
+	if !supported() {
+		return Limits{}, ErrCgroupNotSupported
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return Limits{}, errors.Wrap(err, "read cgroup of snapshotter")
+	}
+	return detectLimits(cgroupRoot, string(data), cgroups.Mode() == cgroups.Unified)
+}
+
+// `procCgroup` is in format of /proc/<pid>/cgroup, whose lines are
+// `<hierarchy ID>:<controllers>:<path>`.
+func detectLimits(root, procCgroup string, unified bool) (Limits, error) {
+	var limits Limits
+	for _, line := range strings.Split(strings.TrimSpace(procCgroup), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		controllers := strings.Split(fields[1], ",")
+		switch {
+		case unified && fields[0] == "0":
+			if err := walkAncestors(root, fields[2], limits.readV2); err != nil {
+				return Limits{}, err
+			}
+		case !unified && slices.Contains(controllers, "cpu"):
+			if err := walkAncestors(filepath.Join(root, "cpu"), fields[2], limits.readCPUV1); err != nil {
+				return Limits{}, err
+			}
+		case !unified && slices.Contains(controllers, "memory"):
+			if err := walkAncestors(filepath.Join(root, "memory"), fields[2], limits.readMemoryV1); err != nil {
+				return Limits{}, err
+			}
+		}
+	}
+	return limits, nil
+}
+
+// Visits the cgroup `path` in hierarchy mounted at `mount` and its ancestors. Within a
+// cgroup namespace, paths don't exist from the mount, whose root is the own cgroup.
+func walkAncestors(mount, path string, fn func(dir string) error) error {
+	for p := filepath.Clean("/" + path); ; p = filepath.Dir(p) {
+		dir := filepath.Join(mount, p)
+		if _, err := os.Stat(dir); err == nil {
+			if err := fn(dir); err != nil {
+				return err
+			}
+		}
+		if p == "/" {
+			return nil
+		}
+	}
+}
+
+func (l *Limits) setCPUs(cpus float64) {
+	if cpus > 0 && (l.CPUs == 0 || cpus < l.CPUs) {
+		l.CPUs = cpus
+	}
+}
+
+func (l *Limits) setMemory(bytes int64) {
+	if bytes > 0 && (l.MemoryBytes == 0 || bytes < l.MemoryBytes) {
+		l.MemoryBytes = bytes
+	}
+}
+
+// Reads a file of the cgroup, missing files of the root cgroup are taken as empty.
+func readCgroupFile(dir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "read %s of cgroup %s", name, dir)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (l *Limits) readV2(dir string) error {
+	// In format of `$MAX $PERIOD`, `max` means no limit.
+	cpuMax, err := readCgroupFile(dir, "cpu.max")
+	if err != nil {
+		return err
+	}
+	if fields := strings.Fields(cpuMax); len(fields) == 2 && fields[0] != "max" {
+		quota, err1 := strconv.ParseFloat(fields[0], 64)
+		period, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || period <= 0 {
+			return errors.Errorf("invalid cpu.max %q of cgroup %s", cpuMax, dir)
+		}
+		l.setCPUs(quota / period)
+	}
+
+	memoryMax, err := readCgroupFile(dir, "memory.max")
+	if err != nil {
+		return err
+	}
+	if memoryMax != "" && memoryMax != "max" {
+		bytes, err := strconv.ParseInt(memoryMax, 10, 64)
+		if err != nil {
+			return errors.Errorf("invalid memory.max %q of cgroup %s", memoryMax, dir)
+		}
+		l.setMemory(bytes)
+	}
+	return nil
+}
+
+func (l *Limits) readCPUV1(dir string) error {
+	quota, err := readCgroupFile(dir, "cpu.cfs_quota_us")
+	if err != nil || quota == "" {
+		return err
+	}
+	period, err := readCgroupFile(dir, "cpu.cfs_period_us")
+	if err != nil {
+		return err
+	}
+	q, err1 := strconv.ParseFloat(quota, 64)
+	p, err2 := strconv.ParseFloat(period, 64)
+	if err1 != nil || err2 != nil {
+		return errors.Errorf("invalid CFS quota %q or period %q of cgroup %s", quota, period, dir)
+	}
+	// Quota is -1 without limit.
+	if q > 0 && p > 0 {
+		l.setCPUs(q / p)
+	}
+	return nil
+}
+
+func (l *Limits) readMemoryV1(dir string) error {
+	limit, err := readCgroupFile(dir, "memory.limit_in_bytes")
+	if err != nil || limit == "" {
+		return err
+	}
+	bytes, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid memory.limit_in_bytes %q of cgroup %s", limit, dir)
+	}
+	if bytes < unlimitedMemoryV1 {
+		l.setMemory(bytes)
+	}
+	return nil
+}
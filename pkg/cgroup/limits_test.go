@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCgroupFiles(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content+"\n"), 0644))
+	}
+}
+
+func TestDetectLimitsV2(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, filepath.Join(root, "kubepods"), map[string]string{
+		"cpu.max":    "max 100000",
+		"memory.max": "8589934592",
+	})
+	writeCgroupFiles(t, filepath.Join(root, "kubepods", "pod"), map[string]string{
+		"cpu.max":    "150000 100000",
+		"memory.max": "max",
+	})
+
+	limits, err := detectLimits(root, "0::/kubepods/pod\n", true)
+	require.NoError(t, err)
+	require.Equal(t, Limits{CPUs: 1.5, MemoryBytes: 8589934592}, limits)
+
+	// Within a cgroup namespace, the own cgroup is mounted as root.
+	limits, err = detectLimits(filepath.Join(root, "kubepods", "pod"), "0::/\n", true)
+	require.NoError(t, err)
+	require.Equal(t, Limits{CPUs: 1.5}, limits)
+
+	writeCgroupFiles(t, root, map[string]string{"cpu.max": "abc 100000"})
+	_, err = detectLimits(root, "0::/\n", true)
+	require.Error(t, err)
+}
+
+func TestDetectLimitsV1(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, filepath.Join(root, "cpu"), map[string]string{
+		"cpu.cfs_quota_us":  "200000",
+		"cpu.cfs_period_us": "100000",
+	})
+	writeCgroupFiles(t, filepath.Join(root, "memory"), map[string]string{
+		"memory.limit_in_bytes": "9223372036854771712",
+	})
+	writeCgroupFiles(t, filepath.Join(root, "memory", "docker", "abc"), map[string]string{
+		"memory.limit_in_bytes": "1073741824",
+	})
+
+	procCgroup := "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n"
+	limits, err := detectLimits(root, procCgroup, false)
+	require.NoError(t, err)
+	require.Equal(t, Limits{CPUs: 2, MemoryBytes: 1073741824}, limits)
+}
+
+func TestAvailableCPUs(t *testing.T) {
+	require.Equal(t, runtime.NumCPU(), Limits{}.AvailableCPUs())
+	require.Equal(t, 1, Limits{CPUs: 0.5}.AvailableCPUs())
+	require.Equal(t, min(2, runtime.NumCPU()), Limits{CPUs: 1.5}.AvailableCPUs())
+	require.Equal(t, runtime.NumCPU(), Limits{CPUs: float64(runtime.NumCPU() + 8)}.AvailableCPUs())
+}
@@ -0,0 +1,371 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package client is a Go client of the system API served by nydus-snapshotter on
+// a unix domain socket, `/run/containerd-nydus/system.sock` by default, to manage
+// nydusd daemons, prefetch, preload and checkpoint of images on the node.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
+	"github.com/containerd/nydus-snapshotter/pkg/health"
+)
+
+const (
+	DefaultSystemSocket = "/run/containerd-nydus/system.sock"
+
+	endpointDaemons         = "/api/v1/daemons"
+	endpointDaemonRecords   = "/api/v1/daemons/records"
+	endpointDaemonsUpgrade  = "/api/v1/daemons/upgrade"
+	endpointDaemonBackend   = "/api/v1/daemons/%s/backend"
+	endpointDaemonCache     = "/api/v1/daemons/%s/cache"
+	endpointPrefetch        = "/api/v1/prefetch"
+	endpointPrefetchProfile = "/api/v1/prefetch/profile"
+	endpointImageExport     = "/api/v1/images/export"
+	endpointCheckpoint      = "/api/v1/checkpoint"
+	endpointRestore         = "/api/v1/restore"
+	endpointPreload         = "/api/v1/preload"
+	endpointPreloadJob      = "/api/v1/preload/%s"
+	endpointFaults          = "/api/v1/faults"
+	endpointEvents          = "/api/v1/events"
+	endpointBlobReferences  = "/api/v1/blobs/%s/references"
+	endpointLiveness        = "/api/v1/health/live"
+	endpointReadiness       = "/api/v1/health/ready"
+
+	jsonContentType = "application/json"
+)
+
+// Client of the system API. Requests are bounded by their contexts, as some of them,
+// e.g. upgrading daemons or exporting images, take long.
+type Client struct {
+	httpClient *http.Client
+}
+
+// New returns a client talking to the system API listening on unix socket `sock`.
+func New(sock string) *Client {
+	if sock == "" {
+		sock = DefaultSystemSocket
+	}
+	transport := &http.Transport{
+		MaxIdleConns:    10,
+		IdleConnTimeout: 10 * time.Second,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, "unix", sock)
+		},
+	}
+	return &Client{httpClient: &http.Client{Transport: transport}}
+}
+
+// Daemons lists nydusd daemons managed by the snapshotter and their file system instances.
+func (c *Client) Daemons(ctx context.Context) ([]DaemonInfo, error) {
+	var daemons []DaemonInfo
+	if err := c.do(ctx, http.MethodGet, endpointDaemons, nil, &daemons); err != nil {
+		return nil, errors.Wrap(err, "list daemons")
+	}
+	return daemons, nil
+}
+
+// DaemonRecords lists states of daemons persisted in the snapshotter database, which
+// can't be opened while the snapshotter is running.
+func (c *Client) DaemonRecords(ctx context.Context) ([]DaemonRecord, error) {
+	var records []DaemonRecord
+	if err := c.do(ctx, http.MethodGet, endpointDaemonRecords, nil, &records); err != nil {
+		return nil, errors.Wrap(err, "list daemon records")
+	}
+	return records, nil
+}
+
+// DaemonBackend returns the storage backend of daemon `id`, errdefs.ErrNotFound if no such daemon.
+func (c *Client) DaemonBackend(ctx context.Context, id string) (*Backend, error) {
+	var backend Backend
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf(endpointDaemonBackend, id), nil, &backend); err != nil {
+		return nil, errors.Wrapf(err, "get backend of daemon %s", id)
+	}
+	return &backend, nil
+}
+
+// DaemonCacheStats returns cache hit ratio and prefetch coverage of RAFS instances of daemon
+// `id` since they are mounted, errdefs.ErrNotFound if no such daemon.
+func (c *Client) DaemonCacheStats(ctx context.Context, id string) (*DaemonCacheStats, error) {
+	var stats DaemonCacheStats
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf(endpointDaemonCache, id), nil, &stats); err != nil {
+		return nil, errors.Wrapf(err, "get cache stats of daemon %s", id)
+	}
+	return &stats, nil
+}
+
+// UpgradeDaemons live upgrades all daemons to the nydusd binary of the request.
+func (c *Client) UpgradeDaemons(ctx context.Context, req UpgradeRequest) error {
+	if err := c.do(ctx, http.MethodPut, endpointDaemonsUpgrade, req, nil); err != nil {
+		return errors.Wrapf(err, "upgrade daemons to %s", req.NydusdPath)
+	}
+	return nil
+}
+
+// SetPrefetchFiles sets prefetch patterns, one per line, of images keyed by image reference,
+// which take effect when the images are mounted afterwards.
+func (c *Client) SetPrefetchFiles(ctx context.Context, patterns map[string]string) error {
+	items := make([]map[string]string, 0, len(patterns))
+	for image, p := range patterns {
+		items = append(items, map[string]string{"image": image, "prefetch": p})
+	}
+	if err := c.do(ctx, http.MethodPut, endpointPrefetch, items, nil); err != nil {
+		return errors.Wrap(err, "set prefetch files")
+	}
+	return nil
+}
+
+// BuildPrefetchProfile merges access traces of an image into a ranked prefetch list.
+func (c *Client) BuildPrefetchProfile(ctx context.Context, req PrefetchProfileRequest) (*PrefetchProfile, error) {
+	var profile PrefetchProfile
+	if err := c.do(ctx, http.MethodPost, endpointPrefetchProfile, req, &profile); err != nil {
+		return nil, errors.Wrapf(err, "build prefetch profile of image %s", req.Image)
+	}
+	return &profile, nil
+}
+
+// ExportImage streams the nydus image, mounted or located by its meta layer snapshot, as
+// an OCI image archive. The caller must close the returned reader. A truncated archive
+// means the export fails after it has started.
+func (c *Client) ExportImage(ctx context.Context, snapshotID, image string) (io.ReadCloser, error) {
+	req := struct {
+		SnapshotID string `json:"snapshot_id"`
+		Image      string `json:"image"`
+	}{snapshotID, image}
+	resp, err := c.request(ctx, http.MethodPost, endpointImageExport, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "export image %s", image)
+	}
+	return resp.Body, nil
+}
+
+// Checkpoint drains requests to the image before CRIU dumps a container running on it.
+func (c *Client) Checkpoint(ctx context.Context, req CheckpointRequest) (*CheckpointRecord, error) {
+	var record CheckpointRecord
+	if err := c.do(ctx, http.MethodPost, endpointCheckpoint, req, &record); err != nil {
+		return nil, errors.Wrapf(err, "checkpoint image %s", req.Image)
+	}
+	return &record, nil
+}
+
+// Restore re-establishes the image of a checkpoint before the container is restored. It
+// returns true if the image is mounted and served, otherwise it's warmed up when mounted.
+func (c *Client) Restore(ctx context.Context, record *CheckpointRecord) (bool, error) {
+	var resp struct {
+		Ready bool `json:"ready"`
+	}
+	if err := c.do(ctx, http.MethodPost, endpointRestore, record, &resp); err != nil {
+		return false, errors.Wrapf(err, "restore image %s", record.ImageID)
+	}
+	return resp.Ready, nil
+}
+
+// Preload submits a job warming up images on the node in background.
+func (c *Client) Preload(ctx context.Context, images []string) (*PreloadJob, error) {
+	req := struct {
+		Images []string `json:"images"`
+	}{images}
+	var job PreloadJob
+	if err := c.do(ctx, http.MethodPost, endpointPreload, req, &job); err != nil {
+		return nil, errors.Wrap(err, "preload images")
+	}
+	return &job, nil
+}
+
+// PreloadJob queries progress of a preload job.
+func (c *Client) PreloadJob(ctx context.Context, id string) (*PreloadJob, error) {
+	var job PreloadJob
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf(endpointPreloadJob, id), nil, &job); err != nil {
+		return nil, errors.Wrapf(err, "get preload job %s", id)
+	}
+	return &job, nil
+}
+
+// Faults returns fault injection rules in effect, it fails unless fault injection is
+// enabled by configuration.
+func (c *Client) Faults(ctx context.Context) ([]fault.Rule, error) {
+	var rules []fault.Rule
+	if err := c.do(ctx, http.MethodGet, endpointFaults, nil, &rules); err != nil {
+		return nil, errors.Wrap(err, "get fault rules")
+	}
+	return rules, nil
+}
+
+// SetFaults replaces all fault injection rules, an empty list stops injecting.
+func (c *Client) SetFaults(ctx context.Context, rules []fault.Rule) error {
+	if rules == nil {
+		rules = []fault.Rule{}
+	}
+	if err := c.do(ctx, http.MethodPut, endpointFaults, rules, nil); err != nil {
+		return errors.Wrap(err, "set fault rules")
+	}
+	return nil
+}
+
+// Subscribe calls `fn` with events of `topics`, or all the events if no topic is
+// specified, as they happen until `fn` fails or `ctx` is canceled.
+func (c *Client) Subscribe(ctx context.Context, topics []string, fn func(Event) error) error {
+	query := url.Values{"topic": topics}
+	endpoint := endpointEvents
+	if len(topics) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	resp, err := c.request(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return errors.Wrap(err, "subscribe events")
+	}
+	defer resp.Body.Close()
+
+	// Server-sent events, only `data` lines carry the events in JSON.
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return errors.Wrap(err, "decode event")
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read events")
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// BlobReferences looks up snapshots, images and containers depending on blob `id`, which
+// is taken with or without the `sha256:` prefix.
+func (c *Client) BlobReferences(ctx context.Context, id string) (*BlobReferences, error) {
+	var refs BlobReferences
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf(endpointBlobReferences, id), nil, &refs); err != nil {
+		return nil, errors.Wrapf(err, "get references of blob %s", id)
+	}
+	return &refs, nil
+}
+
+// Live tells whether the snapshotter answers.
+func (c *Client) Live(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodGet, endpointLiveness, nil, nil); err != nil {
+		return errors.Wrap(err, "check liveness")
+	}
+	return nil
+}
+
+// Ready runs checks of components the snapshotter depends on, a report of failed
+// components is returned without error if the snapshotter isn't ready.
+func (c *Client) Ready(ctx context.Context) (*health.Report, error) {
+	resp, err := c.send(ctx, http.MethodGet, endpointReadiness, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "check readiness")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, errors.Wrap(parseErrorMessage(resp), "check readiness")
+	}
+
+	var report health.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, errors.Wrap(err, "decode readiness report")
+	}
+	return &report, nil
+}
+
+// Send the request and decode the JSON response into `v` if it's not nil.
+func (c *Client) do(ctx context.Context, method, endpoint string, body, v any) error {
+	resp, err := c.request(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "decode response")
+	}
+	return nil
+}
+
+func (c *Client) request(ctx context.Context, method, endpoint string, body any) (*http.Response, error) {
+	resp, err := c.send(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		defer resp.Body.Close()
+		return nil, parseErrorMessage(resp)
+	}
+	return resp, nil
+}
+
+// Send the request regardless of the response status.
+func (c *Client) send(ctx context.Context, method, endpoint string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal request")
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://unix"+endpoint, r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "construct request %s", endpoint)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", jsonContentType)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// The system API reports errors in JSON like `{"code": "Unknown", "message": "..."}`.
+func parseErrorMessage(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := strings.TrimSpace(string(data))
+	var m errorMessage
+	if err := json.Unmarshal(data, &m); err == nil && m.Message != "" {
+		msg = m.Message
+	}
+
+	var err error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = errdefs.ErrNotFound
+	case http.StatusBadRequest:
+		err = errdefs.ErrInvalidArgument
+	case http.StatusNotImplemented:
+		err = errdefs.ErrNotImplemented
+	default:
+		return errors.Errorf("http response: %d, error message: %s", resp.StatusCode, msg)
+	}
+	return errors.Wrapf(err, "http response: %d, error message: %s", resp.StatusCode, msg)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
+	"github.com/containerd/nydus-snapshotter/pkg/health"
+)
+
+func serve(t *testing.T, mux *http.ServeMux) *Client {
+	sock := filepath.Join(t.TempDir(), "system.sock")
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	server := &http.Server{Handler: mux}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { server.Close() })
+	return New(sock)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	var rules []fault.Rule
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+endpointDaemons, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[{"id": "d1", "pid": 10, "instances": {"1": {"snapshot_id": "1", "image_id": "img"}}}]`))
+	})
+	mux.HandleFunc("GET "+endpointDaemonRecords, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[{"ID": "d1", "ProcessID": 10, "DaemonMode": "dedicated", "FsDriver": "fusedev"}]`))
+	})
+	mux.HandleFunc("GET /api/v1/daemons/{id}/backend", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "d1" {
+			http.Error(w, `{"code": "Unknown", "message": "not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"type": "registry", "config": {"host": "docker.io"}}`))
+	})
+	mux.HandleFunc("GET /api/v1/blobs/{id}/references", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "sha256:abc", r.PathValue("id"))
+		_, _ = w.Write([]byte(`{"blob_id": "abc", "snapshots": [{"key": "1", "kind": "Committed"}], "images": ["img"], "containers": []}`))
+	})
+	mux.HandleFunc("GET "+endpointLiveness, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET "+endpointReadiness, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"ready": false, "components": [{"name": "daemons", "error": "hung"}]}`))
+	})
+	mux.HandleFunc("GET /api/v1/daemons/{id}/cache", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"daemon_id": "d1", "instances": [{"snapshot_id": "1", "reads": 4, "hit_ratio": 0.5, "prefetch_coverage": 0.25}]}`))
+	})
+	mux.HandleFunc("POST "+endpointPreload, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Images []string `json:"images"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if len(req.Images) == 0 {
+			http.Error(w, `{"code": "Unknown", "message": "no image to preload"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "job", "images": [{"image": "img", "state": "pending"}]}`))
+	})
+	mux.HandleFunc("PUT "+endpointFaults, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&rules))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+endpointImageExport, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("archive"))
+	})
+	mux.HandleFunc("GET "+endpointEvents, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, []string{"mount"}, r.URL.Query()["topic"])
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, ": keepalive\n\n")
+		_, _ = io.WriteString(w, "event: mount\ndata: {\"topic\": \"mount\", \"attributes\": {\"action\": \"mount\"}}\n\n")
+		_, _ = io.WriteString(w, "event: mount\ndata: {\"topic\": \"mount\", \"attributes\": {\"action\": \"umount\"}}\n\n")
+	})
+	c := serve(t, mux)
+
+	daemons, err := c.Daemons(ctx)
+	require.NoError(t, err)
+	require.Len(t, daemons, 1)
+	require.Equal(t, "d1", daemons[0].ID)
+	require.Equal(t, "img", daemons[0].Instances["1"].ImageID)
+
+	records, err := c.DaemonRecords(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []DaemonRecord{{ID: "d1", ProcessID: 10, DaemonMode: "dedicated", FsDriver: "fusedev"}}, records)
+
+	backend, err := c.DaemonBackend(ctx, "d1")
+	require.NoError(t, err)
+	require.Equal(t, "registry", backend.Type)
+	require.Equal(t, "docker.io", backend.Config["host"])
+	_, err = c.DaemonBackend(ctx, "d2")
+	require.True(t, errdefs.IsNotFound(err))
+	require.ErrorContains(t, err, "not found")
+
+	stats, err := c.DaemonCacheStats(ctx, "d1")
+	require.NoError(t, err)
+	require.Len(t, stats.Instances, 1)
+	require.Equal(t, 0.5, stats.Instances[0].HitRatio)
+	require.Nil(t, stats.Instances[0].OnDemandBytes)
+	require.Equal(t, 0.25, *stats.Instances[0].PrefetchCoverage)
+
+	refs, err := c.BlobReferences(ctx, "sha256:abc")
+	require.NoError(t, err)
+	require.Equal(t, []SnapshotReference{{Key: "1", Kind: "Committed"}}, refs.Snapshots)
+	require.Equal(t, []string{"img"}, refs.Images)
+
+	require.NoError(t, c.Live(ctx))
+	report, err := c.Ready(ctx)
+	require.NoError(t, err)
+	require.False(t, report.Ready)
+	require.Equal(t, []health.ComponentReport{{Name: "daemons", Error: "hung"}}, report.Components)
+
+	job, err := c.Preload(ctx, []string{"img"})
+	require.NoError(t, err)
+	require.Equal(t, "job", job.ID)
+	require.Equal(t, PreloadStatePending, job.Images[0].State)
+	_, err = c.Preload(ctx, nil)
+	require.ErrorIs(t, err, errdefs.ErrInvalidArgument)
+	require.ErrorContains(t, err, "no image to preload")
+
+	require.NoError(t, c.SetFaults(ctx, nil))
+	require.NotNil(t, rules)
+	require.Empty(t, rules)
+
+	archive, err := c.ExportImage(ctx, "", "img")
+	require.NoError(t, err)
+	data, err := io.ReadAll(archive)
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	require.Equal(t, "archive", string(data))
+
+	received := []Event{}
+	err = c.Subscribe(ctx, []string{"mount"}, func(ev Event) error {
+		received = append(received, ev)
+		if len(received) == 2 {
+			return context.Canceled
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, "mount", received[0].Topic)
+	require.Equal(t, "umount", received[1].Attributes["action"])
+
+	// Endpoints not served, e.g. faults without fault injection enabled.
+	_, err = c.Faults(ctx)
+	require.Error(t, err)
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package client
+
+import "time"
+
+type DaemonInfo struct {
+	ID                    string  `json:"id"`
+	Pid                   int     `json:"pid"`
+	APISock               string  `json:"api_socket"`
+	SupervisorPath        string  `json:"supervisor_path"`
+	Reference             int     `json:"reference"`
+	HostMountpoint        string  `json:"mountpoint"`
+	StartupCPUUtilization float64 `json:"startup_cpu_utilization"`
+	MemoryRSS             float64 `json:"memory_rss_kb"`
+	ReadData              float32 `json:"read_data_kb"`
+
+	// Keyed by snapshot ID.
+	Instances map[string]RafsInstanceInfo `json:"instances"`
+}
+
+type RafsInstanceInfo struct {
+	SnapshotID  string `json:"snapshot_id"`
+	SnapshotDir string `json:"snapshot_dir"`
+	Mountpoint  string `json:"mountpoint"`
+	ImageID     string `json:"image_id"`
+}
+
+// Persisted state of a daemon.
+type DaemonRecord struct {
+	ID              string
+	ProcessID       int
+	APISocket       string
+	DaemonMode      string
+	FsDriver        string
+	LogDir          string
+	LogLevel        string
+	LogRotationSize int
+	LogToStdout     bool
+	Mountpoint      string
+	SupervisorPath  string
+	ThreadNum       int
+	SandboxID       string
+	ConfigDir       string
+}
+
+// Storage backend of a daemon, `Config` is the backend section of nydusd configuration.
+type Backend struct {
+	Type   string         `json:"type"`
+	Config map[string]any `json:"config"`
+}
+
+type InstanceCacheStats struct {
+	SnapshotID string `json:"snapshot_id"`
+	ImageID    string `json:"image_id"`
+	// Reads of blob data, and the fraction of them served by blob cache wholly or partially.
+	Reads    uint64  `json:"reads"`
+	HitRatio float64 `json:"hit_ratio"`
+	// Bytes fetched from backend by prefetch.
+	PrefetchBytes uint64 `json:"prefetch_bytes"`
+	// Absent if nydusd does not serve backend metrics.
+	OnDemandBytes    *uint64  `json:"on_demand_bytes,omitempty"`
+	PrefetchCoverage *float64 `json:"prefetch_coverage,omitempty"`
+	// Why metrics of the instance are not available, e.g. nydusd is restarting.
+	Error string `json:"error,omitempty"`
+}
+
+type DaemonCacheStats struct {
+	DaemonID  string               `json:"daemon_id"`
+	Instances []InstanceCacheStats `json:"instances"`
+}
+
+type UpgradeRequest struct {
+	NydusdPath string `json:"nydusd_path"`
+	Version    string `json:"version"`
+	// Either "rolling" or "immediate".
+	Policy string `json:"policy"`
+}
+
+type PrefetchProfileRequest struct {
+	Image string `json:"image"`
+	// Access recordings persisted by optimizer-nri-plugin, one per container.
+	Traces []string `json:"traces"`
+	// Maximum number of files in the profile, 0 means no limit.
+	Limit int `json:"limit"`
+	// Use the profile as prefetch list for nydusd serving the image afterwards.
+	Apply bool `json:"apply"`
+}
+
+type PrefetchProfile struct {
+	Image string   `json:"image"`
+	Files []string `json:"files"`
+	// Prefetch patterns for nydus image builder.
+	Patterns string `json:"patterns"`
+}
+
+type CheckpointRequest struct {
+	SnapshotID string `json:"snapshot_id"`
+	Image      string `json:"image"`
+	// Seconds to wait for in-flight FUSE requests to drain, 0 takes the default.
+	Timeout int `json:"timeout"`
+}
+
+// CheckpointRecord should be saved with the CRIU images and handed to Restore.
+type CheckpointRecord struct {
+	ImageID    string `json:"image_id"`
+	SnapshotID string `json:"snapshot_id"`
+	FsDriver   string `json:"fs_driver"`
+	// Bootstrap of the image converted locally, which is mounted with locally converted blobs.
+	LocalBootstrap string `json:"local_bootstrap,omitempty"`
+}
+
+type PreloadState string
+
+const (
+	PreloadStatePending  PreloadState = "pending"
+	PreloadStatePulling  PreloadState = "pulling"
+	PreloadStateMounting PreloadState = "mounting"
+	PreloadStateDone     PreloadState = "done"
+	PreloadStateFailed   PreloadState = "failed"
+)
+
+type PreloadImageProgress struct {
+	Image string       `json:"image"`
+	State PreloadState `json:"state"`
+	Error string       `json:"error,omitempty"`
+}
+
+type PreloadJob struct {
+	ID        string                 `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Images    []PreloadImageProgress `json:"images"`
+	Completed int                    `json:"completed"`
+	Failed    int                    `json:"failed"`
+	Done      bool                   `json:"done"`
+}
+
+// Event of a state change, topic is one of "daemon", "mount", "gc" and "preload".
+type Event struct {
+	Topic      string            `json:"topic"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type SnapshotReference struct {
+	Key string `json:"key"`
+	// Kind of the snapshot, e.g. "Committed" or "Active".
+	Kind string `json:"kind"`
+}
+
+// Containers are named by keys of active snapshots, which are container IDs with CRI.
+type BlobReferences struct {
+	BlobID     string              `json:"blob_id"`
+	Snapshots  []SnapshotReference `json:"snapshots"`
+	Images     []string            `json:"images"`
+	Containers []string            `json:"containers"`
+	// Holders of the blob in the shared blob store.
+	Holders []string `json:"holders,omitempty"`
+}
+
+type errorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
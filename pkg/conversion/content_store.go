@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+const (
+	DefaultContentNamespace = "k8s.io"
+	DefaultContentRoot      = "/var/lib/containerd/io.containerd.content.v1.content"
+
+	// Set on contents of OCI layers to reference the converted artifacts, so that they're
+	// kept by containerd as long as the OCI layers.
+	labelGCRefBlob      = "containerd.io/gc.ref.content.nydus-blob"
+	labelGCRefBootstrap = "containerd.io/gc.ref.content.nydus-bootstrap"
+	// Protects artifacts from containerd GC until they are referenced.
+	ingestLeaseExpiration = time.Hour
+)
+
+// Keeps converted artifacts in the content store of containerd, where they take part
+// in containerd GC through references of the OCI layer contents, and are deduplicated
+// with identical contents of other images.
+type contentStore struct {
+	address string
+	// Connected on first use since containerd may start after snapshotter.
+	mutex  sync.Mutex
+	cs     content.Store
+	leases leases.Manager
+	// Namespace of the OCI layer contents.
+	namespace string
+	// Root directory of the local content store, where nydusd reads blobs from.
+	root string
+}
+
+func newContentStore(address, namespace, root string) *contentStore {
+	if namespace == "" {
+		namespace = DefaultContentNamespace
+	}
+	if root == "" {
+		root = DefaultContentRoot
+	}
+
+	return &contentStore{
+		address:   address,
+		namespace: namespace,
+		root:      root,
+	}
+}
+
+func (s *contentStore) connect() (content.Store, leases.Manager, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cs == nil {
+		c, err := client.New(s.address, client.WithDefaultNamespace(s.namespace))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "connect to containerd %s", s.address)
+		}
+		s.cs = c.ContentStore()
+		s.leases = c.LeasesService()
+	}
+	return s.cs, s.leases, nil
+}
+
+func (s *contentStore) blobDir() string {
+	return filepath.Join(s.root, "blobs", string(digest.SHA256))
+}
+
+func (s *contentStore) bootstrapPath(ctx context.Context, layerDigest digest.Digest) (string, error) {
+	cs, _, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	ctx = namespaces.WithNamespace(ctx, s.namespace)
+	info, err := cs.Info(ctx, layerDigest)
+	if err != nil {
+		return "", errors.Wrapf(err, "get content info of layer %s", layerDigest)
+	}
+	bootstrapDigest, err := digest.Parse(info.Labels[labelGCRefBootstrap])
+	if err != nil {
+		return "", errors.Wrapf(errdefs.ErrNotFound, "bootstrap of layer %s", layerDigest)
+	}
+
+	// Referenced contents are never removed, so the file is expected to exist.
+	p := filepath.Join(s.blobDir(), bootstrapDigest.Hex())
+	if _, err := os.Stat(p); err != nil {
+		return "", errors.Wrapf(err, "bootstrap of layer %s", layerDigest)
+	}
+	return p, nil
+}
+
+func (s *contentStore) commit(ctx context.Context, layerDigest, blobDigest digest.Digest, blobFile, bootstrapFile string) error {
+	cs, lm, err := s.connect()
+	if err != nil {
+		return err
+	}
+	ctx = namespaces.WithNamespace(ctx, s.namespace)
+	if lm != nil {
+		l, err := lm.Create(ctx, leases.WithRandomID(), leases.WithExpiration(ingestLeaseExpiration))
+		if err != nil {
+			return errors.Wrap(err, "create lease for converted contents")
+		}
+		defer func() {
+			if err := lm.Delete(ctx, l); err != nil {
+				log.L.WithError(err).Warnf("failed to delete lease %s", l.ID)
+			}
+		}()
+		ctx = leases.WithLease(ctx, l.ID)
+	}
+
+	bootstrapDigest, err := digestFile(bootstrapFile)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{converter.LayerAnnotationNydusSourceDigest: layerDigest.String()}
+	if err := ingest(ctx, cs, blobFile, blobDigest, labels); err != nil {
+		return err
+	}
+	if err := ingest(ctx, cs, bootstrapFile, bootstrapDigest, labels); err != nil {
+		return err
+	}
+
+	info := content.Info{
+		Digest: layerDigest,
+		Labels: map[string]string{
+			labelGCRefBlob:      blobDigest.String(),
+			labelGCRefBootstrap: bootstrapDigest.String(),
+		},
+	}
+	if _, err := cs.Update(ctx, info, "labels."+labelGCRefBlob, "labels."+labelGCRefBootstrap); err != nil {
+		return errors.Wrapf(err, "reference converted contents from layer %s", layerDigest)
+	}
+
+	return nil
+}
+
+// Write the file into content store with expected digest, it's a no-op if the
+// content exists already.
+func ingest(ctx context.Context, cs content.Ingester, file string, d digest.Digest, labels map[string]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "open file %s", file)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat file %s", file)
+	}
+
+	desc := ocispec.Descriptor{Digest: d, Size: st.Size()}
+	if err := content.WriteBlob(ctx, cs, "nydus-conversion-"+d.Hex(), f, desc, content.WithLabels(labels)); err != nil {
+		return errors.Wrapf(err, "write content %s", d)
+	}
+	return nil
+}
+
+func digestFile(file string) (digest.Digest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "open file %s", file)
+	}
+	defer f.Close()
+	d, err := digest.Canonical.FromReader(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "digest file %s", file)
+	}
+	return d, nil
+}
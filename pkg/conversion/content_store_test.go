@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+type labelStore map[digest.Digest]map[string]string
+
+func (s labelStore) Get(d digest.Digest) (map[string]string, error) {
+	return s[d], nil
+}
+
+func (s labelStore) Set(d digest.Digest, labels map[string]string) error {
+	s[d] = labels
+	return nil
+}
+
+func (s labelStore) Update(d digest.Digest, update map[string]string) (map[string]string, error) {
+	if s[d] == nil {
+		s[d] = map[string]string{}
+	}
+	for k, v := range update {
+		if v == "" {
+			delete(s[d], k)
+		} else {
+			s[d][k] = v
+		}
+	}
+	return s[d], nil
+}
+
+func TestContentStore(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	cs, err := local.NewLabeledStore(root, labelStore{})
+	require.NoError(t, err)
+	s := newContentStore("", "default", root)
+	s.cs = cs
+
+	layer := []byte("layer")
+	layerDigest := digest.FromBytes(layer)
+	_, err = s.bootstrapPath(ctx, layerDigest)
+	require.Error(t, err)
+	require.NoError(t, content.WriteBlob(ctx, cs, "layer", bytes.NewReader(layer),
+		ocispec.Descriptor{Digest: layerDigest, Size: int64(len(layer))}))
+	_, err = s.bootstrapPath(ctx, layerDigest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	workDir := t.TempDir()
+	blobFile := filepath.Join(workDir, "blob")
+	bootstrapFile := filepath.Join(workDir, "image.boot")
+	require.NoError(t, os.WriteFile(blobFile, []byte("blob"), 0640))
+	require.NoError(t, os.WriteFile(bootstrapFile, []byte("boot"), 0640))
+	blobDigest := digest.FromString("blob")
+	require.NoError(t, s.commit(ctx, layerDigest, blobDigest, blobFile, bootstrapFile))
+
+	// Converted contents are referenced by the layer and readable by nydusd.
+	info, err := cs.Info(ctx, layerDigest)
+	require.NoError(t, err)
+	require.Equal(t, blobDigest.String(), info.Labels[labelGCRefBlob])
+	require.Equal(t, digest.FromString("boot").String(), info.Labels[labelGCRefBootstrap])
+	require.FileExists(t, filepath.Join(s.blobDir(), blobDigest.Hex()))
+	p, err := s.bootstrapPath(ctx, layerDigest)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(s.blobDir(), digest.FromString("boot").Hex()), p)
+
+	// Committing again is fine, e.g. layer converted by another image.
+	require.NoError(t, s.commit(ctx, layerDigest, blobDigest, blobFile, bootstrapFile))
+}
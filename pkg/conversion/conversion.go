@@ -0,0 +1,593 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package conversion converts OCI image layers into nydus format on the
+// local host, for images which have neither nydus layers nor a referenced
+// nydus manifest in registry.
+package conversion
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/conversion/worker"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/converter/tool"
+	"github.com/containerd/nydus-snapshotter/pkg/gc"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+const (
+	LayerStatusConverting = 1
+	LayerStatusReady      = 2
+	LayerStatusFailed     = 3
+)
+
+const defaultMaxConcurrentProcess = 2
+
+type Opt struct {
+	Insecure       bool
+	WorkDir        string
+	NydusImagePath string
+	// Maximum number of layers converted in parallel, 0 means default.
+	MaxConcurrentProcess int
+	// Conversion of a layer is aborted once exceeding it, 0 means no limit.
+	JobTimeout time.Duration
+	// Layers of compressed size larger than it are not converted, 0 means no limit.
+	MaxLayerSize int64
+	// Address of remote converter worker to offload conversion to, empty means
+	// converting by local builder.
+	WorkerAddress string
+	WorkerTLS     worker.TLSOpt
+	// Address of containerd to keep converted artifacts in its content store, empty
+	// means keeping them in `WorkDir`.
+	ContainerdAddress string
+	// Namespace of OCI layer contents, DefaultContentNamespace if empty.
+	ContentNamespace string
+	// Root directory of the content store, DefaultContentRoot if empty.
+	ContentRoot string
+	// Published with chunks of converted blobs if not nil.
+	ChunkIndex converter.ChunkIndex
+	// Shared blob store of the cache manager to keep converted blobs, instead of
+	// `WorkDir`, if not nil. It's not used with `ContainerdAddress`.
+	BlobStore *cache.BlobStore
+}
+
+type Manager struct {
+	mutex          sync.Mutex
+	layers         map[digest.Digest]int // conversion status, indexed by OCI layer digest
+	workDir        string
+	nydusImagePath string
+	insecure       bool
+	maxRunning     int
+	jobTimeout     time.Duration
+	maxLayerSize   int64
+	worker         *worker.Client
+	tracker        gc.Tracker
+	store          store
+	chunkIndex     converter.ChunkIndex
+
+	queue      jobQueue
+	pending    map[digest.Digest]*job
+	running    map[digest.Digest]*job
+	preempting int
+	seq        uint64
+	// Diff IDs of layers from configs of images, to seed layers of the same content.
+	diffIDs map[digest.Digest]digest.Digest
+	// Converts a layer, replaceable for testing.
+	convert func(ctx context.Context, ref string, layerDigest digest.Digest) error
+}
+
+func NewManager(opt Opt) (*Manager, error) {
+	if opt.MaxConcurrentProcess <= 0 {
+		opt.MaxConcurrentProcess = defaultMaxConcurrentProcess
+	}
+
+	m := &Manager{
+		layers:         map[digest.Digest]int{},
+		workDir:        opt.WorkDir,
+		nydusImagePath: opt.NydusImagePath,
+		chunkIndex:     opt.ChunkIndex,
+		insecure:       opt.Insecure,
+		maxRunning:     opt.MaxConcurrentProcess,
+		jobTimeout:     opt.JobTimeout,
+		maxLayerSize:   opt.MaxLayerSize,
+		pending:        map[digest.Digest]*job{},
+		running:        map[digest.Digest]*job{},
+		diffIDs:        map[digest.Digest]digest.Digest{},
+	}
+	m.convert = m.convertLayer
+
+	if err := os.MkdirAll(m.tmpDir(), 0750); err != nil {
+		return nil, errors.Wrapf(err, "create directory %s", m.tmpDir())
+	}
+
+	if opt.ContainerdAddress != "" {
+		m.store = newContentStore(opt.ContainerdAddress, opt.ContentNamespace, opt.ContentRoot)
+	} else {
+		s, err := newDirStore(m.workDir, &m.tracker, opt.BlobStore)
+		if err != nil {
+			return nil, err
+		}
+		m.store = s
+	}
+
+	if opt.WorkerAddress != "" {
+		c, err := worker.NewClient(opt.WorkerAddress, opt.WorkerTLS)
+		if err != nil {
+			return nil, err
+		}
+		m.worker = c
+		log.L.Infof("offload local conversion to worker %s", opt.WorkerAddress)
+	}
+
+	return m, nil
+}
+
+// Close disconnects from the converter worker, conversions in progress fail.
+func (m *Manager) Close() error {
+	if m.worker == nil {
+		return nil
+	}
+	return m.worker.Close()
+}
+
+// Directory hosting converted nydus blobs, named by blob ID. It's used as
+// the localfs storage backend of nydusd.
+func (m *Manager) BlobDir() string {
+	return m.store.blobDir()
+}
+
+func (m *Manager) tmpDir() string {
+	return filepath.Join(m.workDir, "tmp")
+}
+
+// IsLayerReady checks whether the layer has been converted, possibly by
+// a previous run of snapshotter.
+func (m *Manager) IsLayerReady(layerDigest digest.Digest) bool {
+	m.mutex.Lock()
+	status, ok := m.layers[layerDigest]
+	m.mutex.Unlock()
+	if ok {
+		return status == LayerStatusReady
+	}
+
+	if _, err := m.store.bootstrapPath(context.Background(), layerDigest); err == nil {
+		m.mutex.Lock()
+		m.layers[layerDigest] = LayerStatusReady
+		m.mutex.Unlock()
+		return true
+	}
+
+	return false
+}
+
+// ConvertLayer schedules a background conversion of the OCI layer. Layers being
+// converted or already converted are skipped, failed ones are retried. A layer
+// scheduled again with higher priority is promoted.
+func (m *Manager) ConvertLayer(ref string, layerDigest digest.Digest, priority Priority) {
+	if m.IsLayerReady(layerDigest) {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if j, ok := m.pending[layerDigest]; ok {
+		if priority > j.priority {
+			j.priority = priority
+			heap.Fix(&m.queue, j.index)
+			m.scheduleLocked()
+		}
+		return
+	}
+	if j, ok := m.running[layerDigest]; ok {
+		if priority > j.priority {
+			j.priority = priority
+		}
+		return
+	}
+
+	m.layers[layerDigest] = LayerStatusConverting
+	m.seq++
+	j := &job{
+		ref:         ref,
+		layerDigest: layerDigest,
+		priority:    priority,
+		seq:         m.seq,
+	}
+	m.pending[layerDigest] = j
+	heap.Push(&m.queue, j)
+	m.scheduleLocked()
+}
+
+// Start pending jobs in order of priority. If all slots are taken, a running job of
+// lower priority than the most urgent pending one is canceled and queued again.
+func (m *Manager) scheduleLocked() {
+	for m.queue.Len() > 0 {
+		next := m.queue[0]
+		if len(m.running) < m.maxRunning {
+			heap.Pop(&m.queue)
+			delete(m.pending, next.layerDigest)
+			m.startLocked(next)
+			continue
+		}
+
+		// Wait for the slot of the job being preempted.
+		if m.preempting > 0 {
+			return
+		}
+		var victim *job
+		for _, j := range m.running {
+			if j.priority >= next.priority || j.preempted {
+				continue
+			}
+			// Preempt the least urgent and most recently scheduled one.
+			if victim == nil || j.priority < victim.priority || (j.priority == victim.priority && j.seq > victim.seq) {
+				victim = j
+			}
+		}
+		if victim != nil {
+			log.L.Infof("preempt conversion of layer %s for layer %s", victim.layerDigest, next.layerDigest)
+			victim.preempted = true
+			m.preempting++
+			victim.cancel()
+		}
+		return
+	}
+}
+
+func (m *Manager) startLocked(j *job) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if m.jobTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), m.jobTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	j.cancel = cancel
+	m.running[j.layerDigest] = j
+
+	go func() {
+		err := m.convert(ctx, j.ref, j.layerDigest)
+		cancel()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		delete(m.running, j.layerDigest)
+		if j.preempted {
+			j.preempted = false
+			m.preempting--
+			m.pending[j.layerDigest] = j
+			heap.Push(&m.queue, j)
+		} else if err != nil {
+			log.L.WithError(err).Errorf("convert layer %s of image %s locally", j.layerDigest, j.ref)
+			m.layers[j.layerDigest] = LayerStatusFailed
+		} else {
+			log.L.Infof("layer %s of image %s is converted locally", j.layerDigest, j.ref)
+			m.layers[j.layerDigest] = LayerStatusReady
+		}
+		m.scheduleLocked()
+	}()
+}
+
+func (m *Manager) getBlobStream(ctx context.Context, remote *remote.Remote, ref string, layerDigest digest.Digest) (io.ReadCloser, error) {
+	fetcher, err := remote.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "get remote fetcher")
+	}
+
+	fetcherByDigest, ok := fetcher.(remotes.FetcherByDigest)
+	if !ok {
+		return nil, errors.Errorf("fetcher %T does not implement remotes.FetcherByDigest", fetcher)
+	}
+
+	rc, desc, err := fetcherByDigest.FetchByDigest(ctx, layerDigest)
+	if err != nil {
+		return nil, err
+	}
+	if m.maxLayerSize > 0 && desc.Size > m.maxLayerSize {
+		rc.Close()
+		return nil, errors.Errorf("layer size %d exceeds limit %d", desc.Size, m.maxLayerSize)
+	}
+	// Registries and proxies may serve corrupted layers, which abort conversion.
+	// Registries not reporting the size have the digest verified only.
+	size := desc.Size
+	if size <= 0 {
+		size = -1
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{converter.NewVerifyingReader(rc, layerDigest, size), rc}, nil
+}
+
+func (m *Manager) convertLayer(ctx context.Context, ref string, layerDigest digest.Digest) error {
+	if seeded, err := m.seedLayer(layerDigest, ""); seeded || err != nil {
+		return err
+	}
+
+	keyChain, err := auth.GetKeyChainByRef(ref, nil)
+	if err != nil {
+		return errors.Wrap(err, "create key chain for connection")
+	}
+	remote := remote.New(keyChain, m.insecure)
+	if diffID := m.layerDiffID(ctx, remote, ref, layerDigest); diffID != "" {
+		if seeded, err := m.seedLayer(layerDigest, diffID); seeded || err != nil {
+			return err
+		}
+	}
+
+	rc, err := m.getBlobStream(ctx, remote, ref, layerDigest)
+	if err != nil && remote.RetryWithPlainHTTP(ref, err) {
+		rc, err = m.getBlobStream(ctx, remote, ref, layerDigest)
+	}
+	if err != nil {
+		return errors.Wrap(err, "get blob stream by digest")
+	}
+	defer rc.Close()
+
+	jobDir, release, err := m.tracker.MkdirTemp(m.tmpDir(), layerDigest.Hex()+"-")
+	if err != nil {
+		return errors.Wrap(err, "create conversion work directory")
+	}
+	defer release()
+	defer os.RemoveAll(jobDir)
+
+	blobFileTmp := filepath.Join(jobDir, "blob")
+	blobFile, err := os.Create(blobFileTmp)
+	if err != nil {
+		return errors.Wrap(err, "create temporary blob file")
+	}
+	defer blobFile.Close()
+
+	var blobDigest, diffID digest.Digest
+	if m.worker != nil {
+		if blobDigest, err = m.worker.Convert(ctx, layerDigest, rc, blobFile); err != nil {
+			return errors.Wrap(err, "convert layer by remote worker")
+		}
+	} else if blobDigest, diffID, err = m.packLayer(ctx, jobDir, rc, blobFile); err != nil {
+		return err
+	}
+	// The layer is verified only after it's read to the end.
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return errors.Wrap(err, "verify layer")
+	}
+
+	ra, err := local.OpenReader(blobFileTmp)
+	if err != nil {
+		return errors.Wrap(err, "open nydus blob")
+	}
+	defer ra.Close()
+
+	bootstrapTmp := filepath.Join(jobDir, "image.boot")
+	bootstrap, err := os.Create(bootstrapTmp)
+	if err != nil {
+		return errors.Wrap(err, "create layer bootstrap")
+	}
+	defer bootstrap.Close()
+	if _, err := converter.UnpackEntry(ra, converter.EntryBootstrap, bootstrap); err != nil {
+		return errors.Wrap(err, "unpack layer bootstrap")
+	}
+
+	if m.chunkIndex != nil {
+		m.publishChunks(ctx, ra, blobDigest)
+	}
+
+	if err := m.store.commit(ctx, layerDigest, blobDigest, blobFileTmp, bootstrapTmp); err != nil {
+		return err
+	}
+	// Only diff IDs of the layer content read are recorded, not those claimed by
+	// image configs, so that a layer is never seeded from a blob of other content.
+	if ds, ok := m.store.(*dirStore); ok && ds.blobs != nil && diffID != "" {
+		if err := ds.recordDiff(diffID, blobDigest); err != nil {
+			log.L.WithError(err).Warnf("failed to record blob of layer %s", layerDigest)
+		}
+	}
+	return nil
+}
+
+// Diff ID of the layer in the config of image `ref`, empty if unknown. Configs are
+// fetched only with the shared blob store, where layers can be seeded by diff IDs.
+func (m *Manager) layerDiffID(ctx context.Context, remote *remote.Remote, ref string, layerDigest digest.Digest) digest.Digest {
+	if ds, ok := m.store.(*dirStore); !ok || ds.blobs == nil {
+		return ""
+	}
+
+	m.mutex.Lock()
+	diffID, ok := m.diffIDs[layerDigest]
+	m.mutex.Unlock()
+	if ok {
+		return diffID
+	}
+
+	manifest, imageConfig, err := remote.FetchImage(ctx, ref)
+	if err != nil {
+		log.L.WithError(err).Debugf("no diff ID of layer %s", layerDigest)
+		return ""
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, layer := range manifest.Layers {
+		if i < len(imageConfig.RootFS.DiffIDs) {
+			m.diffIDs[layer.Digest] = imageConfig.RootFS.DiffIDs[i]
+		}
+	}
+	return m.diffIDs[layerDigest]
+}
+
+// Restore the layer from a blob converted before, if it's still in the shared blob
+// store, by unpacking the bootstrap from the blob. The blob is either converted from
+// the same layer, or from another one of the same uncompressed content `diffID`
+// if not empty, e.g. the same layer compressed differently.
+func (m *Manager) seedLayer(layerDigest, diffID digest.Digest) (bool, error) {
+	ds, ok := m.store.(*dirStore)
+	if !ok || ds.blobs == nil {
+		return false, nil
+	}
+	blobDigest, err := ds.recordedBlob(layerDigest)
+	if err != nil && diffID != "" {
+		blobDigest, err = ds.recordedDiffBlob(diffID)
+	}
+	if err != nil {
+		return false, nil
+	}
+	// Recorded before the blob is held like commit does, so the reference is released
+	// by Sweep if the layer is not in place.
+	if err := os.WriteFile(ds.layerBlobRefPath(layerDigest), []byte(blobDigest.String()), 0640); err != nil {
+		return false, errors.Wrap(err, "record blob of layer")
+	}
+	// The blob may be released meanwhile, the layer is converted then.
+	if err := ds.blobs.Acquire(blobDigest.Hex(), layerBlobHolder(layerDigest)); err != nil {
+		log.L.WithError(err).Debugf("no blob to seed layer %s", layerDigest)
+		return false, nil
+	}
+
+	jobDir, release, err := m.tracker.MkdirTemp(m.tmpDir(), layerDigest.Hex()+"-")
+	if err != nil {
+		return false, errors.Wrap(err, "create conversion work directory")
+	}
+	defer release()
+	defer os.RemoveAll(jobDir)
+
+	ra, err := local.OpenReader(filepath.Join(ds.blobDir(), blobDigest.Hex()))
+	if err != nil {
+		return false, errors.Wrap(err, "open nydus blob")
+	}
+	defer ra.Close()
+
+	bootstrapTmp := filepath.Join(jobDir, "image.boot")
+	bootstrap, err := os.Create(bootstrapTmp)
+	if err != nil {
+		return false, errors.Wrap(err, "create layer bootstrap")
+	}
+	defer bootstrap.Close()
+	if _, err := converter.UnpackEntry(ra, converter.EntryBootstrap, bootstrap); err != nil {
+		return false, errors.Wrap(err, "unpack layer bootstrap")
+	}
+	if err := os.Rename(bootstrapTmp, ds.layerBootstrapPath(layerDigest)); err != nil {
+		return false, errors.Wrapf(err, "rename file %s", bootstrapTmp)
+	}
+
+	log.L.Infof("layer %s is seeded from blob %s in shared blob store", layerDigest, blobDigest)
+	return true, nil
+}
+
+// Let other nodes deduplicate against chunks of the converted blob, failures
+// are only logged since the blob is usable anyway.
+func (m *Manager) publishChunks(ctx context.Context, ra content.ReaderAt, blobDigest digest.Digest) {
+	chunks, err := converter.ChunkDigests(ra)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to read chunks of converted blob %s", blobDigest)
+		return
+	}
+	if err := m.chunkIndex.Publish(ctx, blobDigest.Hex(), chunks); err != nil {
+		log.L.WithError(err).Warnf("failed to publish chunks of converted blob %s", blobDigest)
+	}
+}
+
+// Pack the compressed OCI layer blob `rc` into nydus blob `dest` by local builder,
+// returning digests of the nydus blob and the uncompressed layer.
+func (m *Manager) packLayer(ctx context.Context, workDir string, rc io.Reader, dest io.Writer) (digest.Digest, digest.Digest, error) {
+	ds, err := compression.DecompressStream(rc)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decompress layer blob stream")
+	}
+	defer ds.Close()
+
+	digester := digest.Canonical.Digester()
+	diffDigester := digest.Canonical.Digester()
+	w, err := converter.Pack(ctx, io.MultiWriter(dest, digester.Hash()), converter.PackOption{
+		WorkDir:     workDir,
+		BuilderPath: m.nydusImagePath,
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "create nydus packer")
+	}
+	if _, err := io.Copy(w, io.TeeReader(ds, diffDigester.Hash())); err != nil {
+		w.Close()
+		return "", "", errors.Wrap(err, "pack layer")
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		w.Close()
+		return "", "", errors.Wrap(err, "verify layer")
+	}
+	if err := w.Close(); err != nil {
+		return "", "", errors.Wrap(err, "finish packing layer")
+	}
+
+	return digester.Digest(), diffDigester.Digest(), nil
+}
+
+// MergeLayers merges bootstraps of converted layers, in order from lowest to
+// uppermost, into an image bootstrap at `target`.
+func (m *Manager) MergeLayers(layers []digest.Digest, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	bootstraps := make([]string, 0, len(layers))
+	for _, l := range layers {
+		if ds, ok := m.store.(*dirStore); ok {
+			// Not swept while being merged.
+			defer m.tracker.Acquire(ds.layerBootstrapPath(l))()
+			defer m.tracker.Acquire(ds.layerBlobRefPath(l))()
+		}
+		if !m.IsLayerReady(l) {
+			return errors.Errorf("layer %s is not converted", l)
+		}
+		bootstrap, err := m.store.bootstrapPath(context.Background(), l)
+		if err != nil {
+			return errors.Wrapf(err, "find bootstrap of layer %s", l)
+		}
+		bootstraps = append(bootstraps, bootstrap)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return errors.Wrapf(err, "create directory for %s", target)
+	}
+
+	workDir, release, err := m.tracker.MkdirTemp(m.tmpDir(), "merge-")
+	if err != nil {
+		return errors.Wrap(err, "create merge work directory")
+	}
+	defer release()
+	defer os.RemoveAll(workDir)
+
+	targetTmp := filepath.Join(workDir, "image.boot")
+	if _, err := tool.Merge(tool.MergeOption{
+		BuilderPath:          m.nydusImagePath,
+		SourceBootstrapPaths: bootstraps,
+		TargetBootstrapPath:  targetTmp,
+		OutputJSONPath:       filepath.Join(workDir, "merge-output.json"),
+	}); err != nil {
+		return errors.Wrap(err, "merge layer bootstraps")
+	}
+
+	if err := os.Rename(targetTmp, target); err != nil {
+		return errors.Wrapf(err, "rename file %s to %s", targetTmp, target)
+	}
+
+	return nil
+}
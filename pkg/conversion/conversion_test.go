@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+func TestLayerReadiness(t *testing.T) {
+	m, err := NewManager(Opt{WorkDir: t.TempDir(), NydusImagePath: "nydus-image"})
+	require.NoError(t, err)
+
+	ds := m.store.(*dirStore)
+	converted := digest.FromString("converted")
+	pending := digest.FromString("pending")
+
+	// Layers converted by a previous run are found on disk.
+	require.NoError(t, os.WriteFile(ds.layerBootstrapPath(converted), []byte("boot"), 0640))
+	require.True(t, m.IsLayerReady(converted))
+	require.False(t, m.IsLayerReady(pending))
+
+	m.layers[pending] = LayerStatusConverting
+	require.False(t, m.IsLayerReady(pending))
+
+	target := filepath.Join(t.TempDir(), "image.boot")
+	require.Error(t, m.MergeLayers([]digest.Digest{converted, pending}, target))
+	require.NoFileExists(t, target)
+}
+
+func TestConversionPreemption(t *testing.T) {
+	m, err := NewManager(Opt{WorkDir: t.TempDir(), MaxConcurrentProcess: 1})
+	require.NoError(t, err)
+
+	started := make(chan digest.Digest, 8)
+	release := make(chan struct{})
+	m.convert = func(ctx context.Context, _ string, layerDigest digest.Digest) error {
+		started <- layerDigest
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+			return nil
+		}
+	}
+	next := func() digest.Digest {
+		select {
+		case d := <-started:
+			return d
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no conversion started")
+			return ""
+		}
+	}
+
+	preloaded := digest.FromString("preloaded")
+	queued := digest.FromString("queued")
+	urgent := digest.FromString("urgent")
+
+	m.ConvertLayer("preload", preloaded, PriorityBackground)
+	require.Equal(t, preloaded, next())
+	m.ConvertLayer("preload", queued, PriorityBackground)
+
+	// The foreground layer preempts the running background one, which is queued again
+	// ahead of the later background layer.
+	m.ConvertLayer("start", urgent, PriorityForeground)
+	require.Equal(t, urgent, next())
+	release <- struct{}{}
+	require.Equal(t, preloaded, next())
+	release <- struct{}{}
+	require.Equal(t, queued, next())
+	release <- struct{}{}
+
+	require.Eventually(t, func() bool {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return len(m.running) == 0 && m.queue.Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, d := range []digest.Digest{preloaded, queued, urgent} {
+		require.True(t, m.IsLayerReady(d))
+	}
+}
+
+func TestConversionJobTimeout(t *testing.T) {
+	m, err := NewManager(Opt{WorkDir: t.TempDir(), JobTimeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+	m.convert = func(ctx context.Context, _ string, _ digest.Digest) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	layer := digest.FromString("slow")
+	m.ConvertLayer("slow", layer, PriorityForeground)
+	require.Eventually(t, func() bool {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return m.layers[layer] == LayerStatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSweep(t *testing.T) {
+	m, err := NewManager(Opt{WorkDir: t.TempDir()})
+	require.NoError(t, err)
+	ds := m.store.(*dirStore)
+
+	addLayer := func(layer, blob string) {
+		layerDigest := digest.FromString(layer)
+		require.NoError(t, os.WriteFile(ds.layerBootstrapPath(layerDigest), []byte("boot"), 0640))
+		require.NoError(t, os.WriteFile(ds.layerBlobRefPath(layerDigest), []byte(digest.FromString(blob).String()), 0640))
+		require.NoError(t, os.WriteFile(filepath.Join(m.BlobDir(), digest.FromString(blob).Hex()), nil, 0640))
+	}
+	addLayer("used", "shared")
+	addLayer("unused", "shared")
+	addLayer("removed", "removed")
+	require.True(t, m.IsLayerReady(digest.FromString("removed")))
+	stale := filepath.Join(m.tmpDir(), "stale-work")
+	require.NoError(t, os.Mkdir(stale, 0750))
+
+	require.NoError(t, m.Sweep(map[digest.Digest]struct{}{digest.FromString("used"): {}}))
+	require.NoDirExists(t, stale)
+	require.FileExists(t, ds.layerBootstrapPath(digest.FromString("used")))
+	require.NoFileExists(t, ds.layerBootstrapPath(digest.FromString("unused")))
+	require.NoFileExists(t, ds.layerBlobRefPath(digest.FromString("unused")))
+	require.FileExists(t, filepath.Join(m.BlobDir(), digest.FromString("shared").Hex()))
+	require.NoFileExists(t, filepath.Join(m.BlobDir(), digest.FromString("removed").Hex()))
+	require.False(t, m.IsLayerReady(digest.FromString("removed")))
+
+	// Blobs are kept if any layer doesn't record its blob.
+	legacy := digest.FromString("legacy")
+	require.NoError(t, os.WriteFile(ds.layerBootstrapPath(legacy), []byte("boot"), 0640))
+	orphan := filepath.Join(m.BlobDir(), digest.FromString("orphan").Hex())
+	require.NoError(t, os.WriteFile(orphan, nil, 0640))
+	require.NoError(t, m.Sweep(map[digest.Digest]struct{}{digest.FromString("used"): {}, legacy: {}}))
+	require.FileExists(t, orphan)
+}
+
+// Nydus blob with only the bootstrap entry, laid out as data followed by its tar header.
+func nydusBlobWithBootstrap(t *testing.T, bootstrap []byte) []byte {
+	var hdr bytes.Buffer
+	tw := tar.NewWriter(&hdr)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: converter.EntryBootstrap, Mode: 0444, Size: int64(len(bootstrap))}))
+	return append(append([]byte{}, bootstrap...), hdr.Bytes()[:512]...)
+}
+
+func TestSharedBlobStore(t *testing.T) {
+	bs, err := cache.NewBlobStore(t.TempDir())
+	require.NoError(t, err)
+	m, err := NewManager(Opt{WorkDir: t.TempDir(), BlobStore: bs})
+	require.NoError(t, err)
+	ds := m.store.(*dirStore)
+	require.Equal(t, bs.BlobDir(), m.BlobDir())
+
+	blob := nydusBlobWithBootstrap(t, []byte("bootstrap"))
+	blobDigest := digest.FromBytes(blob)
+	commit := func(layer digest.Digest) {
+		blobFile := filepath.Join(t.TempDir(), "blob")
+		require.NoError(t, os.WriteFile(blobFile, blob, 0640))
+		bootstrapFile := filepath.Join(t.TempDir(), "image.boot")
+		require.NoError(t, os.WriteFile(bootstrapFile, []byte("bootstrap"), 0640))
+		require.NoError(t, ds.commit(context.Background(), layer, blobDigest, blobFile, bootstrapFile))
+	}
+
+	// Identical layers in different compression are converted to the same blob.
+	gzipLayer := digest.FromString("gzip")
+	zstdLayer := digest.FromString("zstd")
+	commit(gzipLayer)
+	commit(zstdLayer)
+	holders, err := bs.Holders(blobDigest.Hex())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{layerBlobHolder(gzipLayer), layerBlobHolder(zstdLayer)}, holders)
+
+	// The blob converted from the swept layer is still held by the other layer, so the
+	// layer is seeded from it rather than converted again.
+	require.NoError(t, m.Sweep(map[digest.Digest]struct{}{zstdLayer: {}}))
+	require.NoFileExists(t, ds.layerBootstrapPath(gzipLayer))
+	require.True(t, bs.Has(blobDigest.Hex()))
+	require.False(t, m.IsLayerReady(gzipLayer))
+	require.NoError(t, m.convertLayer(context.Background(), "invalid reference", gzipLayer))
+	data, err := os.ReadFile(ds.layerBootstrapPath(gzipLayer))
+	require.NoError(t, err)
+	require.Equal(t, "bootstrap", string(data))
+
+	// Layers never converted are seeded by the blob converted from the same diff.
+	diffID := digest.FromString("diff")
+	require.NoError(t, ds.recordDiff(diffID, blobDigest))
+	otherLayer := digest.FromString("other")
+	seeded, err := m.seedLayer(otherLayer, "")
+	require.NoError(t, err)
+	require.False(t, seeded)
+	seeded, err = m.seedLayer(otherLayer, diffID)
+	require.NoError(t, err)
+	require.True(t, seeded)
+	require.FileExists(t, ds.layerBootstrapPath(otherLayer))
+	holders, err = bs.Holders(blobDigest.Hex())
+	require.NoError(t, err)
+	require.Contains(t, holders, layerBlobHolder(otherLayer))
+
+	// The blob is removed once no layer holds it.
+	require.NoError(t, m.Sweep(map[digest.Digest]struct{}{}))
+	require.False(t, bs.Has(blobDigest.Hex()))
+	require.NoFileExists(t, ds.layerBlobRefPath(gzipLayer))
+	require.NoFileExists(t, ds.layerBlobRefPath(zstdLayer))
+	require.NoFileExists(t, ds.layerBlobRefPath(otherLayer))
+	require.NoFileExists(t, ds.diffBlobRefPath(diffID))
+}
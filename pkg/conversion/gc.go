@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	bootstrapExt = ".boot"
+	blobRefExt   = ".blob"
+)
+
+// Sweep removes bootstraps converted from layers not in `inUse`, blobs no longer
+// referenced by any bootstrap, and work files left by interrupted conversions.
+// Layers being converted or merged are kept. Artifacts in containerd content store
+// are left to containerd GC.
+func (m *Manager) Sweep(inUse map[digest.Digest]struct{}) error {
+	removed, err := m.tracker.Sweep(m.tmpDir(), nil)
+	if err != nil {
+		return errors.Wrap(err, "sweep conversion work directories")
+	}
+	if len(removed) > 0 {
+		log.L.Infof("removed stale conversion work files %v", removed)
+	}
+
+	ds, ok := m.store.(*dirStore)
+	if !ok {
+		return nil
+	}
+
+	removed, err = m.tracker.Sweep(ds.bootstrapDir(), func(path string) bool {
+		layerDigest, ok := layerOfBootstrapFile(path)
+		if !ok {
+			// Leave alone files not created by us.
+			return true
+		}
+		if _, ok := inUse[layerDigest]; ok {
+			return true
+		}
+		// Records are left to seed later conversions while the blob is shared.
+		if ds.blobs != nil && filepath.Ext(path) == blobRefExt {
+			return true
+		}
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return m.layers[layerDigest] == LayerStatusConverting
+	})
+	// Forget removed layers after the removal, so they're converted again if pulled later.
+	m.mutex.Lock()
+	for _, path := range removed {
+		if layerDigest, ok := layerOfBootstrapFile(path); ok {
+			delete(m.layers, layerDigest)
+		}
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "sweep layer bootstraps")
+	}
+	if len(removed) > 0 {
+		log.L.Infof("removed bootstraps of unused layers %v", removed)
+	}
+
+	if ds.blobs != nil {
+		return m.sweepBlobStore(ds)
+	}
+
+	// Blobs of layers being converted are referenced by the conversion itself until
+	// their bootstraps are in place, so references are collected with the tracker locked.
+	var refs map[string]struct{}
+	var refsErr error
+	loaded := false
+	removed, err = m.tracker.Sweep(ds.blobDir(), func(path string) bool {
+		if !loaded {
+			refs, refsErr = ds.blobRefs()
+			loaded = true
+		}
+		if refs == nil {
+			return true
+		}
+		_, ok := refs[filepath.Base(path)]
+		return ok
+	})
+	if err != nil {
+		return errors.Wrap(err, "sweep converted blobs")
+	}
+	if refsErr != nil {
+		return refsErr
+	}
+	if loaded && refs == nil {
+		log.L.Warn("skip sweeping converted blobs since some layers don't record their blobs")
+	}
+	if len(removed) > 0 {
+		log.L.Infof("removed unreferenced converted blobs %v", removed)
+	}
+
+	return nil
+}
+
+// Release blobs of layers without bootstraps from the shared blob store, and forget
+// blobs of them once the blobs are removed.
+func (m *Manager) sweepBlobStore(ds *dirStore) error {
+	entries, err := os.ReadDir(ds.bootstrapDir())
+	if err != nil {
+		return errors.Wrapf(err, "read directory %s", ds.bootstrapDir())
+	}
+
+	released := make(map[digest.Digest]digest.Digest)
+	for _, e := range entries {
+		layerDigest, ok := layerOfBootstrapFile(e.Name())
+		if !ok || filepath.Ext(e.Name()) != blobRefExt {
+			continue
+		}
+		if _, err := os.Stat(ds.layerBootstrapPath(layerDigest)); err == nil {
+			continue
+		}
+		m.mutex.Lock()
+		converting := m.layers[layerDigest] == LayerStatusConverting
+		m.mutex.Unlock()
+		if converting {
+			continue
+		}
+
+		blobDigest, err := ds.recordedBlob(layerDigest)
+		if err != nil {
+			log.L.WithError(err).Warnf("invalid blob record of layer %s", layerDigest)
+			continue
+		}
+		if err := ds.blobs.Release(blobDigest.Hex(), layerBlobHolder(layerDigest)); err != nil {
+			return errors.Wrapf(err, "release blob of layer %s", layerDigest)
+		}
+		released[layerDigest] = blobDigest
+	}
+
+	// Checked after all releases since layers may share a blob.
+	for layerDigest, blobDigest := range released {
+		if ds.blobs.Has(blobDigest.Hex()) {
+			continue
+		}
+		if err := os.Remove(ds.layerBlobRefPath(layerDigest)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove blob record of layer %s", layerDigest)
+		}
+	}
+
+	removed, err := ds.blobs.Sweep()
+	if err != nil {
+		return errors.Wrap(err, "sweep shared blob store")
+	}
+	if len(removed) > 0 {
+		log.L.Infof("removed unreferenced blobs %v from shared blob store", removed)
+	}
+	return ds.sweepDiffs()
+}
+
+// Forget blobs of diffs once the blobs are removed from the shared blob store.
+func (s *dirStore) sweepDiffs() error {
+	entries, err := os.ReadDir(s.diffDir())
+	if err != nil {
+		return errors.Wrapf(err, "read directory %s", s.diffDir())
+	}
+	for _, e := range entries {
+		diffID := digest.NewDigestFromEncoded(digest.SHA256, strings.TrimSuffix(e.Name(), blobRefExt))
+		if filepath.Ext(e.Name()) != blobRefExt || diffID.Validate() != nil {
+			continue
+		}
+		blobDigest, err := s.recordedDiffBlob(diffID)
+		if err == nil && s.blobs.Has(blobDigest.Hex()) {
+			continue
+		}
+		if err := os.Remove(s.diffBlobRefPath(diffID)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove blob record of diff %s", diffID)
+		}
+	}
+	return nil
+}
+
+// Collect IDs of blobs referenced by converted layers. It returns nil if a layer
+// doesn't record its blob, as converted by old versions of snapshotter.
+func (s *dirStore) blobRefs() (map[string]struct{}, error) {
+	entries, err := os.ReadDir(s.bootstrapDir())
+	if err != nil {
+		return nil, errors.Wrapf(err, "read directory %s", s.bootstrapDir())
+	}
+
+	refs := make(map[string]struct{})
+	for _, e := range entries {
+		layerDigest, ok := layerOfBootstrapFile(e.Name())
+		if !ok || filepath.Ext(e.Name()) != bootstrapExt {
+			continue
+		}
+		data, err := os.ReadFile(s.layerBlobRefPath(layerDigest))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "read blob of layer %s", layerDigest)
+		}
+		blobDigest, err := digest.Parse(string(data))
+		if err != nil {
+			return nil, nil
+		}
+		refs[blobDigest.Hex()] = struct{}{}
+	}
+
+	return refs, nil
+}
+
+// Parse the layer digest from name of bootstrap or blob record files.
+func layerOfBootstrapFile(path string) (digest.Digest, bool) {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if ext != bootstrapExt && ext != blobRefExt {
+		return "", false
+	}
+	layerDigest := digest.NewDigestFromEncoded(digest.SHA256, strings.TrimSuffix(name, ext))
+	return layerDigest, layerDigest.Validate() == nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"context"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Priority of a layer conversion, jobs of higher priority run first and preempt lower ones.
+type Priority int
+
+const (
+	// Layers pulled to warm up nodes, e.g. by the preload API.
+	PriorityBackground Priority = iota
+	// Layers pulled for containers about to start.
+	PriorityForeground
+)
+
+// Value of label `containerd.io/snapshot/nydus-conversion-priority` for background layers.
+const PriorityBackgroundValue = "background"
+
+type job struct {
+	ref         string
+	layerDigest digest.Digest
+	priority    Priority
+	// Jobs of the same priority run in order of scheduling.
+	seq    uint64
+	index  int
+	cancel context.CancelFunc
+	// Canceled to make room for a higher priority job, it's queued again.
+	preempted bool
+}
+
+// A heap of pending jobs, implementing heap.Interface.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*q = old[:n-1]
+	return j
+}
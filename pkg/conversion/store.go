@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package conversion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/gc"
+)
+
+// store keeps nydus blobs and layer bootstraps converted from OCI layers.
+type store interface {
+	// Directory hosting nydus blobs named by blob ID, used as the localfs backend of nydusd.
+	blobDir() string
+	// Path to the bootstrap converted from the layer, errdefs.ErrNotFound if not converted.
+	bootstrapPath(ctx context.Context, layerDigest digest.Digest) (string, error)
+	// Move the blob and bootstrap files converted from the layer into the store.
+	commit(ctx context.Context, layerDigest, blobDigest digest.Digest, blobFile, bootstrapFile string) error
+}
+
+// Keeps converted artifacts as files in the work directory of the manager, which
+// are swept by the snapshotter. Blobs are kept in the shared blob store of the cache
+// manager instead if `blobs` is not nil, held by layers converted to them.
+type dirStore struct {
+	workDir string
+	tracker *gc.Tracker
+	blobs   *cache.BlobStore
+}
+
+func newDirStore(workDir string, tracker *gc.Tracker, blobs *cache.BlobStore) (*dirStore, error) {
+	s := &dirStore{workDir: workDir, tracker: tracker, blobs: blobs}
+	for _, dir := range []string{s.blobDir(), s.bootstrapDir(), s.diffDir()} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, errors.Wrapf(err, "create directory %s", dir)
+		}
+	}
+	return s, nil
+}
+
+func (s *dirStore) blobDir() string {
+	if s.blobs != nil {
+		return s.blobs.BlobDir()
+	}
+	return filepath.Join(s.workDir, "blobs")
+}
+
+// Name of the reference to the blob converted from the layer in the shared blob store.
+func layerBlobHolder(layerDigest digest.Digest) string {
+	return "layer-" + layerDigest.Hex()
+}
+
+func (s *dirStore) bootstrapDir() string {
+	return filepath.Join(s.workDir, "bootstraps")
+}
+
+func (s *dirStore) layerBootstrapPath(layerDigest digest.Digest) string {
+	return filepath.Join(s.bootstrapDir(), layerDigest.Hex()+bootstrapExt)
+}
+
+// Records digest of the nydus blob converted from the layer.
+func (s *dirStore) layerBlobRefPath(layerDigest digest.Digest) string {
+	return filepath.Join(s.bootstrapDir(), layerDigest.Hex()+blobRefExt)
+}
+
+func (s *dirStore) bootstrapPath(_ context.Context, layerDigest digest.Digest) (string, error) {
+	p := s.layerBootstrapPath(layerDigest)
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Wrapf(errdefs.ErrNotFound, "bootstrap of layer %s", layerDigest)
+		}
+		return "", err
+	}
+	return p, nil
+}
+
+func (s *dirStore) commit(_ context.Context, layerDigest, blobDigest digest.Digest, blobFile, bootstrapFile string) error {
+	// Record the blob referenced by the layer bootstrap before both are in place.
+	blobPath := filepath.Join(s.blobDir(), blobDigest.Hex())
+	defer s.tracker.Acquire(blobPath)()
+	if err := os.WriteFile(s.layerBlobRefPath(layerDigest), []byte(blobDigest.String()), 0640); err != nil {
+		return errors.Wrap(err, "record blob of layer")
+	}
+
+	// Nydusd with localfs backend looks up blobs by blob ID which is the blob digest.
+	if s.blobs != nil {
+		if err := s.blobs.Add(blobDigest.Hex(), blobFile, layerBlobHolder(layerDigest)); err != nil {
+			return errors.Wrap(err, "add blob to shared blob store")
+		}
+	} else if err := os.Rename(blobFile, blobPath); err != nil {
+		return errors.Wrapf(err, "rename file %s to %s", blobFile, blobPath)
+	}
+	if err := os.Rename(bootstrapFile, s.layerBootstrapPath(layerDigest)); err != nil {
+		return errors.Wrapf(err, "rename file %s", bootstrapFile)
+	}
+
+	return nil
+}
+
+// Blob recorded for the layer, which may be converted before and kept in the shared
+// blob store by other holders after the layer is swept.
+func (s *dirStore) recordedBlob(layerDigest digest.Digest) (digest.Digest, error) {
+	data, err := os.ReadFile(s.layerBlobRefPath(layerDigest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Wrapf(errdefs.ErrNotFound, "blob of layer %s", layerDigest)
+		}
+		return "", errors.Wrapf(err, "read blob of layer %s", layerDigest)
+	}
+	return digest.Parse(string(data))
+}
+
+// Records blobs converted from uncompressed layer contents, named by the diff ID.
+func (s *dirStore) diffDir() string {
+	return filepath.Join(s.workDir, "diffs")
+}
+
+func (s *dirStore) diffBlobRefPath(diffID digest.Digest) string {
+	return filepath.Join(s.diffDir(), diffID.Hex()+blobRefExt)
+}
+
+// Record the blob converted from a layer of uncompressed content `diffID`, so that
+// layers of the same content, e.g. compressed differently, are seeded from it.
+func (s *dirStore) recordDiff(diffID, blobDigest digest.Digest) error {
+	if err := os.WriteFile(s.diffBlobRefPath(diffID), []byte(blobDigest.String()), 0640); err != nil {
+		return errors.Wrapf(err, "record blob of diff %s", diffID)
+	}
+	return nil
+}
+
+func (s *dirStore) recordedDiffBlob(diffID digest.Digest) (digest.Digest, error) {
+	data, err := os.ReadFile(s.diffBlobRefPath(diffID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Wrapf(errdefs.ErrNotFound, "blob of diff %s", diffID)
+		}
+		return "", errors.Wrapf(err, "read blob of diff %s", diffID)
+	}
+	return digest.Parse(string(data))
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package worker offloads local conversion of OCI layers to remote converter
+// workers over gRPC, as defined in converter.proto. The snapshotter streams
+// layer blobs to a worker and receives the packed nydus blobs back.
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Size of data carried by a single message, below the default gRPC limit of 4MiB.
+const chunkSize = 1 << 20
+
+// TLSOpt secures connections between snapshotters and workers, which are in plaintext
+// if nothing is set.
+type TLSOpt struct {
+	// CA certificate verifying the peer. Workers require snapshotters to present
+	// certificates signed by it.
+	CAFile string
+	// Certificate and key presented to the peer, mandatory for workers.
+	CertFile string
+	KeyFile  string
+}
+
+func (o TLSOpt) enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != ""
+}
+
+func (o TLSOpt) config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load key pair %s and %s", o.CertFile, o.KeyFile)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read CA certificate %s", o.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+func (o TLSOpt) clientCredentials() (credentials.TransportCredentials, error) {
+	if !o.enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+func (o TLSOpt) serverCredentials() (credentials.TransportCredentials, error) {
+	if !o.enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("certificate and key are required to serve TLS")
+	}
+	cfg, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ClientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(cfg), nil
+}
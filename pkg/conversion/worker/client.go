@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package worker
+
+import (
+	"context"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+type Client struct {
+	conn   *grpc.ClientConn
+	client ConverterClient
+}
+
+// NewClient connects to the converter worker at `address`, e.g. "converter.svc:9090".
+// Connection is established lazily on first conversion.
+func NewClient(address string, tlsOpt TLSOpt, opts ...grpc.DialOption) (*Client, error) {
+	creds, err := tlsOpt.clientCredentials()
+	if err != nil {
+		return nil, errors.Wrap(err, "configure TLS")
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+	conn, err := grpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to converter worker %s", address)
+	}
+	return &Client{conn: conn, client: NewConverterClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Convert streams the layer blob `layerDigest` read from `r` to the worker and writes
+// the nydus blob converted from it to `w`, returning the digest of the nydus blob.
+func (c *Client) Convert(ctx context.Context, layerDigest digest.Digest, r io.Reader, w io.Writer) (digest.Digest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.Convert(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "create conversion stream")
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		err := sendLayer(stream, layerDigest, r)
+		if err != nil && err != io.EOF {
+			// Abort the stream, otherwise the worker waits for more data.
+			cancel()
+		}
+		sendErr <- err
+	}()
+
+	digester := digest.Canonical.Digester()
+	dest := io.MultiWriter(w, digester.Hash())
+	var blobDigest digest.Digest
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			cancel()
+			// Failure to read the layer is the cause of the aborted stream.
+			if serr := <-sendErr; serr != nil && serr != io.EOF {
+				return "", serr
+			}
+			return "", errors.Wrapf(err, "convert layer %s by worker", layerDigest)
+		}
+		if _, err := dest.Write(resp.Data); err != nil {
+			return "", errors.Wrap(err, "write nydus blob")
+		}
+		if resp.BlobDigest != "" {
+			if blobDigest, err = digest.Parse(resp.BlobDigest); err != nil {
+				return "", errors.Wrapf(err, "invalid blob digest %q from worker", resp.BlobDigest)
+			}
+		}
+	}
+
+	if blobDigest == "" {
+		return "", errors.Errorf("no blob digest from worker for layer %s", layerDigest)
+	}
+	if blobDigest != digester.Digest() {
+		return "", errors.Errorf("digest mismatch of nydus blob for layer %s, expected %s, actual %s",
+			layerDigest, blobDigest, digester.Digest())
+	}
+
+	return blobDigest, nil
+}
+
+func sendLayer(stream Converter_ConvertClient, layerDigest digest.Digest, r io.Reader) error {
+	if err := stream.Send(&ConvertRequest{LayerDigest: layerDigest.String()}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&ConvertRequest{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return stream.CloseSend()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read layer %s", layerDigest)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package worker
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+const defaultMaxConcurrentProcess = 4
+
+type ServerOpt struct {
+	NydusImagePath string
+	WorkDir        string
+	// Maximum number of layers converted in parallel, 0 means default.
+	MaxConcurrentProcess int
+	TLS                  TLSOpt
+}
+
+// Packs the OCI tar stream written to the returned writer into a nydus blob in `dest`.
+type packFunc func(ctx context.Context, dest io.Writer) (io.WriteCloser, error)
+
+type Server struct {
+	UnimplementedConverterServer
+
+	limiter *semaphore.Weighted
+	pack    packFunc
+	grpc    *grpc.Server
+}
+
+func NewServer(opt ServerOpt) (*Server, error) {
+	if opt.MaxConcurrentProcess <= 0 {
+		opt.MaxConcurrentProcess = defaultMaxConcurrentProcess
+	}
+	if err := os.MkdirAll(opt.WorkDir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "create work directory %s", opt.WorkDir)
+	}
+
+	creds, err := opt.TLS.serverCredentials()
+	if err != nil {
+		return nil, errors.Wrap(err, "configure TLS")
+	}
+
+	s := &Server{
+		limiter: semaphore.NewWeighted(int64(opt.MaxConcurrentProcess)),
+		pack: func(ctx context.Context, dest io.Writer) (io.WriteCloser, error) {
+			return converter.Pack(ctx, dest, converter.PackOption{
+				WorkDir:     opt.WorkDir,
+				BuilderPath: opt.NydusImagePath,
+			})
+		},
+		grpc: grpc.NewServer(grpc.Creds(creds)),
+	}
+	RegisterConverterServer(s.grpc, s)
+
+	return s, nil
+}
+
+// Serve accepts conversion requests on `l` until Stop is called.
+func (s *Server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+// Stop waits for ongoing conversions to finish.
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+func (s *Server) Convert(stream Converter_ConvertServer) error {
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return errors.Wrap(err, "receive conversion request")
+	}
+	layerDigest, err := digest.Parse(req.LayerDigest)
+	if err != nil {
+		return errors.Wrapf(err, "invalid layer digest %q", req.LayerDigest)
+	}
+
+	if err := s.limiter.Acquire(ctx, 1); err != nil {
+		return errors.Wrap(err, "wait for conversion slot")
+	}
+	defer s.limiter.Release(1)
+	log.G(ctx).Infof("converting layer %s", layerDigest)
+
+	src := &requestReader{stream: stream, buf: req.Data}
+	ds, err := compression.DecompressStream(src)
+	if err != nil {
+		return errors.Wrap(err, "decompress layer stream")
+	}
+	defer ds.Close()
+
+	digester := digest.Canonical.Digester()
+	dest := &responseWriter{stream: stream}
+	w, err := s.pack(ctx, io.MultiWriter(dest, digester.Hash()))
+	if err != nil {
+		return errors.Wrap(err, "create nydus packer")
+	}
+	if _, err := io.Copy(w, ds); err != nil {
+		w.Close()
+		return errors.Wrapf(err, "pack layer %s", layerDigest)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "finish packing layer %s", layerDigest)
+	}
+
+	blobDigest := digester.Digest()
+	if err := stream.Send(&ConvertResponse{BlobDigest: blobDigest.String()}); err != nil {
+		return errors.Wrap(err, "send blob digest")
+	}
+	log.G(ctx).Infof("converted layer %s to nydus blob %s", layerDigest, blobDigest)
+
+	return nil
+}
+
+// Reads layer data carried by requests until the client closes sending.
+type requestReader struct {
+	stream Converter_ConvertServer
+	buf    []byte
+}
+
+func (r *requestReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = req.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Sends written data in chunks of responses.
+type responseWriter struct {
+	stream Converter_ConvertServer
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		end := min(written+chunkSize, len(p))
+		if err := w.stream.Send(&ConvertResponse{Data: p[written:end]}); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return len(p), nil
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Serve a worker with a fake packer upper-casing the layer.
+func serveWorker(t *testing.T, tlsOpt TLSOpt) *bufconn.Listener {
+	s, err := NewServer(ServerOpt{WorkDir: t.TempDir(), TLS: tlsOpt})
+	require.NoError(t, err)
+	s.pack = func(_ context.Context, dest io.Writer) (io.WriteCloser, error) {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			data, _ := io.ReadAll(pr)
+			_, _ = dest.Write(bytes.ToUpper(data))
+		}()
+		return &closer{pw: pw, done: done}, nil
+	}
+
+	l := bufconn.Listen(1 << 20)
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+	return l
+}
+
+func dialWorker(t *testing.T, l *bufconn.Listener, tlsOpt TLSOpt) *Client {
+	c, err := NewClient("passthrough:///bufnet", tlsOpt, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return l.DialContext(ctx)
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestConvertByWorker(t *testing.T) {
+	c := dialWorker(t, serveWorker(t, TLSOpt{}), TLSOpt{})
+
+	// Exceeding a single message.
+	layer := strings.Repeat("nydus", chunkSize/2)
+	var blob bytes.Buffer
+	blobDigest, err := c.Convert(context.Background(), digest.FromString(layer), strings.NewReader(layer), &blob)
+	require.NoError(t, err)
+	require.Equal(t, strings.ToUpper(layer), blob.String())
+	require.Equal(t, digest.FromBytes(blob.Bytes()), blobDigest)
+
+	_, err = c.Convert(context.Background(), "invalid", strings.NewReader(layer), io.Discard)
+	require.Error(t, err)
+}
+
+func TestConvertByWorkerOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	caFile := filepath.Join(dir, "ca.crt")
+	serverOpt := TLSOpt{CAFile: caFile, CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}
+
+	_, err := NewServer(ServerOpt{WorkDir: t.TempDir(), TLS: TLSOpt{CAFile: caFile}})
+	require.Error(t, err)
+
+	l := serveWorker(t, serverOpt)
+	layer := "nydus"
+	c := dialWorker(t, l, TLSOpt{
+		CAFile:   caFile,
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	})
+	var blob bytes.Buffer
+	_, err = c.Convert(context.Background(), digest.FromString(layer), strings.NewReader(layer), &blob)
+	require.NoError(t, err)
+	require.Equal(t, "NYDUS", blob.String())
+
+	// Snapshotters are required to present certificates.
+	c = dialWorker(t, l, TLSOpt{CAFile: caFile})
+	_, err = c.Convert(context.Background(), digest.FromString(layer), strings.NewReader(layer), io.Discard)
+	require.Error(t, err)
+	// Plaintext is refused.
+	c = dialWorker(t, l, TLSOpt{})
+	_, err = c.Convert(context.Background(), digest.FromString(layer), strings.NewReader(layer), io.Discard)
+	require.Error(t, err)
+}
+
+// Write certificate `name` signed by `parent`, or a self-signed CA if no parent is given.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"bufnet"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+type closer struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func (c *closer) Write(p []byte) (int, error) { return c.pw.Write(p) }
+
+func (c *closer) Close() error {
+	c.pw.Close()
+	<-c.done
+	return nil
+}
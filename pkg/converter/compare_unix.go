@@ -0,0 +1,125 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"strconv"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+)
+
+// CompareChunks reports chunks the image of `targetBootstrap` shares with the image of
+// `baseBootstrap` and how much transfer they save, helping decide layer ordering of the
+// target and whether a chunk dictionary shared by the images is worth maintaining.
+// Chunks are only comparable if both images digest them by the same algorithm.
+func CompareChunks(baseBootstrap, targetBootstrap string) (*ChunkComparison, error) {
+	baseAlgorithm, base, err := comparedFiles(baseBootstrap)
+	if err != nil {
+		return nil, err
+	}
+	targetAlgorithm, target, err := comparedFiles(targetBootstrap)
+	if err != nil {
+		return nil, err
+	}
+	// Shared chunks can't be told without digests comparable to each other.
+	if baseAlgorithm != targetAlgorithm {
+		return nil, errors.Errorf("chunks of the base image are digested by %s but by %s in the target image, shared chunks are unknown",
+			baseAlgorithm, targetAlgorithm)
+	}
+
+	return compareChunks(base, target), nil
+}
+
+func comparedFiles(bootstrapPath string) (digest.Algorithm, []layout.File, error) {
+	bootstrap, err := layout.ReadBootstrap(bootstrapPath)
+	if err != nil {
+		return "", nil, err
+	}
+	files, err := bootstrap.Files()
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "read files of bootstrap %s", bootstrapPath)
+	}
+	return bootstrap.DigestAlgorithm(), files, nil
+}
+
+func (s *ChunkStats) add(chunk layout.Chunk) {
+	s.Chunks++
+	s.CompressedSize += chunk.CompressedSize
+	s.UncompressedSize += chunk.UncompressedSize
+}
+
+func chunkLocation(chunk layout.Chunk) string {
+	return chunk.BlobID + ":" + strconv.FormatUint(chunk.CompressedOffset, 10)
+}
+
+// Chunks of identical data are stored once per blob, but may be in several blobs.
+func chunkKey(chunk layout.Chunk) digest.Digest {
+	return chunk.Digest
+}
+
+func compareChunks(base, target []layout.File) *ChunkComparison {
+	var report ChunkComparison
+
+	baseKeys := make(map[digest.Digest]struct{})
+	baseLocations := make(map[string]struct{})
+	for _, file := range base {
+		for _, chunk := range file.Chunks {
+			baseLocations[chunkLocation(chunk)] = struct{}{}
+			if _, ok := baseKeys[chunkKey(chunk)]; !ok {
+				baseKeys[chunkKey(chunk)] = struct{}{}
+				report.Base.add(chunk)
+			}
+		}
+	}
+
+	targetKeys := make(map[digest.Digest]struct{})
+	blobs := make(map[string]int)
+	report.TargetBlobs = []BlobChunkComparison{}
+	for _, file := range target {
+		for _, chunk := range file.Chunks {
+			key := chunkKey(chunk)
+			if _, ok := targetKeys[key]; ok {
+				continue
+			}
+			targetKeys[key] = struct{}{}
+
+			i, ok := blobs[chunk.BlobID]
+			if !ok {
+				i = len(report.TargetBlobs)
+				blobs[chunk.BlobID] = i
+				report.TargetBlobs = append(report.TargetBlobs, BlobChunkComparison{
+					Digest: digest.NewDigestFromEncoded(digest.SHA256, chunk.BlobID),
+				})
+			}
+			blob := &report.TargetBlobs[i]
+
+			report.Target.add(chunk)
+			blob.Total.add(chunk)
+			if _, ok := baseKeys[key]; !ok {
+				report.Unique.add(chunk)
+				continue
+			}
+			report.Shared.add(chunk)
+			blob.Shared.add(chunk)
+			if _, ok := baseLocations[chunkLocation(chunk)]; ok {
+				report.SharedInPlace.add(chunk)
+			}
+		}
+	}
+
+	if size := report.Target.CompressedSize; size > 0 {
+		report.TransferSavings = float64(report.SharedInPlace.CompressedSize) / float64(size)
+		report.PotentialTransferSavings = float64(report.Shared.CompressedSize) / float64(size)
+	}
+	return &report
+}
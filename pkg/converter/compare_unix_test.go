@@ -0,0 +1,94 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/internal/testutil"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+)
+
+func TestCompareChunks(t *testing.T) {
+	chunk := func(blob, data string, offset, size uint64) layout.Chunk {
+		return layout.Chunk{
+			BlobID:           blob,
+			Digest:           digest.FromString(data),
+			CompressedOffset: offset,
+			CompressedSize:   size,
+			UncompressedSize: size * 2,
+		}
+	}
+	baseBlob, targetBlob := digest.FromString("base").Encoded(), digest.FromString("target").Encoded()
+
+	base := []layout.File{
+		{Path: "/bin/sh", Chunks: []layout.Chunk{chunk(baseBlob, "sh", 0, 100), chunk(baseBlob, "libc", 100, 300)}},
+		{Path: "/etc/os-release", Chunks: []layout.Chunk{chunk(baseBlob, "os", 400, 10)}},
+	}
+	target := []layout.File{
+		// Built upon the base layer.
+		{Path: "/bin/sh", Chunks: []layout.Chunk{chunk(baseBlob, "sh", 0, 100), chunk(baseBlob, "libc", 100, 300)}},
+		// Identical data rebuilt into another blob.
+		{Path: "/etc/os-release", Chunks: []layout.Chunk{chunk(targetBlob, "os", 0, 10)}},
+		{Path: "/app", Chunks: []layout.Chunk{chunk(targetBlob, "app", 10, 590), chunk(targetBlob, "app", 10, 590)}},
+	}
+
+	report := compareChunks(base, target)
+	require.Equal(t, ChunkStats{Chunks: 3, CompressedSize: 410, UncompressedSize: 820}, report.Base)
+	require.Equal(t, ChunkStats{Chunks: 4, CompressedSize: 1000, UncompressedSize: 2000}, report.Target)
+	require.Equal(t, ChunkStats{Chunks: 3, CompressedSize: 410, UncompressedSize: 820}, report.Shared)
+	require.Equal(t, ChunkStats{Chunks: 2, CompressedSize: 400, UncompressedSize: 800}, report.SharedInPlace)
+	require.Equal(t, ChunkStats{Chunks: 1, CompressedSize: 590, UncompressedSize: 1180}, report.Unique)
+	require.InDelta(t, 0.4, report.TransferSavings, 1e-9)
+	require.InDelta(t, 0.41, report.PotentialTransferSavings, 1e-9)
+	require.Equal(t, []BlobChunkComparison{
+		{
+			Digest: digest.NewDigestFromEncoded(digest.SHA256, baseBlob),
+			Total:  ChunkStats{Chunks: 2, CompressedSize: 400, UncompressedSize: 800},
+			Shared: ChunkStats{Chunks: 2, CompressedSize: 400, UncompressedSize: 800},
+		},
+		{
+			Digest: digest.NewDigestFromEncoded(digest.SHA256, targetBlob),
+			Total:  ChunkStats{Chunks: 2, CompressedSize: 600, UncompressedSize: 1200},
+			Shared: ChunkStats{Chunks: 1, CompressedSize: 10, UncompressedSize: 20},
+		},
+	}, report.TargetBlobs)
+
+	report = compareChunks(nil, nil)
+	require.Zero(t, report.TransferSavings)
+	require.Empty(t, report.TargetBlobs)
+}
+
+func TestCompareChunksDigestAlgorithm(t *testing.T) {
+	bootstrap := testutil.ExtractBootstrap(t, "../filesystem/testdata/v6-bootstrap-chunk-pos-438272.tar.gz")
+	data, err := os.ReadFile(bootstrap)
+	require.NoError(t, err)
+
+	report, err := CompareChunks(bootstrap, bootstrap)
+	require.NoError(t, err)
+	require.Equal(t, report.Target, report.Shared)
+	require.InDelta(t, 1, report.PotentialTransferSavings, 1e-9)
+
+	// Swap the blake3 and sha256 flags of the extended superblock.
+	flagsOffset := layout.RafsV6SuperBlockOffset + 128
+	flags := binary.LittleEndian.Uint64(data[flagsOffset:])
+	binary.LittleEndian.PutUint64(data[flagsOffset:], flags^0xc)
+	other := filepath.Join(t.TempDir(), "other.boot")
+	require.NoError(t, os.WriteFile(other, data, 0644))
+
+	_, err = CompareChunks(bootstrap, other)
+	require.ErrorContains(t, err, "shared chunks are unknown")
+}
@@ -15,6 +15,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -31,6 +32,7 @@ import (
 	"github.com/containerd/containerd/v2/plugins/content/local"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/fifo"
+	"github.com/containerd/log"
 	"github.com/klauspost/compress/zstd"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/identity"
@@ -40,12 +42,14 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/pkg/converter/tool"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
 )
 
 const EntryBlob = "image.blob"
 const EntryBootstrap = "image.boot"
 const EntryBlobMeta = "blob.meta"
 const EntryBlobMetaHeader = "blob.meta.header"
+const EntryBlobDigest = "blob.digest"
 const EntryTOC = "rafs.blob.toc"
 
 const envNydusBuilder = "NYDUS_BUILDER"
@@ -120,6 +124,46 @@ func unpackOciTar(ctx context.Context, dst string, reader io.Reader) error {
 	return nil
 }
 
+// unpackBootstrapLayer extracts the bootstrap from a nydus bootstrap layer in the
+// content store to `dst`.
+func unpackBootstrapLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor, dst string) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "get reader for bootstrap layer %s", desc.Digest)
+	}
+	defer ra.Close()
+
+	ds, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return errors.Wrap(err, "decompress bootstrap layer")
+	}
+	defer ds.Close()
+
+	tr := tar.NewReader(ds)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return errors.Wrapf(ErrNotFound, "find %s in bootstrap layer", BootstrapFileNameInLayer)
+			}
+			return errors.Wrap(err, "read bootstrap layer")
+		}
+		if hdr.Name != BootstrapFileNameInLayer {
+			continue
+		}
+
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+		if err != nil {
+			return errors.Wrapf(err, "create bootstrap %s", dst)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, tr); err != nil {
+			return errors.Wrapf(err, "write bootstrap %s", dst)
+		}
+		return nil
+	}
+}
+
 // unpackNydusBlob unpacks a Nydus formatted tar stream into a directory.
 // unpackBlob indicates whether to unpack blob data.
 func unpackNydusBlob(bootDst, blobDst string, ra content.ReaderAt, unpackBlob bool) error {
@@ -290,6 +334,40 @@ func UnpackEntry(ra content.ReaderAt, targetName string, target io.Writer) (*TOC
 	return seekFile(ra, targetName, handle)
 }
 
+// ChunkDigests returns digests of all chunks in the nydus blob by the order of
+// chunk index, which are recorded as an array in the blob. They're digested by the
+// algorithm of the bootstrap inlined in the blob, blake3 unless the blob is built
+// with `--digester sha256`.
+func ChunkDigests(ra content.ReaderAt) ([]digest.Digest, error) {
+	header := make([]byte, layout.RafsV6SuperBlockSize)
+	if _, err := seekFile(ra, EntryBootstrap, func(r io.Reader, _ *tar.Header) error {
+		_, err := io.ReadFull(r, header)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "read bootstrap superblock")
+	}
+	algorithm, err := layout.DetectDigestAlgorithm(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "detect digest algorithm of chunks")
+	}
+
+	var buf bytes.Buffer
+	if _, err := UnpackEntry(ra, EntryBlobDigest, &buf); err != nil {
+		return nil, errors.Wrap(err, "unpack chunk digests")
+	}
+
+	const digestSize = 32
+	if buf.Len()%digestSize != 0 {
+		return nil, fmt.Errorf("invalid size %d of chunk digests", buf.Len())
+	}
+	data := buf.Bytes()
+	chunks := make([]digest.Digest, 0, len(data)/digestSize)
+	for i := 0; i < len(data); i += digestSize {
+		chunks = append(chunks, digest.NewDigestFromEncoded(algorithm, hex.EncodeToString(data[i:i+digestSize])))
+	}
+	return chunks, nil
+}
+
 func seekFile(ra content.ReaderAt, targetName string, handle func(io.Reader, *tar.Header) error) (*TOCEntry, error) {
 	// Try seek target data by TOC.
 	entry, err := seekFileByTOC(ra, targetName, handle)
@@ -348,11 +426,27 @@ func Pack(ctx context.Context, dest io.Writer, opt PackOption) (io.WriteCloser,
 		return nil, fmt.Errorf("'--batch-size' can only be supported by fs version 6")
 	}
 
+	if opt.Reproducible && opt.Encrypt {
+		return nil, fmt.Errorf("encrypted blobs can't be reproducible")
+	}
+
+	var wc io.WriteCloser
 	if opt.features.Contains(tool.FeatureTar2Rafs) {
-		return packFromTar(ctx, dest, opt)
+		wc, err = packFromTar(ctx, dest, opt)
+	} else {
+		wc, err = packFromDirectory(ctx, dest, opt, builderPath)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return packFromDirectory(ctx, dest, opt, builderPath)
+	// Layers with many hard links, e.g. conda or nix trees, are slow to convert
+	// when links refer to other links, so point each link to its inode once here.
+	rewrites := []func(*tar.Header){newHardlinkResolver().resolve}
+	if opt.Reproducible {
+		rewrites = append(rewrites, pinTimestamps(opt.SourceDateEpoch))
+	}
+	return newTarRewriter(wc, rewrites...), nil
 }
 
 func packFromDirectory(ctx context.Context, dest io.Writer, opt PackOption, builderPath string) (io.WriteCloser, error) {
@@ -366,12 +460,24 @@ func packFromDirectory(ctx context.Context, dest io.Writer, opt PackOption, buil
 		}
 	}()
 
+	// Check up front rather than failing with ENOSPC halfway through unpacking.
+	release, err := reserveSpace(workDir, opt.SourceSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "reserve space to unpack layer")
+	}
+
 	sourceDir := filepath.Join(workDir, "source")
-	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+	if err = os.MkdirAll(sourceDir, 0755); err != nil {
+		release()
 		return nil, errors.Wrap(err, "create source directory")
 	}
 
 	pr, pw := io.Pipe()
+	// Fail writes of the caller once it gives up, the work directory is still
+	// removed by Close.
+	stop := context.AfterFunc(ctx, func() {
+		pr.CloseWithError(ctx.Err())
+	})
 
 	unpackDone := make(chan bool, 1)
 	go func() {
@@ -385,6 +491,8 @@ func packFromDirectory(ctx context.Context, dest io.Writer, opt PackOption, buil
 
 	wc := newWriteCloser(pw, func() error {
 		defer os.RemoveAll(workDir)
+		defer release()
+		defer stop()
 
 		// Because PipeWriter#Close is called does not mean that the PipeReader
 		// has finished reading all the data, and unpack may not be complete yet,
@@ -400,12 +508,15 @@ func packFromDirectory(ctx context.Context, dest io.Writer, opt PackOption, buil
 
 		go func() {
 			err := tool.Pack(tool.PackOption{
+				Context:     ctx,
 				BuilderPath: builderPath,
+				WorkDir:     workDir,
 
 				BlobPath:         blobPath,
 				FsVersion:        opt.FsVersion,
 				SourcePath:       sourceDir,
 				ChunkDictPath:    opt.ChunkDictPath,
+				ChunkDictPaths:   opt.ChunkDictPaths,
 				PrefetchPatterns: opt.PrefetchPatterns,
 				AlignedChunk:     opt.AlignedChunk,
 				ChunkSize:        opt.ChunkSize,
@@ -460,9 +571,14 @@ func packFromTar(ctx context.Context, dest io.Writer, opt PackOption) (io.WriteC
 
 	pr, pw := io.Pipe()
 	eg := errgroup.Group{}
+	// The builder is killed by ctx, and writes of the caller fail then.
+	stop := context.AfterFunc(ctx, func() {
+		pr.CloseWithError(ctx.Err())
+	})
 
 	wc := newWriteCloser(pw, func() error {
 		defer os.RemoveAll(workDir)
+		defer stop()
 		if err := eg.Wait(); err != nil {
 			return errors.Wrapf(err, "convert nydus ref")
 		}
@@ -474,6 +590,8 @@ func packFromTar(ctx context.Context, dest io.Writer, opt PackOption) (io.WriteC
 		buffer := bufPool.Get().(*[]byte)
 		defer bufPool.Put(buffer)
 		if _, err := io.CopyBuffer(tarBlobFifo, pr, *buffer); err != nil {
+			// Don't block writes of the caller if the builder quits early.
+			pr.CloseWithError(err)
 			return errors.Wrapf(err, "copy targz to fifo")
 		}
 		return nil
@@ -493,6 +611,7 @@ func packFromTar(ctx context.Context, dest io.Writer, opt PackOption) (io.WriteC
 		var err error
 		if opt.OCIRef {
 			err = tool.Pack(tool.PackOption{
+				Context:     ctx,
 				BuilderPath: getBuilder(opt.BuilderPath),
 
 				OCIRef:     opt.OCIRef,
@@ -504,12 +623,15 @@ func packFromTar(ctx context.Context, dest io.Writer, opt PackOption) (io.WriteC
 			})
 		} else {
 			err = tool.Pack(tool.PackOption{
+				Context:     ctx,
 				BuilderPath: getBuilder(opt.BuilderPath),
+				WorkDir:     workDir,
 
 				BlobPath:         rafsBlobPath,
 				FsVersion:        opt.FsVersion,
 				SourcePath:       tarBlobPath,
 				ChunkDictPath:    opt.ChunkDictPath,
+				ChunkDictPaths:   opt.ChunkDictPaths,
 				PrefetchPatterns: opt.PrefetchPatterns,
 				AlignedChunk:     opt.AlignedChunk,
 				ChunkSize:        opt.ChunkSize,
@@ -549,8 +671,8 @@ func calcBlobTOCDigest(ra content.ReaderAt) (*digest.Digest, error) {
 
 // Merge multiple nydus bootstraps (from each layer of image) to a final
 // bootstrap. And due to the possibility of enabling the `ChunkDictPath`
-// option causes the data deduplication, it will return the actual blob
-// digests referenced by the bootstrap.
+// or `ChunkDictPaths` option causes the data deduplication, it will return
+// the actual blob digests referenced by the bootstrap.
 func Merge(ctx context.Context, layers []Layer, dest io.Writer, opt MergeOption) ([]digest.Digest, error) {
 	workDir, err := ensureWorkDir(opt.WorkDir)
 	if err != nil {
@@ -566,7 +688,7 @@ func Merge(ctx context.Context, layers []Layer, dest io.Writer, opt MergeOption)
 		return filepath.Join(workDir, digestHex)
 	}
 
-	eg, _ := errgroup.WithContext(ctx)
+	eg, egCtx := errgroup.WithContext(ctx)
 	sourceBootstrapPaths := []string{}
 	rafsBlobDigests := []string{}
 	rafsBlobSizes := []int64{}
@@ -584,6 +706,9 @@ func Merge(ctx context.Context, layers []Layer, dest io.Writer, opt MergeOption)
 		}
 		eg.Go(func(idx int) func() error {
 			return func() error {
+				if err := egCtx.Err(); err != nil {
+					return err
+				}
 				// Use the hex hash string of whole tar blob as the bootstrap name.
 				bootstrap, err := os.Create(getBootstrapPath(idx))
 				if err != nil {
@@ -607,7 +732,9 @@ func Merge(ctx context.Context, layers []Layer, dest io.Writer, opt MergeOption)
 	targetBootstrapPath := filepath.Join(workDir, "bootstrap")
 
 	blobDigests, err := tool.Merge(tool.MergeOption{
+		Context:     ctx,
 		BuilderPath: getBuilder(opt.BuilderPath),
+		WorkDir:     workDir,
 
 		SourceBootstrapPaths: sourceBootstrapPaths,
 		RafsBlobDigests:      rafsBlobDigests,
@@ -616,6 +743,7 @@ func Merge(ctx context.Context, layers []Layer, dest io.Writer, opt MergeOption)
 
 		TargetBootstrapPath: targetBootstrapPath,
 		ChunkDictPath:       opt.ChunkDictPath,
+		ChunkDictPaths:      opt.ChunkDictPaths,
 		ParentBootstrapPath: opt.ParentBootstrapPath,
 		PrefetchPatterns:    opt.PrefetchPatterns,
 		OutputJSONPath:      filepath.Join(workDir, "merge-output.json"),
@@ -680,6 +808,7 @@ func Unpack(ctx context.Context, ra content.ReaderAt, dest io.Writer, opt Unpack
 	defer blobFifo.Close()
 
 	unpackOpt := tool.UnpackOption{
+		Context:       ctx,
 		BuilderPath:   getBuilder(opt.BuilderPath),
 		BootstrapPath: bootPath,
 		BlobPath:      blobPath,
@@ -722,6 +851,10 @@ func Unpack(ctx context.Context, ra content.ReaderAt, dest io.Writer, opt Unpack
 		}
 		return errors.Wrap(err, "copy oci tar")
 	}
+	// The tar stream ends early if the builder is killed.
+	if unpackErr := <-unpackErrChan; unpackErr != nil {
+		return errors.Wrap(unpackErr, "unpack")
+	}
 
 	return nil
 }
@@ -811,6 +944,17 @@ func makeBlobDesc(ctx context.Context, cs content.Store, opt PackOption, sourceD
 	return &targetDesc, nil
 }
 
+// Compressed layers are assumed to expand up to this ratio when unpacked.
+const layerExpansionRatio = 4
+
+// estimateUncompressedSize estimates the uncompressed size of layer `desc` pessimistically.
+func estimateUncompressedSize(ctx context.Context, desc ocispec.Descriptor) int64 {
+	if compression, err := images.DiffCompression(ctx, desc.MediaType); err == nil && compression == "" {
+		return desc.Size
+	}
+	return desc.Size * layerExpansionRatio
+}
+
 // LayerConvertFunc returns a function which converts an OCI image layer to
 // a nydus blob layer, and set the media type to "application/vnd.oci.image.layer.nydus.blob.v1".
 func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
@@ -830,7 +974,11 @@ func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 			return nil, errors.Wrapf(err, "get blob info %s", desc.Digest)
 		}
 		if targetDigest := digest.Digest(info.Labels[LayerAnnotationNydusTargetDigest]); targetDigest.Validate() == nil {
-			return makeBlobDesc(ctx, cs, opt, desc.Digest, targetDigest)
+			newDesc, err := makeBlobDesc(ctx, cs, opt, desc.Digest, targetDigest)
+			if err != nil || !opt.GzipWrap {
+				return newDesc, err
+			}
+			return wrapBlob(ctx, cs, *newDesc)
 		}
 
 		ra, err := cs.ReaderAt(ctx, desc)
@@ -838,7 +986,11 @@ func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 			return nil, errors.Wrap(err, "get source blob reader")
 		}
 		defer ra.Close()
-		rdr := io.NewSectionReader(ra, 0, ra.Size())
+		if ra.Size() != desc.Size {
+			return nil, errors.Wrapf(ErrCorruptedSource, "%s has %d bytes instead of %d", desc.Digest, ra.Size(), desc.Size)
+		}
+		// Verified along with conversion, invalid source aborts it.
+		rdr := NewVerifyingReader(io.NewSectionReader(ra, 0, ra.Size()), desc.Digest, desc.Size)
 
 		ref := fmt.Sprintf("convert-nydus-from-%s", desc.Digest)
 		dst, err := content.OpenWriter(ctx, cs, content.WithRef(ref))
@@ -857,9 +1009,14 @@ func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 			}
 		}
 
+		packOpt := opt
+		if packOpt.SourceSize == 0 && packOpt.EstimateSourceSize {
+			packOpt.SourceSize = estimateUncompressedSize(ctx, desc)
+		}
+
 		digester := digest.SHA256.Digester()
 		pr, pw := io.Pipe()
-		tw, err := Pack(ctx, io.MultiWriter(pw, digester.Hash()), opt)
+		tw, err := Pack(ctx, io.MultiWriter(pw, digester.Hash()), packOpt)
 		if err != nil {
 			return nil, errors.Wrap(err, "pack tar to nydus")
 		}
@@ -876,6 +1033,10 @@ func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 				pw.CloseWithError(err)
 				return
 			}
+			if _, err := io.Copy(io.Discard, rdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
 			if err := tw.Close(); err != nil {
 				pw.CloseWithError(err)
 				return
@@ -892,14 +1053,137 @@ func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 			return nil, err
 		}
 
+		layerDesc := newDesc
+		if opt.GzipWrap {
+			if layerDesc, err = wrapBlob(ctx, cs, *newDesc); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := callBlobHook(ctx, cs, opt.BlobHook, *layerDesc); err != nil {
+			return nil, err
+		}
+
+		// Backend keeps the unwrapped blob, which is what nydusd reads.
 		if opt.Backend != nil {
-			if err := opt.Backend.Push(ctx, cs, *newDesc); err != nil {
-				return nil, errors.Wrap(err, "push to storage backend")
+			if err := pushBlob(ctx, cs, opt, *newDesc); err != nil {
+				return nil, err
 			}
 		}
 
-		return newDesc, nil
+		return layerDesc, nil
+	}
+}
+
+// IsGzipWrappedBlob returns true when the specified descriptor is nydus blob layer
+// wrapped in gzip.
+func IsGzipWrappedBlob(desc ocispec.Descriptor) bool {
+	return IsNydusBlob(desc) && desc.Annotations[LayerAnnotationNydusGzipWrapped] == "true"
+}
+
+// nydusBlobDigest returns digest of the nydus blob in layer `desc`, which is the
+// layer digest unless the blob is wrapped.
+func nydusBlobDigest(desc ocispec.Descriptor) digest.Digest {
+	if IsGzipWrappedBlob(desc) {
+		return digest.Digest(desc.Annotations[LayerAnnotationNydusBlobDigest])
+	}
+	return desc.Digest
+}
+
+// wrapBlob writes nydus blob `desc` in gzip into the content store, the wrapped layer
+// references the blob for garbage collection, so that it's kept to be merged.
+func wrapBlob(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get reader of blob %s", desc.Digest)
+	}
+	defer ra.Close()
+
+	cw, err := content.OpenWriter(ctx, cs, content.WithRef("nydus-gzip-"+desc.Digest.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "open content store writer")
+	}
+	defer cw.Close()
+
+	gw := gzip.NewWriter(cw)
+	buffer := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buffer)
+	if _, err := io.CopyBuffer(gw, io.NewSectionReader(ra, 0, ra.Size()), *buffer); err != nil {
+		return nil, errors.Wrapf(err, "wrap blob %s in gzip", desc.Digest)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, errors.Wrap(err, "close gzip writer")
+	}
+
+	wrappedDigest := cw.Digest()
+	if err := cw.Commit(ctx, 0, wrappedDigest, content.WithLabels(map[string]string{
+		LayerAnnotationUncompressed:            desc.Digest.String(),
+		"containerd.io/gc.ref.content.nydus.0": desc.Digest.String(),
+	})); err != nil && !errdefs.IsAlreadyExists(err) {
+		return nil, errors.Wrap(err, "commit to content store")
+	}
+	info, err := cs.Info(ctx, wrappedDigest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get info of wrapped blob %s", wrappedDigest)
+	}
+
+	annotations := make(map[string]string, len(desc.Annotations)+2)
+	for k, v := range desc.Annotations {
+		annotations[k] = v
+	}
+	annotations[LayerAnnotationNydusGzipWrapped] = "true"
+	annotations[LayerAnnotationNydusBlobDigest] = desc.Digest.String()
+
+	return &ocispec.Descriptor{
+		Digest:      wrappedDigest,
+		Size:        info.Size,
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Annotations: annotations,
+	}, nil
+}
+
+// pushBlob pushes blob `desc` to the backend unless the chunk index tells it's there
+// already, then publishes its chunks as stored.
+func pushBlob(ctx context.Context, cs content.Store, opt PackOption, desc ocispec.Descriptor) error {
+	var chunks []digest.Digest
+	// Referenced blobs hold no chunk data, which stays in the OCI layers.
+	if opt.ChunkIndex != nil && !opt.OCIRef {
+		var err error
+		if chunks, err = blobChunkDigests(ctx, cs, desc); err != nil {
+			// The index is an optimization, conversion goes on without it.
+			log.G(ctx).WithError(err).Warnf("failed to read chunks of blob %s", desc.Digest)
+		}
+	}
+
+	if len(chunks) > 0 {
+		stored, err := opt.ChunkIndex.Stored(ctx, desc.Digest.Hex(), chunks)
+		if err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to query chunk index for blob %s", desc.Digest)
+		} else if stored {
+			log.G(ctx).Infof("skip pushing blob %s already in storage backend", desc.Digest)
+			return nil
+		}
+	}
+
+	if err := opt.Backend.Push(ctx, cs, desc); err != nil {
+		return errors.Wrap(err, "push to storage backend")
 	}
+
+	if len(chunks) > 0 {
+		if err := opt.ChunkIndex.PublishStored(ctx, desc.Digest.Hex(), chunks); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to publish chunks of blob %s", desc.Digest)
+		}
+	}
+	return nil
+}
+
+func blobChunkDigests(ctx context.Context, cs content.Store, desc ocispec.Descriptor) ([]digest.Digest, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "get reader of blob")
+	}
+	defer ra.Close()
+	return ChunkDigests(ra)
 }
 
 // ConvertHookFunc returns a function which will be used as a callback
@@ -1072,8 +1356,48 @@ func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descript
 	layers := []Layer{}
 
 	var chainID digest.Digest
+	baseBlobs := map[digest.Digest]ocispec.Descriptor{}
+	baseBlobDigests := []digest.Digest{}
+	if opt.BaseBootstrap != nil {
+		workDir, err := ensureWorkDir(opt.WorkDir)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "ensure work directory")
+		}
+		defer os.RemoveAll(workDir)
+
+		opt.ParentBootstrapPath = filepath.Join(workDir, "base-bootstrap")
+		if err := unpackBootstrapLayer(ctx, cs, *opt.BaseBootstrap, opt.ParentBootstrapPath); err != nil {
+			return nil, nil, errors.Wrapf(err, "unpack base bootstrap %s", opt.BaseBootstrap.Digest)
+		}
+		for _, desc := range opt.BaseBlobs {
+			baseBlobs[nydusBlobDigest(desc)] = desc
+			baseBlobDigests = append(baseBlobDigests, nydusBlobDigest(desc))
+		}
+		chainID = opt.BaseBootstrap.Digest
+	}
+
 	nydusBlobDigests := []digest.Digest{}
+	// Layers wrapping nydus blobs in gzip, indexed by digest of the blob.
+	wrappedBlobs := map[digest.Digest]ocispec.Descriptor{}
 	for _, nydusBlobDesc := range descs {
+		if _, ok := baseBlobs[nydusBlobDigest(nydusBlobDesc)]; ok {
+			// Merged into the base bootstrap already.
+			continue
+		}
+		if IsGzipWrappedBlob(nydusBlobDesc) {
+			blobDigest := nydusBlobDigest(nydusBlobDesc)
+			blobInfo, err := cs.Info(ctx, blobDigest)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "get info of blob %s wrapped in layer %s", blobDigest, nydusBlobDesc.Digest)
+			}
+			wrappedBlobs[blobDigest] = nydusBlobDesc
+			nydusBlobDesc = ocispec.Descriptor{
+				Digest:      blobDigest,
+				Size:        blobInfo.Size,
+				MediaType:   MediaTypeNydusBlob,
+				Annotations: nydusBlobDesc.Annotations,
+			}
+		}
 		ra, err := cs.ReaderAt(ctx, nydusBlobDesc)
 		if err != nil {
 			return nil, nil, errors.Wrapf(err, "get reader for blob %q", nydusBlobDesc.Digest)
@@ -1152,14 +1476,27 @@ func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descript
 	originalBlobDigests := <-originalBlobDigestChan
 	blobDescs := []ocispec.Descriptor{}
 
-	var blobDigests []digest.Digest
+	// Blobs of the base image come first, whether the builder reports them or not.
+	blobDigests := baseBlobDigests
 	if opt.OCIRef {
-		blobDigests = nydusBlobDigests
+		blobDigests = append(blobDigests, nydusBlobDigests...)
 	} else {
-		blobDigests = originalBlobDigests
+		for _, blobDigest := range originalBlobDigests {
+			if _, ok := baseBlobs[blobDigest]; !ok {
+				blobDigests = append(blobDigests, blobDigest)
+			}
+		}
 	}
 
 	for idx, blobDigest := range blobDigests {
+		if desc, ok := baseBlobs[blobDigest]; ok {
+			blobDescs = append(blobDescs, desc)
+			continue
+		}
+		if desc, ok := wrappedBlobs[blobDigest]; ok {
+			blobDescs = append(blobDescs, desc)
+			continue
+		}
 		blobInfo, err := cs.Info(ctx, blobDigest)
 		if err != nil {
 			return nil, nil, errors.Wrap(err, "get info from content store")
@@ -1174,7 +1511,7 @@ func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descript
 			},
 		}
 		if opt.OCIRef {
-			blobDesc.Annotations[label.NydusRefLayer] = layers[idx].OriginalDigest.String()
+			blobDesc.Annotations[label.NydusRefLayer] = layers[idx-len(baseBlobDigests)].OriginalDigest.String()
 		}
 
 		if opt.Encrypt != nil {
@@ -1211,5 +1548,25 @@ func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descript
 			return nil, nil, errors.Wrap(err, "encrypt bootstrap layer")
 		}
 	}
+
+	if err := callBlobHook(ctx, cs, opt.BlobHook, bootstrapDesc); err != nil {
+		return nil, nil, err
+	}
+
 	return &bootstrapDesc, blobDescs, nil
 }
+
+func callBlobHook(ctx context.Context, cs content.Store, hook BlobHook, desc ocispec.Descriptor) error {
+	if hook == nil {
+		return nil
+	}
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrapf(err, "get reader of blob %s", desc.Digest)
+	}
+	defer ra.Close()
+	if err := hook(ctx, desc, ra); err != nil {
+		return errors.Wrapf(err, "call hook for blob %s", desc.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+)
+
+func TestUnpackBootstrapLayer(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	rc := packToTar([]File{{Name: EntryBootstrap, Reader: strings.NewReader("bootstrap"), Size: 9}}, true)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, content.WriteBlob(ctx, cs, "base-bootstrap", bytes.NewReader(data), desc))
+
+	dst := filepath.Join(t.TempDir(), "bootstrap")
+	require.NoError(t, unpackBootstrapLayer(ctx, cs, desc, dst))
+	bootstrap, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "bootstrap", string(bootstrap))
+
+	// Not a bootstrap layer.
+	rc = packToTar([]File{{Name: EntryBlob, Reader: strings.NewReader("blob"), Size: 4}}, true)
+	data, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	desc.Digest, desc.Size = digest.FromBytes(data), int64(len(data))
+	require.NoError(t, content.WriteBlob(ctx, cs, "blob", bytes.NewReader(data), desc))
+	require.ErrorIs(t, unpackBootstrapLayer(ctx, cs, desc, dst), ErrNotFound)
+}
+
+func TestCallBlobHook(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	data := []byte("nydus blob")
+	desc := ocispec.Descriptor{
+		MediaType: MediaTypeNydusBlob,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, content.WriteBlob(ctx, cs, "blob", bytes.NewReader(data), desc))
+
+	require.NoError(t, callBlobHook(ctx, cs, nil, desc))
+
+	var hooked []byte
+	require.NoError(t, callBlobHook(ctx, cs, func(_ context.Context, d ocispec.Descriptor, ra content.ReaderAt) error {
+		require.Equal(t, desc.Digest, d.Digest)
+		hooked, err = io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+		return err
+	}, desc))
+	require.Equal(t, data, hooked)
+
+	errRejected := errors.New("rejected")
+	err = callBlobHook(ctx, cs, func(context.Context, ocispec.Descriptor, content.ReaderAt) error {
+		return errRejected
+	}, desc)
+	require.ErrorIs(t, err, errRejected)
+}
+
+func TestWrapBlob(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	data := []byte("nydus blob")
+	desc := ocispec.Descriptor{
+		MediaType: MediaTypeNydusBlob,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+		Annotations: map[string]string{
+			LayerAnnotationUncompressed: digest.FromBytes(data).String(),
+			LayerAnnotationNydusBlob:    "true",
+		},
+	}
+	require.NoError(t, content.WriteBlob(ctx, cs, "blob", bytes.NewReader(data), desc))
+	require.False(t, IsGzipWrappedBlob(desc))
+	require.Equal(t, desc.Digest, nydusBlobDigest(desc))
+
+	wrapped, err := wrapBlob(ctx, cs, desc)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageLayerGzip, wrapped.MediaType)
+	require.True(t, IsNydusBlob(*wrapped))
+	require.True(t, IsGzipWrappedBlob(*wrapped))
+	require.Equal(t, desc.Digest, nydusBlobDigest(*wrapped))
+	require.Equal(t, desc.Digest.String(), wrapped.Annotations[LayerAnnotationUncompressed])
+
+	ra, err := cs.ReaderAt(ctx, *wrapped)
+	require.NoError(t, err)
+	defer ra.Close()
+	require.Equal(t, wrapped.Size, ra.Size())
+	gr, err := gzip.NewReader(io.NewSectionReader(ra, 0, ra.Size()))
+	require.NoError(t, err)
+	unwrapped, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, data, unwrapped)
+
+	// Wrapping again hits the content store.
+	again, err := wrapBlob(ctx, cs, desc)
+	require.NoError(t, err)
+	require.Equal(t, wrapped.Digest, again.Digest)
+}
+
+// A nydus blob of chunks filled with `data`, digested as `digestFlag` of the bootstrap tells,
+// whose entries are laid out like the builder does, each followed by its tar header.
+func makeNydusBlob(t *testing.T, digestFlag byte, data ...byte) []byte {
+	bootstrap := make([]byte, layout.RafsV6SuperBlockSize)
+	binary.LittleEndian.PutUint32(bootstrap[layout.RafsV6SuperBlockOffset:], layout.RafsV6SuperMagic)
+	bootstrap[layout.RafsV6SuperBlockOffset+128] = digestFlag
+	var chunks []byte
+	for _, b := range data {
+		chunks = append(chunks, bytes.Repeat([]byte{b}, 32)...)
+	}
+
+	var blob bytes.Buffer
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{EntryBlobDigest, chunks}, {EntryBootstrap, bootstrap}} {
+		blob.Write(entry.data)
+		var hdr bytes.Buffer
+		require.NoError(t, tar.NewWriter(&hdr).WriteHeader(&tar.Header{Name: entry.name, Size: int64(len(entry.data)), Mode: 0444}))
+		blob.Write(hdr.Bytes()[:512])
+	}
+	return blob.Bytes()
+}
+
+func TestChunkDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blob")
+	// Blake3 flag of the extended superblock.
+	require.NoError(t, os.WriteFile(path, makeNydusBlob(t, 0x4, 0xab, 0xcd), 0644))
+	ra, err := local.OpenReader(path)
+	require.NoError(t, err)
+	defer ra.Close()
+
+	digests, err := ChunkDigests(ra)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{
+		digest.Digest("blake3:" + strings.Repeat("ab", 32)),
+		digest.Digest("blake3:" + strings.Repeat("cd", 32)),
+	}, digests)
+
+	// Builders digesting with sha256.
+	require.NoError(t, os.WriteFile(path, makeNydusBlob(t, 0x8, 0xab), 0644))
+	ra, err = local.OpenReader(path)
+	require.NoError(t, err)
+	defer ra.Close()
+	digests, err = ChunkDigests(ra)
+	require.NoError(t, err)
+	require.Equal(t, digest.SHA256, digests[0].Algorithm())
+}
+
+type fakeBackend struct {
+	pushed []digest.Digest
+	err    error
+}
+
+func (b *fakeBackend) Push(_ context.Context, _ content.Store, desc ocispec.Descriptor) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.pushed = append(b.pushed, desc.Digest)
+	return nil
+}
+
+func (b *fakeBackend) Check(digest.Digest) (string, error) { return "", nil }
+
+func (b *fakeBackend) Type() string { return "fake" }
+
+type fakeChunkIndex struct {
+	stored map[string]bool
+}
+
+func (i *fakeChunkIndex) Publish(context.Context, string, []digest.Digest) error {
+	return errors.New("converters publish stored blobs only")
+}
+
+func (i *fakeChunkIndex) PublishStored(_ context.Context, blobID string, _ []digest.Digest) error {
+	i.stored[blobID] = true
+	return nil
+}
+
+func (i *fakeChunkIndex) Stored(_ context.Context, blobID string, _ []digest.Digest) (bool, error) {
+	return i.stored[blobID], nil
+}
+
+func TestPushBlob(t *testing.T) {
+	ctx := context.Background()
+	cs, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	data := makeNydusBlob(t, 0x4, 0x1, 0x2)
+	desc := ocispec.Descriptor{MediaType: MediaTypeNydusBlob, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	require.NoError(t, content.WriteBlob(ctx, cs, "blob", bytes.NewReader(data), desc))
+
+	// Blobs failing to push are not published.
+	backend := &fakeBackend{err: errors.New("unavailable")}
+	index := &fakeChunkIndex{stored: map[string]bool{}}
+	opt := PackOption{Backend: backend, ChunkIndex: index}
+	require.Error(t, pushBlob(ctx, cs, opt, desc))
+	require.Empty(t, index.stored)
+
+	backend.err = nil
+	require.NoError(t, pushBlob(ctx, cs, opt, desc))
+	require.Equal(t, []digest.Digest{desc.Digest}, backend.pushed)
+	require.True(t, index.stored[desc.Digest.Hex()])
+
+	// Pushed once only.
+	require.NoError(t, pushBlob(ctx, cs, opt, desc))
+	require.Len(t, backend.pushed, 1)
+}
@@ -43,6 +43,10 @@ func IsNydusBootstrap(desc ocispec.Descriptor) bool {
 	panic("not implemented")
 }
 
+func IsGzipWrappedBlob(desc ocispec.Descriptor) bool {
+	panic("not implemented")
+}
+
 func LayerConvertFunc(opt PackOption) converter.ConvertFunc {
 	panic("not implemented")
 }
@@ -54,3 +58,11 @@ func ConvertHookFunc(opt MergeOption) converter.ConvertHookFunc {
 func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descriptor, opt MergeOption) (*ocispec.Descriptor, []ocispec.Descriptor, error) {
 	panic("not implemented")
 }
+
+func Provenance(bootstrapPath string) (*ProvenanceReport, error) {
+	panic("not implemented")
+}
+
+func CompareChunks(baseBootstrap, targetBootstrap string) (*ChunkComparison, error) {
+	panic("not implemented")
+}
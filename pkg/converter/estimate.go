@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultChunkSize = 0x100000
+	paxXattrPrefix   = "SCHILY.xattr."
+)
+
+// Every this many distinct chunks one is compressed to sample the compression ratio.
+const estimateSampleInterval = 8
+
+// Approximate sizes of RAFS metadata on disk.
+const (
+	rafsSuperBlockSize   = 8192
+	rafsV5InodeSize      = 128
+	rafsV5ChunkInfoSize  = 80
+	rafsV6InodeSize      = 64
+	rafsV6ChunkIndexSize = 8
+	rafsDirentSize       = 12
+	blobChunkInfoSize    = 16
+)
+
+// EstimateConvertedSize scans the OCI layer `source`, in tar or compressed tar, and
+// predicts sizes of the nydus blob and bootstrap converted from it without running
+// the builder. Data chunks are deduplicated like the builder does, only a sample of
+// them is compressed, so the blob size is approximate for compressed blobs.
+//
+// lz4_block is estimated by the fastest level of zstd.
+func EstimateConvertedSize(ctx context.Context, source io.Reader, opt EstimateOption) (*SizeEstimate, error) {
+	chunkSize := int64(defaultChunkSize)
+	if opt.ChunkSize != "" {
+		size, err := strconv.ParseInt(opt.ChunkSize, 0, 64)
+		if err != nil || size < 0x1000 || size > 0x1000000 || size&(size-1) != 0 {
+			return nil, errors.Errorf("invalid chunk size %s", opt.ChunkSize)
+		}
+		chunkSize = size
+	}
+
+	inodeSize, chunkRefSize := int64(rafsV6InodeSize), int64(rafsV6ChunkIndexSize)
+	switch opt.FsVersion {
+	case "", "6":
+	case "5":
+		inodeSize, chunkRefSize = rafsV5InodeSize, rafsV5ChunkInfoSize
+	default:
+		return nil, errors.Errorf("unsupported fs version %s", opt.FsVersion)
+	}
+
+	var encoder *zstd.Encoder
+	var err error
+	switch opt.Compressor {
+	case "", "zstd":
+		encoder, err = zstd.NewWriter(nil)
+	case "lz4_block":
+		encoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	case "none":
+	default:
+		return nil, errors.Errorf("unsupported compressor %s", opt.Compressor)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "create compressor")
+	}
+
+	ds, err := compression.DecompressStream(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress layer stream")
+	}
+	defer ds.Close()
+
+	var estimate SizeEstimate
+	var sampled, sampledCompressed int64
+	var chunkRefs int64
+	metaSize := int64(rafsSuperBlockSize)
+	chunks := make(map[[sha256.Size]byte]struct{})
+	buf := make([]byte, chunkSize)
+	var compressed []byte
+
+	tr := tar.NewReader(ds)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read layer entry")
+		}
+
+		estimate.Files++
+		metaSize += rafsDirentSize + int64(len(path.Base(path.Clean(hdr.Name))))
+		for key, value := range hdr.PAXRecords {
+			if name, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+				metaSize += int64(len(name) + len(value))
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			// Hard links share the inode of their targets.
+			continue
+		case tar.TypeSymlink:
+			metaSize += int64(len(hdr.Linkname))
+		}
+		metaSize += inodeSize
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		for remaining := hdr.Size; remaining > 0; {
+			n, err := io.ReadFull(tr, buf[:min(remaining, chunkSize)])
+			if err != nil {
+				return nil, errors.Wrapf(err, "read data of %s", hdr.Name)
+			}
+			remaining -= int64(n)
+			chunkRefs++
+
+			sum := sha256.Sum256(buf[:n])
+			if _, ok := chunks[sum]; ok {
+				continue
+			}
+			chunks[sum] = struct{}{}
+			estimate.UncompressedSize += int64(n)
+			if encoder != nil && len(chunks)%estimateSampleInterval == 1 {
+				compressed = encoder.EncodeAll(buf[:n], compressed[:0])
+				sampled += int64(n)
+				// The builder stores chunks not shrunk by compression as they are.
+				sampledCompressed += int64(min(len(compressed), n))
+			}
+		}
+	}
+
+	estimate.Chunks = len(chunks)
+	estimate.BlobSize = estimate.UncompressedSize
+	if sampled > 0 {
+		estimate.BlobSize = int64(float64(estimate.UncompressedSize) * float64(sampledCompressed) / float64(sampled))
+	}
+	estimate.BlobSize += int64(estimate.Chunks) * blobChunkInfoSize
+	estimate.BootstrapSize = metaSize + chunkRefs*chunkRefSize
+
+	return &estimate, nil
+}
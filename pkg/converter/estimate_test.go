@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func estimateFiles(t *testing.T, opt EstimateOption, files ...File) (*SizeEstimate, error) {
+	t.Helper()
+	rc := packToTar(files, true)
+	defer rc.Close()
+	return EstimateConvertedSize(context.Background(), rc, opt)
+}
+
+func TestEstimateConvertedSize(t *testing.T) {
+	random := make([]byte, 2*defaultChunkSize+100)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	zeros := make([]byte, 4*defaultChunkSize)
+
+	// Identical files are stored once.
+	estimate, err := estimateFiles(t, EstimateOption{Compressor: "none"},
+		File{Name: "a", Reader: bytes.NewReader(random), Size: int64(len(random))},
+		File{Name: "b", Reader: bytes.NewReader(random), Size: int64(len(random))},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, estimate.Files)
+	require.Equal(t, 3, estimate.Chunks)
+	require.Equal(t, int64(len(random)), estimate.UncompressedSize)
+	require.Equal(t, int64(len(random))+3*blobChunkInfoSize, estimate.BlobSize)
+	require.Greater(t, estimate.BootstrapSize, int64(rafsSuperBlockSize))
+
+	// Random data doesn't shrink, while zeros do.
+	estimate, err = estimateFiles(t, EstimateOption{},
+		File{Name: "a", Reader: bytes.NewReader(random), Size: int64(len(random))})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(random))+3*blobChunkInfoSize, estimate.BlobSize)
+
+	estimate, err = estimateFiles(t, EstimateOption{ChunkSize: "0x400000"},
+		File{Name: "zeros", Reader: bytes.NewReader(zeros), Size: int64(len(zeros))})
+	require.NoError(t, err)
+	require.Equal(t, 1, estimate.Chunks)
+	require.Less(t, estimate.BlobSize, int64(len(zeros))/100)
+
+	// RAFS v5 has larger metadata.
+	v5, err := estimateFiles(t, EstimateOption{FsVersion: "5"},
+		File{Name: "zeros", Reader: bytes.NewReader(zeros), Size: int64(len(zeros))})
+	require.NoError(t, err)
+	v6, err := estimateFiles(t, EstimateOption{FsVersion: "6"},
+		File{Name: "zeros", Reader: bytes.NewReader(zeros), Size: int64(len(zeros))})
+	require.NoError(t, err)
+	require.Greater(t, v5.BootstrapSize, v6.BootstrapSize)
+
+	for _, opt := range []EstimateOption{{ChunkSize: "0x1001"}, {Compressor: "gzip"}, {FsVersion: "4"}} {
+		_, err := EstimateConvertedSize(context.Background(), bytes.NewReader(nil), opt)
+		require.Error(t, err)
+	}
+}
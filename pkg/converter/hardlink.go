@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"archive/tar"
+	"path"
+	"strings"
+)
+
+// hardlinkResolver rewrites hard links of a layer in a single pass, so that
+// every link refers to the first path of its inode instead of another link.
+// Links to paths not seen in the layer are left as they are.
+type hardlinkResolver struct {
+	// First path of the inode of each path having hard links.
+	first map[string]string
+	// Links to each first path, in the order of the layer.
+	links map[string][]string
+}
+
+func newHardlinkResolver() *hardlinkResolver {
+	return &hardlinkResolver{
+		first: make(map[string]string),
+		links: make(map[string][]string),
+	}
+}
+
+func cleanTarPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+func (r *hardlinkResolver) resolve(hdr *tar.Header) {
+	name := cleanTarPath(hdr.Name)
+	if _, ok := r.first[name]; ok {
+		// A later entry replaces the path.
+		r.remove(name)
+	}
+	if hdr.Typeflag != tar.TypeLink {
+		return
+	}
+
+	target := cleanTarPath(hdr.Linkname)
+	if first, ok := r.first[target]; ok {
+		if first != target {
+			hdr.Linkname = first
+		}
+		target = first
+	} else {
+		r.first[target] = target
+	}
+	if target == name {
+		return
+	}
+	r.first[name] = target
+	r.links[target] = append(r.links[target], name)
+}
+
+func (r *hardlinkResolver) remove(name string) {
+	first := r.first[name]
+	delete(r.first, name)
+
+	links := r.links[first]
+	if name != first {
+		for i, link := range links {
+			if link == name {
+				r.links[first] = append(links[:i:i], links[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+
+	// The remaining links keep the inode, the first of them stands for it now.
+	delete(r.links, first)
+	if len(links) == 0 {
+		return
+	}
+	next := links[0]
+	r.first[next] = next
+	for _, link := range links[1:] {
+		r.first[link] = next
+	}
+	if len(links) > 1 {
+		r.links[next] = links[1:]
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHardlinkResolver(t *testing.T) {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	for _, hdr := range []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		{Name: "./b", Typeflag: tar.TypeLink, Linkname: "a"},
+		{Name: "c", Typeflag: tar.TypeLink, Linkname: "./b"},
+		{Name: "d", Typeflag: tar.TypeLink, Linkname: "/c"},
+		// Replaces the first path of the inode, which is kept by its links.
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		{Name: "e", Typeflag: tar.TypeLink, Linkname: "d"},
+		{Name: "f", Typeflag: tar.TypeLink, Linkname: "a"},
+		// Not in the layer.
+		{Name: "g", Typeflag: tar.TypeLink, Linkname: "lower"},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte("x"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	var out bytes.Buffer
+	w := newTarRewriter(nopWriteCloser{&out}, newHardlinkResolver().resolve)
+	_, err := io.Copy(w, &layer)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	links := make(map[string]string)
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeLink {
+			links[hdr.Name] = hdr.Linkname
+		}
+	}
+	require.Equal(t, map[string]string{
+		"./b": "a",
+		"c":   "a",
+		"d":   "a",
+		"e":   "b",
+		"f":   "a",
+		"g":   "lower",
+	}, links)
+}
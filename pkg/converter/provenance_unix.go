@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+)
+
+// Provenance reports which blobs, and which ranges of them, every regular file in the
+// bootstrap is read from, to trace a file of a running container back to registry
+// blobs during incident response.
+func Provenance(bootstrapPath string) (*ProvenanceReport, error) {
+	files, err := bootstrapFiles(bootstrapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ProvenanceReport{Files: make([]FileProvenance, 0, len(files))}
+	for _, file := range files {
+		report.Files = append(report.Files, fileProvenance(file))
+	}
+	return &report, nil
+}
+
+// Regular files of the bootstrap with their chunks, read from the chunk table.
+func bootstrapFiles(bootstrapPath string) ([]layout.File, error) {
+	bootstrap, err := layout.ReadBootstrap(bootstrapPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := bootstrap.Files()
+	if err != nil {
+		return nil, errors.Wrapf(err, "read files of bootstrap %s", bootstrapPath)
+	}
+	return files, nil
+}
+
+func fileProvenance(file layout.File) FileProvenance {
+	fp := FileProvenance{Path: file.Path, Blobs: []BlobProvenance{}}
+	index := map[string]int{}
+	for _, chunk := range file.Chunks {
+		i, ok := index[chunk.BlobID]
+		if !ok {
+			i = len(fp.Blobs)
+			index[chunk.BlobID] = i
+			fp.Blobs = append(fp.Blobs, BlobProvenance{
+				Digest: digest.NewDigestFromEncoded(digest.SHA256, chunk.BlobID),
+			})
+		}
+
+		blob := &fp.Blobs[i]
+		if n := len(blob.Ranges); n > 0 {
+			// Chunks of a file are mostly laid out contiguously in the blob.
+			last := &blob.Ranges[n-1]
+			if last.CompressedOffset+last.CompressedSize == chunk.CompressedOffset &&
+				last.UncompressedOffset+last.UncompressedSize == chunk.UncompressedOffset {
+				last.CompressedSize += chunk.CompressedSize
+				last.UncompressedSize += chunk.UncompressedSize
+				continue
+			}
+		}
+		blob.Ranges = append(blob.Ranges, BlobRange{
+			CompressedOffset:   chunk.CompressedOffset,
+			CompressedSize:     chunk.CompressedSize,
+			UncompressedOffset: chunk.UncompressedOffset,
+			UncompressedSize:   chunk.UncompressedSize,
+		})
+	}
+	return fp
+}
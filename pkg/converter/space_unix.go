@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Space reserved by conversions in progress, by device of their work directories.
+var (
+	reservedMutex sync.Mutex
+	reservedSpace = make(map[uint64]uint64)
+)
+
+// reserveSpace fails with ErrInsufficientSpace unless the filesystem of `dir` has
+// `size` bytes available besides the space reserved by other conversions, otherwise
+// reserves it until `release` is called.
+func reserveSpace(dir string, size int64) (release func(), err error) {
+	if size <= 0 {
+		return func() {}, nil
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return nil, errors.Wrapf(err, "stat %s", dir)
+	}
+	var fs unix.Statfs_t
+	if err := unix.Statfs(dir, &fs); err != nil {
+		return nil, errors.Wrapf(err, "statfs %s", dir)
+	}
+	dev := uint64(st.Dev) //nolint:unconvert
+	available := fs.Bavail * uint64(fs.Bsize)
+	required := uint64(size)
+
+	reservedMutex.Lock()
+	defer reservedMutex.Unlock()
+
+	reserved := reservedSpace[dev]
+	if available < reserved || available-reserved < required {
+		return nil, errors.Wrapf(ErrInsufficientSpace,
+			"work directory %s requires %d bytes, but only %d bytes are available with %d bytes reserved by other conversions",
+			dir, required, available, reserved)
+	}
+	reservedSpace[dev] += required
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			reservedMutex.Lock()
+			defer reservedMutex.Unlock()
+			if reservedSpace[dev] -= required; reservedSpace[dev] == 0 {
+				delete(reservedSpace, dev)
+			}
+		})
+	}, nil
+}
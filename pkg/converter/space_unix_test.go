@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReserveSpace(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := reserveSpace(dir, 1<<62)
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+
+	release, err := reserveSpace(dir, 0)
+	require.NoError(t, err)
+	release()
+
+	var fs unix.Statfs_t
+	require.NoError(t, unix.Statfs(dir, &fs))
+	half := int64(fs.Bavail*uint64(fs.Bsize)) / 2
+
+	// Space reserved by a conversion isn't available to others until released.
+	release, err = reserveSpace(dir, half+1)
+	require.NoError(t, err)
+	_, err = reserveSpace(dir, half+1)
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+
+	release()
+	release()
+	release, err = reserveSpace(dir, half+1)
+	require.NoError(t, err)
+	release()
+}
@@ -10,8 +10,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -22,18 +25,68 @@ import (
 
 var logger = logrus.WithField("module", "builder")
 
+// Time to wait for pipes of the builder to be closed after it's killed, which may be
+// held by its orphaned children.
+const builderWaitDelay = 3 * time.Second
+
 func isSignalKilled(err error) bool {
 	return strings.Contains(err.Error(), "signal: killed")
 }
 
+// Run the builder until it exits, or kill it once `ctx` is done or `timeout` is
+// exceeded. Output of the builder is logged unless `stdout` is given, failures are
+// returned as *BuilderError diagnosed from stderr.
+func run(ctx context.Context, builderPath string, args []string, stdin io.Reader, stdout io.Writer, timeout *time.Duration) error {
+	var cancel context.CancelFunc
+	if timeout != nil {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	logrus.Debugf("\tCommand: %s %s", builderPath, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, builderPath, args...)
+	cmd.Stdout = logger.Writer()
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	stderr := &tailBuffer{size: stderrTailSize}
+	cmd.Stderr = io.MultiWriter(logger.Writer(), stderr)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.WaitDelay = builderWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if isSignalKilled(err) && timeout != nil {
+			logrus.WithError(err).Errorf("fail to run %v %+v, possibly due to timeout %v", builderPath, args, *timeout)
+		} else {
+			logrus.WithError(err).Errorf("fail to run %v %+v", builderPath, args)
+		}
+		// The builder is killed as the caller has given up.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return errors.Wrapf(ctxErr, "run builder: %v", err)
+		}
+		return newBuilderError(err, stderr.Bytes())
+	}
+
+	return nil
+}
+
 type PackOption struct {
 	BuilderPath string
+	// Context bounds the builder run besides Timeout, nil means context.Background().
+	Context context.Context
+
+	// Directory of temporary files, e.g. merged chunk dicts, empty means os.TempDir().
+	WorkDir string
 
 	BootstrapPath    string
 	BlobPath         string
 	FsVersion        string
 	SourcePath       string
 	ChunkDictPath    string
+	ChunkDictPaths   []string
 	PrefetchPatterns string
 	Compressor       string
 	OCIRef           bool
@@ -48,6 +101,10 @@ type PackOption struct {
 
 type MergeOption struct {
 	BuilderPath string
+	// Like PackOption.Context.
+	Context context.Context
+	// Like PackOption.WorkDir.
+	WorkDir string
 
 	SourceBootstrapPaths []string
 	RafsBlobDigests      []string
@@ -56,6 +113,7 @@ type MergeOption struct {
 
 	TargetBootstrapPath string
 	ChunkDictPath       string
+	ChunkDictPaths      []string
 	ParentBootstrapPath string
 	PrefetchPatterns    string
 	OutputJSONPath      string
@@ -63,7 +121,10 @@ type MergeOption struct {
 }
 
 type UnpackOption struct {
-	BuilderPath       string
+	BuilderPath string
+	// Like PackOption.Context.
+	Context context.Context
+
 	BootstrapPath     string
 	BlobPath          string
 	BackendConfigPath string
@@ -75,11 +136,68 @@ type outputJSON struct {
 	Blobs []string
 }
 
-func buildPackArgs(option PackOption) []string {
+func chunkDictArgs(chunkDictPath string) []string {
+	if chunkDictPath == "" {
+		return []string{}
+	}
+	return []string{"--chunk-dict", fmt.Sprintf("bootstrap=%s", chunkDictPath)}
+}
+
+// mergeChunkDicts merges chunk dicts into a temporary one for builder accepting a
+// single `--chunk-dict`. Bootstraps merged later are upper layers, so the dicts are
+// merged in reverse order to let a file of an earlier dict win. The returned func
+// removes the merged dict.
+func mergeChunkDicts(ctx context.Context, builderPath, workDir, chunkDictPath string, chunkDictPaths []string, timeout *time.Duration) (string, func(), error) {
+	paths := []string{}
+	for _, path := range append([]string{chunkDictPath}, chunkDictPaths...) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return "", func() {}, nil
+	} else if len(paths) == 1 {
+		return paths[0], func() {}, nil
+	}
+
+	workDir, err := os.MkdirTemp(workDir, "nydus-chunk-dict-")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create work directory")
+	}
+	cleanup := func() { os.RemoveAll(workDir) }
+
+	target := filepath.Join(workDir, "chunk-dict.boot")
+	args := []string{
+		"merge",
+		"--log-level",
+		"warn",
+		"--output-json",
+		filepath.Join(workDir, "output.json"),
+		"--bootstrap",
+		target,
+	}
+	slices.Reverse(paths)
+	args = append(args, paths...)
+	if err := run(ctx, builderPath, args, nil, nil, timeout); err != nil {
+		cleanup()
+		return "", nil, errors.Wrap(err, "merge chunk dicts")
+	}
+
+	return target, cleanup, nil
+}
+
+// buildPackArgs builds arguments of `nydus-image create` for `option`, the source is
+// an overlayfs upper directory if `upperDir`, otherwise an OCI layer as tar stream or
+// directory.
+func buildPackArgs(option PackOption, upperDir bool) []string {
 	if option.FsVersion == "" {
 		option.FsVersion = "6"
 	}
 
+	whiteoutSpec := "none"
+	if upperDir {
+		whiteoutSpec = "overlayfs"
+	}
 	args := []string{
 		"create",
 		"--log-level",
@@ -89,16 +207,20 @@ func buildPackArgs(option PackOption) []string {
 		"--blob",
 		option.BlobPath,
 		"--whiteout-spec",
-		"none",
+		whiteoutSpec,
 		"--fs-version",
 		option.FsVersion,
 	}
 
-	if option.Features.Contains(FeatureTar2Rafs) {
+	if upperDir || option.Features.Contains(FeatureTar2Rafs) {
+		sourceType := "tar-rafs"
+		if upperDir {
+			sourceType = "dir-rafs"
+		}
 		args = append(
 			args,
 			"--type",
-			"tar-rafs",
+			sourceType,
 			"--blob-inline-meta",
 		)
 		if option.FsVersion == "6" {
@@ -119,12 +241,7 @@ func buildPackArgs(option PackOption) []string {
 		)
 	}
 
-	if option.ChunkDictPath != "" {
-		args = append(args, "--chunk-dict", fmt.Sprintf("bootstrap=%s", option.ChunkDictPath))
-	}
-	if option.PrefetchPatterns == "" {
-		option.PrefetchPatterns = "/"
-	}
+	args = append(args, chunkDictArgs(option.ChunkDictPath)...)
 	if option.Compressor != "" {
 		args = append(args, "--compressor", option.Compressor)
 	}
@@ -145,39 +262,49 @@ func buildPackArgs(option PackOption) []string {
 	return args
 }
 
+func contextOf(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 func Pack(option PackOption) error {
+	ctx := contextOf(option.Context)
 	if option.OCIRef {
-		return packRef(option)
+		return packRef(ctx, option)
 	}
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if option.Timeout != nil {
-		ctx, cancel = context.WithTimeout(ctx, *option.Timeout)
-		defer cancel()
+	chunkDict, cleanup, err := mergeChunkDicts(ctx, option.BuilderPath, option.WorkDir, option.ChunkDictPath, option.ChunkDictPaths, option.Timeout)
+	if err != nil {
+		return err
 	}
+	defer cleanup()
+	option.ChunkDictPath, option.ChunkDictPaths = chunkDict, nil
 
-	args := buildPackArgs(option)
-	logrus.Debugf("\tCommand: %s %s", option.BuilderPath, strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, option.BuilderPath, args...)
-	cmd.Stdout = logger.Writer()
-	cmd.Stderr = logger.Writer()
-	cmd.Stdin = strings.NewReader(option.PrefetchPatterns)
+	args := buildPackArgs(option, false)
+	return run(ctx, option.BuilderPath, args, strings.NewReader(option.PrefetchPatterns), nil, option.Timeout)
+}
 
-	if err := cmd.Run(); err != nil {
-		if isSignalKilled(err) && option.Timeout != nil {
-			logrus.WithError(err).Errorf("fail to run %v %+v, possibly due to timeout %v", option.BuilderPath, args, *option.Timeout)
-		} else {
-			logrus.WithError(err).Errorf("fail to run %v %+v", option.BuilderPath, args)
-		}
+// PackDirectory builds a nydus blob with inlined bootstrap from the overlayfs upper
+// directory `option.SourcePath`, whose whiteouts are translated to nydus whiteouts.
+func PackDirectory(option PackOption) error {
+	ctx := contextOf(option.Context)
+	if option.PrefetchPatterns == "" {
+		option.PrefetchPatterns = "/"
+	}
+	chunkDict, cleanup, err := mergeChunkDicts(ctx, option.BuilderPath, option.WorkDir, option.ChunkDictPath, option.ChunkDictPaths, option.Timeout)
+	if err != nil {
 		return err
 	}
+	defer cleanup()
+	option.ChunkDictPath, option.ChunkDictPaths = chunkDict, nil
 
-	return nil
+	args := buildPackArgs(option, true)
+	return run(ctx, option.BuilderPath, args, strings.NewReader(option.PrefetchPatterns), nil, option.Timeout)
 }
 
-func packRef(option PackOption) error {
+func packRef(ctx context.Context, option PackOption) error {
 	args := []string{
 		"create",
 		"--log-level",
@@ -192,25 +319,7 @@ func packRef(option PackOption) error {
 	}
 	args = append(args, option.SourcePath)
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if option.Timeout != nil {
-		ctx, cancel = context.WithTimeout(ctx, *option.Timeout)
-		defer cancel()
-	}
-
-	logrus.Debugf("\tCommand: %s %s", option.BuilderPath, strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, option.BuilderPath, args...)
-	cmd.Stdout = logger.Writer()
-	cmd.Stderr = logger.Writer()
-
-	if err := cmd.Run(); err != nil {
-		if isSignalKilled(err) && option.Timeout != nil {
-			logrus.WithError(err).Errorf("fail to run %v %+v, possibly due to timeout %v", option.BuilderPath, args, *option.Timeout)
-		} else {
-			logrus.WithError(err).Errorf("fail to run %v %+v", option.BuilderPath, args)
-		}
+	if err := run(ctx, option.BuilderPath, args, nil, nil, option.Timeout); err != nil {
 		return err
 	}
 
@@ -218,6 +327,13 @@ func packRef(option PackOption) error {
 }
 
 func Merge(option MergeOption) ([]digest.Digest, error) {
+	ctx := contextOf(option.Context)
+	chunkDict, cleanup, err := mergeChunkDicts(ctx, option.BuilderPath, option.WorkDir, option.ChunkDictPath, option.ChunkDictPaths, option.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	args := []string{
 		"merge",
 		"--log-level",
@@ -229,9 +345,7 @@ func Merge(option MergeOption) ([]digest.Digest, error) {
 		"--bootstrap",
 		option.TargetBootstrapPath,
 	}
-	if option.ChunkDictPath != "" {
-		args = append(args, "--chunk-dict", fmt.Sprintf("bootstrap=%s", option.ChunkDictPath))
-	}
+	args = append(args, chunkDictArgs(chunkDict)...)
 	if option.ParentBootstrapPath != "" {
 		args = append(args, "--parent-bootstrap", option.ParentBootstrapPath)
 	}
@@ -253,25 +367,7 @@ func Merge(option MergeOption) ([]digest.Digest, error) {
 		args = append(args, "--blob-sizes", strings.Join(sizes, ","))
 	}
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if option.Timeout != nil {
-		ctx, cancel = context.WithTimeout(ctx, *option.Timeout)
-		defer cancel()
-	}
-	logrus.Debugf("\tCommand: %s %s", option.BuilderPath, strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, option.BuilderPath, args...)
-	cmd.Stdout = logger.Writer()
-	cmd.Stderr = logger.Writer()
-	cmd.Stdin = strings.NewReader(option.PrefetchPatterns)
-
-	if err := cmd.Run(); err != nil {
-		if isSignalKilled(err) && option.Timeout != nil {
-			logrus.WithError(err).Errorf("fail to run %v %+v, possibly due to timeout %v", option.BuilderPath, args, *option.Timeout)
-		} else {
-			logrus.WithError(err).Errorf("fail to run %v %+v", option.BuilderPath, args)
-		}
+	if err := run(ctx, option.BuilderPath, args, strings.NewReader(option.PrefetchPatterns), nil, option.Timeout); err != nil {
 		return nil, errors.Wrap(err, "run merge command")
 	}
 
@@ -294,6 +390,7 @@ func Merge(option MergeOption) ([]digest.Digest, error) {
 }
 
 func Unpack(option UnpackOption) error {
+	ctx := contextOf(option.Context)
 	args := []string{
 		"unpack",
 		"--log-level",
@@ -336,25 +433,7 @@ func Unpack(option UnpackOption) error {
 		args = append(args, "--blob", option.BlobPath)
 	}
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if option.Timeout != nil {
-		ctx, cancel = context.WithTimeout(ctx, *option.Timeout)
-		defer cancel()
-	}
-
-	logrus.Debugf("\tCommand: %s %s", option.BuilderPath, strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, option.BuilderPath, args...)
-	cmd.Stdout = logger.Writer()
-	cmd.Stderr = logger.Writer()
-
-	if err := cmd.Run(); err != nil {
-		if isSignalKilled(err) && option.Timeout != nil {
-			logrus.WithError(err).Errorf("fail to run %v %+v, possibly due to timeout %v", option.BuilderPath, args, *option.Timeout)
-		} else {
-			logrus.WithError(err).Errorf("fail to run %v %+v", option.BuilderPath, args)
-		}
+	if err := run(ctx, option.BuilderPath, args, nil, nil, option.Timeout); err != nil {
 		return err
 	}
 
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tool
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeChunkDicts(t *testing.T) {
+	path, cleanup, err := mergeChunkDicts(context.Background(), "/not/found", "", "", []string{""}, nil)
+	require.NoError(t, err)
+	require.Empty(t, path)
+	cleanup()
+	path, cleanup, err = mergeChunkDicts(context.Background(), "/not/found", "", "", []string{"/team"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/team", path)
+	cleanup()
+
+	// Fake builder recording its arguments into the merged bootstrap.
+	builder := filepath.Join(t.TempDir(), "nydus-image")
+	script := "#!/bin/sh\nwhile [ \"$1\" != --bootstrap ]; do shift; done\ntarget=$2; shift 2\necho \"$@\" > $target\n"
+	require.NoError(t, os.WriteFile(builder, []byte(script), 0755))
+
+	workDir := t.TempDir()
+	path, cleanup, err = mergeChunkDicts(context.Background(), builder, workDir, "/base", []string{"", "/org", "/team"}, nil)
+	require.NoError(t, err)
+	// Merged in the work directory given.
+	require.Equal(t, workDir, filepath.Dir(filepath.Dir(path)))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "/team /org /base\n", string(data))
+	cleanup()
+	require.NoFileExists(t, path)
+
+	// Builders accept a single dict.
+	args := buildPackArgs(PackOption{
+		BlobPath:      "/blob",
+		SourcePath:    "/source",
+		ChunkDictPath: path,
+		Features:      Features{},
+	}, false)
+	require.Equal(t, 1, strings.Count(strings.Join(args, " "), "--chunk-dict"))
+	require.Contains(t, args, "bootstrap="+path)
+	require.Empty(t, chunkDictArgs(""))
+}
+
+func TestBuildPackArgs(t *testing.T) {
+	option := PackOption{
+		BlobPath:   "/blob",
+		SourcePath: "/source",
+		Compressor: "zstd",
+		ChunkSize:  "0x100000",
+		Features:   NewFeatures(FeatureTar2Rafs),
+	}
+
+	args := buildPackArgs(option, false)
+	require.Equal(t, []string{
+		"create", "--log-level", "warn", "--prefetch-policy", "fs", "--blob", "/blob",
+		"--whiteout-spec", "none", "--fs-version", "6", "--type", "tar-rafs", "--blob-inline-meta",
+		"--features", "blob-toc", "--compressor", "zstd", "--chunk-size", "0x100000", "/source",
+	}, args)
+
+	// Upper directories differ only in the source type and whiteouts.
+	args = buildPackArgs(option, true)
+	require.Equal(t, []string{
+		"create", "--log-level", "warn", "--prefetch-policy", "fs", "--blob", "/blob",
+		"--whiteout-spec", "overlayfs", "--fs-version", "6", "--type", "dir-rafs", "--blob-inline-meta",
+		"--features", "blob-toc", "--compressor", "zstd", "--chunk-size", "0x100000", "/source",
+	}, args)
+
+	option.FsVersion = "5"
+	option.Features = Features{}
+	args = buildPackArgs(option, false)
+	require.Contains(t, args, "--inline-bootstrap")
+	require.NotContains(t, args, "blob-toc")
+}
+
+func TestRunCanceled(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not found")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err = run(ctx, sleep, []string{"10"}, nil, nil, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	timeout := 100 * time.Millisecond
+	err = run(context.Background(), sleep, []string{"10"}, nil, nil, &timeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, isSignalKilled(err))
+
+	require.NoError(t, run(context.Background(), sleep, []string{"0"}, nil, nil, nil))
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tool
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Causes of builder failures recognized from its stderr, to be checked by errors.Is.
+var (
+	ErrUnsupportedFeature = errors.New("unsupported feature")
+	ErrCorruptTar         = errors.New("corrupt tar")
+	ErrVersionMismatch    = errors.New("version mismatch")
+)
+
+// Only the tail of stderr is kept for diagnostics, where the final error is reported.
+const stderrTailSize = 64 << 10
+
+var (
+	// Log prefix of the builder, e.g. "[2024-01-02 03:04:05.678901 +00:00] ERROR [src/main.rs:12] ".
+	logPrefixPattern = regexp.MustCompile(`^\[[^\]]*\]\s+[A-Z]+\s+(\[[^\]]*\]\s+)?`)
+	causePattern     = regexp.MustCompile(`^\d+: (.*)$`)
+	pathPattern      = regexp.MustCompile(`"([^"]*/[^"]*)"`)
+
+	errorPatterns = []struct {
+		kind    error
+		pattern *regexp.Regexp
+	}{
+		{ErrVersionMismatch, regexp.MustCompile(`(?i)(rafs|fs)[ _-]?version|versions? (are )?(not consistent|inconsistent|mismatch)`)},
+		{ErrUnsupportedFeature, regexp.MustCompile(`(?i)unexpected argument|wasn't expected|unsupported|not supported`)},
+		{ErrCorruptTar, regexp.MustCompile(`(?i)archive header|iterate over archive|invalid tar|tar header|unexpected (eof|end of file)`)},
+	}
+)
+
+// BuilderError is returned when the builder exits with an error, with the cause
+// and the offending file recognized from stderr where possible.
+type BuilderError struct {
+	// Kind is one of ErrUnsupportedFeature, ErrCorruptTar and ErrVersionMismatch,
+	// or nil if the cause isn't recognized.
+	Kind error
+	// Path is the file the builder failed on, if it's reported.
+	Path string
+	// Message is the final error message of the builder.
+	Message string
+	// Err is the error waiting for the builder, e.g. "exit status 1".
+	Err error
+}
+
+func (e *BuilderError) Error() string {
+	var b strings.Builder
+	b.WriteString("builder failed")
+	if e.Kind != nil {
+		fmt.Fprintf(&b, " with %s", e.Kind)
+	}
+	if e.Path != "" {
+		fmt.Fprintf(&b, " on %s", e.Path)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+func (e *BuilderError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BuilderError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+func newBuilderError(err error, stderr []byte) *BuilderError {
+	be := &BuilderError{Err: err, Message: lastErrorMessage(stderr)}
+	for _, p := range errorPatterns {
+		if p.pattern.MatchString(be.Message) {
+			be.Kind = p.kind
+			break
+		}
+	}
+	if m := pathPattern.FindStringSubmatch(be.Message); m != nil {
+		be.Path = m[1]
+	}
+	return be
+}
+
+// lastErrorMessage picks the final error from stderr of the builder, i.e. the last
+// "Error: ..." reported by main with its causes, or the last error logged or
+// reported by the argument parser, or the last line.
+func lastErrorMessage(stderr []byte) string {
+	lines := strings.Split(string(bytes.TrimSpace(stderr)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		msg, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "Error: ")
+		if !ok {
+			continue
+		}
+		// Causes of anyhow errors are listed as "Caused by:\n    0: ...".
+		for _, line := range lines[i+1:] {
+			line = strings.TrimSpace(line)
+			if line == "" || line == "Caused by:" {
+				continue
+			}
+			if m := causePattern.FindStringSubmatch(line); m != nil {
+				line = m[1]
+			}
+			msg += ": " + line
+		}
+		return msg
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		// Invalid arguments are reported by clap as "error: ...".
+		if msg, ok := strings.CutPrefix(line, "error: "); ok {
+			return msg
+		}
+		if strings.Contains(line, " ERROR ") {
+			return logPrefixPattern.ReplaceAllString(line, "")
+		}
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// tailBuffer keeps the last `size` bytes written to it.
+type tailBuffer struct {
+	buf  []byte
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	return t.buf
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tool
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuilderError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	for _, c := range []struct {
+		stderr  string
+		kind    error
+		path    string
+		message string
+	}{
+		{
+			stderr: `[2024-01-02 03:04:05.678901 +00:00] INFO build
+Error: failed to build image from tar "/tmp/nydus-converter-1/blob.targz"
+
+Caused by:
+    0: failed to iterate over archive
+    1: unexpected end of file`,
+			kind:    ErrCorruptTar,
+			path:    "/tmp/nydus-converter-1/blob.targz",
+			message: `failed to build image from tar "/tmp/nydus-converter-1/blob.targz": failed to iterate over archive: unexpected end of file`,
+		},
+		{
+			stderr:  "error: unexpected argument '--batch-size' found\n\nUsage: nydus-image create [OPTIONS] <SOURCE>",
+			kind:    ErrUnsupportedFeature,
+			message: "unexpected argument '--batch-size' found",
+		},
+		{
+			stderr:  `[2024-01-02 03:04:05.678901 +00:00] ERROR [src/bin/nydus-image/main.rs:778] inconsistent RAFS version of bootstrap "/base/image.boot"`,
+			kind:    ErrVersionMismatch,
+			path:    "/base/image.boot",
+			message: `inconsistent RAFS version of bootstrap "/base/image.boot"`,
+		},
+		{
+			stderr:  "something else went wrong",
+			message: "something else went wrong",
+		},
+	} {
+		err := newBuilderError(exitErr, []byte(c.stderr))
+		require.Equal(t, c.path, err.Path)
+		require.Equal(t, c.message, err.Message)
+		require.ErrorIs(t, err, exitErr)
+		if c.kind != nil {
+			require.ErrorIs(t, errors.Wrap(err, "pack"), c.kind)
+		} else {
+			require.Nil(t, err.Kind)
+			require.NotErrorIs(t, err, ErrCorruptTar)
+		}
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not found")
+	}
+	script := filepath.Join(t.TempDir(), "builder")
+	require.NoError(t, os.WriteFile(script, []byte("#!"+sh+"\necho 'Error: invalid tar header' >&2\nexit 1\n"), 0755))
+
+	err = run(context.Background(), script, nil, nil, nil, nil)
+	var be *BuilderError
+	require.ErrorAs(t, err, &be)
+	require.ErrorIs(t, err, ErrCorruptTar)
+	require.Equal(t, "invalid tar header", be.Message)
+	require.Contains(t, err.Error(), "exit status 1")
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{size: 4}
+	_, _ = tail.Write([]byte("ab"))
+	_, _ = tail.Write([]byte("cdef"))
+	require.Equal(t, "cdef", string(tail.Bytes()))
+}
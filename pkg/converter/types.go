@@ -32,6 +32,12 @@ const (
 
 var (
 	ErrNotFound = errors.New("data not found")
+	// ErrInsufficientSpace is returned before conversion if the work directory
+	// doesn't have enough free space for it.
+	ErrInsufficientSpace = errors.New("insufficient free space")
+	// ErrCorruptedSource is returned once the source layer read for conversion turns
+	// out to mismatch its descriptor, so nothing is produced from it.
+	ErrCorruptedSource = errors.New("source mismatches its descriptor")
 )
 
 type Layer struct {
@@ -55,9 +61,31 @@ type Backend interface {
 	Type() string
 }
 
+// ChunkIndex records chunks stored in a cluster for deduplication across nodes,
+// e.g. dedup.Client.
+type ChunkIndex interface {
+	// Publish records chunks of a blob cached by this node.
+	Publish(ctx context.Context, blobID string, chunks []digest.Digest) error
+	// PublishStored records chunks of a blob pushed to the storage backend.
+	PublishStored(ctx context.Context, blobID string, chunks []digest.Digest) error
+	// Stored tells whether all chunks of a blob are stored in it by the backend.
+	Stored(ctx context.Context, blobID string, chunks []digest.Digest) (bool, error)
+}
+
+// BlobHook is called with every blob finished by conversion, e.g. to scan, sign
+// or mirror it to a second store. Conversion fails if it returns an error.
+type BlobHook func(ctx context.Context, desc ocispec.Descriptor, ra content.ReaderAt) error
+
 type PackOption struct {
 	// WorkDir is used as the work directory during layer pack.
 	WorkDir string
+	// SourceSize is the uncompressed size of the layer if it's known. Pack fails
+	// fast with ErrInsufficientSpace if the layer has to be unpacked into WorkDir,
+	// with builders lacking tar2rafs, and there isn't enough free space for it.
+	SourceSize int64
+	// EstimateSourceSize lets LayerConvertFunc reserve space for layers of unknown
+	// SourceSize, assuming compressed layers expand up to 4 times when unpacked.
+	EstimateSourceSize bool
 	// BuilderPath holds the path of `nydus-image` binary tool.
 	BuilderPath string
 	// FsVersion specifies nydus RAFS format version, possible
@@ -65,6 +93,11 @@ type PackOption struct {
 	FsVersion string
 	// ChunkDictPath holds the bootstrap path of chunk dict image.
 	ChunkDictPath string
+	// ChunkDictPaths holds bootstrap paths of more chunk dict images, e.g. an
+	// org-wide dict then a team-specific one. nydus-image takes a single dict, so
+	// they're merged after ChunkDictPath into one before building, and a file at
+	// the same path in several dicts keeps the chunks of the earliest dict.
+	ChunkDictPaths []string
 	// PrefetchPatterns holds file path pattern list want to prefetch.
 	PrefetchPatterns string
 	// Compressor specifies nydus blob compression algorithm.
@@ -79,10 +112,30 @@ type PackOption struct {
 	BatchSize string
 	// Backend uploads blobs generated by nydus-image builder to a backend storage.
 	Backend Backend
+	// ChunkIndex is published with chunks of blobs pushed to Backend, blobs whose
+	// chunks are all in Backend already are not pushed again. It's unused without
+	// Backend, since the blobs are pushed by callers then.
+	ChunkIndex ChunkIndex
+	// BlobHook is called with the layer of the converted nydus blob as it's published,
+	// i.e. the wrapped one with GzipWrap, before the blob is pushed to Backend.
+	BlobHook BlobHook
 	// Timeout cancels execution once exceed the specified time.
 	Timeout *time.Duration
 	// Whether the generated Nydus blobs should be encrypted.
 	Encrypt bool
+	// Reproducible pins timestamps of files in the layer to SourceDateEpoch, so that
+	// the same layer is always converted to the same blob by the same builder.
+	// It takes no effect with OCIRef, whose blobs reference the layer as it is.
+	Reproducible bool
+	// SourceDateEpoch is the modification time of all files in reproducible mode,
+	// the Unix epoch by default.
+	SourceDateEpoch time.Time
+	// GzipWrap wraps converted blobs in gzip as layers of the standard media type
+	// "application/vnd.oci.image.layer.v1.tar+gzip", for registries and proxies
+	// rejecting unknown media types or uncompressed layers. The nydus annotations are
+	// kept, and nydusd reads blobs by digest of the unwrapped ones, either from Backend
+	// or from the shared blob store of the snapshotter, which unwraps such layers on pull.
+	GzipWrap bool
 
 	// Features keeps a feature list supported by newer version of builder,
 	// It is detected automatically, so don't export it.
@@ -99,8 +152,19 @@ type MergeOption struct {
 	FsVersion string
 	// ChunkDictPath holds the bootstrap path of chunk dict image.
 	ChunkDictPath string
-	// ParentBootstrapPath holds the bootstrap path of parent image.
+	// ChunkDictPaths holds bootstrap paths of more chunk dict images, merged
+	// like PackOption.ChunkDictPaths.
+	ChunkDictPaths []string
+	// ParentBootstrapPath holds the bootstrap path of parent image, the layers
+	// are appended to it.
 	ParentBootstrapPath string
+	// BaseBootstrap is the bootstrap layer of a previously merged image, e.g. a
+	// large base image, which MergeLayers appends the new upper layers to instead
+	// of merging the whole layer stack again.
+	BaseBootstrap *ocispec.Descriptor
+	// BaseBlobs are the blob layers referenced by BaseBootstrap, they're skipped
+	// in the layers to merge and kept as they are in the result.
+	BaseBlobs []ocispec.Descriptor
 	// PrefetchPatterns holds file path pattern list want to prefetch.
 	PrefetchPatterns string
 	// WithTar puts bootstrap into a tar stream (no gzip).
@@ -127,6 +191,8 @@ type MergeOption struct {
 	Encrypt Encrypter
 	// AppendFiles specifies the files that need to be appended to the bootstrap layer.
 	AppendFiles []File
+	// BlobHook is called with the merged bootstrap layer, after it's encrypted.
+	BlobHook BlobHook
 }
 
 type UnpackOption struct {
@@ -141,6 +207,93 @@ type UnpackOption struct {
 	Stream bool
 }
 
+// BlobRange is a contiguous range of file data in a blob, merged from adjacent chunks.
+type BlobRange struct {
+	CompressedOffset   uint64 `json:"compressed_offset"`
+	CompressedSize     uint64 `json:"compressed_size"`
+	UncompressedOffset uint64 `json:"uncompressed_offset"`
+	UncompressedSize   uint64 `json:"uncompressed_size"`
+}
+
+type BlobProvenance struct {
+	// Digest of the nydus blob, which is the layer digest in registry.
+	Digest digest.Digest `json:"digest"`
+	Ranges []BlobRange   `json:"ranges"`
+}
+
+// FileProvenance lists blobs backing a file, in the order they're first referenced
+// by the file data.
+type FileProvenance struct {
+	Path  string           `json:"path"`
+	Blobs []BlobProvenance `json:"blobs"`
+}
+
+// ProvenanceReport maps every regular file of a bootstrap to the blobs it's read from.
+type ProvenanceReport struct {
+	Files []FileProvenance `json:"files"`
+}
+
+// ChunkStats sums up distinct chunks.
+type ChunkStats struct {
+	Chunks           uint64 `json:"chunks"`
+	CompressedSize   uint64 `json:"compressed_size"`
+	UncompressedSize uint64 `json:"uncompressed_size"`
+}
+
+// BlobChunkComparison tells how much of a blob of the target image is shared with
+// the base image.
+type BlobChunkComparison struct {
+	Digest digest.Digest `json:"digest"`
+	Total  ChunkStats    `json:"total"`
+	Shared ChunkStats    `json:"shared"`
+}
+
+// ChunkComparison reports chunks shared between a base image and a target image,
+// sized as they're stored by the target. Chunks are identified by digests of their
+// data recorded in the bootstraps.
+type ChunkComparison struct {
+	Base   ChunkStats `json:"base"`
+	Target ChunkStats `json:"target"`
+	// Chunks of the target also in the base.
+	Shared ChunkStats `json:"shared"`
+	// Shared chunks the images read from the same blob locations, which nodes having
+	// the base image don't fetch again. The rest of shared chunks are stored in
+	// different blobs, only deduplicated by a chunk dictionary shared by the images.
+	SharedInPlace ChunkStats `json:"shared_in_place"`
+	Unique        ChunkStats `json:"unique"`
+	// Fractions of the compressed data of the target already fetched by nodes having
+	// the base image, and fetched with a shared chunk dictionary.
+	TransferSavings          float64 `json:"transfer_savings"`
+	PotentialTransferSavings float64 `json:"potential_transfer_savings"`
+	// Blobs of the target by the order they're first referenced.
+	TargetBlobs []BlobChunkComparison `json:"target_blobs"`
+}
+
+type EstimateOption struct {
+	// FsVersion specifies nydus RAFS format version, possible
+	// values: `5`, `6` (EROFS-compatible), default is `6`.
+	FsVersion string
+	// Compressor specifies nydus blob compression algorithm, possible
+	// values: `none`, `zstd`, `lz4_block`, default is `zstd`.
+	Compressor string
+	// ChunkSize sets the size of data chunks, default is 0x100000.
+	ChunkSize string
+}
+
+// SizeEstimate predicts the result of converting a layer to nydus.
+type SizeEstimate struct {
+	// Files is the number of entries in the layer.
+	Files int `json:"files"`
+	// Chunks is the number of distinct data chunks, which are stored once in the blob.
+	Chunks int `json:"chunks"`
+	// UncompressedSize is the size of all distinct data chunks.
+	UncompressedSize int64 `json:"uncompressed_size"`
+	// BlobSize is the size of the nydus blob, excluding the bootstrap appended to it.
+	BlobSize int64 `json:"blob_size"`
+	// BootstrapSize is the size of the bootstrap.
+	BootstrapSize int64 `json:"bootstrap_size"`
+}
+
 type TOCEntry struct {
 	// Feature flags of entry
 	Flags     uint32
@@ -15,10 +15,13 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"time"
 
 	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 )
 
 type File struct {
@@ -57,6 +60,116 @@ func newWriteCloser(wc io.WriteCloser, action func() error) *writeCloser {
 	}
 }
 
+// newTarRewriter rewrites the (compressed) tar stream written to it into `wc`,
+// passing the header of each entry through `rewrites` in order.
+func newTarRewriter(wc io.WriteCloser, rewrites ...func(*tar.Header)) io.WriteCloser {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := rewriteTar(wc, pr, rewrites)
+		// Fail writes of the caller if the stream can't be rewritten.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return newWriteCloser(pw, func() error {
+		err := <-done
+		if closeErr := wc.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
+
+// pinTimestamps sets all timestamps of entries to `epoch`, leaving other
+// metadata as it is.
+func pinTimestamps(epoch time.Time) func(*tar.Header) {
+	if epoch.IsZero() {
+		epoch = time.Unix(0, 0)
+	}
+	epoch = epoch.Truncate(time.Second)
+
+	return func(hdr *tar.Header) {
+		hdr.ModTime = epoch
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+		for _, key := range []string{"mtime", "atime", "ctime"} {
+			delete(hdr.PAXRecords, key)
+		}
+	}
+}
+
+func rewriteTar(dst io.Writer, src io.Reader, rewrites []func(*tar.Header)) error {
+	ds, err := compression.DecompressStream(src)
+	if err != nil {
+		return errors.Wrap(err, "decompress layer")
+	}
+	defer ds.Close()
+
+	tr := tar.NewReader(ds)
+	tw := tar.NewWriter(dst)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read layer")
+		}
+
+		for _, rewrite := range rewrites {
+			rewrite(hdr)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "write header of %s", hdr.Name)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return errors.Wrapf(err, "write %s", hdr.Name)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "close layer")
+	}
+
+	// Consume padding after the end of archive, which the caller still writes.
+	_, err = io.Copy(io.Discard, src)
+	return err
+}
+
+type verifyingReader struct {
+	r        io.Reader
+	digest   digest.Digest
+	verifier digest.Verifier
+	size     int64
+	read     int64
+}
+
+// NewVerifyingReader returns a reader of `r` failing with ErrCorruptedSource as soon
+// as more than `size` bytes are read, or at EOF if the size or digest of the content
+// mismatches. Negative `size` means unknown. Decompressors may stop before EOF,
+// so readers should be drained to be verified.
+func NewVerifyingReader(r io.Reader, d digest.Digest, size int64) io.Reader {
+	return &verifyingReader{r: r, digest: d, verifier: d.Verifier(), size: size}
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.r.Read(p)
+	vr.read += int64(n)
+	vr.verifier.Write(p[:n])
+	if vr.size >= 0 && vr.read > vr.size {
+		return n, errors.Wrapf(ErrCorruptedSource, "%s is larger than %d bytes", vr.digest, vr.size)
+	}
+	if err == io.EOF {
+		if vr.size >= 0 && vr.read != vr.size {
+			return n, errors.Wrapf(ErrCorruptedSource, "%s has %d bytes instead of %d", vr.digest, vr.read, vr.size)
+		}
+		if !vr.verifier.Verified() {
+			return n, errors.Wrapf(ErrCorruptedSource, "digest of %s", vr.digest)
+		}
+	}
+	return n, err
+}
+
 type seekReader struct {
 	io.ReaderAt
 	pos int64
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func buildLayer(t *testing.T, mtime time.Time) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:       "foo",
+		Mode:       0644,
+		Size:       3,
+		ModTime:    mtime,
+		AccessTime: mtime,
+		Format:     tar.FormatPAX,
+	}))
+	_, err := tw.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestTarRewriter(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	normalize := func(layer []byte) []byte {
+		var out bytes.Buffer
+		w := newTarRewriter(nopWriteCloser{&out}, pinTimestamps(epoch))
+		_, err := io.Copy(w, bytes.NewReader(layer))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return out.Bytes()
+	}
+
+	a := normalize(buildLayer(t, time.Now()))
+	b := normalize(buildLayer(t, time.Now().Add(time.Hour)))
+	require.Equal(t, a, b)
+
+	tr := tar.NewReader(bytes.NewReader(a))
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "foo", hdr.Name)
+	require.True(t, hdr.ModTime.Equal(epoch))
+	require.True(t, hdr.AccessTime.IsZero())
+	data, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(data))
+
+	w := newTarRewriter(nopWriteCloser{io.Discard})
+	_, _ = w.Write([]byte("not a tar stream, but long enough to be detected as one"))
+	require.Error(t, w.Close())
+}
+
+func TestVerifyingReader(t *testing.T) {
+	data := []byte("layer data")
+	d := digest.FromBytes(data)
+
+	read, err := io.ReadAll(NewVerifyingReader(bytes.NewReader(data), d, int64(len(data))))
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	_, err = io.ReadAll(NewVerifyingReader(bytes.NewReader(data), d, -1))
+	require.NoError(t, err)
+
+	corrupted := []byte("layer dat4")
+	for _, c := range []struct {
+		data []byte
+		size int64
+	}{
+		{corrupted, int64(len(data))},
+		{corrupted, -1},
+		{data, int64(len(data)) - 1},
+		{data, int64(len(data)) + 1},
+	} {
+		_, err = io.ReadAll(NewVerifyingReader(bytes.NewReader(c.data), d, c.size))
+		require.ErrorIs(t, err, ErrCorruptedSource)
+	}
+
+	// Oversized sources fail before EOF.
+	r := NewVerifyingReader(bytes.NewReader(data), d, 2)
+	n, err := r.Read(make([]byte, 4))
+	require.Equal(t, 4, n)
+	require.ErrorIs(t, err, ErrCorruptedSource)
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/retry"
 )
@@ -39,6 +40,8 @@ const (
 	endpointCacheMetrics = "/api/v1/metrics/blobcache"
 	// Fetch metrics about inflighting operations.
 	endpointInflightMetrics = "/api/v1/metrics/inflight"
+	// Fetch metrics of the storage backend.
+	endpointBackendMetrics = "/api/v1/metrics/backend"
 	// Request nydus daemon to retrieve its runtime states from the supervisor, recovering states for failover.
 	endpointTakeOver = "/api/v1/daemon/fuse/takeover"
 	// Request nydus daemon to send its runtime states to the supervisor, preparing for failover.
@@ -63,6 +66,8 @@ type NydusdClient interface {
 	GetDaemonInfo() (*types.DaemonInfo, error)
 
 	Mount(mountpoint, bootstrap, daemonConfig string) error
+	// Remount replaces bootstrap and configuration of a mounted instance in place.
+	Remount(mountpoint, bootstrap, daemonConfig string) error
 	Umount(mountpoint string) error
 
 	BindBlob(daemonConfig string) error
@@ -71,6 +76,7 @@ type NydusdClient interface {
 	GetFsMetrics(sid string) (*types.FsMetrics, error)
 	GetInflightMetrics() (*types.InflightMetrics, error)
 	GetCacheMetrics(sid string) (*types.CacheMetrics, error)
+	GetBackendMetrics(sid string) (*types.BackendMetrics, error)
 
 	TakeOver() error
 	SendFd() error
@@ -100,6 +106,9 @@ func (c *nydusdClient) url(path string, query query) (url string) {
 // request body and handle or process http response if result is expected.
 func (c *nydusdClient) request(method string, url string,
 	body io.Reader, respHandler func(resp *http.Response) error) error {
+	if err := fault.Inject(fault.PointDaemonAPI, url); err != nil {
+		return err
+	}
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -240,6 +249,19 @@ func (c *nydusdClient) Mount(mp, bootstrap, mountConfig string) error {
 	return c.request(http.MethodPost, url, bytes.NewBuffer(cmd), nil)
 }
 
+func (c *nydusdClient) Remount(mp, bootstrap, mountConfig string) error {
+	cmd, err := json.Marshal(types.NewMountRequest(bootstrap, mountConfig))
+	if err != nil {
+		return errors.Wrap(err, "construct remount request")
+	}
+
+	query := query{}
+	query.Add("mountpoint", mp)
+	url := c.url(endpointMount, query)
+
+	return c.request(http.MethodPut, url, bytes.NewBuffer(cmd), nil)
+}
+
 func (c *nydusdClient) Umount(mp string) error {
 	query := query{}
 	query.Add("mountpoint", mp)
@@ -322,6 +344,23 @@ func (c *nydusdClient) GetCacheMetrics(sid string) (*types.CacheMetrics, error)
 	return &m, nil
 }
 
+func (c *nydusdClient) GetBackendMetrics(sid string) (*types.BackendMetrics, error) {
+	query := query{}
+	if sid != "" {
+		query.Add("id", "/"+sid)
+	}
+
+	url := c.url(endpointBackendMetrics, query)
+	var m types.BackendMetrics
+	if err := c.request(http.MethodGet, url, nil, func(resp *http.Response) error {
+		return decode(resp, &m)
+	}); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
 func (c *nydusdClient) TakeOver() error {
 	url := c.url(endpointTakeOver, query{})
 	return c.request(http.MethodPut, url, nil, nil)
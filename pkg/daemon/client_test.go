@@ -69,3 +69,27 @@ func TestNydusClient_CheckStatus(t *testing.T) {
 	assert.Equal(t, "testid", info.ID)
 	assert.Equal(t, BTI, info.Version)
 }
+
+func TestNydusClient_GetBackendMetrics(t *testing.T) {
+	mockSocket := filepath.Join(t.TempDir(), "nydusd.sock")
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, endpointBackendMetrics, r.URL.Path)
+		assert.Equal(t, "/10", r.URL.Query().Get("id"))
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"id":"/10","backend_type":"registry","read_count":100,"read_errors":3,"read_amount_total":4096}`))
+		assert.Nil(t, err)
+	}))
+	unixListener, err := net.Listen("unix", mockSocket)
+	require.Nil(t, err)
+	ts.Listener = unixListener
+	ts.Start()
+	defer ts.Close()
+
+	client, err := NewNydusClient(mockSocket)
+	require.Nil(t, err)
+	m, err := client.GetBackendMetrics("10")
+	require.Nil(t, err)
+	assert.Equal(t, "registry", m.BackendType)
+	assert.Equal(t, uint64(3), m.ReadErrors)
+	assert.Equal(t, uint64(4096), m.ReadAmountTotal)
+}
@@ -106,6 +106,13 @@ func WithFsDriver(fsDriver string) NewDaemonOpt {
 	}
 }
 
+func WithSandboxID(id string) NewDaemonOpt {
+	return func(d *Daemon) error {
+		d.States.SandboxID = id
+		return nil
+	}
+}
+
 func WithDaemonMode(daemonMode config.DaemonMode) NewDaemonOpt {
 	return func(d *Daemon) error {
 		d.States.DaemonMode = daemonMode
@@ -24,6 +24,7 @@ import (
 	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/containerd/nydus-snapshotter/pkg/supervisor"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/erofs"
@@ -53,6 +54,8 @@ type ConfigState struct {
 	Mountpoint      string
 	SupervisorPath  string
 	ThreadNum       int
+	// Pod sandbox whose images are served by the daemon in shared mode, if any.
+	SandboxID string
 	// Where the configuration file resides, all rafs instances share the same configuration template
 	ConfigDir string
 }
@@ -184,10 +187,15 @@ func (d *Daemon) GetState() (types.DaemonState, error) {
 	st := info.DaemonState()
 
 	d.Lock()
+	changed := d.state != st
 	d.state = st
 	d.Version = info.DaemonVersion()
 	d.Unlock()
 
+	if changed {
+		events.Publish(events.TopicDaemon, map[string]string{"daemon_id": d.ID(), "state": string(st)})
+	}
+
 	return st, nil
 }
 
@@ -371,6 +379,33 @@ func (d *Daemon) sharedErofsUmount(ra *rafs.Rafs) error {
 	return nil
 }
 
+// Remount switches the mounted RAFS instance to configuration `cfg` without umounting it,
+// so that containers keep running on it.
+func (d *Daemon) Remount(r *rafs.Rafs, cfg daemonconfig.DaemonConfig) error {
+	client, err := d.GetClient()
+	if err != nil {
+		return errors.Wrapf(err, "remount instance %s", r.SnapshotID)
+	}
+	bootstrap, err := r.BootstrapFile()
+	if err != nil {
+		return err
+	}
+	c, err := cfg.DumpString()
+	if err != nil {
+		return errors.Wrap(err, "dump instance configuration")
+	}
+
+	// Instances of dedicated daemons are mounted at the root of the daemon.
+	mp := "/"
+	if r.GetMountpoint() != d.HostMountpoint() {
+		mp = r.RelaMountpoint()
+	}
+	if err := client.Remount(mp, bootstrap, c); err != nil {
+		return errors.Wrapf(err, "remount rafs instance %s", r.SnapshotID)
+	}
+	return nil
+}
+
 func (d *Daemon) UmountRafsInstance(r *rafs.Rafs) error {
 	if d.IsSharedDaemon() {
 		if err := d.SharedUmount(r); err != nil {
@@ -503,6 +538,14 @@ func (d *Daemon) GetCacheMetrics(sid string) (*types.CacheMetrics, error) {
 	return c.GetCacheMetrics(sid)
 }
 
+func (d *Daemon) GetBackendMetrics(sid string) (*types.BackendMetrics, error) {
+	c, err := d.GetClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backend metrics")
+	}
+	return c.GetBackendMetrics(sid)
+}
+
 func (d *Daemon) GetClient() (NydusdClient, error) {
 	d.cmu.Lock()
 	defer d.cmu.Unlock()
@@ -30,6 +30,13 @@ type DaemonInfo struct {
 	ID      string        `json:"id"`
 	Version BuildTimeInfo `json:"version"`
 	State   DaemonState   `json:"state"`
+	// Filesystems mounted by the daemon, by their mountpoints relative to the daemon's
+	BackendCollection map[string]FsBackend `json:"backend_collection"`
+}
+
+type FsBackend struct {
+	BackendType string `json:"backend_type"`
+	Mountpoint  string `json:"mountpoint"`
 }
 
 func (info *DaemonInfo) DaemonState() DaemonState {
@@ -82,6 +89,15 @@ type InflightMetrics struct {
 	}
 }
 
+// Metrics of the storage backend, e.g. registry, to fetch blob data from.
+type BackendMetrics struct {
+	ID              string `json:"id"`
+	BackendType     string `json:"backend_type"`
+	ReadCount       uint64 `json:"read_count"`
+	ReadErrors      uint64 `json:"read_errors"`
+	ReadAmountTotal uint64 `json:"read_amount_total"`
+}
+
 type CacheMetrics struct {
 	ID                           string   `json:"id"`
 	UnderlyingFiles              []string `json:"underlying_files"`
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package dedup talks to a cluster-wide chunk deduplication index, which records
+// the nodes and blobs storing each chunk digest. Nodes publish chunks of blobs they
+// convert or cache, and query the index to fetch chunks from peers caching them.
+// Converters publish chunks of blobs they push to the storage backend, and skip
+// pushing blobs the backend stores already.
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	endpointPublish = "/api/v1/chunks/publish"
+	endpointQuery   = "/api/v1/chunks/query"
+
+	defaultTimeout = 10 * time.Second
+	// Keeps request bodies reasonable for blobs of many chunks.
+	maxChunksPerRequest = 4096
+)
+
+// Location is where a chunk is stored in the cluster.
+type Location struct {
+	// Address of the node serving the blob, e.g. a P2P proxy usable as registry mirror.
+	Node string `json:"node"`
+	// ID of the nydus blob hosting the chunk.
+	BlobID string `json:"blob_id"`
+	// The blob is in the storage backend, rather than only cached by the node.
+	Stored bool `json:"stored,omitempty"`
+}
+
+type publishRequest struct {
+	Node   string          `json:"node"`
+	BlobID string          `json:"blob_id"`
+	Stored bool            `json:"stored,omitempty"`
+	Chunks []digest.Digest `json:"chunks"`
+}
+
+type queryRequest struct {
+	Chunks []digest.Digest `json:"chunks"`
+}
+
+type queryResponse struct {
+	Chunks map[digest.Digest][]Location `json:"chunks"`
+}
+
+// Client of the chunk deduplication index service.
+type Client struct {
+	address string
+	// Address of this node published with its chunks.
+	node       string
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the index service at `address`, e.g. "http://dedup.svc:8080",
+// publishing chunks as stored by `node`.
+func NewClient(address, node string) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &Client{
+		address:    strings.TrimSuffix(address, "/"),
+		node:       node,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Publish records that this node caches `chunks` in blob `blobID`, which peers may
+// fetch from it.
+func (c *Client) Publish(ctx context.Context, blobID string, chunks []digest.Digest) error {
+	return c.publish(ctx, blobID, chunks, false)
+}
+
+// PublishStored records that blob `blobID` holding `chunks` is pushed to the storage
+// backend. Only call it once the push succeeds, converters skip pushing the blob again.
+func (c *Client) PublishStored(ctx context.Context, blobID string, chunks []digest.Digest) error {
+	return c.publish(ctx, blobID, chunks, true)
+}
+
+func (c *Client) publish(ctx context.Context, blobID string, chunks []digest.Digest, stored bool) error {
+	for start := 0; start < len(chunks); start += maxChunksPerRequest {
+		end := min(start+maxChunksPerRequest, len(chunks))
+		req := publishRequest{Node: c.node, BlobID: blobID, Stored: stored, Chunks: chunks[start:end]}
+		if err := c.post(ctx, endpointPublish, req, nil); err != nil {
+			return errors.Wrapf(err, "publish chunks of blob %s", blobID)
+		}
+	}
+	return nil
+}
+
+// Query returns locations of `chunks` in the cluster, chunks not stored by any node
+// are absent from the result.
+func (c *Client) Query(ctx context.Context, chunks []digest.Digest) (map[digest.Digest][]Location, error) {
+	locations := make(map[digest.Digest][]Location)
+	for start := 0; start < len(chunks); start += maxChunksPerRequest {
+		end := min(start+maxChunksPerRequest, len(chunks))
+		var resp queryResponse
+		if err := c.post(ctx, endpointQuery, queryRequest{Chunks: chunks[start:end]}, &resp); err != nil {
+			return nil, errors.Wrap(err, "query chunks")
+		}
+		for d, l := range resp.Chunks {
+			if len(l) > 0 {
+				locations[d] = l
+			}
+		}
+	}
+	return locations, nil
+}
+
+// Stored tells whether the storage backend stores every chunk of blob `blobID` in the
+// blob itself, so that pushing it again can be skipped. Blobs only cached by nodes
+// don't count.
+func (c *Client) Stored(ctx context.Context, blobID string, chunks []digest.Digest) (bool, error) {
+	holders, err := c.holders(ctx, blobID, chunks)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range holders {
+		if l.Stored {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Peers returns nodes other than this one caching every chunk of blob `blobID` in the
+// blob itself, which it can be fetched from instead of the registry.
+func (c *Client) Peers(ctx context.Context, blobID string, chunks []digest.Digest) ([]string, error) {
+	holders, err := c.holders(ctx, blobID, chunks)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	for _, l := range holders {
+		if !l.Stored && l.Node != "" && l.Node != c.node && !slices.Contains(peers, l.Node) {
+			peers = append(peers, l.Node)
+		}
+	}
+	return peers, nil
+}
+
+// Locations holding blob `blobID` with all of `chunks`.
+func (c *Client) holders(ctx context.Context, blobID string, chunks []digest.Digest) ([]Location, error) {
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	locations, err := c.Query(ctx, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	var holders []Location
+	for i, d := range chunks {
+		var found []Location
+		for _, l := range locations[d] {
+			if l.BlobID == blobID && (i == 0 || slices.Contains(holders, l)) {
+				found = append(found, l)
+			}
+		}
+		if holders = found; len(holders) == 0 {
+			return nil, nil
+		}
+	}
+	return holders, nil
+}
+
+func (c *Client) post(ctx context.Context, endpoint string, body, v any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "marshal request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.address+endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("unexpected status %d from %s: %s", resp.StatusCode, endpoint, strings.TrimSpace(string(msg)))
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "decode response")
+	}
+	return nil
+}
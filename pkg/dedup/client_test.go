@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// A toy index service keeping chunk locations in memory.
+func newIndexServer(t *testing.T) *httptest.Server {
+	var mutex sync.Mutex
+	index := map[digest.Digest][]Location{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(endpointPublish, func(w http.ResponseWriter, r *http.Request) {
+		var req publishRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, d := range req.Chunks {
+			index[d] = append(index[d], Location{Node: req.Node, BlobID: req.BlobID, Stored: req.Stored})
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(endpointQuery, func(w http.ResponseWriter, r *http.Request) {
+		var req queryRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mutex.Lock()
+		defer mutex.Unlock()
+		resp := queryResponse{Chunks: map[digest.Digest][]Location{}}
+		for _, d := range req.Chunks {
+			resp.Chunks[d] = index[d]
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	s := newIndexServer(t)
+	a := NewClient(s.URL, "10.0.0.1:65001")
+	b := NewClient(s.URL, "10.0.0.2:65001")
+
+	shared, onlyA, onlyB := digest.FromString("shared"), digest.FromString("a"), digest.FromString("b")
+	require.NoError(t, a.Publish(ctx, "blob-a", []digest.Digest{shared, onlyA}))
+
+	locations, err := b.Query(ctx, []digest.Digest{shared, onlyB})
+	require.NoError(t, err)
+	require.Equal(t, map[digest.Digest][]Location{
+		shared: {{Node: "10.0.0.1:65001", BlobID: "blob-a"}},
+	}, locations)
+
+	// Blobs only cached by nodes are fetched from peers, but not in the backend.
+	stored, err := b.Stored(ctx, "blob-a", []digest.Digest{shared, onlyA})
+	require.NoError(t, err)
+	require.False(t, stored)
+	peers, err := b.Peers(ctx, "blob-a", []digest.Digest{shared, onlyA})
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:65001"}, peers)
+	peers, err = a.Peers(ctx, "blob-a", []digest.Digest{shared, onlyA})
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	require.NoError(t, a.PublishStored(ctx, "blob-a", []digest.Digest{shared, onlyA}))
+	stored, err = b.Stored(ctx, "blob-a", []digest.Digest{shared, onlyA})
+	require.NoError(t, err)
+	require.True(t, stored)
+	// Chunks stored in other blobs don't make the blob available.
+	stored, err = b.Stored(ctx, "blob-b", []digest.Digest{shared, onlyB})
+	require.NoError(t, err)
+	require.False(t, stored)
+	peers, err = b.Peers(ctx, "blob-b", []digest.Digest{shared, onlyB})
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	require.Error(t, NewClient(s.URL+"/missing", "").Publish(ctx, "blob", []digest.Digest{shared}))
+}
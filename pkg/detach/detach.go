@@ -0,0 +1,392 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package detach downloads all blobs of lazily served images into the shared blob
+// store in background, after which the images are switched to read the local blobs,
+// so that long-running containers stop depending on registries.
+package detach
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+const defaultMaxConcurrent = 2
+
+// ChunkIndex finds peers caching blobs across the cluster, e.g. dedup.Client.
+type ChunkIndex interface {
+	// Publish records chunks of a blob cached by this node.
+	Publish(ctx context.Context, blobID string, chunks []digest.Digest) error
+	// Peers returns nodes caching all chunks of a blob.
+	Peers(ctx context.Context, blobID string, chunks []digest.Digest) ([]string, error)
+}
+
+type Opt struct {
+	BlobStore *cache.BlobStore
+	// Skip verifying TLS certificates of registries.
+	Insecure bool
+	// Maximum number of images downloaded in parallel, 0 means 2.
+	MaxConcurrent int
+	// Blobs are fetched from peers found in the index rather than registries if
+	// possible, and published to it once downloaded.
+	ChunkIndex ChunkIndex
+	// Blob cache directory of nydusd, blobs cached whole there are copied into the
+	// store rather than downloaded again.
+	CacheDir string
+}
+
+// RemountFunc switches an image to read its blobs from `blobDir`.
+type RemountFunc func(blobDir string) error
+
+type job struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// A blob with digests of its chunks used by an image.
+type blob struct {
+	id string
+	// Size of compressed chunks in the blob, the blob itself may be larger.
+	size   int64
+	chunks []digest.Digest
+}
+
+type Detacher struct {
+	store      *cache.BlobStore
+	insecure   bool
+	chunkIndex ChunkIndex
+	cacheDir   string
+	listBlobs  func(bootstrap string) ([]blob, error)
+	sem        chan struct{}
+
+	mutex sync.Mutex
+	// Jobs in progress, indexed by snapshot ID.
+	jobs map[string]*job
+}
+
+func New(opt Opt) (*Detacher, error) {
+	if opt.BlobStore == nil {
+		return nil, errors.New("shared blob store is required")
+	}
+	maxConcurrent := opt.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+
+	return &Detacher{
+		store:      opt.BlobStore,
+		insecure:   opt.Insecure,
+		chunkIndex: opt.ChunkIndex,
+		cacheDir:   opt.CacheDir,
+		listBlobs:  listBlobs,
+		sem:        make(chan struct{}, maxConcurrent),
+		jobs:       make(map[string]*job),
+	}, nil
+}
+
+// Blobs of a snapshot are referenced by the holder in the blob store.
+func holder(snapshotID string) string {
+	return "detach-" + snapshotID
+}
+
+// Blobs in the blob table of the bootstrap, with digests of their chunks if the
+// chunk table is readable, which are only needed to find peers caching the blobs.
+func listBlobs(bootstrap string) ([]blob, error) {
+	b, err := layout.ReadBootstrap(bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	var blobs []blob
+	for _, bb := range b.Blobs() {
+		index[bb.ID] = len(blobs)
+		blobs = append(blobs, blob{id: bb.ID, size: int64(bb.CompressedSize)})
+	}
+
+	chunks, err := b.Chunks()
+	if err != nil {
+		log.L.WithError(err).Warnf("skip finding peers for blobs of bootstrap %s", bootstrap)
+		return blobs, nil
+	}
+	for _, c := range chunks {
+		if i, ok := index[c.BlobID]; ok {
+			blobs[i].chunks = append(blobs[i].chunks, c.Digest)
+		}
+	}
+	return blobs, nil
+}
+
+// Detach downloads blobs of the image mounted for snapshot `snapshotID` in background,
+// then calls `remount`. Blobs in the store already are not downloaded again, e.g. when
+// detaching an image again after snapshotter is restarted.
+func (d *Detacher) Detach(snapshotID, ref, bootstrap string, keyChain *auth.PassKeyChain, remount RemountFunc) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.jobs[snapshotID]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{cancel: cancel, done: make(chan struct{})}
+	d.jobs[snapshotID] = j
+
+	go func() {
+		defer func() {
+			d.mutex.Lock()
+			delete(d.jobs, snapshotID)
+			d.mutex.Unlock()
+			cancel()
+			close(j.done)
+		}()
+
+		select {
+		case d.sem <- struct{}{}:
+			defer func() { <-d.sem }()
+		case <-ctx.Done():
+			return
+		}
+
+		if err := d.detach(ctx, snapshotID, ref, bootstrap, keyChain, remount); err != nil {
+			if ctx.Err() == nil {
+				log.L.WithError(err).Warnf("failed to detach image %s of snapshot %s", ref, snapshotID)
+			}
+			return
+		}
+		log.L.Infof("detached image %s of snapshot %s from registry", ref, snapshotID)
+		events.Publish(events.TopicMount, map[string]string{"action": "detach", "snapshot_id": snapshotID, "image_id": ref})
+	}()
+}
+
+func (d *Detacher) detach(ctx context.Context, snapshotID, ref, bootstrap string, keyChain *auth.PassKeyChain, remount RemountFunc) error {
+	blobs, err := d.listBlobs(bootstrap)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blobs {
+		err := d.store.Acquire(b.id, holder(snapshotID))
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return errors.Wrapf(err, "acquire blob %s", b.id)
+		}
+		if err := d.download(ctx, snapshotID, ref, b, keyChain); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return remount(d.store.BlobDir())
+}
+
+func (d *Detacher) download(ctx context.Context, snapshotID, ref string, b blob, keyChain *auth.PassKeyChain) error {
+	blobDigest := digest.NewDigestFromEncoded(digest.SHA256, b.id)
+	if err := blobDigest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid blob ID %s", b.id)
+	}
+
+	// Put the file beside the store, which moves it into the store.
+	f, err := os.CreateTemp(filepath.Dir(d.store.BlobDir()), "download-")
+	if err != nil {
+		return errors.Wrap(err, "create file for downloading blob")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if !d.copyFromCache(b, blobDigest, f) && !d.fetchFromPeers(ctx, ref, blobDigest, b.chunks, f) {
+		r := remote.New(keyChain, d.insecure)
+		err = fetchBlob(ctx, r, ref, blobDigest, f)
+		if err != nil && r.RetryWithPlainHTTP(ref, err) {
+			if err = resetFile(f); err == nil {
+				err = fetchBlob(ctx, r, ref, blobDigest, f)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close file %s", f.Name())
+	}
+
+	if err := d.store.Add(b.id, f.Name(), holder(snapshotID)); err != nil {
+		return err
+	}
+	if d.chunkIndex != nil && len(b.chunks) > 0 {
+		if err := d.chunkIndex.Publish(ctx, b.id, b.chunks); err != nil {
+			log.L.WithError(err).Warnf("failed to publish chunks of downloaded blob %s", b.id)
+		}
+	}
+	return nil
+}
+
+func resetFile(f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return f.Truncate(0)
+}
+
+// Copy the blob from the blob cache of nydusd if it's cached whole there, e.g. with
+// compressed data cached, which is verified against the digest. The file is left
+// empty otherwise.
+func (d *Detacher) copyFromCache(b blob, blobDigest digest.Digest, f *os.File) bool {
+	if d.cacheDir == "" {
+		return false
+	}
+	for _, name := range []string{b.id, b.id + ".blob.data"} {
+		cached, err := os.Open(filepath.Join(d.cacheDir, name))
+		if err != nil {
+			continue
+		}
+		info, err := cached.Stat()
+		// Blob cache files holding part of the blob are smaller than its chunks.
+		if err != nil || info.Size() < b.size {
+			cached.Close()
+			continue
+		}
+		err = copyVerified(f, cached, blobDigest)
+		cached.Close()
+		if err == nil {
+			log.L.Infof("copied blob %s from blob cache", blobDigest)
+			return true
+		}
+		if err := resetFile(f); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// Try peers caching the blob in turn, which serve it as registry mirrors, e.g. by
+// their P2P proxies. The file is left empty if none of them succeeds.
+func (d *Detacher) fetchFromPeers(ctx context.Context, ref string, blobDigest digest.Digest, chunks []digest.Digest, f *os.File) bool {
+	if d.chunkIndex == nil || len(chunks) == 0 {
+		return false
+	}
+	peers, err := d.chunkIndex.Peers(ctx, blobDigest.Encoded(), chunks)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to find peers caching blob %s", blobDigest)
+		return false
+	}
+
+	for _, peer := range peers {
+		err := fetchFromPeer(ctx, peer, ref, blobDigest, f)
+		if err == nil {
+			log.L.Infof("fetched blob %s from peer %s", blobDigest, peer)
+			return true
+		}
+		log.L.WithError(err).Warnf("failed to fetch blob %s from peer %s", blobDigest, peer)
+		if err := resetFile(f); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func fetchFromPeer(ctx context.Context, peer, ref string, blobDigest digest.Digest, w io.Writer) error {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return errors.Wrapf(err, "parse image reference %s", ref)
+	}
+	if !strings.Contains(peer, "://") {
+		peer = "http://" + peer
+	}
+	// Mirrors take the upstream registry by `ns` like containerd sends.
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s?ns=%s", strings.TrimSuffix(peer, "/"), reference.Path(named), blobDigest, reference.Domain(named))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return copyVerified(w, resp.Body, blobDigest)
+}
+
+func fetchBlob(ctx context.Context, r *remote.Remote, ref string, blobDigest digest.Digest, w io.Writer) error {
+	fetcher, err := r.Fetcher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	fetcherByDigest, ok := fetcher.(remotes.FetcherByDigest)
+	if !ok {
+		return errors.Errorf("fetcher %T does not implement remotes.FetcherByDigest", fetcher)
+	}
+	rc, _, err := fetcherByDigest.FetchByDigest(ctx, blobDigest)
+	if err != nil {
+		return errors.Wrapf(err, "fetch blob %s", blobDigest)
+	}
+	defer rc.Close()
+
+	return copyVerified(w, rc, blobDigest)
+}
+
+func copyVerified(w io.Writer, r io.Reader, blobDigest digest.Digest) error {
+	verifier := blobDigest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(w, verifier), r); err != nil {
+		return errors.Wrapf(err, "download blob %s", blobDigest)
+	}
+	if !verifier.Verified() {
+		return errors.Errorf("downloaded blob %s is corrupted", blobDigest)
+	}
+	return nil
+}
+
+// Release aborts detaching the image for snapshot `snapshotID`, and drops its
+// references to blobs.
+func (d *Detacher) Release(snapshotID string) error {
+	d.mutex.Lock()
+	j, ok := d.jobs[snapshotID]
+	d.mutex.Unlock()
+	if ok {
+		j.cancel()
+		<-j.done
+	}
+	return d.store.ReleaseHolder(holder(snapshotID))
+}
+
+// Wait waits until jobs in progress end.
+func (d *Detacher) Wait() {
+	d.mutex.Lock()
+	jobs := make([]*job, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		jobs = append(jobs, j)
+	}
+	d.mutex.Unlock()
+
+	for _, j := range jobs {
+		<-j.done
+	}
+}
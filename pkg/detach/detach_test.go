@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package detach
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+)
+
+// A registry serving blobs of any repository.
+func newFakeRegistry(blobs map[string][]byte) *httptest.Server {
+	return httptest.NewServer(fakeRegistryHandler(blobs))
+}
+
+func fakeRegistryHandler(blobs map[string][]byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.SplitN(req.URL.Path, "/blobs/", 2)
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, ok := blobs[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	})
+}
+
+func TestDetach(t *testing.T) {
+	data1, data2 := []byte("blob 1"), []byte("blob 2")
+	blob1, blob2 := digest.FromBytes(data1), digest.FromBytes(data2)
+	server := newFakeRegistry(map[string][]byte{blob1.String(): data1, blob2.String(): data2})
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/library/app:latest"
+
+	store, err := cache.NewBlobStore(t.TempDir())
+	require.NoError(t, err)
+	d, err := New(Opt{BlobStore: store})
+	require.NoError(t, err)
+	d.listBlobs = func(bootstrap string) ([]blob, error) {
+		if bootstrap == "missing" {
+			return []blob{{id: digest.FromString("missing").Encoded()}}, nil
+		}
+		return []blob{{id: blob1.Encoded()}, {id: blob2.Encoded()}}, nil
+	}
+
+	var remounted []string
+	remount := func(blobDir string) error {
+		remounted = append(remounted, blobDir)
+		return nil
+	}
+
+	// Blob 1 is in the store already.
+	tmp := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, os.WriteFile(tmp, data1, 0640))
+	require.NoError(t, store.Add(blob1.Encoded(), tmp, "other"))
+
+	d.Detach("1", ref, "bootstrap", nil, remount)
+	d.Wait()
+	require.Equal(t, []string{store.BlobDir()}, remounted)
+	for _, blob := range []digest.Digest{blob1, blob2} {
+		holders, err := store.Holders(blob.Encoded())
+		require.NoError(t, err)
+		require.Contains(t, holders, holder("1"))
+	}
+
+	// Images are not remounted if any blob fails to download.
+	d.Detach("2", ref, "missing", nil, remount)
+	d.Wait()
+	require.Len(t, remounted, 1)
+
+	require.NoError(t, d.Release("1"))
+	require.True(t, store.Has(blob1.Encoded()))
+	require.False(t, store.Has(blob2.Encoded()))
+	require.NoError(t, d.Release("2"))
+
+	_, err = New(Opt{})
+	require.Error(t, err)
+}
+
+type fakeChunkIndex struct {
+	peers     map[string][]string
+	published []string
+}
+
+func (i *fakeChunkIndex) Publish(_ context.Context, blobID string, _ []digest.Digest) error {
+	i.published = append(i.published, blobID)
+	return nil
+}
+
+func (i *fakeChunkIndex) Peers(_ context.Context, blobID string, _ []digest.Digest) ([]string, error) {
+	return i.peers[blobID], nil
+}
+
+func TestDetachFromPeers(t *testing.T) {
+	data1, data2 := []byte("blob 1"), []byte("blob 2")
+	blob1, blob2 := digest.FromBytes(data1), digest.FromBytes(data2)
+	var requested []string
+	peer := newFakeRegistry(map[string][]byte{blob1.String(): data1})
+	defer peer.Close()
+	// A peer serving corrupted data is skipped.
+	broken := newFakeRegistry(map[string][]byte{blob2.String(): data1})
+	defer broken.Close()
+	registry := httptest.NewServer(recordPaths(fakeRegistryHandler(map[string][]byte{blob2.String(): data2}), &requested))
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+
+	store, err := cache.NewBlobStore(t.TempDir())
+	require.NoError(t, err)
+	index := &fakeChunkIndex{peers: map[string][]string{
+		blob1.Encoded(): {peer.URL},
+		blob2.Encoded(): {strings.TrimPrefix(broken.URL, "http://")},
+	}}
+	d, err := New(Opt{BlobStore: store, ChunkIndex: index})
+	require.NoError(t, err)
+	d.listBlobs = func(string) ([]blob, error) {
+		chunk := []digest.Digest{digest.FromString("chunk")}
+		return []blob{{id: blob1.Encoded(), chunks: chunk}, {id: blob2.Encoded(), chunks: chunk}}, nil
+	}
+
+	ctx := context.Background()
+	require.NoError(t, d.detach(ctx, "1", ref, "bootstrap", nil, func(string) error { return nil }))
+	require.True(t, store.Has(blob1.Encoded()))
+	require.True(t, store.Has(blob2.Encoded()))
+	require.Equal(t, []string{"/v2/library/app/blobs/" + blob2.String()}, requested)
+	// Downloaded blobs are cached for peers in turn.
+	require.Equal(t, []string{blob1.Encoded(), blob2.Encoded()}, index.published)
+}
+
+func recordPaths(h http.Handler, paths *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/blobs/") && req.Method == http.MethodGet {
+			*paths = append(*paths, req.URL.Path)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func TestListBlobs(t *testing.T) {
+	f, err := os.Open("../filesystem/testdata/v6-bootstrap-chunk-pos-438272.tar.gz")
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	bootstrap := filepath.Join(t.TempDir(), "image.boot")
+	for {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		if hdr.Name == layout.BootstrapFile {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(bootstrap, data, 0644))
+			break
+		}
+	}
+
+	blobs, err := listBlobs(bootstrap)
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.Equal(t, "cdde6f5645daea414d60bc75611102a8bc8dae6198f087366365d6ff85bf5726", blobs[0].id)
+	require.Equal(t, int64(43090887), blobs[0].size)
+	require.Len(t, blobs[0].chunks, 2515)
+}
+
+func TestDetachFromCache(t *testing.T) {
+	data1, data2 := []byte("blob 1"), []byte("blob 2")
+	blob1, blob2 := digest.FromBytes(data1), digest.FromBytes(data2)
+	var requested []string
+	registry := httptest.NewServer(recordPaths(fakeRegistryHandler(map[string][]byte{blob2.String(): data2}), &requested))
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+
+	cacheDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, blob1.Encoded()), data1, 0644))
+	// Cache files of uncompressed data don't match the blob.
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, blob2.Encoded()+".blob.data"), data1, 0644))
+
+	store, err := cache.NewBlobStore(t.TempDir())
+	require.NoError(t, err)
+	d, err := New(Opt{BlobStore: store, CacheDir: cacheDir})
+	require.NoError(t, err)
+	d.listBlobs = func(string) ([]blob, error) {
+		return []blob{{id: blob1.Encoded(), size: 4}, {id: blob2.Encoded(), size: 4}}, nil
+	}
+
+	require.NoError(t, d.detach(context.Background(), "1", ref, "bootstrap", nil, func(string) error { return nil }))
+	require.True(t, store.Has(blob1.Encoded()))
+	require.True(t, store.Has(blob2.Encoded()))
+	require.Equal(t, []string{"/v2/library/app/blobs/" + blob2.String()}, requested)
+	// Cache files are kept for nydusd.
+	require.FileExists(t, filepath.Join(cacheDir, blob1.Encoded()))
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package differ implements a containerd diff comparer emitting nydus layers, so that
+// images built upon the snapshotter, e.g. by BuildKit, are natively in nydus format.
+package differ
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/content/proxy"
+	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/pkg/archive"
+	"github.com/containerd/containerd/v2/pkg/dialer"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+const DefaultContainerdAddress = "/run/containerd/containerd.sock"
+
+// NewContentStore connects to the content store of containerd listening on `address`,
+// where the generated nydus layers are written to.
+func NewContentStore(address string) (content.Store, error) {
+	if address == "" {
+		address = DefaultContainerdAddress
+	}
+
+	conn, err := grpc.NewClient(dialer.DialAddress(address),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer.ContextDialer))
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to containerd %s", address)
+	}
+
+	return proxy.NewContentStore(contentapi.NewContentClient(conn)), nil
+}
+
+// Packs the OCI tar stream written to the returned writer into a nydus layer in `dest`.
+type packFunc func(ctx context.Context, dest io.Writer) (io.WriteCloser, error)
+
+type Comparer struct {
+	store content.Store
+	pack  packFunc
+}
+
+func NewComparer(store content.Store, nydusImagePath, workDir string) (*Comparer, error) {
+	if err := os.MkdirAll(workDir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "create differ work directory %s", workDir)
+	}
+
+	return &Comparer{
+		store: store,
+		pack: func(ctx context.Context, dest io.Writer) (io.WriteCloser, error) {
+			return converter.Pack(ctx, dest, converter.PackOption{
+				WorkDir:     workDir,
+				BuilderPath: nydusImagePath,
+			})
+		},
+	}, nil
+}
+
+// Compare computes the difference between `lower` and `upper` as an OCI tar stream,
+// then packs it into a nydus blob with inlined layer bootstrap in the content store.
+// The requested media type is ignored since the result is always a nydus layer.
+func (c *Comparer) Compare(ctx context.Context, lower, upper []mount.Mount, opts ...diff.Opt) (ocispec.Descriptor, error) {
+	var config diff.Config
+	for _, opt := range opts {
+		if err := opt(&config); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	if config.Reference == "" {
+		config.Reference = fmt.Sprintf("nydus-diff-%d", time.Now().UnixNano())
+	}
+
+	// Requests from the proxy plugin carry the namespace in gRPC metadata, pass it on to containerd.
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	ctx = namespaces.WithNamespace(ctx, ns)
+
+	var desc ocispec.Descriptor
+	err = mount.WithReadonlyTempMount(ctx, lower, func(lowerRoot string) error {
+		return mount.WithReadonlyTempMount(ctx, upper, func(upperRoot string) error {
+			var err error
+			desc, err = c.writeNydusLayer(ctx, lowerRoot, upperRoot, &config)
+			return err
+		})
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	return desc, nil
+}
+
+func (c *Comparer) writeNydusLayer(ctx context.Context, lowerRoot, upperRoot string, config *diff.Config) (ocispec.Descriptor, error) {
+	cw, err := content.OpenWriter(ctx, c.store, content.WithRef(config.Reference))
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "open content writer")
+	}
+	defer cw.Close()
+	if err := cw.Truncate(0); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "truncate content writer")
+	}
+
+	digester := digest.Canonical.Digester()
+	counter := &countWriter{}
+	pw, err := c.pack(ctx, io.MultiWriter(cw, digester.Hash(), counter))
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "create nydus packer")
+	}
+	if err := archive.WriteDiff(ctx, pw, lowerRoot, upperRoot); err != nil {
+		pw.Close()
+		return ocispec.Descriptor{}, errors.Wrap(err, "write diff")
+	}
+	if err := pw.Close(); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "pack diff into nydus layer")
+	}
+
+	blobDigest := digester.Digest()
+	labels := map[string]string{}
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	// Nydus blobs are not compressed as a whole, so the diff ID is the blob digest.
+	labels[converter.LayerAnnotationUncompressed] = blobDigest.String()
+	if err := cw.Commit(ctx, counter.n, blobDigest, content.WithLabels(labels)); err != nil && !errdefs.IsAlreadyExists(err) {
+		return ocispec.Descriptor{}, errors.Wrap(err, "commit nydus layer")
+	}
+
+	log.G(ctx).Infof("created nydus layer %s of %d bytes", blobDigest, counter.n)
+
+	return ocispec.Descriptor{
+		MediaType: converter.MediaTypeNydusBlob,
+		Digest:    blobDigest,
+		Size:      counter.n,
+		Annotations: map[string]string{
+			converter.LayerAnnotationUncompressed: blobDigest.String(),
+			converter.LayerAnnotationNydusBlob:    "true",
+		},
+	}, nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package differ
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type memoryLabelStore map[digest.Digest]map[string]string
+
+func (s memoryLabelStore) Get(d digest.Digest) (map[string]string, error) {
+	return s[d], nil
+}
+
+func (s memoryLabelStore) Set(d digest.Digest, labels map[string]string) error {
+	s[d] = labels
+	return nil
+}
+
+func (s memoryLabelStore) Update(d digest.Digest, labels map[string]string) (map[string]string, error) {
+	if s[d] == nil {
+		s[d] = map[string]string{}
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(s[d], k)
+		} else {
+			s[d][k] = v
+		}
+	}
+	return s[d], nil
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+}
+
+// Entries of the layer by name, with data of regular files.
+func readLayer(t *testing.T, ra content.ReaderAt) map[string]string {
+	entries := map[string]string{}
+	tr := tar.NewReader(io.NewSectionReader(ra, 0, ra.Size()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries[hdr.Name] = string(data)
+	}
+}
+
+func TestWriteNydusLayer(t *testing.T) {
+	store, err := local.NewLabeledStore(t.TempDir(), memoryLabelStore{})
+	require.NoError(t, err)
+	// The OCI diff is stored as is to check its entries.
+	c := &Comparer{
+		store: store,
+		pack: func(_ context.Context, dest io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{dest}, nil
+		},
+	}
+
+	lower, upper := t.TempDir(), t.TempDir()
+	writeFiles(t, lower, map[string]string{
+		"etc/hostname":  "lower",
+		"etc/removed":   "removed",
+		"usr/unchanged": "unchanged",
+		"opt/app/old":   "old",
+		"var/cache/a":   "a",
+	})
+	writeFiles(t, upper, map[string]string{
+		"etc/hostname":  "upper",
+		"etc/added":     "added",
+		"usr/unchanged": "unchanged",
+	})
+	writeFiles(t, upper, map[string]string{"opt/app/new": "new"})
+	// Unchanged files have the same metadata in both, the modified one of the
+	// same size differs in mtime regardless of the timestamp granularity.
+	mtime := time.Unix(1700000000, 0)
+	for _, root := range []string{lower, upper} {
+		require.NoError(t, os.Chtimes(filepath.Join(root, "usr/unchanged"), mtime, mtime))
+		require.NoError(t, os.Chtimes(filepath.Join(root, "usr"), mtime, mtime))
+	}
+	modified := mtime.Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(upper, "etc/hostname"), modified, modified))
+
+	config := diff.Config{Reference: "test", Labels: map[string]string{"key": "value"}}
+	desc, err := c.writeNydusLayer(context.Background(), lower, upper, &config)
+	require.NoError(t, err)
+	require.Equal(t, converter.MediaTypeNydusBlob, desc.MediaType)
+	require.Equal(t, "true", desc.Annotations[converter.LayerAnnotationNydusBlob])
+
+	info, err := store.Info(context.Background(), desc.Digest)
+	require.NoError(t, err)
+	require.Equal(t, desc.Size, info.Size)
+	require.Equal(t, "value", info.Labels["key"])
+	require.Equal(t, desc.Digest.String(), info.Labels[converter.LayerAnnotationUncompressed])
+
+	ra, err := store.ReaderAt(context.Background(), desc)
+	require.NoError(t, err)
+	defer ra.Close()
+	entries := readLayer(t, ra)
+
+	// Modified and added files are carried with their data.
+	require.Equal(t, "upper", entries["etc/hostname"])
+	require.Equal(t, "added", entries["etc/added"])
+	require.Equal(t, "new", entries["opt/app/new"])
+	// Removed files are whiteouts.
+	require.Contains(t, entries, "etc/.wh.removed")
+	require.Contains(t, entries, "opt/app/.wh.old")
+	// A removed directory is a single whiteout.
+	require.Contains(t, entries, ".wh.var")
+	require.NotContains(t, entries, "var/cache/a")
+	// Unchanged files are left out.
+	require.NotContains(t, entries, "usr/unchanged")
+}
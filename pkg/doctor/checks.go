@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+)
+
+const releasesURL = "https://github.com/dragonflyoss/nydus/releases"
+
+func servedByNydusd(fsDriver string) bool {
+	return fsDriver == config.FsDriverFusedev || fsDriver == config.FsDriverFscache
+}
+
+func checkConfig(cfg *config.SnapshotterConfig, cfgErr error) []Result {
+	var results []Result
+	if cfgErr != nil {
+		results = append(results, fail("config", cfgErr.Error(),
+			"fix the configuration passed by --config, following misc/snapshotter/config.toml, checks below use defaults"))
+	} else {
+		results = append(results, pass("config", "fs driver %s, daemon mode %s", cfg.DaemonConfig.FsDriver, cfg.DaemonMode))
+	}
+
+	fsDriver := cfg.DaemonConfig.FsDriver
+	if !servedByNydusd(fsDriver) {
+		return append(results, skip("config/nydusd", "not used by fs driver %s", fsDriver))
+	}
+	path := cfg.DaemonConfig.NydusdConfigPath
+	if _, err := daemonconfig.NewDaemonConfig(fsDriver, path); err != nil {
+		return append(results, fail("config/nydusd", err.Error(),
+			"provide a valid nydusd configuration for fs driver "+fsDriver+" by `daemon.nydusd_config` or --nydusd-config"))
+	}
+	return append(results, pass("config/nydusd", "%s", path))
+}
+
+// Names of filesystems supported by the kernel, in format of /proc/filesystems whose
+// lines are `[nodev]\t<name>`.
+func kernelFilesystems(hostRoot string) map[string]bool {
+	filesystems := map[string]bool{}
+	data, err := os.ReadFile(filepath.Join(hostRoot, "proc/filesystems"))
+	if err != nil {
+		return filesystems
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			filesystems[fields[len(fields)-1]] = true
+		}
+	}
+	return filesystems
+}
+
+func isCharDevice(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+func checkKernel(hostRoot, fsDriver string) []Result {
+	filesystems := kernelFilesystems(hostRoot)
+	features := []struct {
+		check     string
+		available bool
+		required  bool
+		detail    string
+		hint      string
+	}{
+		{
+			check:     "kernel/fuse",
+			available: isCharDevice(filepath.Join(hostRoot, "dev/fuse")),
+			required:  fsDriver == config.FsDriverFusedev,
+			detail:    "/dev/fuse",
+			hint:      "load the module by `modprobe fuse`, and expose /dev/fuse to snapshotter if it runs in a container",
+		},
+		{
+			check:     "kernel/erofs",
+			available: filesystems["erofs"],
+			required:  fsDriver == config.FsDriverFscache || fsDriver == config.FsDriverBlockdev,
+			detail:    "erofs in /proc/filesystems",
+			hint:      "load the module by `modprobe erofs`, which needs a kernel built with CONFIG_EROFS_FS",
+		},
+		{
+			check:     "kernel/fscache",
+			available: isCharDevice(filepath.Join(hostRoot, "dev/cachefiles")),
+			required:  fsDriver == config.FsDriverFscache,
+			detail:    "/dev/cachefiles",
+			hint: "load the module by `modprobe cachefiles`, which needs kernel 5.19 or later built with " +
+				"CONFIG_CACHEFILES_ONDEMAND, or use fs driver fusedev instead",
+		},
+	}
+
+	results := make([]Result, 0, len(features))
+	for _, f := range features {
+		switch {
+		case f.available:
+			results = append(results, pass(f.check, "%s is available", f.detail))
+		case f.required:
+			results = append(results, fail(f.check, f.detail+" is not available, but required by fs driver "+fsDriver, f.hint))
+		default:
+			results = append(results, skip(f.check, "%s is not available, not required by fs driver %s", f.detail, fsDriver))
+		}
+	}
+	return results
+}
+
+// Version printed by `<binary> --version` in line `Version: <version>`, or the first
+// line of the output.
+func binaryVersion(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "run %s --version", path)
+	}
+
+	var first string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if version, ok := strings.CutPrefix(line, "Version:"); ok {
+			return strings.TrimSpace(version), nil
+		}
+		if first == "" {
+			first = line
+		}
+	}
+	if first == "" {
+		return "", errors.Errorf("%s --version prints nothing", path)
+	}
+	return first, nil
+}
+
+func checkBinaries(ctx context.Context, cfg *config.SnapshotterConfig) []Result {
+	binaries := []struct {
+		check    string
+		path     string
+		required bool
+		hint     string
+	}{
+		{
+			check:    "binary/nydusd",
+			path:     cfg.DaemonConfig.NydusdPath,
+			required: servedByNydusd(cfg.DaemonConfig.FsDriver) && cfg.DaemonMode != string(config.DaemonModeNone),
+			hint:     "install nydusd from " + releasesURL + " into $PATH, or set `daemon.nydusd_path`",
+		},
+		{
+			check: "binary/nydus-image",
+			path:  cfg.DaemonConfig.NydusImagePath,
+			// Only features like tarfs, local conversion and commit need it.
+			required: false,
+			hint:     "install nydus-image from " + releasesURL + " into $PATH, or set `daemon.nydusimage_path`",
+		},
+	}
+
+	results := make([]Result, 0, len(binaries))
+	for _, b := range binaries {
+		name := filepath.Base(b.check)
+		if b.path == "" {
+			detail := name + " is not found in $PATH"
+			if b.required {
+				results = append(results, fail(b.check, detail, b.hint))
+			} else {
+				results = append(results, warn(b.check, detail+", features like tarfs and local conversion do not work", b.hint))
+			}
+			continue
+		}
+		version, err := binaryVersion(ctx, b.path)
+		if err != nil {
+			results = append(results, fail(b.check, err.Error(), "make sure "+b.path+" is an executable "+name+" binary, "+b.hint))
+			continue
+		}
+		results = append(results, pass(b.check, "%s, version %s", b.path, version))
+	}
+	return results
+}
+
+// Nearest existing ancestor of `path`, where missing directories are created.
+func existingAncestor(path string) string {
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			return dir
+		}
+	}
+}
+
+// Checks the directory `dir`, in which snapshotter creates sockets.
+func checkSocketDir(check, dir string) Result {
+	existing := existingAncestor(dir)
+	if err := unix.Access(existing, unix.W_OK|unix.X_OK); err != nil {
+		return fail(check, "cannot create sockets in "+dir+", "+existing+" is not writable by uid "+strconv.Itoa(os.Getuid()),
+			"run snapshotter as root, or grant the user write access to "+existing)
+	}
+	return pass(check, "%s is writable", existing)
+}
+
+// Checks the unix domain socket `path`, which snapshotter listens on.
+func checkSocket(check, path string) Result {
+	st, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return checkSocketDir(check, filepath.Dir(path))
+		}
+		return fail(check, err.Error(), "fix permissions of "+filepath.Dir(path))
+	}
+	if st.Mode()&os.ModeSocket == 0 {
+		return fail(check, path+" exists but is not a socket", "remove "+path+", snapshotter does not replace files other than sockets")
+	}
+	if err := unix.Access(path, unix.R_OK|unix.W_OK); err != nil {
+		return fail(check, path+" is not accessible by uid "+strconv.Itoa(os.Getuid())+", mode "+st.Mode().Perm().String(),
+			"clients like containerd must run as a user able to read and write the socket")
+	}
+
+	conn, err := net.DialTimeout("unix", path, probeTimeout)
+	if err != nil {
+		return pass(check, "%s is stale, mode %s, replaced when snapshotter starts", path, st.Mode().Perm())
+	}
+	conn.Close()
+	return pass(check, "%s is being served, mode %s", path, st.Mode().Perm())
+}
+
+func checkSockets(cfg *config.SnapshotterConfig) []Result {
+	results := []Result{checkSocket("socket/snapshotter", cfg.Address)}
+
+	socketDir := cfg.PathsConfig.SocketDir
+	if socketDir == "" {
+		socketDir = filepath.Join(cfg.Root, "socket")
+	}
+	if servedByNydusd(cfg.DaemonConfig.FsDriver) {
+		results = append(results, checkSocketDir("socket/nydusd", socketDir))
+	} else {
+		results = append(results, skip("socket/nydusd", "not used by fs driver %s", cfg.DaemonConfig.FsDriver))
+	}
+
+	system := cfg.SystemControllerConfig
+	if system.Enable {
+		results = append(results, checkSocket("socket/system", system.Address))
+		if system.GRPCAddress != "" {
+			results = append(results, checkSocket("socket/system-grpc", system.GRPCAddress))
+		}
+	}
+	return results
+}
+
+// Address to dial for `endpoint`, a URL or a host with optional port, which defaults
+// to the one of `scheme`.
+func endpointAddress(endpoint, scheme string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		if scheme == "" {
+			scheme = "https"
+		}
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse endpoint %q", endpoint)
+	}
+	if u.Hostname() == "" {
+		return "", errors.Errorf("no host in endpoint %q", endpoint)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+func checkEndpoint(ctx context.Context, check, endpoint, scheme string) Result {
+	addr, err := endpointAddress(endpoint, scheme)
+	if err != nil {
+		return fail(check, err.Error(), "fix the backend configuration in the nydusd configuration")
+	}
+	dialer := net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fail(check, "cannot connect to "+endpoint+": "+err.Error(),
+			"check network, DNS and proxy settings of the node, nydusd reads blobs from "+endpoint)
+	}
+	conn.Close()
+	return pass(check, "%s is reachable", endpoint)
+}
+
+// Checks endpoints of the backend in the nydusd configuration. Registries are only
+// known from images and not checked unless the configuration has a fixed host.
+func checkBackend(ctx context.Context, cfg *config.SnapshotterConfig) []Result {
+	fsDriver := cfg.DaemonConfig.FsDriver
+	if !servedByNydusd(fsDriver) {
+		return []Result{skip("backend", "not used by fs driver %s", fsDriver)}
+	}
+	c, err := daemonconfig.NewDaemonConfig(fsDriver, cfg.DaemonConfig.NydusdConfigPath)
+	if err != nil {
+		return []Result{skip("backend", "nydusd configuration is invalid")}
+	}
+	backendType, backend := c.StorageBackend()
+	check := "backend/" + backendType
+
+	if backendType == "localfs" {
+		dir := backend.Dir
+		if dir == "" {
+			dir = filepath.Dir(backend.BlobFile)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return []Result{fail(check, err.Error(), "create "+dir+" or fix the localfs backend configuration")}
+		}
+		return []Result{pass(check, "%s exists", dir)}
+	}
+
+	var endpoints []string
+	for _, e := range []string{backend.Host, backend.EndPoint, backend.Proxy.URL} {
+		if e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	for _, m := range backend.Mirrors {
+		endpoints = append(endpoints, m.Host)
+	}
+	if len(endpoints) == 0 {
+		return []Result{skip(check, "no fixed endpoint, hosts are resolved from images")}
+	}
+
+	results := make([]Result, 0, len(endpoints))
+	for _, e := range endpoints {
+		results = append(results, checkEndpoint(ctx, check, e, backend.Scheme))
+	}
+	return results
+}
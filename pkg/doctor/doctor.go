@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package doctor diagnoses the environment snapshotter runs in, reporting problems
+// like missing kernel features or unreachable backends together with hints to fix
+// them, for support triage before or after deploying snapshotter.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN"
+	StatusFail Status = "FAIL"
+	// The check does not apply to the configuration.
+	StatusSkip Status = "SKIP"
+)
+
+// Timeout of running binaries and connecting to endpoints.
+const probeTimeout = 5 * time.Second
+
+type Result struct {
+	Check  string `json:"check"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// How to fix the problem, only for warnings and failures.
+	Hint string `json:"hint,omitempty"`
+}
+
+func pass(check, format string, args ...interface{}) Result {
+	return Result{Check: check, Status: StatusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func skip(check, format string, args ...interface{}) Result {
+	return Result{Check: check, Status: StatusSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(check, detail, hint string) Result {
+	return Result{Check: check, Status: StatusWarn, Detail: detail, Hint: hint}
+}
+
+func fail(check, detail, hint string) Result {
+	return Result{Check: check, Status: StatusFail, Detail: detail, Hint: hint}
+}
+
+// Diagnose checks the environment for the snapshotter configuration `cfg`, which
+// failed to load with `cfgErr` if not nil, e.g. `cfg` has only default values then.
+func Diagnose(ctx context.Context, cfg *config.SnapshotterConfig, cfgErr error) []Result {
+	return diagnose(ctx, "/", cfg, cfgErr)
+}
+
+// Paths of kernel interfaces are looked up under `hostRoot`.
+func diagnose(ctx context.Context, hostRoot string, cfg *config.SnapshotterConfig, cfgErr error) []Result {
+	results := checkConfig(cfg, cfgErr)
+	results = append(results, checkKernel(hostRoot, cfg.DaemonConfig.FsDriver)...)
+	results = append(results, checkBinaries(ctx, cfg)...)
+	results = append(results, checkSockets(cfg)...)
+	results = append(results, checkBackend(ctx, cfg)...)
+	return results
+}
+
+// Failed tells whether any check failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes results in lines like `[FAIL] kernel/fuse: /dev/fuse does not exist`,
+// followed by hints to fix warnings and failures.
+func Print(w io.Writer, results []Result) {
+	var failed, warned int
+	for _, r := range results {
+		fmt.Fprintf(w, "[%s] %s: %s\n", r.Status, r.Check, r.Detail)
+		if r.Hint != "" {
+			fmt.Fprintf(w, "       hint: %s\n", r.Hint)
+		}
+		switch r.Status {
+		case StatusFail:
+			failed++
+		case StatusWarn:
+			warned++
+		}
+	}
+	fmt.Fprintf(w, "\n%d checks, %d failed, %d warnings\n", len(results), failed, warned)
+}
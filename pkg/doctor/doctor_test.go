@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+func TestCheckKernel(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc/filesystems"), []byte("nodev\tproc\n\text4\n\terofs\n"), 0644))
+
+	statuses := func(results []Result) map[string]Status {
+		m := map[string]Status{}
+		for _, r := range results {
+			m[r.Check] = r.Status
+		}
+		return m
+	}
+
+	// No device files can be created in the temporary root.
+	require.Equal(t, map[string]Status{
+		"kernel/fuse":    StatusFail,
+		"kernel/erofs":   StatusPass,
+		"kernel/fscache": StatusSkip,
+	}, statuses(checkKernel(root, config.FsDriverFusedev)))
+	require.Equal(t, map[string]Status{
+		"kernel/fuse":    StatusSkip,
+		"kernel/erofs":   StatusPass,
+		"kernel/fscache": StatusFail,
+	}, statuses(checkKernel(root, config.FsDriverFscache)))
+
+	require.NoError(t, os.Remove(filepath.Join(root, "proc/filesystems")))
+	require.Equal(t, StatusFail, statuses(checkKernel(root, config.FsDriverBlockdev))["kernel/erofs"])
+}
+
+func TestBinaryVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeScript := func(name, output string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(fmt.Sprintf("#!/bin/sh\nprintf '%s'\n", output)), 0755))
+		return p
+	}
+
+	version, err := binaryVersion(context.Background(), writeScript("nydusd", "Version: \tv2.2.5\nGit Commit: \tabc\n"))
+	require.NoError(t, err)
+	require.Equal(t, "v2.2.5", version)
+
+	version, err = binaryVersion(context.Background(), writeScript("other", "\nnydus-image 2.3.0\n"))
+	require.NoError(t, err)
+	require.Equal(t, "nydus-image 2.3.0", version)
+
+	_, err = binaryVersion(context.Background(), writeScript("empty", ""))
+	require.Error(t, err)
+	_, err = binaryVersion(context.Background(), filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestCheckSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	r := checkSocket("socket", filepath.Join(dir, "sub", "missing.sock"))
+	require.Equal(t, StatusPass, r.Status)
+	require.Contains(t, r.Detail, dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	r = checkSocket("socket", file)
+	require.Equal(t, StatusFail, r.Status)
+	require.NotEmpty(t, r.Hint)
+
+	sock := filepath.Join(dir, "serving.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	r = checkSocket("socket", sock)
+	require.Equal(t, StatusPass, r.Status)
+	require.Contains(t, r.Detail, "being served")
+	l.Close()
+}
+
+func TestEndpointAddress(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"registry.example.com":         "registry.example.com:443",
+		"http://127.0.0.1:65001":       "127.0.0.1:65001",
+		"http://mirror.example.com/v2": "mirror.example.com:80",
+		"[::1]:5000":                   "[::1]:5000",
+	} {
+		addr, err := endpointAddress(endpoint, "")
+		require.NoError(t, err, endpoint)
+		require.Equal(t, expected, addr, endpoint)
+	}
+
+	addr, err := endpointAddress("oss.example.com", "http")
+	require.NoError(t, err)
+	require.Equal(t, "oss.example.com:80", addr)
+
+	_, err = endpointAddress("http://", "")
+	require.Error(t, err)
+}
+
+func TestCheckBackend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	// Nothing listens on the port of the closed listener.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed.Close()
+
+	nydusdConfig := filepath.Join(t.TempDir(), "nydusd-config.json")
+	require.NoError(t, os.WriteFile(nydusdConfig, []byte(fmt.Sprintf(`{
+		"device": {
+			"backend": {
+				"type": "registry",
+				"config": {"mirrors": [{"host": "http://%s"}, {"host": "http://%s"}]}
+			},
+			"cache": {"type": "blobcache"}
+		},
+		"mode": "direct"
+	}`, l.Addr(), closed.Addr())), 0644))
+
+	cfg := &config.SnapshotterConfig{}
+	cfg.DaemonConfig.FsDriver = config.FsDriverFusedev
+	cfg.DaemonConfig.NydusdConfigPath = nydusdConfig
+	results := checkBackend(context.Background(), cfg)
+	require.Len(t, results, 2)
+	require.Equal(t, StatusPass, results[0].Status)
+	require.Equal(t, StatusFail, results[1].Status)
+	require.Equal(t, "backend/registry", results[1].Check)
+
+	cfg.DaemonConfig.FsDriver = config.FsDriverBlockdev
+	results = checkBackend(context.Background(), cfg)
+	require.Equal(t, StatusSkip, results[0].Status)
+}
+
+func TestPrint(t *testing.T) {
+	results := []Result{
+		pass("config", "fs driver %s", "fusedev"),
+		fail("kernel/fuse", "/dev/fuse is not available", "modprobe fuse"),
+	}
+	require.True(t, Failed(results))
+	require.False(t, Failed(results[:1]))
+
+	var buf bytes.Buffer
+	Print(&buf, results)
+	require.Equal(t, "[PASS] config: fs driver fusedev\n"+
+		"[FAIL] kernel/fuse: /dev/fuse is not available\n"+
+		"       hint: modprobe fuse\n"+
+		"\n2 checks, 1 failed, 0 warnings\n", buf.String())
+}
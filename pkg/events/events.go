@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package events broadcasts state changes of the snapshotter to subscribers of the
+// system API, so that controllers don't have to poll the list endpoints.
+package events
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+type Topic string
+
+const (
+	// State transitions of nydusd daemons, attributes: daemon_id, state.
+	TopicDaemon Topic = "daemon"
+	// Images mounted or unmounted, attributes: action, snapshot_id, image_id, daemon_id.
+	TopicMount Topic = "mount"
+	// Garbage collection, attributes: action, and target of the action.
+	TopicGC Topic = "gc"
+	// Progress of preload jobs, attributes: job_id, image, state, error.
+	TopicPreload Topic = "preload"
+	// Progress of prefetching images served by FUSE, attributes: snapshot_id, image_id,
+	// daemon_id, state, data_amount, requests, elapsed_millis.
+	TopicPrefetch Topic = "prefetch"
+)
+
+// Events not received in time by a subscriber are dropped beyond the buffer.
+const subscriberBuffer = 256
+
+// Valid tells whether `topic` is known.
+func Valid(topic Topic) bool {
+	switch topic {
+	case TopicDaemon, TopicMount, TopicGC, TopicPreload, TopicPrefetch:
+		return true
+	}
+	return false
+}
+
+type Event struct {
+	Topic      Topic             `json:"topic"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type subscriber struct {
+	topics []Topic
+	ch     chan Event
+}
+
+var (
+	mutex       sync.Mutex
+	subscribers = make(map[*subscriber]struct{})
+)
+
+// Publish broadcasts an event to subscribers of `topic` without blocking.
+func Publish(topic Topic, attributes map[string]string) {
+	ev := Event{Topic: topic, Time: time.Now(), Attributes: attributes}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for s := range subscribers {
+		if len(s.topics) > 0 && !slices.Contains(s.topics, topic) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			log.L.Warnf("drop %s event for slow subscriber", topic)
+		}
+	}
+}
+
+// Subscribed tells whether any subscriber receives events of `topic`, so that events
+// costly to collect are only published when wanted.
+func Subscribed(topic Topic) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for s := range subscribers {
+		if len(s.topics) == 0 || slices.Contains(s.topics, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a channel receiving events of `topics`, or all the events if no
+// topic is specified. The channel is closed when `ctx` is done.
+func Subscribe(ctx context.Context, topics ...Topic) <-chan Event {
+	s := &subscriber{topics: topics, ch: make(chan Event, subscriberBuffer)}
+
+	mutex.Lock()
+	subscribers[s] = struct{}{}
+	mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		mutex.Lock()
+		delete(subscribers, s)
+		close(s.ch)
+		mutex.Unlock()
+	}()
+
+	return s.ch
+}
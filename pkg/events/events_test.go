@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	all := Subscribe(ctx)
+	mounts := Subscribe(ctx, TopicMount)
+
+	Publish(TopicDaemon, map[string]string{"daemon_id": "d1", "state": "RUNNING"})
+	Publish(TopicMount, map[string]string{"action": "mount", "snapshot_id": "1"})
+
+	ev := <-all
+	require.Equal(t, TopicDaemon, ev.Topic)
+	require.Equal(t, "RUNNING", ev.Attributes["state"])
+	require.Equal(t, TopicMount, (<-all).Topic)
+
+	ev = <-mounts
+	require.Equal(t, TopicMount, ev.Topic)
+	require.Equal(t, "1", ev.Attributes["snapshot_id"])
+	require.Empty(t, mounts)
+
+	// Slow subscribers don't block publishers.
+	for i := 0; i < subscriberBuffer+1; i++ {
+		Publish(TopicGC, nil)
+	}
+	require.Len(t, all, subscriberBuffer)
+
+	cancel()
+	for range all {
+	}
+	_, ok := <-mounts
+	require.False(t, ok)
+}
+
+func TestSubscribed(t *testing.T) {
+	require.False(t, Subscribed(TopicPrefetch))
+	require.True(t, Valid(TopicPrefetch))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mounts := Subscribe(ctx, TopicMount)
+	require.False(t, Subscribed(TopicPrefetch))
+	prefetches := Subscribe(ctx, TopicPrefetch)
+	require.True(t, Subscribed(TopicPrefetch))
+
+	cancel()
+	for range mounts {
+	}
+	for range prefetches {
+	}
+	require.False(t, Subscribed(TopicPrefetch))
+}
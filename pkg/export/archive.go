@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package export assembles image filesystems unpacked from nydus images into
+// archives loadable by `docker load`, `nerdctl load` or `ctr image import`.
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Annotation of image name used by containerd when importing.
+	annotationImageName = "io.containerd.image.name"
+	dockerManifestFile  = "manifest.json"
+)
+
+// Entry of manifest.json in the Docker archive format.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+type archiveWriter struct {
+	tw       *tar.Writer
+	modTime  time.Time
+	blobDirs map[string]bool
+	blobs    map[digest.Digest]bool
+}
+
+// Image is assembled into an archive from its config and uncompressed layer tar files,
+// from the bottom layer up.
+type Image struct {
+	Ref    string
+	Config ocispec.Image
+	Layers []string
+}
+
+// WriteOCIArchive writes an image archive in OCI layout, with a Docker style manifest.json
+// also included for compatibility. Diff IDs in the image config are rewritten after the
+// layers. It returns the digest of the image manifest.
+func WriteOCIArchive(w io.Writer, image Image) (digest.Digest, error) {
+	var layers []*os.File
+	defer func() {
+		for _, layer := range layers {
+			layer.Close()
+		}
+	}()
+
+	layerDescs := make([]ocispec.Descriptor, 0, len(image.Layers))
+	diffIDs := make([]digest.Digest, 0, len(image.Layers))
+	for _, layerTar := range image.Layers {
+		layer, err := os.Open(layerTar)
+		if err != nil {
+			return "", errors.Wrapf(err, "open layer %s", layerTar)
+		}
+		layers = append(layers, layer)
+
+		layerDigest, err := digest.Canonical.FromReader(layer)
+		if err != nil {
+			return "", errors.Wrapf(err, "digest layer %s", layerTar)
+		}
+		info, err := layer.Stat()
+		if err != nil {
+			return "", errors.Wrapf(err, "stat layer %s", layerTar)
+		}
+		if _, err := layer.Seek(0, io.SeekStart); err != nil {
+			return "", errors.Wrapf(err, "seek layer %s", layerTar)
+		}
+		layerDescs = append(layerDescs, ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    layerDigest,
+			Size:      info.Size(),
+		})
+		diffIDs = append(diffIDs, layerDigest)
+	}
+
+	imageConfig := image.Config
+	if imageConfig.OS == "" {
+		imageConfig.Platform = ocispec.Platform{
+			OS:           runtime.GOOS,
+			Architecture: runtime.GOARCH,
+		}
+	}
+	imageConfig.RootFS = ocispec.RootFS{
+		Type:    "layers",
+		DiffIDs: diffIDs,
+	}
+	config, err := json.Marshal(imageConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal image config")
+	}
+	configDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    layerDescs,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal image manifest")
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+
+	var repoTags []string
+	if image.Ref != "" {
+		named, err := reference.ParseDockerRef(image.Ref)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse image reference %s", image.Ref)
+		}
+		manifestDesc.Annotations = map[string]string{
+			annotationImageName: named.String(),
+		}
+		if tagged, ok := named.(reference.Tagged); ok {
+			manifestDesc.Annotations[ocispec.AnnotationRefName] = tagged.Tag()
+			repoTags = append(repoTags, reference.FamiliarString(named))
+		}
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal image index")
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal image layout")
+	}
+	layerPaths := make([]string, 0, len(layerDescs))
+	for _, desc := range layerDescs {
+		layerPaths = append(layerPaths, blobPath(desc.Digest))
+	}
+	dockerManifests, err := json.Marshal([]dockerManifest{{
+		Config:   blobPath(configDesc.Digest),
+		RepoTags: repoTags,
+		Layers:   layerPaths,
+	}})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal docker manifest")
+	}
+
+	aw := archiveWriter{
+		tw:       tar.NewWriter(w),
+		modTime:  time.Unix(0, 0),
+		blobDirs: make(map[string]bool),
+		blobs:    make(map[digest.Digest]bool),
+	}
+	for i, layer := range layers {
+		// Layers of identical contents are stored once.
+		if aw.blobs[layerDescs[i].Digest] {
+			continue
+		}
+		if err := aw.writeBlob(layerDescs[i].Digest, layer, layerDescs[i].Size); err != nil {
+			return "", err
+		}
+	}
+	for _, b := range [][]byte{config, manifest} {
+		if err := aw.writeBlob(digest.FromBytes(b), bytes.NewReader(b), int64(len(b))); err != nil {
+			return "", err
+		}
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{ocispec.ImageIndexFile, index},
+		{ocispec.ImageLayoutFile, layout},
+		{dockerManifestFile, dockerManifests},
+	} {
+		if err := aw.writeFile(f.name, bytes.NewReader(f.data), int64(len(f.data))); err != nil {
+			return "", err
+		}
+	}
+	if err := aw.tw.Close(); err != nil {
+		return "", errors.Wrap(err, "close image archive")
+	}
+
+	return manifestDesc.Digest, nil
+}
+
+func blobPath(d digest.Digest) string {
+	return path.Join(ocispec.ImageBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+func (aw *archiveWriter) writeBlob(d digest.Digest, r io.Reader, size int64) error {
+	p := blobPath(d)
+	for _, dir := range []string{ocispec.ImageBlobsDir, path.Dir(p)} {
+		if aw.blobDirs[dir] {
+			continue
+		}
+		if err := aw.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0755,
+			ModTime:  aw.modTime,
+		}); err != nil {
+			return errors.Wrapf(err, "write directory %s", dir)
+		}
+		aw.blobDirs[dir] = true
+	}
+
+	aw.blobs[d] = true
+	return aw.writeFile(p, r, size)
+}
+
+func (aw *archiveWriter) writeFile(name string, r io.Reader, size int64) error {
+	if err := aw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0444,
+		Size:     size,
+		ModTime:  aw.modTime,
+	}); err != nil {
+		return errors.Wrapf(err, "write header of %s", name)
+	}
+	if _, err := io.Copy(aw.tw, r); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOCIArchive(t *testing.T) {
+	dir := t.TempDir()
+	layers := [][]byte{[]byte("lower layer"), []byte("upper layer")}
+	var layerTars []string
+	var diffIDs []digest.Digest
+	for i, layer := range layers {
+		layerTar := filepath.Join(dir, fmt.Sprintf("layer-%d.tar", i))
+		require.NoError(t, os.WriteFile(layerTar, layer, 0600))
+		layerTars = append(layerTars, layerTar)
+		diffIDs = append(diffIDs, digest.FromBytes(layer))
+	}
+
+	var buf bytes.Buffer
+	manifestDigest, err := WriteOCIArchive(&buf, Image{
+		Ref: "nginx:latest",
+		Config: ocispec.Image{
+			Platform: ocispec.Platform{OS: "linux", Architecture: "arm64"},
+			Config:   ocispec.ImageConfig{Entrypoint: []string{"/docker-entrypoint.sh"}},
+			RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("nydus")}},
+		},
+		Layers: layerTars,
+	})
+	require.NoError(t, err)
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = data
+		}
+	}
+
+	require.Contains(t, files, ocispec.ImageLayoutFile)
+	for _, layer := range layers {
+		require.Equal(t, layer, files[blobPath(digest.FromBytes(layer))])
+	}
+
+	var index ocispec.Index
+	require.NoError(t, json.Unmarshal(files[ocispec.ImageIndexFile], &index))
+	require.Len(t, index.Manifests, 1)
+	require.Equal(t, manifestDigest, index.Manifests[0].Digest)
+	require.Equal(t, "docker.io/library/nginx:latest", index.Manifests[0].Annotations[annotationImageName])
+	require.Equal(t, "latest", index.Manifests[0].Annotations[ocispec.AnnotationRefName])
+
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(files[blobPath(manifestDigest)], &manifest))
+	require.Len(t, manifest.Layers, 2)
+	for i, layer := range manifest.Layers {
+		require.Equal(t, diffIDs[i], layer.Digest)
+	}
+
+	var config ocispec.Image
+	require.NoError(t, json.Unmarshal(files[blobPath(manifest.Config.Digest)], &config))
+	require.Equal(t, diffIDs, config.RootFS.DiffIDs)
+	require.Equal(t, "arm64", config.Architecture)
+	require.Equal(t, []string{"/docker-entrypoint.sh"}, config.Config.Entrypoint)
+
+	var dockerManifests []dockerManifest
+	require.NoError(t, json.Unmarshal(files[dockerManifestFile], &dockerManifests))
+	require.Len(t, dockerManifests, 1)
+	require.Equal(t, []string{"nginx:latest"}, dockerManifests[0].RepoTags)
+	require.Equal(t, []string{blobPath(diffIDs[0]), blobPath(diffIDs[1])}, dockerManifests[0].Layers)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package fault injects delays and errors at a few points of the snapshotter, so that
+// failover of nydusd and backends can be rehearsed. It is a no-op unless enabled.
+package fault
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+type Point string
+
+const (
+	// Starting a nydusd process, targeted by daemon ID.
+	PointDaemonStart Point = "daemon.start"
+	// Requests to nydusd API server, targeted by URL path.
+	PointDaemonAPI Point = "daemon.api"
+	// Fetching blobs and manifests from registry, targeted by image reference.
+	PointBlobFetch Point = "blob.fetch"
+)
+
+var ErrInjected = errors.New("injected fault")
+
+// Rule injects `Delay` and then `Error` at `Point` for targets containing `Target`.
+type Rule struct {
+	Point  Point  `json:"point"`
+	Target string `json:"target,omitempty"`
+	// Error message, empty means only delay is injected.
+	Error   string `json:"error,omitempty"`
+	DelayMs int64  `json:"delay_ms,omitempty"`
+	// Probability in (0, 1] to trigger, 0 means always.
+	Probability float64 `json:"probability,omitempty"`
+	// Number of times left to trigger, 0 means unlimited.
+	Count int `json:"count,omitempty"`
+}
+
+func (r *Rule) validate() error {
+	switch r.Point {
+	case PointDaemonStart, PointDaemonAPI, PointBlobFetch:
+	default:
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "unknown fault point %q", r.Point)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "fault probability %v out of range", r.Probability)
+	}
+	if r.DelayMs < 0 || r.Count < 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "negative fault delay or count")
+	}
+	if r.Error == "" && r.DelayMs == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "fault rule injects nothing")
+	}
+	return nil
+}
+
+var (
+	enabled atomic.Bool
+	mutex   sync.Mutex
+	rules   []*Rule
+)
+
+// Enable fault injection, rules can't be set otherwise.
+func Enable() {
+	enabled.Store(true)
+	log.L.Warn("fault injection is enabled, do not use it in production")
+}
+
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// SetRules replaces all rules, an empty list clears them.
+func SetRules(newRules []Rule) error {
+	if !Enabled() {
+		return errors.Wrap(errdefs.ErrNotImplemented, "fault injection is disabled")
+	}
+
+	rs := make([]*Rule, 0, len(newRules))
+	for i := range newRules {
+		r := newRules[i]
+		if err := r.validate(); err != nil {
+			return err
+		}
+		rs = append(rs, &r)
+	}
+
+	mutex.Lock()
+	rules = rs
+	mutex.Unlock()
+	log.L.Infof("set %d fault injection rules", len(rs))
+
+	return nil
+}
+
+// Rules returns the active rules with their remaining count.
+func Rules() []Rule {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	rs := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, *r)
+	}
+	return rs
+}
+
+// Inject a fault at `point` for `target` if any rule matches.
+func Inject(point Point, target string) error {
+	if !Enabled() {
+		return nil
+	}
+
+	r := match(point, target)
+	if r == nil {
+		return nil
+	}
+
+	log.L.Warnf("inject fault at %s for %s, delay %dms, error %q", point, target, r.DelayMs, r.Error)
+	if r.DelayMs > 0 {
+		time.Sleep(time.Duration(r.DelayMs) * time.Millisecond)
+	}
+	if r.Error != "" {
+		return errors.Wrapf(ErrInjected, "%s at %s", r.Error, point)
+	}
+	return nil
+}
+
+// Find the first triggered rule and consume one count of it.
+func match(point Point, target string) *Rule {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i, r := range rules {
+		if r.Point != point || !strings.Contains(target, r.Target) {
+			continue
+		}
+		if r.Probability > 0 && rand.Float64() >= r.Probability {
+			continue
+		}
+
+		triggered := *r
+		if r.Count > 0 {
+			r.Count--
+			if r.Count == 0 {
+				rules = append(rules[:i:i], rules[i+1:]...)
+			}
+		}
+		return &triggered
+	}
+
+	return nil
+}
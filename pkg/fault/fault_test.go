@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package fault
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInject(t *testing.T) {
+	require.NoError(t, Inject(PointDaemonStart, "d1"))
+	require.Error(t, SetRules([]Rule{{Point: PointDaemonStart, Error: "boom"}}))
+
+	Enable()
+	defer func() { require.NoError(t, SetRules(nil)) }()
+
+	for _, r := range []Rule{
+		{Point: "unknown", Error: "boom"},
+		{Point: PointDaemonAPI},
+		{Point: PointDaemonAPI, Error: "boom", Probability: 2},
+		{Point: PointDaemonAPI, DelayMs: -1},
+	} {
+		require.Error(t, SetRules([]Rule{r}), r)
+	}
+
+	require.NoError(t, SetRules([]Rule{
+		{Point: PointDaemonStart, Target: "d1", Error: "spawn failure", Count: 2},
+		{Point: PointDaemonAPI, Target: "/api/v1/mount", DelayMs: 1},
+	}))
+
+	err := Inject(PointDaemonStart, "d1")
+	require.True(t, errors.Is(err, ErrInjected))
+	require.Contains(t, err.Error(), "spawn failure")
+	require.NoError(t, Inject(PointDaemonStart, "d2"))
+	require.NoError(t, Inject(PointBlobFetch, "d1"))
+	require.NoError(t, Inject(PointDaemonAPI, "http://unix/api/v1/mount?mountpoint=/"))
+
+	// The rule is removed once its count is consumed.
+	require.Len(t, Rules(), 2)
+	require.Equal(t, 1, Rules()[0].Count)
+	require.Error(t, Inject(PointDaemonStart, "d1"))
+	require.NoError(t, Inject(PointDaemonStart, "d1"))
+	require.Len(t, Rules(), 1)
+	require.Equal(t, PointDaemonAPI, Rules()[0].Point)
+}
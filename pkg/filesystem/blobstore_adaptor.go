@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// Blobs read by a mounted snapshot are held in the shared blob store by the holder.
+func mountBlobHolder(snapshotID string) string {
+	return "mount-" + snapshotID
+}
+
+func (fs *Filesystem) sharedBlobStore() *cache.BlobStore {
+	if fs.cacheMgr == nil {
+		return nil
+	}
+	return fs.cacheMgr.BlobStore()
+}
+
+// useSharedBlobs switches `cfg` of RAFS instance `r` to read blobs from the shared
+// blob store if all blobs of the image are there, e.g. converted locally or downloaded
+// for other images, rather than fetching and caching them again. The blobs are held
+// until the instance is unmounted.
+func (fs *Filesystem) useSharedBlobs(cfg daemonconfig.DaemonConfig, r *racache.Rafs, bootstrap string) error {
+	bs := fs.sharedBlobStore()
+	if bs == nil {
+		return nil
+	}
+	b, err := layout.ReadBootstrap(bootstrap)
+	if err != nil {
+		log.L.WithError(err).Debugf("skip reading shared blobs for snapshot %s", r.SnapshotID)
+		return nil
+	}
+
+	holder := mountBlobHolder(r.SnapshotID)
+	var held []string
+	release := func() {
+		for _, id := range held {
+			if err := bs.Release(id, holder); err != nil {
+				log.L.WithError(err).Warnf("failed to release blob %s", id)
+			}
+		}
+	}
+	for _, blob := range b.Blobs() {
+		if !bs.Has(blob.ID) {
+			release()
+			return nil
+		}
+		// The blob may be removed since checked.
+		if err := bs.Acquire(blob.ID, holder); err != nil {
+			release()
+			return nil
+		}
+		held = append(held, blob.ID)
+	}
+
+	if err := daemonconfig.UseLocalfsBackend(cfg, bs.BlobDir()); err != nil {
+		release()
+		return errors.Wrap(err, "use shared blobs")
+	}
+	r.AddAnnotation(racache.AnnoSharedBlobs, "true")
+	log.L.Infof("snapshot %s reads blobs from shared blob store", r.SnapshotID)
+	return nil
+}
+
+func (fs *Filesystem) releaseSharedBlobs(r *racache.Rafs) {
+	bs := fs.sharedBlobStore()
+	if bs == nil || r.Annotations[racache.AnnoSharedBlobs] == "" {
+		return
+	}
+	if err := bs.ReleaseHolder(mountBlobHolder(r.SnapshotID)); err != nil {
+		log.L.WithError(err).Warnf("failed to release shared blobs of snapshot %s", r.SnapshotID)
+	}
+}
+
+// BlobInUse tells if blob `blobID` is held in the shared blob store or read by any
+// mounted image, including blobs of chunk dictionaries and deduplicated chunks, which
+// are referenced by bootstraps rather than layers of the image.
+func (fs *Filesystem) BlobInUse(blobID string) (bool, error) {
+	holders, err := fs.BlobHolders(blobID)
+	if err != nil {
+		return false, err
+	}
+	if len(holders) > 0 {
+		return true, nil
+	}
+
+	for _, r := range racache.RafsGlobalCache.List() {
+		bootstrap, err := r.BootstrapFile()
+		if err != nil {
+			return false, errors.Wrapf(err, "get bootstrap of snapshot %s", r.SnapshotID)
+		}
+		b, err := layout.ReadBootstrap(bootstrap)
+		if err != nil {
+			return false, errors.Wrapf(err, "read bootstrap of snapshot %s", r.SnapshotID)
+		}
+		for _, blob := range b.Blobs() {
+			if blob.ID == blobID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
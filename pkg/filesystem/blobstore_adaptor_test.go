@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/internal/testutil"
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+func TestUseSharedBlobs(t *testing.T) {
+	cacheMgr, err := cache.NewManager(cache.Opt{CacheDir: t.TempDir(), SharedBlobStore: true})
+	require.NoError(t, err)
+	bs := cacheMgr.BlobStore()
+	fs := &Filesystem{cacheMgr: cacheMgr}
+
+	bootstrap := testutil.ExtractBootstrap(t, "testdata/v6-bootstrap-chunk-pos-438272.tar.gz")
+	b, err := layout.ReadBootstrap(bootstrap)
+	require.NoError(t, err)
+	require.NotEmpty(t, b.Blobs())
+
+	newConfig := func() *daemonconfig.FuseDaemonConfig {
+		cfg := &daemonconfig.FuseDaemonConfig{Device: &daemonconfig.DeviceConfig{}}
+		cfg.Device.Backend.BackendType = "registry"
+		return cfg
+	}
+	r := &racache.Rafs{SnapshotID: "1", Annotations: map[string]string{}}
+
+	// Blobs of the image are fetched from registry unless all are in the store.
+	cfg := newConfig()
+	require.NoError(t, fs.useSharedBlobs(cfg, r, bootstrap))
+	require.Equal(t, "registry", cfg.Device.Backend.BackendType)
+	require.NotContains(t, r.Annotations, racache.AnnoSharedBlobs)
+
+	for _, blob := range b.Blobs() {
+		file := filepath.Join(t.TempDir(), blob.ID)
+		require.NoError(t, os.WriteFile(file, []byte(blob.ID), 0640))
+		require.NoError(t, bs.Add(blob.ID, file, "layer"))
+	}
+	cfg = newConfig()
+	require.NoError(t, fs.useSharedBlobs(cfg, r, bootstrap))
+	require.Equal(t, "localfs", cfg.Device.Backend.BackendType)
+	require.Equal(t, bs.BlobDir(), cfg.Device.Backend.Config.Dir)
+	require.Contains(t, r.Annotations, racache.AnnoSharedBlobs)
+	holders, err := bs.Holders(b.Blobs()[0].ID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"layer", mountBlobHolder("1")}, holders)
+
+	// Blobs are kept for other holders once the snapshot is unmounted.
+	fs.releaseSharedBlobs(r)
+	holders, err = bs.Holders(b.Blobs()[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"layer"}, holders)
+}
+
+func TestBlobInUse(t *testing.T) {
+	cacheMgr, err := cache.NewManager(cache.Opt{CacheDir: t.TempDir(), SharedBlobStore: true})
+	require.NoError(t, err)
+	bs := cacheMgr.BlobStore()
+	fs := &Filesystem{cacheMgr: cacheMgr}
+
+	bootstrap := testutil.ExtractBootstrap(t, "testdata/v6-bootstrap-chunk-pos-438272.tar.gz")
+	b, err := layout.ReadBootstrap(bootstrap)
+	require.NoError(t, err)
+	blobID := b.Blobs()[0].ID
+	other := strings.Repeat("a", 64)
+
+	inUse, err := fs.BlobInUse(blobID)
+	require.NoError(t, err)
+	require.False(t, inUse)
+
+	// Blobs in the blob table of a mounted image are in use, whatever its layers.
+	r := &racache.Rafs{SnapshotID: "blob-in-use", Annotations: map[string]string{racache.AnnoBootstrapPath: bootstrap}}
+	racache.RafsGlobalCache.Add(r)
+	defer racache.RafsGlobalCache.Remove(r.SnapshotID)
+	inUse, err = fs.BlobInUse(blobID)
+	require.NoError(t, err)
+	require.True(t, inUse)
+
+	inUse, err = fs.BlobInUse(other)
+	require.NoError(t, err)
+	require.False(t, inUse)
+	file := filepath.Join(t.TempDir(), other)
+	require.NoError(t, os.WriteFile(file, []byte(other), 0640))
+	require.NoError(t, bs.Add(other, file, "layer"))
+	inUse, err = fs.BlobInUse(other)
+	require.NoError(t, err)
+	require.True(t, inUse)
+}
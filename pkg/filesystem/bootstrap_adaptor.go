@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+// Path of the bootstrap in nydus meta layers.
+const bootstrapNameInLayer = "image/image.boot"
+
+// Fetch the bootstrap of a nydus meta layer, which is not unpacked by containerd in
+// lazy bootstrap mode, from registry into the snapshot directory. Only the bootstrap
+// entry is extracted from the layer stream. It's done once on the first mount of
+// the image, concurrent mounts wait for the same fetch. nydusd takes the bootstrap
+// as a local file on mount and has no way to load it from the registry backend, so
+// the snapshotter rather defers the download from pull to the first mount.
+func (fs *Filesystem) fetchLazyBootstrap(ctx context.Context, snapshotDir string, labels map[string]string) error {
+	target := filepath.Join(snapshotDir, "fs", "image", "image.boot")
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	ref, ok := labels[snpkg.TargetRefLabel]
+	if !ok {
+		return errors.Errorf("not found image reference label")
+	}
+	layerDigest := digest.Digest(labels[snpkg.TargetLayerDigestLabel])
+	if err := layerDigest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid meta layer digest %q", layerDigest)
+	}
+
+	_, err, _ := fs.bootstrapFetches.Do(target, func() (interface{}, error) {
+		if _, err := os.Stat(target); err == nil {
+			return nil, nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, errors.Wrapf(err, "create directory for %s", target)
+		}
+
+		keyChain, err := auth.GetKeyChainByRef(ref, labels)
+		if err != nil {
+			return nil, errors.Wrap(err, "create key chain for connection")
+		}
+		r := remote.New(keyChain, config.GetSkipSSLVerify())
+		err = unpackBootstrap(ctx, r, ref, layerDigest, target)
+		if err != nil && r.RetryWithPlainHTTP(ref, err) {
+			err = unpackBootstrap(ctx, r, ref, layerDigest, target)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		log.G(ctx).Infof("fetched bootstrap of image %s from meta layer %s", ref, layerDigest)
+		return nil, nil
+	})
+
+	return err
+}
+
+func unpackBootstrap(ctx context.Context, r *remote.Remote, ref string, layerDigest digest.Digest, target string) error {
+	fetcher, err := r.Fetcher(ctx, ref)
+	if err != nil {
+		return errors.Wrap(err, "get remote fetcher")
+	}
+	fetcherByDigest, ok := fetcher.(remotes.FetcherByDigest)
+	if !ok {
+		return errors.Errorf("fetcher %T does not implement remotes.FetcherByDigest", fetcher)
+	}
+
+	var rc io.ReadCloser
+	if rc, _, err = fetcherByDigest.FetchByDigest(ctx, layerDigest); err != nil {
+		return errors.Wrapf(err, "fetch meta layer %s", layerDigest)
+	}
+	defer rc.Close()
+
+	return unpackVerifiedBootstrap(rc, layerDigest, target)
+}
+
+// The bootstrap is only moved to `target` once the whole layer stream matches `layerDigest`.
+func unpackVerifiedBootstrap(layer io.Reader, layerDigest digest.Digest, target string) error {
+	verifier := layerDigest.Verifier()
+	reader := io.TeeReader(layer, verifier)
+
+	// Never leave a partial bootstrap behind, which would be taken as the fetched one.
+	tmp := target + ".tmp"
+	defer os.Remove(tmp)
+	if err := remote.Unpack(reader, bootstrapNameInLayer, tmp); err != nil {
+		return errors.Wrapf(err, "unpack bootstrap from meta layer %s", layerDigest)
+	}
+	// Unpacking stops at the bootstrap entry, digest the rest of the layer.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return errors.Wrapf(err, "read meta layer %s", layerDigest)
+	}
+	if !verifier.Verified() {
+		return errors.Errorf("digest of meta layer mismatches %s", layerDigest)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return errors.Wrapf(err, "rename file %s", tmp)
+	}
+
+	return nil
+}
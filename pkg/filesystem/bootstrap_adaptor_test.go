@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchLazyBootstrap(t *testing.T) {
+	var fs Filesystem
+	snapshotDir := t.TempDir()
+
+	labels := map[string]string{snpkg.TargetRefLabel: "docker.io/library/nginx:latest"}
+	require.Error(t, fs.fetchLazyBootstrap(context.Background(), snapshotDir, labels))
+	labels[snpkg.TargetLayerDigestLabel] = "sha256:invalid"
+	require.Error(t, fs.fetchLazyBootstrap(context.Background(), snapshotDir, labels))
+
+	// Fetched bootstraps are reused without requesting registry.
+	target := filepath.Join(snapshotDir, "fs", "image", "image.boot")
+	require.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+	require.NoError(t, os.WriteFile(target, []byte("boot"), 0644))
+	require.NoError(t, fs.fetchLazyBootstrap(context.Background(), snapshotDir, nil))
+}
+
+func TestUnpackVerifiedBootstrap(t *testing.T) {
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{bootstrapNameInLayer, "image/blob.meta"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte("boot"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	target := filepath.Join(t.TempDir(), "image.boot")
+	err := unpackVerifiedBootstrap(bytes.NewReader(layer.Bytes()), digest.FromString("tampered"), target)
+	require.Error(t, err)
+	require.NoFileExists(t, target)
+	require.NoFileExists(t, target+".tmp")
+
+	require.NoError(t, unpackVerifiedBootstrap(bytes.NewReader(layer.Bytes()), digest.FromBytes(layer.Bytes()), target))
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "boot", string(data))
+}
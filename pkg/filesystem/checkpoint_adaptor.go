@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const (
+	defaultDrainTimeout = 10 * time.Second
+	drainPollInterval   = 100 * time.Millisecond
+)
+
+// CheckpointRecord is kept along with a CRIU checkpoint of a container on nydus rootfs,
+// so that its image is re-established before the container is restored, maybe on another node.
+type CheckpointRecord struct {
+	ImageID    string `json:"image_id"`
+	SnapshotID string `json:"snapshot_id"`
+	FsDriver   string `json:"fs_driver"`
+	// Bootstrap of the image converted locally, which is mounted with locally converted blobs.
+	LocalBootstrap string `json:"local_bootstrap,omitempty"`
+}
+
+// Checkpoint drains in-flight FUSE requests to the nydusd serving the image before CRIU dumps a
+// container on it, since CRIU fails to freeze tasks blocked in FUSE requests. New requests are
+// not blocked, which would stall the freeze the same way, so it must be called after CRIU starts
+// freezing the tasks of the container, then no more requests are issued by them.
+func (fs *Filesystem) Checkpoint(ctx context.Context, snapshotID, imageRef string, timeout time.Duration) (*CheckpointRecord, error) {
+	rafs := findRafs(snapshotID, imageRef)
+	if rafs == nil {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "image %s of snapshot %s is not mounted", imageRef, snapshotID)
+	}
+
+	record := CheckpointRecord{
+		ImageID:    rafs.ImageID,
+		SnapshotID: rafs.SnapshotID,
+		FsDriver:   rafs.GetFsDriver(),
+	}
+	record.LocalBootstrap = rafs.Annotations[racache.AnnoBootstrapPath]
+	if record.FsDriver != config.FsDriverFusedev {
+		// Nothing to drain for in-kernel EROFS or blockdev mounts.
+		return &record, nil
+	}
+
+	d, err := fs.getDaemonByRafs(rafs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "find daemon of snapshot %s", rafs.SnapshotID)
+	}
+	if d.State() != types.DaemonStateRunning {
+		return nil, errors.Wrapf(errdefs.ErrUnavailable, "daemon %s is %s", d.ID(), d.State())
+	}
+	if err := drainDaemon(ctx, d, timeout); err != nil {
+		return nil, err
+	}
+
+	log.G(ctx).Infof("drained requests to daemon %s for checkpoint of image %s", d.ID(), record.ImageID)
+	return &record, nil
+}
+
+// Restore prepares the image of `record` for a container to be restored by CRIU. Blob cache is
+// warmed up by prefetching the whole image once it's mounted on this node, since restored processes
+// access their working set at once. The meta snapshot of the checkpoint is mounted again if it's
+// still on this node but not mounted any more, otherwise the image is mounted when the rootfs of
+// the container is prepared. If the image is mounted, it waits for nydusd to serve.
+func (fs *Filesystem) Restore(ctx context.Context, record *CheckpointRecord) (bool, error) {
+	if record.ImageID == "" {
+		return false, errors.Wrap(errdefs.ErrInvalidArgument, "image of checkpoint is required")
+	}
+
+	rafs := findRafs("", record.ImageID)
+	if rafs == nil {
+		if record.FsDriver == "" || record.FsDriver == config.FsDriverFusedev {
+			fs.warmupMutex.Lock()
+			if fs.warmupImages == nil {
+				fs.warmupImages = make(map[string]bool)
+			}
+			fs.warmupImages[record.ImageID] = true
+			fs.warmupMutex.Unlock()
+		}
+
+		remounted, err := fs.remountForRestore(ctx, record)
+		if err != nil {
+			return false, errors.Wrapf(err, "mount snapshot %s of checkpoint", record.SnapshotID)
+		}
+		if !remounted {
+			log.G(ctx).Infof("image %s of checkpoint is to be warmed up on mount", record.ImageID)
+			return false, nil
+		}
+		if rafs = findRafs(record.SnapshotID, ""); rafs == nil {
+			return false, errors.Errorf("no RAFS instance of snapshot %s after mount", record.SnapshotID)
+		}
+	}
+
+	if rafs.GetFsDriver() != config.FsDriverFusedev {
+		return true, nil
+	}
+	d, err := fs.getDaemonByRafs(rafs)
+	if err != nil {
+		return false, errors.Wrapf(err, "find daemon of snapshot %s", rafs.SnapshotID)
+	}
+	if err := d.WaitUntilState(types.DaemonStateRunning); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Prefetch the whole image if a checkpoint on it is being restored, it happens once per restore.
+func (fs *Filesystem) warmupForRestore(cfg daemonconfig.DaemonConfig, imageID string) error {
+	fs.warmupMutex.Lock()
+	warmup := fs.warmupImages[imageID]
+	delete(fs.warmupImages, imageID)
+	fs.warmupMutex.Unlock()
+
+	if !warmup {
+		return nil
+	}
+	log.L.Infof("warm up blob cache of image %s to restore checkpoint", imageID)
+	return daemonconfig.TunePrefetch(cfg, true, aggressivePrefetchThreads)
+}
+
+// Mount the meta snapshot of `record` again if it's left on this node, e.g. RAFS instances of
+// the image were released after the checkpoint. Only daemon backed drivers are re-established.
+func (fs *Filesystem) remountForRestore(ctx context.Context, record *CheckpointRecord) (bool, error) {
+	if record.SnapshotID == "" {
+		return false, nil
+	}
+	switch record.FsDriver {
+	case "", config.FsDriverFusedev, config.FsDriverFscache:
+	default:
+		return false, nil
+	}
+	if err := validateSnapshotID(record.SnapshotID); err != nil {
+		return false, err
+	}
+	bootstrap := filepath.Join(config.GetSnapshotsRootDir(), record.SnapshotID, "fs", "image", "image.boot")
+	if record.LocalBootstrap != "" {
+		// Merged by local conversion into the snapshot directory, see snapshot.prepareLocalConversion.
+		merged := filepath.Join(config.GetSnapshotsRootDir(), record.SnapshotID, "conversion", "image.boot")
+		if filepath.Clean(record.LocalBootstrap) != merged {
+			return false, errors.Errorf("local bootstrap %s is not of snapshot %s", record.LocalBootstrap, record.SnapshotID)
+		}
+		bootstrap = merged
+	}
+	if _, err := os.Stat(bootstrap); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "stat bootstrap %s", bootstrap)
+	}
+
+	labels := map[string]string{snpkg.TargetRefLabel: record.ImageID}
+	if record.LocalBootstrap != "" {
+		labels[label.NydusLocalConversion] = record.LocalBootstrap
+	}
+	log.G(ctx).Infof("mount snapshot %s again to restore checkpoint of image %s", record.SnapshotID, record.ImageID)
+	if err := fs.Mount(ctx, record.SnapshotID, labels, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Snapshot IDs from outside, e.g. checkpoint records and API requests, name directories
+// under the snapshots root, so they must be numeric like the ones allocated by containerd.
+func validateSnapshotID(id string) error {
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "snapshot ID %q", id)
+	}
+	return nil
+}
+
+func drainDaemon(ctx context.Context, d *daemon.Daemon, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		m, err := d.GetInflightMetrics()
+		if err != nil {
+			return errors.Wrapf(err, "get inflight metrics of daemon %s", d.ID())
+		}
+		if len(m.Values) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("daemon %s still has %d inflight requests after %v", d.ID(), len(m.Values), timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+)
+
+func TestRestoreWarmup(t *testing.T) {
+	var fs Filesystem
+
+	_, err := fs.Restore(context.Background(), &CheckpointRecord{})
+	require.Error(t, err)
+
+	ready, err := fs.Restore(context.Background(), &CheckpointRecord{ImageID: "docker.io/library/nginx:latest"})
+	require.NoError(t, err)
+	require.False(t, ready)
+
+	cfg := &daemonconfig.FuseDaemonConfig{}
+	require.NoError(t, fs.warmupForRestore(cfg, "docker.io/library/busybox:latest"))
+	require.False(t, cfg.FSPrefetch.PrefetchAll)
+
+	require.NoError(t, fs.warmupForRestore(cfg, "docker.io/library/nginx:latest"))
+	require.True(t, cfg.FSPrefetch.PrefetchAll)
+	require.Equal(t, aggressivePrefetchThreads, cfg.FSPrefetch.ThreadsCount)
+
+	// Only the first mount after restore is warmed up.
+	cfg = &daemonconfig.FuseDaemonConfig{}
+	require.NoError(t, fs.warmupForRestore(cfg, "docker.io/library/nginx:latest"))
+	require.False(t, cfg.FSPrefetch.PrefetchAll)
+}
+
+func TestRemountForRestore(t *testing.T) {
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{Root: t.TempDir(), DaemonMode: "dedicated"}))
+	var fs Filesystem
+	ctx := context.Background()
+	merged := filepath.Join(config.GetSnapshotsRootDir(), "10", "conversion", "image.boot")
+
+	for _, record := range []CheckpointRecord{
+		{ImageID: "docker.io/library/nginx:latest"},
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "10", FsDriver: config.FsDriverBlockdev},
+		// The snapshot is not on this node.
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "10"},
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "10", LocalBootstrap: merged},
+	} {
+		remounted, err := fs.remountForRestore(ctx, &record)
+		require.NoError(t, err)
+		require.False(t, remounted)
+	}
+
+	// Records must not point out of the snapshot directories.
+	for _, record := range []CheckpointRecord{
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "../10"},
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "10", LocalBootstrap: filepath.Join(t.TempDir(), "image.boot")},
+		{ImageID: "docker.io/library/nginx:latest", SnapshotID: "11", LocalBootstrap: merged},
+	} {
+		_, err := fs.remountForRestore(ctx, &record)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const (
+	firstReadPollInterval = time.Second
+	// Containers not reading their rootfs in time are not accounted.
+	firstReadTimeout = 10 * time.Minute
+)
+
+// A container waiting for its first data read of RAFS instance `sid` of a daemon.
+type firstReadWatch struct {
+	sid      string
+	imageID  string
+	baseline uint64
+	mounted  time.Time
+}
+
+// Polls fs metrics of a daemon once per interval for all containers it serves waiting
+// for their first reads, and quits once none is left.
+type firstReadPoller struct {
+	d       *daemon.Daemon
+	watches []*firstReadWatch
+}
+
+// ObserveColdStart records the time to mount rootfs of a container served by RAFS
+// instance `snapshotID` since `prepareStart`, then waits for the first data read of
+// the instance in background.
+func (fs *Filesystem) ObserveColdStart(snapshotID string, prepareStart time.Time) {
+	if !fs.coldStartMetrics {
+		return
+	}
+	rafs := racache.RafsGlobalCache.Get(snapshotID)
+	if rafs == nil {
+		return
+	}
+	mounted := time.Now()
+	collector.NewColdStartCollector(collector.ColdStartPhaseMount, rafs.ImageID, mounted.Sub(prepareStart)).Collect()
+
+	// Only FUSE daemons account data reads of RAFS instances.
+	if rafs.GetFsDriver() != config.FsDriverFusedev {
+		return
+	}
+	d, err := fs.getDaemonByRafs(rafs)
+	if err != nil {
+		return
+	}
+	var sid string
+	if d.IsSharedDaemon() {
+		sid = rafs.SnapshotID
+	}
+	// The instance may be shared by containers of the same image, so reads before
+	// this container are excluded.
+	m, err := d.GetFsMetrics(sid)
+	if err != nil {
+		log.L.WithError(err).Debugf("failed to get fs metrics of snapshot %s", snapshotID)
+		return
+	}
+
+	fs.watchFirstRead(d, &firstReadWatch{sid: sid, imageID: rafs.ImageID, baseline: m.DataRead, mounted: mounted})
+}
+
+func (fs *Filesystem) watchFirstRead(d *daemon.Daemon, w *firstReadWatch) {
+	fs.firstReadMutex.Lock()
+	defer fs.firstReadMutex.Unlock()
+
+	if fs.firstReadPollers == nil {
+		fs.firstReadPollers = make(map[string]*firstReadPoller)
+	}
+	p, ok := fs.firstReadPollers[d.ID()]
+	if !ok {
+		p = &firstReadPoller{d: d}
+		fs.firstReadPollers[d.ID()] = p
+		go fs.pollFirstReads(p)
+	}
+	p.watches = append(p.watches, w)
+}
+
+func (fs *Filesystem) pollFirstReads(p *firstReadPoller) {
+	ticker := time.NewTicker(firstReadPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fs.firstReadMutex.Lock()
+		watches := append([]*firstReadWatch{}, p.watches...)
+		fs.firstReadMutex.Unlock()
+
+		done := checkFirstReads(watches, p.d.GetFsMetrics, time.Now())
+
+		fs.firstReadMutex.Lock()
+		left := p.watches[:0]
+		for _, w := range p.watches {
+			if !done[w] {
+				left = append(left, w)
+			}
+		}
+		p.watches = left
+		if len(left) == 0 {
+			delete(fs.firstReadPollers, p.d.ID())
+			fs.firstReadMutex.Unlock()
+			return
+		}
+		fs.firstReadMutex.Unlock()
+	}
+}
+
+// checkFirstReads gets fs metrics of each instance once for all `watches`, and returns
+// the watches done, i.e. read, timed out or gone with the instance.
+func checkFirstReads(watches []*firstReadWatch, getFsMetrics func(sid string) (*types.FsMetrics, error),
+	now time.Time) map[*firstReadWatch]bool {
+	done := make(map[*firstReadWatch]bool)
+	metrics := make(map[string]*types.FsMetrics)
+	for _, w := range watches {
+		if now.Sub(w.mounted) > firstReadTimeout {
+			done[w] = true
+			continue
+		}
+		m, ok := metrics[w.sid]
+		if !ok {
+			var err error
+			// The instance or its daemon is gone if failed.
+			if m, err = getFsMetrics(w.sid); err != nil {
+				m = nil
+			}
+			metrics[w.sid] = m
+		}
+		if m == nil {
+			done[w] = true
+		} else if m.DataRead > w.baseline {
+			collector.NewColdStartCollector(collector.ColdStartPhaseFirstRead, w.imageID, now.Sub(w.mounted)).Collect()
+			done[w] = true
+		}
+	}
+	return done
+}
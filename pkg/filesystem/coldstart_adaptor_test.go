@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+func TestCheckFirstReads(t *testing.T) {
+	now := time.Now()
+	read := &firstReadWatch{sid: "1", imageID: "docker.io/library/nginx:latest", baseline: 10, mounted: now.Add(-time.Second)}
+	waiting := &firstReadWatch{sid: "1", imageID: "docker.io/library/nginx:latest", baseline: 20, mounted: now.Add(-time.Second)}
+	expired := &firstReadWatch{sid: "2", imageID: "docker.io/library/redis:latest", mounted: now.Add(-firstReadTimeout - time.Second)}
+	gone := &firstReadWatch{sid: "3", imageID: "docker.io/library/redis:latest", mounted: now}
+
+	calls := map[string]int{}
+	done := checkFirstReads([]*firstReadWatch{read, waiting, expired, gone}, func(sid string) (*types.FsMetrics, error) {
+		calls[sid]++
+		if sid == "3" {
+			return nil, errors.New("instance not found")
+		}
+		return &types.FsMetrics{DataRead: 15}, nil
+	}, now)
+
+	require.Equal(t, map[*firstReadWatch]bool{read: true, expired: true, gone: true}, done)
+	// Containers of the same instance share the query.
+	require.Equal(t, map[string]int{"1": 1, "3": 1}, calls)
+}
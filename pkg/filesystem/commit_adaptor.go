@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/converter/tool"
+)
+
+const (
+	// Nydus layer blob with inlined bootstrap packed from a writable layer.
+	CommitLayerBlob = "layer.blob"
+	// Bootstrap of the packed layer only.
+	CommitLayerBootstrap = "layer.boot"
+	// Bootstrap of the parent image appended with the packed layer.
+	CommitImageBootstrap = "image.boot"
+
+	rafsV5Magic    = 0x52414653
+	erofsMagic     = 0xE0F5E1E2
+	erofsSbOffset  = 1024
+	superBlockSize = erofsSbOffset + 4
+)
+
+// Detect RAFS version of a bootstrap, since layers must be merged with the same version.
+func bootstrapFsVersion(bootstrap string) (string, error) {
+	f, err := os.Open(bootstrap)
+	if err != nil {
+		return "", errors.Wrapf(err, "open bootstrap %s", bootstrap)
+	}
+	defer f.Close()
+
+	buf := make([]byte, superBlockSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return "", errors.Wrapf(err, "read super block of %s", bootstrap)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(buf[erofsSbOffset:]) == erofsMagic:
+		return "6", nil
+	case binary.LittleEndian.Uint32(buf) == rafsV5Magic:
+		return "5", nil
+	default:
+		return "", errors.Errorf("unknown bootstrap format of %s", bootstrap)
+	}
+}
+
+// CommitNydusLayer packs the overlayfs `upperDir` of a container into a nydus layer
+// and appends it to `parentBootstrap`, all artifacts are put into `targetDir`.
+// It returns the digest of the layer blob.
+func (fs *Filesystem) CommitNydusLayer(ctx context.Context, upperDir, parentBootstrap, targetDir string) (digest.Digest, error) {
+	fsVersion, err := bootstrapFsVersion(parentBootstrap)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(targetDir, 0750); err != nil {
+		return "", errors.Wrapf(err, "create directory %s", targetDir)
+	}
+	workDir, err := os.MkdirTemp(targetDir, "commit-")
+	if err != nil {
+		return "", errors.Wrap(err, "create commit work directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	blobPath := filepath.Join(workDir, CommitLayerBlob)
+	if err := tool.PackDirectory(tool.PackOption{
+		Context:     ctx,
+		BuilderPath: fs.nydusImageBinaryPath,
+		BlobPath:    blobPath,
+		SourcePath:  upperDir,
+		FsVersion:   fsVersion,
+	}); err != nil {
+		return "", errors.Wrapf(err, "pack writable layer %s", upperDir)
+	}
+
+	ra, err := local.OpenReader(blobPath)
+	if err != nil {
+		return "", errors.Wrap(err, "open nydus blob")
+	}
+	defer ra.Close()
+
+	layerBootstrap := filepath.Join(workDir, CommitLayerBootstrap)
+	bootstrap, err := os.Create(layerBootstrap)
+	if err != nil {
+		return "", errors.Wrap(err, "create layer bootstrap")
+	}
+	defer bootstrap.Close()
+	if _, err := converter.UnpackEntry(ra, converter.EntryBootstrap, bootstrap); err != nil {
+		return "", errors.Wrap(err, "unpack layer bootstrap")
+	}
+
+	imageBootstrap := filepath.Join(workDir, CommitImageBootstrap)
+	if _, err := tool.Merge(tool.MergeOption{
+		Context:              ctx,
+		BuilderPath:          fs.nydusImageBinaryPath,
+		ParentBootstrapPath:  parentBootstrap,
+		SourceBootstrapPaths: []string{layerBootstrap},
+		TargetBootstrapPath:  imageBootstrap,
+		OutputJSONPath:       filepath.Join(workDir, "merge-output.json"),
+	}); err != nil {
+		return "", errors.Wrap(err, "append layer to parent bootstrap")
+	}
+
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return "", errors.Wrap(err, "open nydus blob")
+	}
+	defer blob.Close()
+	blobDigest, err := digest.Canonical.FromReader(blob)
+	if err != nil {
+		return "", errors.Wrap(err, "digest nydus blob")
+	}
+
+	for _, name := range []string{CommitLayerBlob, CommitLayerBootstrap, CommitImageBootstrap} {
+		if err := os.Rename(filepath.Join(workDir, name), filepath.Join(targetDir, name)); err != nil {
+			return "", errors.Wrapf(err, "move %s to %s", name, targetDir)
+		}
+	}
+
+	return blobDigest, nil
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapFsVersion(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, offset int, magic uint32) string {
+		buf := make([]byte, 8192)
+		binary.LittleEndian.PutUint32(buf[offset:], magic)
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, buf, 0644))
+		return p
+	}
+
+	v, err := bootstrapFsVersion(write("v5", 0, rafsV5Magic))
+	require.NoError(t, err)
+	require.Equal(t, "5", v)
+
+	v, err = bootstrapFsVersion(write("v6", erofsSbOffset, erofsMagic))
+	require.NoError(t, err)
+	require.Equal(t, "6", v)
+
+	_, err = bootstrapFsVersion(write("unknown", 0, 0))
+	require.Error(t, err)
+
+	short := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(short, []byte("RAFS"), 0644))
+	_, err = bootstrapFsVersion(short)
+	require.Error(t, err)
+}
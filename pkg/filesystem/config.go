@@ -8,8 +8,14 @@
 package filesystem
 
 import (
+	"time"
+
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/conversion"
+	"github.com/containerd/nydus-snapshotter/pkg/detach"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/mirror"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/signature"
 	"github.com/containerd/nydus-snapshotter/pkg/stargz"
@@ -70,6 +76,57 @@ func WithTarfsManager(tm *tarfs.Manager) NewFSOpt {
 	}
 }
 
+func WithConversionManager(cm *conversion.Manager) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if cm == nil {
+			return errors.New("conversion manager cannot be nil")
+		}
+		fs.conversionMgr = cm
+		return nil
+	}
+}
+
+// WithWriteThroughMirror copies nydus blobs downloaded to the node into a nearby registry.
+func WithWriteThroughMirror(m *mirror.Mirror) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if m == nil {
+			return errors.New("write-through mirror cannot be nil")
+		}
+		fs.mirror = m
+		return nil
+	}
+}
+
+// WithDetacher downloads images fully in background and detaches them from registries.
+func WithDetacher(d *detach.Detacher) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if d == nil {
+			return errors.New("detacher cannot be nil")
+		}
+		fs.detacher = d
+		return nil
+	}
+}
+
+func WithAdaptivePrefetch(policy *prefetch.AdaptivePolicy, samplePeriod time.Duration) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if policy == nil {
+			return errors.New("adaptive prefetch policy cannot be nil")
+		}
+		fs.adaptivePrefetch = policy
+		fs.prefetchSamplePeriod = samplePeriod
+		return nil
+	}
+}
+
+// WithColdStartMetrics measures time to mount and time to first read of containers.
+func WithColdStartMetrics() NewFSOpt {
+	return func(fs *Filesystem) error {
+		fs.coldStartMetrics = true
+		return nil
+	}
+}
+
 func WithVerifier(verifier *signature.Verifier) NewFSOpt {
 	return func(fs *Filesystem) error {
 		fs.verifier = verifier
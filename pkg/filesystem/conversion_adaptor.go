@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/conversion"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func (fs *Filesystem) LocalConversionEnabled() bool {
+	return fs.conversionMgr != nil
+}
+
+// ScheduleLocalConversion converts the OCIv1 layer described by `labels` into
+// nydus format in background. It never blocks the image pulling.
+func (fs *Filesystem) ScheduleLocalConversion(labels map[string]string) error {
+	ref, ok := labels[snpkg.TargetRefLabel]
+	if !ok {
+		return errors.Errorf("not found image reference label")
+	}
+	layerDigest := digest.Digest(labels[snpkg.TargetLayerDigestLabel])
+	if layerDigest.Validate() != nil {
+		return errors.Errorf("not found layer digest label")
+	}
+
+	priority := conversion.PriorityForeground
+	if labels[label.NydusConversionPriority] == conversion.PriorityBackgroundValue {
+		priority = conversion.PriorityBackground
+	}
+	fs.conversionMgr.ConvertLayer(ref, layerDigest, priority)
+
+	return nil
+}
+
+// PrepareLocalConversion merges bootstraps of locally converted layers, ordered
+// from lowest to uppermost, into `target`. It fails if any of the layers is not
+// converted yet, in which case the snapshot should be served natively.
+func (fs *Filesystem) PrepareLocalConversion(layers []digest.Digest, target string) error {
+	return fs.conversionMgr.MergeLayers(layers, target)
+}
+
+// StopLocalConversion disconnects from the converter worker on shutdown.
+func (fs *Filesystem) StopLocalConversion() {
+	if fs.conversionMgr == nil {
+		return
+	}
+	if err := fs.conversionMgr.Close(); err != nil {
+		log.L.WithError(err).Warn("failed to close local conversion")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"github.com/containerd/log"
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// Only images served by FUSE from registries can be switched to local blobs in place.
+func (fs *Filesystem) detachable(r *racache.Rafs) bool {
+	if fs.detacher == nil || r.GetFsDriver() != config.FsDriverFusedev {
+		return false
+	}
+	// Locally converted images and those of shared blobs read local blobs already.
+	_, converted := r.Annotations[racache.AnnoBootstrapPath]
+	_, shared := r.Annotations[racache.AnnoSharedBlobs]
+	return !converted && !shared
+}
+
+// Download the image of RAFS instance `r` in background and detach it from registry.
+func (fs *Filesystem) scheduleDetach(r *racache.Rafs, labels map[string]string) {
+	if !fs.detachable(r) {
+		return
+	}
+	bootstrap, err := r.BootstrapFile()
+	if err != nil {
+		log.L.WithError(err).Warnf("skip detaching snapshot %s", r.SnapshotID)
+		return
+	}
+	keyChain, err := auth.GetKeyChainByRef(r.ImageID, labels)
+	if err != nil {
+		log.L.WithError(err).Warnf("skip detaching snapshot %s", r.SnapshotID)
+		return
+	}
+
+	snapshotID := r.SnapshotID
+	fs.detacher.Detach(snapshotID, r.ImageID, bootstrap, keyChain, func(blobDir string) error {
+		if err := fs.remountDetached(snapshotID, blobDir); err != nil {
+			return err
+		}
+		fs.mirrorSharedBlobs(r)
+		return nil
+	})
+}
+
+func (fs *Filesystem) remountDetached(snapshotID, blobDir string) error {
+	r := racache.RafsGlobalCache.Get(snapshotID)
+	if r == nil {
+		return errors.Wrapf(errdefs.ErrNotFound, "RAFS instance of snapshot %s", snapshotID)
+	}
+	d, err := fs.getDaemonByRafs(r)
+	if err != nil {
+		return err
+	}
+	fsManager, err := fs.getManager(r.GetFsDriver())
+	if err != nil {
+		return err
+	}
+
+	cfg := deepcopy.Copy(d.Config).(daemonconfig.DaemonConfig)
+	if err := daemonconfig.UseLocalfsBackend(cfg, blobDir); err != nil {
+		return errors.Wrap(err, "use downloaded blobs")
+	}
+	if err := d.Remount(r, cfg); err != nil {
+		return err
+	}
+
+	r.AddAnnotation(racache.AnnoDetached, "true")
+	return errors.Wrapf(fsManager.UpdateRafsInstance(r), "persist instance %s", snapshotID)
+}
+
+// Images not yet detached when snapshotter stopped are downloaded again, and detached
+// ones are switched again in case nydusd was restarted serving them from registries.
+// Labels of the mounts are gone, so credentials only come from the configured providers,
+// e.g. kubesecret, CRI and docker config. Pull credentials in labels are never persisted.
+func (fs *Filesystem) resumeDetach() {
+	for _, r := range racache.RafsGlobalCache.List() {
+		fs.scheduleDetach(r, nil)
+	}
+}
+
+func (fs *Filesystem) releaseDetach(snapshotID string) {
+	if fs.detacher == nil {
+		return
+	}
+	if err := fs.detacher.Release(snapshotID); err != nil {
+		log.L.WithError(err).Warnf("failed to release downloaded blobs of snapshot %s", snapshotID)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/log"
+	"github.com/mohae/deepcopy"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/converter/tool"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/export"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+)
+
+// Comment of the history item appended by the converter for the bootstrap layer.
+const bootstrapHistoryComment = "Nydus Bootstrap Layer"
+
+// ExportImage reassembles the nydus image of meta layer `snapshotID` into an image archive
+// written to `w`, which can be loaded by `docker load` or `nerdctl load`. Either `snapshotID`
+// or `imageRef` identifies a mounted image, while both are needed for an image only cached.
+// The image config is fetched from registry and a layer is unpacked per nydus blob layer.
+// It returns the digest of the exported image manifest.
+func (fs *Filesystem) ExportImage(ctx context.Context, snapshotID, imageRef string, w io.Writer) (digest.Digest, error) {
+	rafs := findRafs(snapshotID, imageRef)
+
+	var bootstrap string
+	var err error
+	var labels map[string]string
+	fsDriver := config.GetFsDriver()
+	localConversion := false
+	if rafs != nil {
+		if bootstrap, err = rafs.BootstrapFile(); err != nil {
+			return "", errors.Wrapf(err, "find bootstrap of snapshot %s", rafs.SnapshotID)
+		}
+		snapshotID = rafs.SnapshotID
+		if imageRef == "" {
+			imageRef = rafs.ImageID
+		}
+		fsDriver = rafs.GetFsDriver()
+		_, localConversion = rafs.Annotations[racache.AnnoBootstrapPath]
+		labels = rafs.Annotations
+	} else {
+		if snapshotID == "" || imageRef == "" {
+			return "", errors.Wrapf(errdefs.ErrNotFound, "image %s is not mounted", imageRef)
+		}
+		if err := validateSnapshotID(snapshotID); err != nil {
+			return "", err
+		}
+		bootstrap = filepath.Join(config.GetSnapshotsRootDir(), snapshotID, "fs", "image", "image.boot")
+		if _, err := os.Stat(bootstrap); err != nil {
+			return "", errors.Wrapf(err, "find bootstrap of snapshot %s", snapshotID)
+		}
+	}
+
+	fsManager, err := fs.getManager(fsDriver)
+	if err != nil {
+		return "", err
+	}
+	cfg := deepcopy.Copy(*fsManager.DaemonConfig).(daemonconfig.DaemonConfig)
+	if err := daemonconfig.SupplementDaemonConfig(cfg, &daemon.NydusdSupplementInfo{
+		ImageID:    imageRef,
+		SnapshotID: snapshotID,
+		Params:     map[string]string{daemonconfig.Bootstrap: bootstrap},
+	}); err != nil {
+		return "", errors.Wrap(err, "supplement configuration")
+	}
+	if localConversion {
+		if fs.conversionMgr == nil {
+			return "", errors.Errorf("local conversion is disabled for snapshot %s", snapshotID)
+		}
+		if err := daemonconfig.UseLocalfsBackend(cfg, fs.conversionMgr.BlobDir()); err != nil {
+			return "", errors.Wrap(err, "use localfs backend")
+		}
+	}
+
+	exportDir := exportWorkDir()
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return "", errors.Wrapf(err, "create directory %s", exportDir)
+	}
+	workDir, release, err := fs.workTracker.MkdirTemp(exportDir, snapshotID+"-")
+	if err != nil {
+		return "", errors.Wrap(err, "create export work directory")
+	}
+	defer release()
+	defer os.RemoveAll(workDir)
+
+	// The backend configuration carries registry credentials, it's removed with the work directory.
+	backendConfigPath := filepath.Join(workDir, "backend.json")
+	if err := writeBackendConfig(cfg, backendConfigPath); err != nil {
+		return "", err
+	}
+
+	keyChain, err := auth.GetKeyChainByRef(imageRef, labels)
+	if err != nil {
+		return "", errors.Wrap(err, "create key chain for connection")
+	}
+	r := remote.New(keyChain, config.GetSkipSSLVerify())
+	manifest, imageConfig, err := r.FetchImage(ctx, imageRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetch image %s", imageRef)
+	}
+
+	log.G(ctx).Infof("exporting image %s from snapshot %s", imageRef, snapshotID)
+	var layers []string
+	// Blobs of local conversion are not pushed, the image in registry is the original one.
+	if !localConversion {
+		if layers, err = fs.unpackBlobLayers(ctx, r, imageRef, manifest, workDir); err != nil {
+			return "", errors.Wrapf(err, "unpack layers of image %s", imageRef)
+		}
+	}
+	if layers == nil {
+		// Without bootstraps of layers, e.g. blobs are stored in a separate backend, the
+		// image is flattened into a single layer from the merged bootstrap.
+		layerTar := filepath.Join(workDir, "layer.tar")
+		if err := tool.Unpack(tool.UnpackOption{
+			Context:           ctx,
+			BuilderPath:       fs.nydusImageBinaryPath,
+			BootstrapPath:     bootstrap,
+			BackendConfigPath: backendConfigPath,
+			TarPath:           layerTar,
+		}); err != nil {
+			return "", errors.Wrapf(err, "unpack image %s", imageRef)
+		}
+		layers = []string{layerTar}
+		imageConfig.History = flattenHistory(imageConfig.History)
+	} else {
+		imageConfig.History = dropBootstrapHistory(imageConfig.History)
+	}
+
+	return export.WriteOCIArchive(w, export.Image{
+		Ref:    imageRef,
+		Config: *imageConfig,
+		Layers: layers,
+	})
+}
+
+// Unpack each nydus blob layer of the image to a tar file from its own bootstrap, so that
+// the exported image keeps the layers. Nil is returned if any layer carries no bootstrap.
+func (fs *Filesystem) unpackBlobLayers(ctx context.Context, r *remote.Remote, ref string, manifest *ocispec.Manifest, workDir string) ([]string, error) {
+	var layers []string
+	for _, desc := range manifest.Layers {
+		if !converter.IsNydusBlob(desc) {
+			continue
+		}
+
+		blobPath := filepath.Join(workDir, desc.Digest.Encoded())
+		err := fetchBlob(ctx, r, ref, desc, blobPath)
+		if err != nil && r.RetryWithPlainHTTP(ref, err) {
+			err = fetchBlob(ctx, r, ref, desc, blobPath)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch layer %s", desc.Digest)
+		}
+
+		layerTar := filepath.Join(workDir, fmt.Sprintf("layer-%d.tar", len(layers)))
+		err = unpackBlobLayer(ctx, fs.nydusImageBinaryPath, blobPath, layerTar, workDir)
+		os.Remove(blobPath)
+		if errors.Is(err, converter.ErrNotFound) {
+			log.G(ctx).Infof("layer %s carries no bootstrap, flatten image %s", desc.Digest, ref)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unpack layer %s", desc.Digest)
+		}
+		layers = append(layers, layerTar)
+	}
+
+	return layers, nil
+}
+
+func fetchBlob(ctx context.Context, r *remote.Remote, ref string, desc ocispec.Descriptor, target string) error {
+	fetcher, err := r.Fetcher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(f, verifier), rc); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return errors.Errorf("digest of blob %s mismatches", desc.Digest)
+	}
+	return nil
+}
+
+func unpackBlobLayer(ctx context.Context, builderPath, blobPath, layerTar, workDir string) error {
+	ra, err := local.OpenReader(blobPath)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+
+	f, err := os.OpenFile(layerTar, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return converter.Unpack(ctx, ra, f, converter.UnpackOption{
+		WorkDir:     workDir,
+		BuilderPath: builderPath,
+	})
+}
+
+// Drop the history item of the nydus bootstrap layer appended by the converter.
+func dropBootstrapHistory(history []ocispec.History) []ocispec.History {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].EmptyLayer {
+			continue
+		}
+		if history[i].Comment == bootstrapHistoryComment {
+			return append(history[:i:i], history[i+1:]...)
+		}
+		break
+	}
+	return history
+}
+
+// Keep one history item for the flattened layer, items of empty layers are kept as they are.
+func flattenHistory(history []ocispec.History) []ocispec.History {
+	flattened := make([]ocispec.History, 0, len(history)+1)
+	for _, h := range history {
+		if h.EmptyLayer {
+			flattened = append(flattened, h)
+		}
+	}
+	return append(flattened, ocispec.History{
+		CreatedBy: "nydus-snapshotter export",
+		Comment:   "Layers flattened from the nydus image",
+	})
+}
+
+// Find the RAFS instance of meta layer `snapshotID`, or any instance of `imageRef` if no snapshot is given.
+func findRafs(snapshotID, imageRef string) *racache.Rafs {
+	if snapshotID != "" {
+		return racache.RafsGlobalCache.Get(snapshotID)
+	}
+	for _, r := range racache.RafsGlobalCache.List() {
+		if r.ImageID == imageRef {
+			return r
+		}
+	}
+	return nil
+}
+
+// Dump storage backend in the form accepted by `nydus-image unpack`.
+func writeBackendConfig(cfg daemonconfig.DaemonConfig, path string) error {
+	backendType, backendConfig := cfg.StorageBackend()
+	b, err := json.Marshal(map[string]interface{}{
+		"backend": map[string]interface{}{
+			"type":      backendType,
+			backendType: backendConfig,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal backend configuration")
+	}
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return errors.Wrapf(err, "write backend configuration %s", path)
+	}
+	return nil
+}
+
+func exportWorkDir() string {
+	return filepath.Join(config.GetWorkDir(), "export")
+}
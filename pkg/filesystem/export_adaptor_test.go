@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func TestExportHistory(t *testing.T) {
+	history := []ocispec.History{
+		{CreatedBy: "ADD rootfs.tar /"},
+		{CreatedBy: "ENV PATH=/bin", EmptyLayer: true},
+		{CreatedBy: "RUN make"},
+		{CreatedBy: "Nydus Converter", Comment: bootstrapHistoryComment},
+	}
+
+	dropped := dropBootstrapHistory(append([]ocispec.History{}, history...))
+	require.Equal(t, history[:3], dropped)
+	// Only the item of the top layer can be the one of bootstrap layer.
+	require.Equal(t, history[:3], dropBootstrapHistory(history[:3]))
+
+	flattened := flattenHistory(history)
+	require.Len(t, flattened, 2)
+	require.Equal(t, history[1], flattened[0])
+	require.False(t, flattened[1].EmptyLayer)
+}
+
+func TestExportImageSnapshotID(t *testing.T) {
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{Root: t.TempDir(), DaemonMode: "dedicated"}))
+	_, err := (&Filesystem{}).ExportImage(context.Background(), "../1", "docker.io/library/nginx:latest", io.Discard)
+	require.ErrorIs(t, err, errdefs.ErrInvalidArgument)
+}
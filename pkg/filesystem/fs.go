@@ -14,12 +14,16 @@ import (
 	"context"
 	"os"
 	"path"
+	"sync"
+	"time"
 
 	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
 	"github.com/mohae/deepcopy"
 	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
@@ -28,16 +32,24 @@ import (
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/conversion"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/detach"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/gc"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/mirror"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
 	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/signature"
 	"github.com/containerd/nydus-snapshotter/pkg/stargz"
 	"github.com/containerd/nydus-snapshotter/pkg/tarfs"
+	"github.com/containerd/nydus-snapshotter/pkg/tracing"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/erofs"
 )
 
 type Filesystem struct {
@@ -48,9 +60,27 @@ type Filesystem struct {
 	referrerMgr          *referrer.Manager
 	stargzResolver       *stargz.Resolver
 	tarfsMgr             *tarfs.Manager
+	conversionMgr        *conversion.Manager
+	mirror               *mirror.Mirror
+	detacher             *detach.Detacher
+	adaptivePrefetch     *prefetch.AdaptivePolicy
+	prefetchSamplePeriod time.Duration
 	verifier             *signature.Verifier
+	coldStartMetrics     bool
 	nydusImageBinaryPath string
 	rootMountpoint       string
+	// Images of checkpoints being restored, whose blob cache is warmed up on mount
+	warmupMutex  sync.Mutex
+	warmupImages map[string]bool
+	// Work directories in use, not to be swept
+	workTracker gc.Tracker
+	// Fetches of lazy bootstraps in progress, indexed by bootstrap path
+	bootstrapFetches singleflight.Group
+	// Serializes lookup and creation of daemons serving pod sandboxes
+	sandboxMutex sync.Mutex
+	// Pollers of first reads of cold started containers, indexed by daemon ID
+	firstReadMutex   sync.Mutex
+	firstReadPollers map[string]*firstReadPoller
 }
 
 // NewFileSystem initialize Filesystem instance
@@ -78,14 +108,14 @@ func NewFileSystem(ctx context.Context, opt ...NewFSOpt) (*Filesystem, error) {
 	for _, daemon := range liveDaemons {
 		if daemon.States.FsDriver == config.FsDriverFscache {
 			hasFscacheSharedDaemon = true
-		} else if daemon.States.FsDriver == config.FsDriverFusedev && daemon.IsSharedDaemon() {
+		} else if daemon.States.FsDriver == config.FsDriverFusedev && daemon.IsSharedDaemon() && daemon.States.SandboxID == "" {
 			hasFusedevSharedDaemon = true
 		}
 	}
 	for _, daemon := range recoveringDaemons {
 		if daemon.States.FsDriver == config.FsDriverFscache {
 			hasFscacheSharedDaemon = true
-		} else if daemon.States.FsDriver == config.FsDriverFusedev && daemon.IsSharedDaemon() {
+		} else if daemon.States.FsDriver == config.FsDriverFusedev && daemon.IsSharedDaemon() && daemon.States.SandboxID == "" {
 			hasFusedevSharedDaemon = true
 		}
 	}
@@ -164,9 +194,38 @@ func NewFileSystem(ctx context.Context, opt ...NewFSOpt) (*Filesystem, error) {
 		fs.TryRetainSharedDaemon(d)
 	}
 
+	fs.cleanupStaleResources()
+	fs.pinMountedBlobCaches()
+	fs.resumeDetach()
+
+	if fs.adaptivePrefetch != nil {
+		go fs.runAdaptivePrefetch(fs.prefetchSamplePeriod)
+	}
+	go fs.runPrefetchProgress(prefetchProgressPeriod)
+
 	return &fs, nil
 }
 
+// Remove sockets, pid files and empty directories left by daemons
+// that are not managed by any enabled manager anymore.
+func (fs *Filesystem) cleanupStaleResources() {
+	roots := []string{config.GetSocketRoot(), config.GetConfigRoot()}
+	for _, fsManager := range fs.enabledManagers {
+		if fsManager.SupervisorSet != nil {
+			roots = append(roots, fsManager.SupervisorSet.Root())
+		}
+	}
+
+	manager.CleanupStaleResources(func(id string) bool {
+		for _, fsManager := range fs.enabledManagers {
+			if d := fsManager.GetByDaemonID(id); d != nil {
+				return true
+			}
+		}
+		return false
+	}, roots...)
+}
+
 func (fs *Filesystem) TryRetainSharedDaemon(d *daemon.Daemon) {
 	if d.States.FsDriver == config.FsDriverFscache {
 		if fs.fscacheSharedDaemon == nil {
@@ -240,6 +299,10 @@ func (fs *Filesystem) WaitUntilReady(snapshotID string) error {
 // this method will fork nydus daemon and manage it in the internal store, and indexed by snapshotID
 // It must set up all necessary resources during Mount procedure and revoke any step if necessary.
 func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[string]string, s *storage.Snapshot) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "Mount")
+	span.SetAttributes(attribute.String("snapshot.id", snapshotID))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	rafs := racache.RafsGlobalCache.Get(snapshotID)
 	if rafs != nil {
 		// Instance already exists, how could this happen? Can containerd handle this case?
@@ -268,10 +331,15 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 	if err != nil {
 		return errors.Wrapf(err, "create rafs instance %s", snapshotID)
 	}
+	if bootstrap, ok := labels[label.NydusLocalConversion]; ok {
+		rafs.AddAnnotation(racache.AnnoBootstrapPath, bootstrap)
+	}
 
 	defer func() {
 		if err != nil {
 			racache.RafsGlobalCache.Remove(snapshotID)
+			fs.releaseSharedBlobs(rafs)
+			fs.unpinBlobCaches(rafs)
 		}
 	}()
 
@@ -282,16 +350,37 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 
 	var d *daemon.Daemon
 	if fsDriver == config.FsDriverFscache || fsDriver == config.FsDriverFusedev {
+		if label.IsNydusLazyBootstrap(labels) {
+			if err := fs.fetchLazyBootstrap(ctx, rafs.GetSnapshotDir(), labels); err != nil {
+				return errors.Wrapf(err, "fetch bootstrap for snapshot %s", snapshotID)
+			}
+		}
 		bootstrap, err := rafs.BootstrapFile()
 		if err != nil {
 			return errors.Wrapf(err, "find bootstrap file snapshot %s", snapshotID)
 		}
+		fs.pinBlobCaches(rafs, bootstrap)
 
 		if useSharedDaemon {
+			if hasFuseSessionLabels(labels) {
+				log.L.Warnf("FUSE session labels of snapshot %s are ignored by shared daemon", snapshotID)
+			}
 			d, err = fs.getSharedDaemon(fsDriver)
 			if err != nil {
 				return err
 			}
+		} else if sandboxID := labels[label.NydusSandboxID]; sandboxID != "" && fsDriver == config.FsDriverFusedev &&
+			config.IsSandboxSharingEnabled() {
+			if hasFuseSessionLabels(labels) {
+				log.L.Warnf("FUSE session labels of snapshot %s are ignored by daemon of sandbox %s", snapshotID, sandboxID)
+			}
+			d, err = fs.getSandboxDaemon(fsManager, sandboxID)
+			if err != nil {
+				return err
+			}
+			defer fs.releaseSandboxDaemon(fsManager, d)
+			// The sandbox daemon mounts images like the shared one does.
+			useSharedDaemon = true
 		} else {
 			mp, err := fs.decideDaemonMountpoint(fsDriver, false, rafs)
 			if err != nil {
@@ -327,6 +416,31 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 		if err != nil {
 			return errors.Wrap(err, "supplement configuration")
 		}
+		if label.IsNydusLocalConversion(labels) {
+			if !fs.LocalConversionEnabled() {
+				return errors.Errorf("local conversion is disabled for snapshot %s", snapshotID)
+			}
+			if err := daemonconfig.UseLocalfsBackend(cfg, fs.conversionMgr.BlobDir()); err != nil {
+				return errors.Wrap(err, "use locally converted blobs")
+			}
+		} else if err := fs.useSharedBlobs(cfg, rafs, bootstrap); err != nil {
+			return errors.Wrapf(err, "read shared blobs for snapshot %s", snapshotID)
+		}
+		if err := fs.tunePrefetch(cfg, imageID); err != nil {
+			return errors.Wrap(err, "tune prefetch")
+		}
+		if fsDriver == config.FsDriverFusedev {
+			sessionLabels := labels
+			if useSharedDaemon {
+				sessionLabels = nil
+			}
+			if err := tuneFuseSession(cfg, sessionLabels); err != nil {
+				return errors.Wrapf(err, "tune FUSE session for snapshot %s", snapshotID)
+			}
+		}
+		if err := fs.warmupForRestore(cfg, imageID); err != nil {
+			return errors.Wrapf(err, "warm up image %s", imageID)
+		}
 		if errs := fsManager.AddSupplementInfo(supplementInfo); errs != nil {
 			return errors.Wrapf(err, "AddSupplementInfo failed %s", d.States.ID)
 		}
@@ -344,12 +458,12 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 
 	switch fsDriver {
 	case config.FsDriverFscache:
-		err = fs.mountRemote(fsManager, useSharedDaemon, d, rafs)
+		err = fs.mountRemote(ctx, fsManager, useSharedDaemon, d, rafs)
 		if err != nil {
 			err = errors.Wrapf(err, "mount file system by daemon %s, snapshot %s", d.ID(), snapshotID)
 		}
 	case config.FsDriverFusedev:
-		err = fs.mountRemote(fsManager, useSharedDaemon, d, rafs)
+		err = fs.mountRemote(ctx, fsManager, useSharedDaemon, d, rafs)
 		if err != nil {
 			err = errors.Wrapf(err, "mount file system by daemon %s, snapshot %s", d.ID(), snapshotID)
 		}
@@ -384,10 +498,26 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 		return err
 	}
 
+	fs.scheduleDetach(rafs, labels)
+	fs.mirrorSharedBlobs(rafs)
+
+	log.G(ctx).Infof("mounted snapshot %s of image %s by daemon %s", snapshotID, imageID, rafs.DaemonID)
+	events.Publish(events.TopicMount, map[string]string{
+		"action":      "mount",
+		"snapshot_id": snapshotID,
+		"image_id":    imageID,
+		"daemon_id":   rafs.DaemonID,
+		"trace_id":    tracing.TraceID(ctx),
+	})
+
 	return nil
 }
 
-func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
+func (fs *Filesystem) Umount(ctx context.Context, snapshotID string) (err error) {
+	_, span := tracing.StartSpan(ctx, "Umount")
+	span.SetAttributes(attribute.String("snapshot.id", snapshotID))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	rafs := racache.RafsGlobalCache.Get(snapshotID)
 	if rafs == nil {
 		log.L.Debugf("no RAFS filesystem instance associated with snapshot %s", snapshotID)
@@ -411,6 +541,8 @@ func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
 			return errors.Wrapf(err, "get daemon with ID %s for snapshot %s", rafs.DaemonID, snapshotID)
 		}
 
+		fs.releaseDetach(snapshotID)
+		defer fs.releaseSharedBlobs(rafs)
 		daemon.RemoveRafsInstance(snapshotID)
 		if err := fsManager.RemoveRafsInstance(snapshotID); err != nil {
 			return errors.Wrapf(err, "remove snapshot %s", snapshotID)
@@ -418,11 +550,10 @@ func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
 		if err := daemon.UmountRafsInstance(rafs); err != nil {
 			return errors.Wrapf(err, "umount instance %s", snapshotID)
 		}
+		fs.unpinBlobCaches(rafs)
 		// Once daemon's reference reaches 0, destroy the whole daemon
-		if daemon.GetRef() == 0 {
-			if err := fsManager.DestroyDaemon(daemon); err != nil {
-				return errors.Wrapf(err, "destroy daemon %s", daemon.ID())
-			}
+		if err := fs.destroyIdleDaemon(fsManager, daemon); err != nil {
+			return errors.Wrapf(err, "destroy daemon %s", daemon.ID())
 		}
 	case config.FsDriverBlockdev:
 		if err := fs.tarfsMgr.UmountTarErofs(snapshotID); err != nil {
@@ -437,6 +568,13 @@ func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
 		return errors.Errorf("unknown filesystem driver %s for snapshot %s", fsDriver, snapshotID)
 	}
 
+	events.Publish(events.TopicMount, map[string]string{
+		"action":      "umount",
+		"snapshot_id": snapshotID,
+		"image_id":    rafs.ImageID,
+		"daemon_id":   rafs.DaemonID,
+	})
+
 	return nil
 }
 
@@ -453,6 +591,14 @@ func (fs *Filesystem) CacheUsage(ctx context.Context, blobDigest string) (snapsh
 	return fs.cacheMgr.CacheUsage(ctx, blobID)
 }
 
+// BlobHolders returns holders of the blob in the shared blob store, if enabled.
+func (fs *Filesystem) BlobHolders(blobID string) ([]string, error) {
+	if fs.cacheMgr == nil || fs.cacheMgr.BlobStore() == nil {
+		return nil, nil
+	}
+	return fs.cacheMgr.BlobStore().Holders(blobID)
+}
+
 func (fs *Filesystem) RemoveCache(blobDigest string) error {
 	log.L.Infof("remove cache %s", blobDigest)
 	digest := digest.Digest(blobDigest)
@@ -462,6 +608,19 @@ func (fs *Filesystem) RemoveCache(blobDigest string) error {
 	}
 	blobID := digest.Hex()
 
+	// Layers may be shared by images kept by the retention policy, whose snapshots
+	// are still there. Nothing is removed if retained blobs are unknown.
+	if fs.cacheMgr != nil {
+		retained, err := fs.cacheMgr.IsRetained(context.Background(), blobID)
+		if err != nil {
+			return err
+		}
+		if retained {
+			log.L.Infof("keep cache %s retained by retention policy", blobDigest)
+			return nil
+		}
+	}
+
 	if fscacheManager, ok := fs.enabledManagers[config.FsDriverFscache]; ok {
 		if fscacheManager != nil {
 			c, err := fs.fscacheSharedDaemon.GetClient()
@@ -473,6 +632,7 @@ func (fs *Filesystem) RemoveCache(blobDigest string) error {
 			if err := c.UnbindBlob("", blobID); err != nil {
 				return err
 			}
+			events.Publish(events.TopicGC, map[string]string{"action": "remove_cache", "blob_id": blobID})
 			return nil
 		}
 	}
@@ -520,8 +680,25 @@ func (fs *Filesystem) BootstrapFile(id string) (string, error) {
 
 // daemon mountpoint to rafs mountpoint
 // calculate rafs mountpoint for snapshots mount slice.
-func (fs *Filesystem) mountRemote(fsManager *manager.Manager, useSharedDaemon bool,
-	d *daemon.Daemon, r *racache.Rafs) error {
+func (fs *Filesystem) mountRemote(ctx context.Context, fsManager *manager.Manager, useSharedDaemon bool,
+	d *daemon.Daemon, r *racache.Rafs) (err error) {
+	// Covers the daemon calls, i.e. starting a dedicated daemon or mounting by the API.
+	// Nydusd takes no trace context, so its logs are found by the daemon and instance IDs.
+	ctx, span := tracing.StartSpan(ctx, "MountByDaemon")
+	instanceID := "/"
+	if useSharedDaemon {
+		instanceID = r.RelaMountpoint()
+		if fsManager.FsDriver == config.FsDriverFscache {
+			instanceID = erofs.FscacheID(r.SnapshotID)
+		}
+	}
+	span.SetAttributes(attribute.String("daemon.id", d.ID()), attribute.String("nydusd.instance_id", instanceID))
+	defer func() { tracing.EndSpan(span, err) }()
+	log.G(ctx).WithFields(log.Fields{
+		"trace_id":    tracing.TraceID(ctx),
+		"daemon_id":   d.ID(),
+		"instance_id": instanceID,
+	}).Infof("mount snapshot %s by nydusd", r.SnapshotID)
 
 	if useSharedDaemon {
 		if fsManager.FsDriver == config.FsDriverFusedev {
@@ -611,7 +788,7 @@ func (fs *Filesystem) initSharedDaemon(fsManager *manager.Manager) (err error) {
 
 // createDaemon create new nydus daemon by snapshotID and imageID
 func (fs *Filesystem) createDaemon(fsManager *manager.Manager, daemonMode config.DaemonMode,
-	mountpoint string, ref int32) (d *daemon.Daemon, err error) {
+	mountpoint string, ref int32, extraOpts ...daemon.NewDaemonOpt) (d *daemon.Daemon, err error) {
 	opts := []daemon.NewDaemonOpt{
 		daemon.WithRef(ref),
 		daemon.WithSocketDir(config.GetSocketRoot()),
@@ -624,6 +801,7 @@ func (fs *Filesystem) createDaemon(fsManager *manager.Manager, daemonMode config
 		daemon.WithFsDriver(fsManager.FsDriver),
 		daemon.WithDaemonMode(daemonMode),
 	}
+	opts = append(opts, extraOpts...)
 
 	// For fscache driver, no need to provide mountpoint to nydusd daemon.
 	if mountpoint != "" {
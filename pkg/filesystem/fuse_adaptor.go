@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/parser"
+)
+
+// Decide the FUSE session of nydusd from the snapshotter configuration, overridden by
+// `labels` of the meta layer if any. Workloads of many tiny files prefer more background
+// requests, while large sequential reads benefit from bigger readahead.
+func fuseSession(labels map[string]string) (daemonconfig.FuseSession, error) {
+	cfg := config.GetFuseSessionConfig()
+	s := daemonconfig.FuseSession{
+		MaxBackground:       cfg.MaxBackground,
+		CongestionThreshold: cfg.CongestionThreshold,
+		WritebackCache:      cfg.WritebackCache,
+	}
+	readaheadSize := cfg.ReadaheadSize
+
+	for key, v := range map[string]*int{
+		label.NydusFuseMaxBackground:       &s.MaxBackground,
+		label.NydusFuseCongestionThreshold: &s.CongestionThreshold,
+	} {
+		if value, ok := labels[key]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return s, errors.Errorf("invalid label %s=%q", key, value)
+			}
+			*v = n
+		}
+	}
+	if value, ok := labels[label.NydusFuseWritebackCache]; ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return s, errors.Errorf("invalid label %s=%q", label.NydusFuseWritebackCache, value)
+		}
+		s.WritebackCache = b
+	}
+	if value, ok := labels[label.NydusFuseReadaheadSize]; ok {
+		readaheadSize = value
+	}
+
+	if readaheadSize != "" {
+		size, err := parser.MemoryConfigToBytes(readaheadSize, 0)
+		if err != nil || size < 0 {
+			return s, errors.Errorf("invalid FUSE readahead size %q", readaheadSize)
+		}
+		s.ReadaheadSize = size
+	}
+
+	return s, nil
+}
+
+// Pass the FUSE session to nydusd in the configuration of the mount, which keeps the
+// `fuse` section of the nydusd configuration template if no parameter is set.
+func tuneFuseSession(cfg daemonconfig.DaemonConfig, labels map[string]string) error {
+	s, err := fuseSession(labels)
+	if err != nil {
+		return err
+	}
+	if s == (daemonconfig.FuseSession{}) {
+		return nil
+	}
+	return daemonconfig.TuneFuseSession(cfg, s)
+}
+
+func hasFuseSessionLabels(labels map[string]string) bool {
+	for _, key := range []string{label.NydusFuseMaxBackground, label.NydusFuseCongestionThreshold,
+		label.NydusFuseWritebackCache, label.NydusFuseReadaheadSize} {
+		if _, ok := labels[key]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func TestFuseSession(t *testing.T) {
+	cfg := config.SnapshotterConfig{Root: t.TempDir(), DaemonMode: "dedicated"}
+	cfg.DaemonConfig.Fuse = config.FuseSessionConfig{MaxBackground: 64, ReadaheadSize: "128Ki"}
+	require.NoError(t, config.ProcessConfigurations(&cfg))
+
+	s, err := fuseSession(nil)
+	require.NoError(t, err)
+	require.Equal(t, daemonconfig.FuseSession{MaxBackground: 64, ReadaheadSize: 128 << 10}, s)
+
+	labels := map[string]string{
+		label.NydusFuseCongestionThreshold: "48",
+		label.NydusFuseWritebackCache:      "true",
+		label.NydusFuseReadaheadSize:       "1Mi",
+	}
+	require.True(t, hasFuseSessionLabels(labels))
+	s, err = fuseSession(labels)
+	require.NoError(t, err)
+	require.Equal(t, daemonconfig.FuseSession{
+		MaxBackground:       64,
+		CongestionThreshold: 48,
+		WritebackCache:      true,
+		ReadaheadSize:       1 << 20,
+	}, s)
+
+	_, err = fuseSession(map[string]string{label.NydusFuseMaxBackground: "-1"})
+	require.Error(t, err)
+	_, err = fuseSession(map[string]string{label.NydusFuseWritebackCache: "maybe"})
+	require.Error(t, err)
+
+	// The session is passed in the nydusd configuration, not on its command line.
+	daemonCfg := &daemonconfig.FuseDaemonConfig{Device: &daemonconfig.DeviceConfig{}}
+	require.NoError(t, tuneFuseSession(daemonCfg, labels))
+	dumped, err := daemonCfg.DumpString()
+	require.NoError(t, err)
+	require.Contains(t, dumped, `"fuse":{"max_background":64,"congestion_threshold":48,"writeback_cache":true,"readahead_size":1048576}`)
+}
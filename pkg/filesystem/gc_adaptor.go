@@ -0,0 +1,36 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Sweep removes work directories left by interrupted exports, and artifacts of
+// local conversion for layers not in `inUseLayers`, which are digests of OCI
+// layers still having snapshots.
+func (fs *Filesystem) Sweep(ctx context.Context, inUseLayers map[digest.Digest]struct{}) error {
+	removed, err := fs.workTracker.Sweep(exportWorkDir(), nil)
+	if err != nil {
+		return errors.Wrap(err, "sweep export work directories")
+	}
+	if len(removed) > 0 {
+		log.G(ctx).Infof("removed stale export work directories %v", removed)
+	}
+
+	if fs.conversionMgr != nil {
+		if err := fs.conversionMgr.Sweep(inUseLayers); err != nil {
+			return errors.Wrap(err, "sweep local conversion")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// mirrorSharedBlobs copies blobs of the image of RAFS instance `r` which are in the
+// shared blob store into the write-through mirror registry in background, if one is
+// configured. Blobs are mirrored only once downloaded completely, e.g. by download
+// detach, so mirroring costs no traffic to upstream.
+func (fs *Filesystem) mirrorSharedBlobs(r *racache.Rafs) {
+	bs := fs.sharedBlobStore()
+	if fs.mirror == nil || bs == nil {
+		return
+	}
+	// Locally converted blobs are not in the upstream registry.
+	if _, converted := r.Annotations[racache.AnnoBootstrapPath]; converted {
+		return
+	}
+	bootstrap, err := r.BootstrapFile()
+	if err != nil {
+		log.L.WithError(err).Warnf("skip mirroring blobs of snapshot %s", r.SnapshotID)
+		return
+	}
+	b, err := layout.ReadBootstrap(bootstrap)
+	if err != nil {
+		log.L.WithError(err).Warnf("skip mirroring blobs of snapshot %s", r.SnapshotID)
+		return
+	}
+	for _, blob := range b.Blobs() {
+		if bs.Has(blob.ID) {
+			fs.mirror.Copy(r.ImageID, digest.NewDigestFromEncoded(digest.SHA256, blob.ID), filepath.Join(bs.BlobDir(), blob.ID))
+		}
+	}
+}
+
+// WaitMirroring waits until blobs being copied into the write-through mirror are done.
+func (fs *Filesystem) WaitMirroring() {
+	if fs.mirror != nil {
+		fs.mirror.Wait()
+	}
+}
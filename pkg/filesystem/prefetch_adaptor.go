@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const (
+	defaultPrefetchSamplePeriod = time.Minute
+	prefetchProgressPeriod      = 10 * time.Second
+	aggressivePrefetchThreads   = 8
+	throttledPrefetchThreads    = 1
+)
+
+func (fs *Filesystem) runAdaptivePrefetch(period time.Duration) {
+	if period <= 0 {
+		period = defaultPrefetchSamplePeriod
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fs.sampleCacheHitRatio()
+	}
+}
+
+// Call `fn` with blob cache metrics of every RAFS instance served by running FUSE daemons,
+// identified by `source` as the daemon and snapshot IDs.
+func (fs *Filesystem) walkCacheMetrics(fn func(source string, d *daemon.Daemon, i *racache.Rafs, m *types.CacheMetrics)) {
+	for _, fsManager := range fs.enabledManagers {
+		if fsManager.FsDriver != config.FsDriverFusedev {
+			continue
+		}
+
+		for _, d := range fsManager.ListDaemons() {
+			if d.State() != types.DaemonStateRunning {
+				continue
+			}
+
+			for _, i := range d.RafsCache.List() {
+				var sid string
+				if d.IsSharedDaemon() {
+					sid = i.SnapshotID
+				}
+
+				m, err := d.GetCacheMetrics(sid)
+				if err != nil {
+					log.L.WithError(err).Debugf("failed to get cache metrics of snapshot %s", i.SnapshotID)
+					continue
+				}
+				fn(d.ID()+"/"+i.SnapshotID, d, i, m)
+			}
+		}
+	}
+}
+
+// Feed blob cache metrics of all serving RAFS instances to the adaptive prefetch policy.
+func (fs *Filesystem) sampleCacheHitRatio() {
+	alive := make(map[string]bool)
+	fs.walkCacheMetrics(func(source string, _ *daemon.Daemon, i *racache.Rafs, m *types.CacheMetrics) {
+		alive[source] = true
+		fs.adaptivePrefetch.Record(i.ImageID, source, m.PartialHits+m.WholeHits, m.Total)
+	})
+	fs.adaptivePrefetch.Retain(alive)
+}
+
+// Publish prefetch progress of RAFS instances from blob cache metrics, only while anyone
+// subscribes to it, as sampling queries every nydusd.
+func (fs *Filesystem) runPrefetchProgress(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	published := make(map[string]types.CacheMetrics)
+	for range ticker.C {
+		if !events.Subscribed(events.TopicPrefetch) {
+			clear(published)
+			continue
+		}
+		fs.publishPrefetchProgress(published)
+	}
+}
+
+// Instances are published when their progress changed since `published`, which is
+// updated, e.g. once more data is prefetched or prefetch is done.
+func (fs *Filesystem) publishPrefetchProgress(published map[string]types.CacheMetrics) {
+	alive := make(map[string]bool)
+	fs.walkCacheMetrics(func(source string, d *daemon.Daemon, i *racache.Rafs, m *types.CacheMetrics) {
+		alive[source] = true
+		attributes, changed := prefetchProgress(published[source], *m)
+		if !changed {
+			return
+		}
+		published[source] = *m
+		attributes["snapshot_id"] = i.SnapshotID
+		attributes["image_id"] = i.ImageID
+		attributes["daemon_id"] = d.ID()
+		events.Publish(events.TopicPrefetch, attributes)
+	})
+	for source := range published {
+		if !alive[source] {
+			delete(published, source)
+		}
+	}
+}
+
+func prefetchProgress(last, m types.CacheMetrics) (map[string]string, bool) {
+	if m.PrefetchBeginTimeSecs == 0 ||
+		(m.PrefetchDataAmount == last.PrefetchDataAmount && m.PrefetchEndTimeSecs == last.PrefetchEndTimeSecs) {
+		return nil, false
+	}
+	state := "running"
+	if m.PrefetchEndTimeSecs >= m.PrefetchBeginTimeSecs {
+		state = "done"
+	}
+	return map[string]string{
+		"state":          state,
+		"data_amount":    strconv.FormatUint(m.PrefetchDataAmount, 10),
+		"requests":       strconv.FormatUint(m.PrefetchRequestsCount, 10),
+		"elapsed_millis": strconv.FormatUint(m.PrefetchCumulativeTimeMillis, 10),
+	}, true
+}
+
+// Adjust prefetch in the daemon configuration of the RAFS instance to be mounted for `imageID`.
+func (fs *Filesystem) tunePrefetch(cfg daemonconfig.DaemonConfig, imageID string) error {
+	if fs.adaptivePrefetch == nil {
+		return nil
+	}
+
+	level := fs.adaptivePrefetch.Level(imageID)
+	switch level {
+	case prefetch.LevelAggressive:
+		if err := daemonconfig.TunePrefetch(cfg, true, aggressivePrefetchThreads); err != nil {
+			return err
+		}
+	case prefetch.LevelThrottled:
+		if err := daemonconfig.TunePrefetch(cfg, false, throttledPrefetchThreads); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	ratio, total := fs.adaptivePrefetch.HitRatio(imageID)
+	log.L.Infof("%s prefetch for image %s, cache hit ratio %.2f of %d requests", level, imageID, ratio, total)
+	return nil
+}
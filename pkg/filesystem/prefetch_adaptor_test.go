@@ -0,0 +1,37 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+func TestPrefetchProgress(t *testing.T) {
+	// Prefetch not started.
+	_, changed := prefetchProgress(types.CacheMetrics{}, types.CacheMetrics{})
+	require.False(t, changed)
+
+	running := types.CacheMetrics{PrefetchBeginTimeSecs: 100, PrefetchDataAmount: 4096, PrefetchRequestsCount: 2}
+	attributes, changed := prefetchProgress(types.CacheMetrics{}, running)
+	require.True(t, changed)
+	require.Equal(t, "running", attributes["state"])
+	require.Equal(t, "4096", attributes["data_amount"])
+	require.Equal(t, "2", attributes["requests"])
+
+	_, changed = prefetchProgress(running, running)
+	require.False(t, changed)
+
+	done := running
+	done.PrefetchEndTimeSecs = 102
+	attributes, changed = prefetchProgress(running, done)
+	require.True(t, changed)
+	require.Equal(t, "done", attributes["state"])
+}
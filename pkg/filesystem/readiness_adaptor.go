@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const defaultReadinessTimeout = 10 * time.Second
+
+type readinessPolicy struct {
+	files     []string
+	threshold float64
+	timeout   time.Duration
+}
+
+// Parse the readiness policy of an image from labels of its meta layer, nil if the
+// image has no critical files.
+func parseReadinessPolicy(labels map[string]string) (*readinessPolicy, error) {
+	var files []string
+	for _, f := range strings.Split(labels[label.NydusReadinessFiles], ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	policy := readinessPolicy{files: files, threshold: 1, timeout: defaultReadinessTimeout}
+	if v, ok := labels[label.NydusReadinessThreshold]; ok {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "readiness threshold %q", v)
+		}
+		policy.threshold = threshold
+	}
+	if v, ok := labels[label.NydusReadinessTimeout]; ok {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "readiness timeout %q", v)
+		}
+		policy.timeout = timeout
+	}
+	return &policy, nil
+}
+
+// WaitUntilWarm blocks until critical files of the image mounted for snapshot
+// `snapshotID` are cached as its readiness policy requires. Containers start anyway
+// once the policy times out, reading the rest on demand.
+func (fs *Filesystem) WaitUntilWarm(ctx context.Context, snapshotID string, labels map[string]string) error {
+	policy, err := parseReadinessPolicy(labels)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+	rafs := racache.RafsGlobalCache.Get(snapshotID)
+	if rafs == nil || (rafs.GetFsDriver() != config.FsDriverFusedev && rafs.GetFsDriver() != config.FsDriverFscache) {
+		return nil
+	}
+
+	start := time.Now()
+	fraction, err := prefetch.WarmUp(ctx, rafs.GetMountpoint(), policy.files, policy.threshold, policy.timeout)
+	if ctx.Err() != nil {
+		return err
+	}
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("start containers of image %s before it's warm", rafs.ImageID)
+		return nil
+	}
+	log.G(ctx).Infof("%.2f of critical files of image %s cached in %v", fraction, rafs.ImageID, time.Since(start))
+	return nil
+}
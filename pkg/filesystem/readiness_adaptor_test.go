@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func TestParseReadinessPolicy(t *testing.T) {
+	policy, err := parseReadinessPolicy(map[string]string{})
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	policy, err = parseReadinessPolicy(map[string]string{label.NydusReadinessFiles: "/bin/app, /etc/app.conf,"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/bin/app", "/etc/app.conf"}, policy.files)
+	require.Equal(t, 1.0, policy.threshold)
+	require.Equal(t, defaultReadinessTimeout, policy.timeout)
+
+	policy, err = parseReadinessPolicy(map[string]string{
+		label.NydusReadinessFiles:     "/bin/app",
+		label.NydusReadinessThreshold: "0.8",
+		label.NydusReadinessTimeout:   "3s",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0.8, policy.threshold)
+	require.Equal(t, 3*time.Second, policy.timeout)
+
+	for _, labels := range []map[string]string{
+		{label.NydusReadinessFiles: "/bin/app", label.NydusReadinessThreshold: "1.5"},
+		{label.NydusReadinessFiles: "/bin/app", label.NydusReadinessTimeout: "soon"},
+	} {
+		_, err = parseReadinessPolicy(labels)
+		require.ErrorIs(t, err, errdefs.ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/manager"
+)
+
+// Mountpoints of sandbox daemons reside in the root mountpoint, beside those of
+// snapshots which are named by sequence numbers.
+const sandboxMountpointPrefix = "sandbox-"
+
+// getSandboxDaemon returns the daemon serving images of pod sandbox `sandboxID`, and
+// starts one in shared mode if there is none yet. Like dedicated daemons, it's
+// destroyed once all its RAFS instances are unmounted. The daemon is referenced for
+// the caller until releaseSandboxDaemon, so it's kept while an instance is added.
+func (fs *Filesystem) getSandboxDaemon(fsManager *manager.Manager, sandboxID string) (_ *daemon.Daemon, err error) {
+	fs.sandboxMutex.Lock()
+	defer fs.sandboxMutex.Unlock()
+
+	for _, d := range fsManager.ListDaemons() {
+		if d.States.SandboxID == sandboxID {
+			d.IncRef()
+			return d, nil
+		}
+	}
+
+	if sandboxID == "." || sandboxID == ".." || filepath.Base(sandboxID) != sandboxID {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "sandbox ID %q", sandboxID)
+	}
+	mp := path.Join(fs.rootMountpoint, sandboxMountpointPrefix+sandboxID)
+	if err := os.MkdirAll(mp, 0755); err != nil {
+		return nil, errors.Wrapf(err, "create directory %s", mp)
+	}
+
+	d, err := fs.createDaemon(fsManager, config.DaemonModeShared, mp, 0, daemon.WithSandboxID(sandboxID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "create daemon for sandbox %s", sandboxID)
+	}
+	defer func() {
+		if err != nil {
+			if err := fsManager.DeleteDaemon(d); err != nil {
+				log.L.WithError(err).Warnf("failed to delete daemon %s", d.ID())
+			}
+		}
+	}()
+
+	// The configuration is loaded per instance by the mount API, it's persisted
+	// for recovering the daemon like the shared one.
+	d.Config = *fsManager.DaemonConfig
+	if err := fsManager.StartDaemon(d); err != nil {
+		return nil, errors.Wrapf(err, "start daemon for sandbox %s", sandboxID)
+	}
+	if err := d.WaitUntilState(types.DaemonStateRunning); err != nil {
+		return nil, errors.Wrapf(err, "wait for daemon of sandbox %s", sandboxID)
+	}
+
+	log.L.Infof("started daemon %s for sandbox %s", d.ID(), sandboxID)
+	d.IncRef()
+	return d, nil
+}
+
+// releaseSandboxDaemon drops the reference taken by getSandboxDaemon, destroying
+// the daemon if no RAFS instance was added to it.
+func (fs *Filesystem) releaseSandboxDaemon(fsManager *manager.Manager, d *daemon.Daemon) {
+	fs.sandboxMutex.Lock()
+	defer fs.sandboxMutex.Unlock()
+
+	if d.DecRef() > 0 {
+		return
+	}
+	if err := fsManager.DestroyDaemon(d); err != nil {
+		log.L.WithError(err).Warnf("failed to destroy daemon %s of sandbox %s", d.ID(), d.States.SandboxID)
+	}
+}
+
+// destroyIdleDaemon destroys `d` once it has no RAFS instances. Daemons of sandboxes
+// are checked under sandboxMutex, so they are not handed to mounts being destroyed.
+func (fs *Filesystem) destroyIdleDaemon(fsManager *manager.Manager, d *daemon.Daemon) error {
+	if d.States.SandboxID != "" {
+		fs.sandboxMutex.Lock()
+		defer fs.sandboxMutex.Unlock()
+	}
+
+	if d.GetRef() > 0 {
+		return nil
+	}
+	return fsManager.DestroyDaemon(d)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
+)
+
+func TestGetSandboxDaemon(t *testing.T) {
+	db, err := store.NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	fsManager, err := manager.NewManager(manager.Opt{Database: db, FsDriver: config.FsDriverFusedev})
+	require.NoError(t, err)
+
+	d, err := daemon.NewDaemon(
+		daemon.WithDaemonMode(config.DaemonModeShared),
+		daemon.WithMountpoint(t.TempDir()),
+		daemon.WithSandboxID("pod-1"),
+	)
+	require.NoError(t, err)
+	require.NoError(t, fsManager.AddDaemon(d))
+
+	fs := &Filesystem{rootMountpoint: t.TempDir()}
+	got, err := fs.getSandboxDaemon(fsManager, "pod-1")
+	require.NoError(t, err)
+	require.Same(t, d, got)
+	require.True(t, got.IsSharedDaemon())
+
+	// Referenced for the mount, so an unmount racing with it keeps the daemon.
+	require.Equal(t, int32(1), got.GetRef())
+	require.NoError(t, fs.destroyIdleDaemon(fsManager, got))
+	require.Len(t, fsManager.ListDaemons(), 1)
+
+	for _, id := range []string{"..", "../pod", "a/b"} {
+		_, err := fs.getSandboxDaemon(fsManager, id)
+		require.ErrorIs(t, err, errdefs.ErrInvalidArgument)
+	}
+}
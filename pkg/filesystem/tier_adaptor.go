@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"github.com/containerd/log"
+
+	"github.com/containerd/nydus-snapshotter/pkg/layout"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// Blob caches read by the bootstrap of RAFS instance `r` are not moved between cache
+// tiers while it's mounted, including those of chunk dictionaries. All of them are
+// pinned if its blobs are unknown, e.g. of RAFS v5.
+func (fs *Filesystem) pinBlobCaches(r *racache.Rafs, bootstrap string) {
+	if fs.cacheMgr == nil || !fs.cacheMgr.Tiered() {
+		return
+	}
+	b, err := layout.ReadBootstrap(bootstrap)
+	if err != nil {
+		log.L.WithError(err).Warnf("pin all blob caches for snapshot %s", r.SnapshotID)
+		fs.cacheMgr.PinBlobCaches(r.SnapshotID, nil)
+		return
+	}
+	ids := []string{}
+	for _, blob := range b.Blobs() {
+		ids = append(ids, blob.ID)
+	}
+	fs.cacheMgr.PinBlobCaches(r.SnapshotID, ids)
+}
+
+func (fs *Filesystem) unpinBlobCaches(r *racache.Rafs) {
+	if fs.cacheMgr != nil {
+		fs.cacheMgr.UnpinBlobCaches(r.SnapshotID)
+	}
+}
+
+// Instances recovered on start are mounted already.
+func (fs *Filesystem) pinMountedBlobCaches() {
+	if fs.cacheMgr == nil || !fs.cacheMgr.Tiered() {
+		return
+	}
+	for _, r := range racache.RafsGlobalCache.List() {
+		bootstrap, err := r.BootstrapFile()
+		if err != nil {
+			log.L.WithError(err).Warnf("pin all blob caches for snapshot %s", r.SnapshotID)
+			fs.cacheMgr.PinBlobCaches(r.SnapshotID, nil)
+			continue
+		}
+		fs.pinBlobCaches(r, bootstrap)
+	}
+}
+
+func (fs *Filesystem) StopCacheTiering() {
+	if fs.cacheMgr != nil {
+		fs.cacheMgr.Close()
+	}
+}
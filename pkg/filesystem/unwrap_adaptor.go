@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+// Unwrapped blobs of a data layer are referenced by the holder in the blob store.
+func unwrappedBlobHolder(snapshotID string) string {
+	return "unwrap-" + snapshotID
+}
+
+// UnwrapLayer downloads the nydus data layer of snapshot `snapshotID` described by
+// `labels`, whose blob is wrapped in gzip, and keeps the unwrapped blob in the shared
+// blob store. Registries only have the wrapped layer, while nydusd fetches blobs by
+// digest of the unwrapped ones, so images of such layers are served from the store.
+func (fs *Filesystem) UnwrapLayer(ctx context.Context, snapshotID string, labels map[string]string) error {
+	bs := fs.sharedBlobStore()
+	if bs == nil {
+		return errors.Errorf("shared blob store is required by nydus layers wrapped in gzip")
+	}
+	ref, ok := labels[snpkg.TargetRefLabel]
+	if !ok {
+		return errors.Errorf("not found image reference label")
+	}
+	layerDigest := digest.Digest(labels[snpkg.TargetLayerDigestLabel])
+	if err := layerDigest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid layer digest label")
+	}
+	blobDigest := digest.Digest(labels[label.NydusBlobDigest])
+	if err := blobDigest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid blob digest label")
+	}
+
+	holder := unwrappedBlobHolder(snapshotID)
+	err := bs.Acquire(blobDigest.Encoded(), holder)
+	if err == nil || !errdefs.IsNotFound(err) {
+		return errors.Wrapf(err, "acquire blob %s", blobDigest)
+	}
+
+	keyChain, err := auth.GetKeyChainByRef(ref, labels)
+	if err != nil {
+		return errors.Wrap(err, "create key chain for connection")
+	}
+	// Put the file beside the store, which moves it into the store.
+	f, err := os.CreateTemp(filepath.Dir(bs.BlobDir()), "unwrap-")
+	if err != nil {
+		return errors.Wrap(err, "create file for unwrapping blob")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r := remote.New(keyChain, config.GetSkipSSLVerify())
+	err = fetchUnwrapped(ctx, r, ref, layerDigest, blobDigest, f)
+	if err != nil && r.RetryWithPlainHTTP(ref, err) {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			if err = f.Truncate(0); err == nil {
+				err = fetchUnwrapped(ctx, r, ref, layerDigest, blobDigest, f)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close file %s", f.Name())
+	}
+
+	log.G(ctx).Infof("unwrapped blob %s of layer %s for snapshot %s", blobDigest, layerDigest, snapshotID)
+	return bs.Add(blobDigest.Encoded(), f.Name(), holder)
+}
+
+// Both the wrapped layer and the blob in it are verified against their digests.
+func fetchUnwrapped(ctx context.Context, r *remote.Remote, ref string, layerDigest, blobDigest digest.Digest, w io.Writer) error {
+	fetcher, err := r.Fetcher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	fetcherByDigest, ok := fetcher.(remotes.FetcherByDigest)
+	if !ok {
+		return errors.Errorf("fetcher %T does not implement remotes.FetcherByDigest", fetcher)
+	}
+	rc, _, err := fetcherByDigest.FetchByDigest(ctx, layerDigest)
+	if err != nil {
+		return errors.Wrapf(err, "fetch layer %s", layerDigest)
+	}
+	defer rc.Close()
+
+	layerVerifier := layerDigest.Verifier()
+	layer := io.TeeReader(rc, layerVerifier)
+	gr, err := gzip.NewReader(layer)
+	if err != nil {
+		return errors.Wrapf(err, "read layer %s in gzip", layerDigest)
+	}
+	blobVerifier := blobDigest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(w, blobVerifier), gr); err != nil {
+		return errors.Wrapf(err, "unwrap layer %s", layerDigest)
+	}
+	if _, err := io.Copy(io.Discard, layer); err != nil {
+		return errors.Wrapf(err, "read layer %s", layerDigest)
+	}
+	if !layerVerifier.Verified() {
+		return errors.Errorf("downloaded layer %s is corrupted", layerDigest)
+	}
+	if !blobVerifier.Verified() {
+		return errors.Errorf("blob %s unwrapped from layer %s is corrupted", blobDigest, layerDigest)
+	}
+	return nil
+}
+
+// ReleaseUnwrappedLayer drops the reference of snapshot `snapshotID` to the blob
+// unwrapped from its layer.
+func (fs *Filesystem) ReleaseUnwrappedLayer(snapshotID string) {
+	bs := fs.sharedBlobStore()
+	if bs == nil {
+		return
+	}
+	if err := bs.ReleaseHolder(unwrappedBlobHolder(snapshotID)); err != nil {
+		log.L.WithError(err).Warnf("failed to release unwrapped blob of snapshot %s", snapshotID)
+	}
+}
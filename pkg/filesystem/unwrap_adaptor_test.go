@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func TestUnwrapLayer(t *testing.T) {
+	blob := []byte("nydus blob")
+	var wrapped bytes.Buffer
+	gw := gzip.NewWriter(&wrapped)
+	_, err := gw.Write(blob)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	blobDigest, layerDigest := digest.FromBytes(blob), digest.FromBytes(wrapped.Bytes())
+
+	var fetches int
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/blobs/"+layerDigest.String()) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(wrapped.Len()))
+		if req.Method == http.MethodGet {
+			fetches++
+			w.Write(wrapped.Bytes())
+		}
+	}))
+	defer registry.Close()
+
+	labels := map[string]string{
+		snpkg.TargetRefLabel:         strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest",
+		snpkg.TargetLayerDigestLabel: layerDigest.String(),
+		label.NydusDataLayer:         "true",
+		label.NydusGzipWrapped:       "true",
+		label.NydusBlobDigest:        blobDigest.String(),
+	}
+	ctx := context.TODO()
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{Root: t.TempDir(), DaemonMode: "dedicated"}))
+
+	require.Error(t, (&Filesystem{}).UnwrapLayer(ctx, "1", labels))
+
+	cacheMgr, err := cache.NewManager(cache.Opt{CacheDir: t.TempDir(), SharedBlobStore: true})
+	require.NoError(t, err)
+	bs := cacheMgr.BlobStore()
+	fs := &Filesystem{cacheMgr: cacheMgr}
+
+	require.NoError(t, fs.UnwrapLayer(ctx, "1", labels))
+	data, err := os.ReadFile(bs.BlobDir() + "/" + blobDigest.Encoded())
+	require.NoError(t, err)
+	require.Equal(t, blob, data)
+
+	// Layers of the same blob are not downloaded again.
+	require.NoError(t, fs.UnwrapLayer(ctx, "2", labels))
+	require.Equal(t, 1, fetches)
+
+	fs.ReleaseUnwrappedLayer("1")
+	require.True(t, bs.Has(blobDigest.Encoded()))
+	fs.ReleaseUnwrappedLayer("2")
+	require.False(t, bs.Has(blobDigest.Encoded()))
+
+	// The unwrapped blob must match its digest.
+	labels[label.NydusBlobDigest] = digest.FromString("other").String()
+	require.Error(t, fs.UnwrapLayer(ctx, "3", labels))
+	require.False(t, bs.Has(digest.FromString("other").Encoded()))
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultContainerdAddress = "/run/containerd/containerd.sock"
+	DefaultSnapshotter       = "nydus"
+	// Garbage collection of containerd removes snapshots in a burst, clean up once after it.
+	defaultCleanupDelay = 5 * time.Second
+	resubscribeInterval = 10 * time.Second
+)
+
+var cascadeTopics = []string{
+	`topic=="/images/delete"`,
+	`topic=="/content/delete"`,
+	`topic=="/snapshot/remove"`,
+}
+
+// Cleaner releases resources of the snapshotter once containerd drops what they belong to.
+type Cleaner interface {
+	// ReleaseImages unmounts RAFS instances of images that no container is using, whose
+	// meta layers are not in `referencedLayers`, stopping nydusd left with no instance.
+	ReleaseImages(ctx context.Context, referencedLayers map[digest.Digest]struct{}) error
+	// RemoveLayer removes blob caches of the layer unless a snapshot still references it.
+	RemoveLayer(ctx context.Context, layer digest.Digest) error
+	// Cleanup removes directories, mounts and bootstraps of removed snapshots.
+	Cleanup(ctx context.Context) error
+}
+
+type CascadeOpt struct {
+	ContainerdAddress string
+	// Name of the snapshotter registered in containerd, removals of snapshots by other
+	// snapshotters are ignored.
+	Snapshotter  string
+	CleanupDelay time.Duration
+}
+
+type subscribeFunc func(ctx context.Context) (<-chan *events.Envelope, <-chan error, error)
+
+// Digests of contents of all images in containerd.
+type referencedFunc func(ctx context.Context) (map[digest.Digest]struct{}, error)
+
+// Cascade subscribes to deletions of images, contents and snapshots in containerd and
+// cleans up the associated resources of the snapshotter in response.
+type Cascade struct {
+	opt        CascadeOpt
+	cleaner    Cleaner
+	subscribe  subscribeFunc
+	referenced referencedFunc
+
+	mutex        sync.Mutex
+	cleanupTimer *time.Timer
+	// Deletions since the last cleanup.
+	deletedImages   []string
+	deletedContents []digest.Digest
+}
+
+func NewCascade(opt CascadeOpt, cleaner Cleaner) *Cascade {
+	if opt.ContainerdAddress == "" {
+		opt.ContainerdAddress = DefaultContainerdAddress
+	}
+	if opt.Snapshotter == "" {
+		opt.Snapshotter = DefaultSnapshotter
+	}
+	if opt.CleanupDelay <= 0 {
+		opt.CleanupDelay = defaultCleanupDelay
+	}
+
+	c := &Cascade{opt: opt, cleaner: cleaner}
+	c.subscribe = c.subscribeContainerd
+	c.referenced = c.referencedContents
+	return c
+}
+
+func (c *Cascade) subscribeContainerd(ctx context.Context) (<-chan *events.Envelope, <-chan error, error) {
+	cli, err := client.New(c.opt.ContainerdAddress)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "connect to containerd at %s", c.opt.ContainerdAddress)
+	}
+	envelopes, errs := cli.Subscribe(ctx, cascadeTopics...)
+	go func() {
+		<-ctx.Done()
+		cli.Close()
+	}()
+	return envelopes, errs, nil
+}
+
+// Images may share contents with deleted ones under other names or in other namespaces.
+func (c *Cascade) referencedContents(ctx context.Context) (map[digest.Digest]struct{}, error) {
+	cli, err := client.New(c.opt.ContainerdAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to containerd at %s", c.opt.ContainerdAddress)
+	}
+	defer cli.Close()
+
+	nss, err := cli.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list namespaces")
+	}
+	cs := cli.ContentStore()
+	referenced := make(map[digest.Digest]struct{})
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		referenced[desc.Digest] = struct{}{}
+		children, err := images.Children(ctx, cs, desc)
+		// Manifests of other platforms are not always fetched.
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+		return children, err
+	})
+	for _, ns := range nss {
+		nsCtx := namespaces.WithNamespace(ctx, ns)
+		imgs, err := cli.ImageService().List(nsCtx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list images in namespace %s", ns)
+		}
+		for _, img := range imgs {
+			if err := images.Walk(nsCtx, handler, img.Target); err != nil {
+				return nil, errors.Wrapf(err, "walk image %s in namespace %s", img.Name, ns)
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// Run handles events until `ctx` is done, subscribing again whenever the subscription
+// breaks, e.g. containerd restarts.
+func (c *Cascade) Run(ctx context.Context) {
+	for {
+		if err := c.watch(ctx); err != nil {
+			log.L.WithError(err).Warnf("cascade cleanup lost events of containerd, subscribe again in %v", resubscribeInterval)
+		}
+		select {
+		case <-ctx.Done():
+			c.stopCleanup()
+			return
+		case <-time.After(resubscribeInterval):
+		}
+	}
+}
+
+func (c *Cascade) watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	envelopes, errs, err := c.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return err
+		case env := <-envelopes:
+			if env != nil {
+				c.handle(ctx, env)
+			}
+		}
+	}
+}
+
+func (c *Cascade) handle(ctx context.Context, env *events.Envelope) {
+	if env.Event == nil {
+		return
+	}
+	v, err := typeurl.UnmarshalAny(env.Event)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to decode event of topic %s", env.Topic)
+		return
+	}
+
+	switch e := v.(type) {
+	case *eventstypes.ImageDelete:
+		log.L.Infof("[Cascade] image %s is deleted in namespace %s", e.Name, env.Namespace)
+		c.mutex.Lock()
+		c.deletedImages = append(c.deletedImages, e.Name)
+		c.mutex.Unlock()
+		// Snapshots of the image are removed by garbage collection afterwards.
+		c.scheduleCleanup()
+	case *eventstypes.ContentDelete:
+		c.mutex.Lock()
+		c.deletedContents = append(c.deletedContents, digest.Digest(e.Digest))
+		c.mutex.Unlock()
+		c.scheduleCleanup()
+	case *eventstypes.SnapshotRemove:
+		if e.Snapshotter == c.opt.Snapshotter {
+			c.scheduleCleanup()
+		}
+	}
+}
+
+// scheduleCleanup cleans up once no removal happens within the delay.
+func (c *Cascade) scheduleCleanup() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cleanupTimer != nil {
+		c.cleanupTimer.Reset(c.opt.CleanupDelay)
+		return
+	}
+	c.cleanupTimer = time.AfterFunc(c.opt.CleanupDelay, func() {
+		c.cleanup(context.Background())
+	})
+}
+
+// cleanup releases images and removes layers deleted since the last cleanup, unless
+// images left in containerd still reference them.
+func (c *Cascade) cleanup(ctx context.Context) {
+	c.mutex.Lock()
+	deletedImages, deletedContents := c.deletedImages, c.deletedContents
+	c.deletedImages, c.deletedContents = nil, nil
+	c.mutex.Unlock()
+
+	if len(deletedImages) > 0 || len(deletedContents) > 0 {
+		// Nothing is released if references are unknown.
+		referenced, err := c.referenced(ctx)
+		if err != nil {
+			log.L.WithError(err).Warnf("skip releasing deleted images %v and %d contents", deletedImages, len(deletedContents))
+		} else {
+			if len(deletedImages) > 0 {
+				if err := c.cleaner.ReleaseImages(ctx, referenced); err != nil {
+					log.L.WithError(err).Warnf("failed to release deleted images %v", deletedImages)
+				}
+			}
+			for _, d := range deletedContents {
+				if _, ok := referenced[d]; ok {
+					continue
+				}
+				if err := c.cleaner.RemoveLayer(ctx, d); err != nil {
+					log.L.WithError(err).Warnf("failed to remove caches of layer %s", d)
+				}
+			}
+		}
+	}
+
+	if err := c.cleaner.Cleanup(ctx); err != nil {
+		log.L.WithError(err).Warn("failed to clean up after removals in containerd")
+	}
+}
+
+func (c *Cascade) stopCleanup() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cleanupTimer != nil {
+		c.cleanupTimer.Stop()
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCleaner struct {
+	mutex    sync.Mutex
+	releases []map[digest.Digest]struct{}
+	layers   []digest.Digest
+	cleanups int
+}
+
+func (f *fakeCleaner) ReleaseImages(_ context.Context, referencedLayers map[digest.Digest]struct{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.releases = append(f.releases, referencedLayers)
+	return nil
+}
+
+func (f *fakeCleaner) RemoveLayer(_ context.Context, layer digest.Digest) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.layers = append(f.layers, layer)
+	return nil
+}
+
+func (f *fakeCleaner) Cleanup(_ context.Context) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cleanups++
+	return nil
+}
+
+func (f *fakeCleaner) cleanupCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.cleanups
+}
+
+func TestCascade(t *testing.T) {
+	cleaner := &fakeCleaner{}
+	c := NewCascade(CascadeOpt{CleanupDelay: 50 * time.Millisecond}, cleaner)
+
+	envelopes := make(chan *events.Envelope)
+	subscribed := make(chan struct{}, 2)
+	c.subscribe = func(_ context.Context) (<-chan *events.Envelope, <-chan error, error) {
+		subscribed <- struct{}{}
+		return envelopes, make(chan error), nil
+	}
+	shared := digest.FromString("shared")
+	referenced := map[digest.Digest]struct{}{shared: {}}
+	c.referenced = func(context.Context) (map[digest.Digest]struct{}, error) {
+		return referenced, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	<-subscribed
+
+	send := func(topic string, event interface{}) {
+		any, err := typeurl.MarshalAny(event)
+		require.NoError(t, err)
+		envelopes <- &events.Envelope{Namespace: "k8s.io", Topic: topic, Event: any}
+	}
+	layer := digest.FromString("layer")
+	send("/images/delete", &eventstypes.ImageDelete{Name: "docker.io/library/nginx:latest"})
+	send("/content/delete", &eventstypes.ContentDelete{Digest: layer.String()})
+	// Layers of images left are kept.
+	send("/content/delete", &eventstypes.ContentDelete{Digest: shared.String()})
+	send("/snapshot/remove", &eventstypes.SnapshotRemove{Key: "k1", Snapshotter: "overlayfs"})
+	send("/snapshot/remove", &eventstypes.SnapshotRemove{Key: "k2", Snapshotter: "nydus"})
+	send("/snapshot/remove", &eventstypes.SnapshotRemove{Key: "k3", Snapshotter: "nydus"})
+
+	// Removals in a burst are cleaned up once.
+	require.Eventually(t, func() bool { return cleaner.cleanupCount() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 1, cleaner.cleanupCount())
+
+	cleaner.mutex.Lock()
+	require.Equal(t, []map[digest.Digest]struct{}{referenced}, cleaner.releases)
+	require.Equal(t, []digest.Digest{layer}, cleaner.layers)
+	cleaner.mutex.Unlock()
+
+	// Removals by other snapshotters are ignored.
+	send("/snapshot/remove", &eventstypes.SnapshotRemove{Key: "k4", Snapshotter: "overlayfs"})
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 1, cleaner.cleanupCount())
+
+	// Nothing is released or removed if references are unknown.
+	c.referenced = func(context.Context) (map[digest.Digest]struct{}, error) {
+		return nil, errors.New("containerd is unavailable")
+	}
+	send("/images/delete", &eventstypes.ImageDelete{Name: "docker.io/library/redis:latest"})
+	send("/content/delete", &eventstypes.ContentDelete{Digest: digest.FromString("other").String()})
+	require.Eventually(t, func() bool { return cleaner.cleanupCount() == 2 }, time.Second, 10*time.Millisecond)
+	cleaner.mutex.Lock()
+	require.Len(t, cleaner.releases, 1)
+	require.Len(t, cleaner.layers, 1)
+	cleaner.mutex.Unlock()
+
+	cancel()
+	<-done
+}
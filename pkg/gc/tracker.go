@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package gc sweeps files left behind by the snapshotter, like temporary work
+// directories of crashed conversions and bootstraps of removed layers.
+package gc
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Tracker counts references to files and directories in use, so that sweeping
+// never removes them. The zero value is ready to use.
+type Tracker struct {
+	mutex sync.Mutex
+	refs  map[string]int
+}
+
+// Acquire references `path` until the returned function is called.
+func (t *Tracker) Acquire(path string) func() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.acquireLocked(path)
+}
+
+// MkdirTemp creates a new temporary directory in `dir` like os.MkdirTemp, referenced
+// until the returned function is called.
+func (t *Tracker) MkdirTemp(dir, pattern string) (string, func(), error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	path, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, t.acquireLocked(path), nil
+}
+
+func (t *Tracker) acquireLocked(path string) func() {
+	if t.refs == nil {
+		t.refs = make(map[string]int)
+	}
+	t.refs[path]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if t.refs[path]--; t.refs[path] <= 0 {
+				delete(t.refs, path)
+			}
+		})
+	}
+}
+
+// Sweep removes entries directly inside `dir` which are neither referenced nor kept
+// by `keep`, returning paths of the removed ones. `keep` is called with the tracker
+// locked, so it must not reference paths.
+func (t *Tracker) Sweep(dir string, keep func(path string) bool) ([]string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read directory %s", dir)
+	}
+
+	var removed []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if t.refs[path] > 0 || (keep != nil && keep(path)) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return removed, errors.Wrapf(err, "remove %s", path)
+		}
+		removed = append(removed, path)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package gc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackerSweep(t *testing.T) {
+	var tracker Tracker
+	dir := t.TempDir()
+
+	inUse, release, err := tracker.MkdirTemp(dir, "work-")
+	require.NoError(t, err)
+	stale := filepath.Join(dir, "stale")
+	require.NoError(t, os.Mkdir(stale, 0750))
+	kept := filepath.Join(dir, "kept")
+	require.NoError(t, os.WriteFile(kept, nil, 0640))
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0640))
+	releaseFile := tracker.Acquire(file)
+	releaseFile2 := tracker.Acquire(file)
+
+	removed, err := tracker.Sweep(dir, func(path string) bool { return path == kept })
+	require.NoError(t, err)
+	require.Equal(t, []string{stale}, removed)
+	require.DirExists(t, inUse)
+
+	release()
+	releaseFile()
+	// Releasing twice takes no effect.
+	releaseFile()
+	removed, err = tracker.Sweep(dir, func(path string) bool { return path == kept })
+	require.NoError(t, err)
+	require.Equal(t, []string{inUse}, removed)
+
+	releaseFile2()
+	removed, err = tracker.Sweep(dir, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{file, kept}, removed)
+
+	removed, err = tracker.Sweep(filepath.Join(dir, "none"), nil)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+}
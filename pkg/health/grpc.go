@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Services of the standard health protocol. The empty service, the snapshots
+	// service and ReadinessService run all checks, LivenessService runs none.
+	LivenessService  = "liveness"
+	ReadinessService = "readiness"
+	snapshotsService = "containerd.services.snapshots.v1.Snapshots"
+)
+
+// Interval to check again for watchers of the health service.
+const watchInterval = 5 * time.Second
+
+type server struct {
+	healthpb.UnimplementedHealthServer
+}
+
+// RegisterServer serves the standard gRPC health service on `s`.
+func RegisterServer(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, &server{})
+}
+
+func servingStatus(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	switch service {
+	case LivenessService:
+		return healthpb.HealthCheckResponse_SERVING, nil
+	case "", ReadinessService, snapshotsService:
+		if Check(ctx).Ready {
+			return healthpb.HealthCheckResponse_SERVING, nil
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+}
+
+func (s *server) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := servingStatus(ctx, req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status on start and on every change afterwards, unknown services
+// are reported as SERVICE_UNKNOWN as the protocol requires.
+func (s *server) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, _ := servingStatus(stream.Context(), req.Service)
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package health checks whether components the snapshotter depends on are accessible,
+// reported by the gRPC health service on the snapshotter socket and the system API.
+// The snapshotter is alive as long as it answers, and ready if every check passes.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Timeout of each check, a hung component is taken as unhealthy.
+const checkTimeout = 5 * time.Second
+
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+type ComponentReport struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Ready      bool              `json:"ready"`
+	Components []ComponentReport `json:"components"`
+}
+
+var (
+	mutex  sync.Mutex
+	checks []check
+)
+
+// Register adds a check of component `name`, run on every readiness probe.
+func Register(name string, fn CheckFunc) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	checks = append(checks, check{name: name, fn: fn})
+}
+
+// Check runs all the registered checks in order of registration.
+func Check(ctx context.Context) *Report {
+	mutex.Lock()
+	registered := append([]check(nil), checks...)
+	mutex.Unlock()
+
+	report := Report{Ready: true, Components: []ComponentReport{}}
+	for _, c := range registered {
+		component := ComponentReport{Name: c.name}
+		if err := runCheck(ctx, c.fn); err != nil {
+			component.Error = err.Error()
+			report.Ready = false
+		}
+		report.Components = append(report.Components, component)
+	}
+	return &report
+}
+
+func runCheck(ctx context.Context, fn CheckFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "check not finished")
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package health
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func resetChecks(t *testing.T) {
+	mutex.Lock()
+	checks = nil
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		checks = nil
+		mutex.Unlock()
+	})
+}
+
+func TestCheck(t *testing.T) {
+	resetChecks(t)
+	ctx := context.Background()
+	s := &server{}
+
+	require.True(t, Check(ctx).Ready)
+
+	var broken error
+	Register("metadata", func(context.Context) error { return nil })
+	Register("cache", func(context.Context) error { return broken })
+
+	report := Check(ctx)
+	require.True(t, report.Ready)
+	require.Equal(t, []ComponentReport{{Name: "metadata"}, {Name: "cache"}}, report.Components)
+	resp, err := s.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	broken = errors.New("read-only file system")
+	report = Check(ctx)
+	require.False(t, report.Ready)
+	require.Equal(t, "read-only file system", report.Components[1].Error)
+	for _, service := range []string{"", ReadinessService, snapshotsService} {
+		resp, err = s.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	}
+
+	// Alive even if not ready.
+	resp, err = s.Check(ctx, &healthpb.HealthCheckRequest{Service: LivenessService})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	_, err = s.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -34,6 +34,10 @@ const (
 	NydusMetaLayer = "containerd.io/snapshot/nydus-bootstrap"
 	// The referenced blob sha256 in format of `sha256:xxx`, set by image builders.
 	NydusRefLayer = "containerd.io/snapshot/nydus-ref"
+	// A bool flag marking the nydus data layer as its blob wrapped in gzip, whose digest is
+	// in NydusBlobDigest, set by image builders.
+	NydusGzipWrapped = "containerd.io/snapshot/nydus-gzip-wrapped"
+	NydusBlobDigest  = "containerd.io/snapshot/nydus-blob-digest"
 	// The blobID of associated layer, also marking the layer as a nydus tarfs, set by the snapshotter
 	NydusTarfsLayer = "containerd.io/snapshot/nydus-tarfs"
 	// Dm-verity information for image block device
@@ -48,6 +52,49 @@ const (
 	NydusProxyMode = "containerd.io/snapshot/nydus-proxy-mode"
 	// A bool flag to enable integrity verification of meta data blob
 	NydusSignature = "containerd.io/snapshot/nydus-signature"
+	// A bool flag marking the nydus meta layer not unpacked by containerd, whose bootstrap is
+	// fetched from registry on the first mount, set by the snapshotter.
+	NydusLazyBootstrap = "containerd.io/snapshot/nydus-lazy-bootstrap"
+
+	// Path to the bootstrap merged from locally converted OCIv1 layers, also marking the
+	// snapshot to be served by nydusd from the converted copy, set by the snapshotter.
+	NydusLocalConversion = "containerd.io/snapshot/nydus-local-conversion"
+	// Priority of converting the layer locally, "background" for preloaded images which
+	// yield to layers of containers about to start, set by clients.
+	NydusConversionPriority = "containerd.io/snapshot/nydus-conversion-priority"
+
+	// A bool flag passed to Commit to pack the writable layer into a nydus layer, set by clients.
+	NydusCommit = "containerd.io/snapshot/nydus-commit"
+	// Digest of the nydus layer blob packed from the committed writable layer, set by the snapshotter.
+	NydusCommitBlob = "containerd.io/snapshot/nydus-commit-blob"
+
+	// Size limit of the writable layer, e.g. "10Gi", overriding `writable_layer_quota` of the snapshotter.
+	NydusWritableLayerQuota = "containerd.io/snapshot/nydus-writable-layer-quota"
+
+	// FUSE session parameters of the image, overriding `[daemon.fuse]` of the snapshotter
+	// in dedicated daemon mode, set on the meta layer by image annotations.
+	NydusFuseMaxBackground       = "containerd.io/snapshot/nydus-fuse-max-background"
+	NydusFuseCongestionThreshold = "containerd.io/snapshot/nydus-fuse-congestion-threshold"
+	NydusFuseWritebackCache      = "containerd.io/snapshot/nydus-fuse-writeback-cache"
+	NydusFuseReadaheadSize       = "containerd.io/snapshot/nydus-fuse-readahead-size"
+
+	// Critical files of the image separated by commas, set on the meta layer by image
+	// annotations. Mounts of containers block until the threshold fraction of their bytes,
+	// 1 by default, is cached, or until timeout, e.g. "10s".
+	NydusReadinessFiles     = "containerd.io/snapshot/nydus-readiness-files"
+	NydusReadinessThreshold = "containerd.io/snapshot/nydus-readiness-threshold"
+	NydusReadinessTimeout   = "containerd.io/snapshot/nydus-readiness-timeout"
+
+	// Bucket and object prefix of blobs of the image in the s3 backend, set on the meta
+	// layer by image annotations, overriding the ones in the nydusd configuration if the
+	// bucket is in `remote.s3_buckets`.
+	NydusS3Bucket       = "containerd.io/snapshot/nydus-s3-bucket"
+	NydusS3ObjectPrefix = "containerd.io/snapshot/nydus-s3-object-prefix"
+
+	// ID of the pod sandbox of the container, set by clients on its writable layer. Images
+	// of containers in the same sandbox are served by one nydusd in dedicated daemon mode
+	// if `daemon.share_by_sandbox` is enabled.
+	NydusSandboxID = "containerd.io/snapshot/nydus-sandbox-id"
 
 	// A bool flag to mark the blob as a estargz data blob, set by the snapshotter.
 	StargzLayer = "containerd.io/snapshot/stargz"
@@ -67,16 +114,29 @@ func IsNydusDataLayer(labels map[string]string) bool {
 	return ok
 }
 
+func IsNydusGzipWrapped(labels map[string]string) bool {
+	return labels[NydusGzipWrapped] == "true"
+}
+
 func IsNydusMetaLayer(labels map[string]string) bool {
 	_, ok := labels[NydusMetaLayer]
 	return ok
 }
 
+func IsNydusLazyBootstrap(labels map[string]string) bool {
+	return labels[NydusLazyBootstrap] == "true"
+}
+
 func IsTarfsDataLayer(labels map[string]string) bool {
 	_, ok := labels[NydusTarfsLayer]
 	return ok
 }
 
+func IsNydusLocalConversion(labels map[string]string) bool {
+	_, ok := labels[NydusLocalConversion]
+	return ok
+}
+
 func IsNydusProxyMode(labels map[string]string) bool {
 	_, ok := labels[NydusProxyMode]
 	return ok
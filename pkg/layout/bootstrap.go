@@ -0,0 +1,400 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package layout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// On-disk layout of RAFS v6 bootstraps, which are EROFS images with the superblock
+// extended by a table of blobs and a table of chunks.
+const (
+	rafsV6ExtSuperBlockOffset = RafsV6SuperBlockOffset + 128
+	rafsV6BlobEntrySize       = 256
+	rafsV6ChunkEntrySize      = 80
+	rafsV6DirentSize          = 12
+	rafsV6ChunkIndexSize      = 8
+	rafsV6InodeSlotSize       = 32
+
+	erofsInodeLayoutFlatInline  = 2
+	erofsInodeLayoutChunkBased  = 4
+	erofsChunkFormatIndexes     = 0x20
+	erofsChunkFormatBlkbitsMask = 0x1f
+
+	modeTypeMask = 0xf000
+	modeDir      = 0x4000
+	modeRegular  = 0x8000
+
+	// Flags of the extended superblock.
+	rafsV6FlagDigestBlake3 = 0x4
+	rafsV6FlagDigestSHA256 = 0x8
+)
+
+var errNoChunkTable = errors.New("no chunk table in bootstrap, which is built by an old builder")
+
+// Blake3 is the default algorithm of chunk digests of nydus-image.
+const Blake3 digest.Algorithm = "blake3"
+
+// Blob is an entry of the blob table of a bootstrap.
+type Blob struct {
+	// ID is the hex sha256 digest of the nydus blob.
+	ID               string
+	ChunkCount       uint32
+	CompressedSize   uint64
+	UncompressedSize uint64
+}
+
+// Chunk locates a chunk of file data in a blob.
+type Chunk struct {
+	BlobID string
+	// Digest of the uncompressed chunk data.
+	Digest             digest.Digest
+	FileOffset         uint64
+	CompressedOffset   uint64
+	CompressedSize     uint64
+	UncompressedOffset uint64
+	UncompressedSize   uint64
+}
+
+// File is a regular file with its chunks in the order of file offset. Hard links
+// of a file are listed as files of their own sharing the chunks.
+type File struct {
+	Path   string
+	Size   uint64
+	Chunks []Chunk
+}
+
+type chunkID struct {
+	blobIndex  uint32
+	chunkIndex uint32
+}
+
+// Bootstrap is a RAFS v6 bootstrap read into memory.
+type Bootstrap struct {
+	data        []byte
+	blockSize   uint64
+	metaOffset  uint64
+	rootNid     uint64
+	algorithm   digest.Algorithm
+	blobs       []Blob
+	chunkOffset uint64
+	chunkCount  uint64
+}
+
+// ReadBootstrap reads the RAFS v6 bootstrap at `path`, RAFS v5 is not supported.
+func ReadBootstrap(path string) (*Bootstrap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read bootstrap %s", path)
+	}
+	b, err := parseBootstrap(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse bootstrap %s", path)
+	}
+	return b, nil
+}
+
+func parseBootstrap(data []byte) (*Bootstrap, error) {
+	le := binary.LittleEndian
+	if len(data) < int(RafsV6SuperBlockSize) {
+		return nil, errors.New("too small to be a bootstrap")
+	}
+	if fsVersion, err := DetectFsVersion(data); err != nil {
+		return nil, err
+	} else if fsVersion != RafsV6 {
+		return nil, errors.Errorf("unsupported RAFS %s", fsVersion)
+	}
+
+	sb := data[RafsV6SuperBlockOffset:]
+	ext := data[rafsV6ExtSuperBlockOffset:]
+	b := &Bootstrap{
+		data:        data,
+		blockSize:   1 << sb[12],
+		rootNid:     uint64(le.Uint16(sb[14:])),
+		chunkOffset: le.Uint64(ext[24:]),
+		chunkCount:  le.Uint64(ext[32:]) / rafsV6ChunkEntrySize,
+	}
+	b.metaOffset = uint64(le.Uint32(sb[40:])) * b.blockSize
+
+	algorithm, err := DetectDigestAlgorithm(data)
+	if err != nil {
+		return nil, err
+	}
+	b.algorithm = algorithm
+
+	blobOffset, blobSize := le.Uint64(ext[8:]), uint64(le.Uint32(ext[16:]))
+	if blobOffset+blobSize > uint64(len(data)) {
+		return nil, errors.New("blob table out of range")
+	}
+	for off := blobOffset; off+rafsV6BlobEntrySize <= blobOffset+blobSize; off += rafsV6BlobEntrySize {
+		entry := data[off : off+rafsV6BlobEntrySize]
+		b.blobs = append(b.blobs, Blob{
+			ID:               string(bytes.TrimRight(entry[:64], "\x00")),
+			ChunkCount:       le.Uint32(entry[72:]),
+			CompressedSize:   le.Uint64(entry[88:]),
+			UncompressedSize: le.Uint64(entry[96:]),
+		})
+	}
+
+	if b.chunkOffset+b.chunkCount*rafsV6ChunkEntrySize > uint64(len(data)) {
+		return nil, errors.New("chunk table out of range")
+	}
+	if b.metaOffset >= uint64(len(data)) {
+		return nil, errors.New("meta blocks out of range")
+	}
+	return b, nil
+}
+
+// DetectDigestAlgorithm returns the algorithm digesting chunks of the RAFS v6 bootstrap
+// starting with `header`, which holds the superblocks at least.
+func DetectDigestAlgorithm(header []byte) (digest.Algorithm, error) {
+	if len(header) < int(RafsV6SuperBlockSize) || !isRafsV6(header) {
+		return "", errors.New("not a RAFS v6 bootstrap")
+	}
+	switch flags := binary.LittleEndian.Uint64(header[rafsV6ExtSuperBlockOffset:]); {
+	case flags&rafsV6FlagDigestSHA256 != 0:
+		return digest.SHA256, nil
+	case flags&rafsV6FlagDigestBlake3 != 0:
+		return Blake3, nil
+	default:
+		return "", errors.Errorf("unknown digest algorithm in flags %#x", flags)
+	}
+}
+
+// Blobs returns the blob table, which includes blobs of chunk dicts the image
+// is deduplicated against.
+func (b *Bootstrap) Blobs() []Blob {
+	return b.blobs
+}
+
+// DigestAlgorithm returns the algorithm digesting chunks of the image.
+func (b *Bootstrap) DigestAlgorithm() digest.Algorithm {
+	return b.algorithm
+}
+
+// Chunks returns the chunk table, each distinct chunk of file data in the image with
+// the file offset of the first file it's found in.
+func (b *Bootstrap) Chunks() ([]Chunk, error) {
+	if b.chunkCount == 0 {
+		return nil, errNoChunkTable
+	}
+
+	chunks := make([]Chunk, 0, b.chunkCount)
+	for i := uint64(0); i < b.chunkCount; i++ {
+		_, chunk, err := b.chunk(i)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (b *Bootstrap) chunk(i uint64) (chunkID, Chunk, error) {
+	le := binary.LittleEndian
+	entry := b.data[b.chunkOffset+i*rafsV6ChunkEntrySize:]
+	id := chunkID{blobIndex: le.Uint32(entry[32:]), chunkIndex: le.Uint32(entry[72:])}
+	if int(id.blobIndex) >= len(b.blobs) {
+		return id, Chunk{}, errors.Errorf("chunk %d of blob index %d out of blob table", i, id.blobIndex)
+	}
+	return id, Chunk{
+		BlobID:             b.blobs[id.blobIndex].ID,
+		Digest:             digest.NewDigestFromEncoded(b.algorithm, hex.EncodeToString(entry[:32])),
+		CompressedSize:     uint64(le.Uint32(entry[40:])),
+		UncompressedSize:   uint64(le.Uint32(entry[44:])),
+		CompressedOffset:   le.Uint64(entry[48:]),
+		UncompressedOffset: le.Uint64(entry[56:]),
+		FileOffset:         le.Uint64(entry[64:]),
+	}, nil
+}
+
+// Files returns regular files of the image in the order of directory entries.
+func (b *Bootstrap) Files() ([]File, error) {
+	if b.chunkCount == 0 {
+		return nil, errNoChunkTable
+	}
+	chunks := make(map[chunkID]Chunk, b.chunkCount)
+	for i := uint64(0); i < b.chunkCount; i++ {
+		id, chunk, err := b.chunk(i)
+		if err != nil {
+			return nil, err
+		}
+		chunks[id] = chunk
+	}
+
+	var files []File
+	// Directories are visited once, a corrupted bootstrap may loop.
+	visited := map[uint64]bool{}
+	var walk func(nid uint64, p string) error
+	walk = func(nid uint64, p string) error {
+		ino, err := b.inode(nid)
+		if err != nil {
+			return errors.Wrapf(err, "read inode of %s", p)
+		}
+		switch ino.mode & modeTypeMask {
+		case modeDir:
+			if visited[nid] {
+				return errors.Errorf("directory %s is linked more than once", p)
+			}
+			visited[nid] = true
+			return b.walkDir(ino, func(childNid uint64, name string) error {
+				return walk(childNid, path.Join(p, name))
+			})
+		case modeRegular:
+			fileChunks, err := b.fileChunks(ino, chunks)
+			if err != nil {
+				return errors.Wrapf(err, "read chunks of %s", p)
+			}
+			files = append(files, File{Path: p, Size: ino.size, Chunks: fileChunks})
+		}
+		return nil
+	}
+	if err := walk(b.rootNid, "/"); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+type inode struct {
+	// Offset of the inode and size of it with xattrs.
+	offset uint64
+	size   uint64
+	layout uint16
+	mode   uint16
+	// Union of raw block address, chunk format, device number, etc.
+	u         uint32
+	totalSize uint64
+}
+
+func (b *Bootstrap) inode(nid uint64) (*inode, error) {
+	le := binary.LittleEndian
+	offset := b.metaOffset + nid*rafsV6InodeSlotSize
+	if offset+64 > uint64(len(b.data)) {
+		return nil, errors.Errorf("nid %d out of range", nid)
+	}
+	raw := b.data[offset:]
+	format := le.Uint16(raw)
+	ino := &inode{
+		offset: offset,
+		layout: (format >> 1) & 0x7,
+		mode:   le.Uint16(raw[4:]),
+		u:      le.Uint32(raw[16:]),
+	}
+	// Compact inodes are 32 bytes with 32-bit sizes, extended ones are 64 bytes.
+	if format&1 == 1 {
+		ino.totalSize = 64
+		ino.size = le.Uint64(raw[8:])
+	} else {
+		ino.totalSize = 32
+		ino.size = uint64(le.Uint32(raw[8:]))
+	}
+	if xattrCount := uint64(le.Uint16(raw[2:])); xattrCount > 0 {
+		ino.totalSize += 12 + (xattrCount-1)*4
+	}
+	return ino, nil
+}
+
+func (b *Bootstrap) slice(offset, size uint64) ([]byte, error) {
+	if offset+size > uint64(len(b.data)) {
+		return nil, fmt.Errorf("range %d+%d out of bootstrap", offset, size)
+	}
+	return b.data[offset : offset+size], nil
+}
+
+// Directory data is in blocks from the raw block address, or inlined after the
+// inode for its tail.
+func (b *Bootstrap) walkDir(ino *inode, fn func(nid uint64, name string) error) error {
+	if ino.layout != erofsInodeLayoutFlatInline && ino.layout != 0 {
+		return errors.Errorf("unsupported directory layout %d", ino.layout)
+	}
+	le := binary.LittleEndian
+	for blockStart := uint64(0); blockStart < ino.size; blockStart += b.blockSize {
+		blockLen := min(b.blockSize, ino.size-blockStart)
+		var block []byte
+		var err error
+		if ino.layout == erofsInodeLayoutFlatInline && blockStart+b.blockSize > ino.size {
+			block, err = b.slice(ino.offset+ino.totalSize, blockLen)
+		} else {
+			block, err = b.slice(uint64(ino.u)*b.blockSize+blockStart, blockLen)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(block) < rafsV6DirentSize {
+			return errors.New("truncated directory entries")
+		}
+		count := int(le.Uint16(block[8:])) / rafsV6DirentSize
+		if count == 0 || count*rafsV6DirentSize > len(block) {
+			return errors.New("invalid directory entries")
+		}
+		for i := 0; i < count; i++ {
+			dirent := block[i*rafsV6DirentSize:]
+			nameStart, nameEnd := int(le.Uint16(dirent[8:])), len(block)
+			if i+1 < count {
+				nameEnd = int(le.Uint16(block[(i+1)*rafsV6DirentSize+8:]))
+			}
+			if nameStart > nameEnd || nameEnd > len(block) {
+				return errors.New("invalid name of directory entry")
+			}
+			name := block[nameStart:nameEnd]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			if string(name) == "." || string(name) == ".." {
+				continue
+			}
+			if err := fn(le.Uint64(dirent), string(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Chunk indexes of a chunk-based file follow the inode aligned to 8 bytes.
+func (b *Bootstrap) fileChunks(ino *inode, chunks map[chunkID]Chunk) ([]Chunk, error) {
+	if ino.size == 0 {
+		return []Chunk{}, nil
+	}
+	if ino.layout != erofsInodeLayoutChunkBased || ino.u&erofsChunkFormatIndexes == 0 {
+		return nil, errors.Errorf("unsupported file layout %d", ino.layout)
+	}
+	chunkBits := 12 + uint64(ino.u&erofsChunkFormatBlkbitsMask)
+	count := (ino.size + 1<<chunkBits - 1) >> chunkBits
+	start := (ino.offset + ino.totalSize + rafsV6ChunkIndexSize - 1) &^ (rafsV6ChunkIndexSize - 1)
+	indexes, err := b.slice(start, count*rafsV6ChunkIndexSize)
+	if err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	fileChunks := make([]Chunk, 0, count)
+	for i := uint64(0); i < count; i++ {
+		index := indexes[i*rafsV6ChunkIndexSize:]
+		lo, hi := le.Uint16(index), le.Uint16(index[2:])
+		// Blob indexes are stored plus one, zero for holes of sparse files.
+		if hi&0xff == 0 {
+			continue
+		}
+		id := chunkID{blobIndex: uint32(hi&0xff) - 1, chunkIndex: uint32(hi>>8)<<16 | uint32(lo)}
+		chunk, ok := chunks[id]
+		if !ok {
+			return nil, errors.Errorf("chunk %d of blob index %d is not in chunk table", id.chunkIndex, id.blobIndex)
+		}
+		fileChunks = append(fileChunks, chunk)
+	}
+	return fileChunks, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package layout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/internal/testutil"
+)
+
+func TestReadBootstrap(t *testing.T) {
+	b, err := ReadBootstrap(testutil.ExtractBootstrap(t, "../filesystem/testdata/v6-bootstrap-chunk-pos-438272.tar.gz"))
+	require.NoError(t, err)
+
+	blobID := "cdde6f5645daea414d60bc75611102a8bc8dae6198f087366365d6ff85bf5726"
+	require.Equal(t, []Blob{{ID: blobID, ChunkCount: 2515, CompressedSize: 43090887, UncompressedSize: 83767296}}, b.Blobs())
+	require.Equal(t, Blake3, b.DigestAlgorithm())
+
+	files, err := b.Files()
+	require.NoError(t, err)
+	byPath := map[string]File{}
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+
+	require.Empty(t, byPath["/etc/.pwd.lock"].Chunks)
+	readme := byPath["/etc/alternatives/README"]
+	require.Equal(t, uint64(100), readme.Size)
+	require.Len(t, readme.Chunks, 1)
+	require.Equal(t, blobID, readme.Chunks[0].BlobID)
+	require.Equal(t, uint64(23507), readme.Chunks[0].CompressedOffset)
+	require.Equal(t, uint64(147456), readme.Chunks[0].UncompressedOffset)
+	require.Equal(t, uint64(100), readme.Chunks[0].CompressedSize)
+	require.Equal(t, Blake3, readme.Chunks[0].Digest.Algorithm())
+	require.Len(t, readme.Chunks[0].Digest.Encoded(), 64)
+
+	// Hard links share chunks.
+	perl := byPath["/usr/bin/perl"]
+	require.Len(t, perl.Chunks, 4)
+	require.Equal(t, perl.Chunks, byPath["/usr/bin/perl5.34.0"].Chunks)
+	var size uint64
+	for i, c := range perl.Chunks {
+		require.Equal(t, uint64(i)<<20, c.FileOffset)
+		size += c.UncompressedSize
+	}
+	require.Equal(t, perl.Size, size)
+
+	chunks, err := b.Chunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2515)
+	require.Contains(t, chunks, readme.Chunks[0])
+
+	_, err = ReadBootstrap(testutil.ExtractBootstrap(t, "../filesystem/testdata/v5-bootstrap-file-size-736032.tar.gz"))
+	require.ErrorContains(t, err, "unsupported RAFS v5")
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/command"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	metrics "github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
 	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
@@ -36,6 +37,9 @@ const endpointGetBackend string = "/api/v1/daemons/%s/backend"
 //     ensure the daemon has reached specified state.
 //   - `d` may have not been inserted into daemonStates and store yet.
 func (m *Manager) StartDaemon(d *daemon.Daemon) error {
+	if err := fault.Inject(fault.PointDaemonStart, d.ID()); err != nil {
+		return err
+	}
 	cmd, err := m.BuildDaemonCommand(d, "", false)
 	if err != nil {
 		return errors.Wrapf(err, "create command for daemon %s", d.ID())
@@ -44,11 +48,8 @@ func (m *Manager) StartDaemon(d *daemon.Daemon) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
-	fsDriver := config.GetFsDriver()
-	isSharedFusedev := fsDriver == config.FsDriverFusedev && config.GetDaemonMode() == config.DaemonModeShared
-	useSharedDaemon := fsDriver == config.FsDriverFscache || isSharedFusedev
-
-	if !useSharedDaemon {
+	// Daemons serving pod sandboxes are shared ones in dedicated daemon mode.
+	if !d.IsSharedDaemon() {
 		errs := d.MountByAPI()
 		if errs != nil {
 			return errors.Wrapf(err, "failed to mount")
@@ -14,6 +14,7 @@ import (
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	"github.com/pkg/errors"
 )
@@ -39,6 +40,7 @@ func (m *Manager) handleDaemonDeathEvent() {
 	// TODO: ratelimit for daemon recovery operations?
 	for ev := range m.LivenessNotifier {
 		log.L.Warnf("Daemon %s died! socket path %s", ev.daemonID, ev.path)
+		events.Publish(events.TopicDaemon, map[string]string{"daemon_id": ev.daemonID, "state": string(types.DaemonStateDied)})
 
 		d := m.GetByDaemonID(ev.daemonID)
 		if d == nil {
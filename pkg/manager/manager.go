@@ -11,7 +11,6 @@ import (
 	"context"
 	"os"
 	"path"
-	"path/filepath"
 	"sync"
 
 	"github.com/containerd/log"
@@ -23,6 +22,7 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	"github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/containerd/nydus-snapshotter/pkg/store"
@@ -59,7 +59,7 @@ type Opt struct {
 	FsDriver         string
 	NydusdBinaryPath string
 	RecoverPolicy    config.DaemonRecoverPolicy
-	RootDir          string // Nydus-snapshotter work directory
+	SupervisorDir    string // Directory hosting supervisor sockets
 }
 
 func NewManager(opt Opt) (*Manager, error) {
@@ -75,7 +75,7 @@ func NewManager(opt Opt) (*Manager, error) {
 
 	var supervisorSet *supervisor.SupervisorsSet
 	if opt.RecoverPolicy == config.RecoverPolicyFailover {
-		supervisorSet, err = supervisor.NewSupervisorSet(filepath.Join(opt.RootDir, "supervisor"))
+		supervisorSet, err = supervisor.NewSupervisorSet(opt.SupervisorDir)
 		if err != nil {
 			return nil, errors.Wrap(err, "create supervisor set")
 		}
@@ -129,6 +129,9 @@ func (m *Manager) Recover(ctx context.Context,
 	if err := m.recoverRafsInstances(ctx, recoveringDaemons, liveDaemons); err != nil {
 		return errors.Wrapf(err, "recover RAFS instances")
 	}
+	if err := m.reconcileLiveDaemons(recoveringDaemons, liveDaemons); err != nil {
+		return errors.Wrapf(err, "reconcile running nydusd daemons")
+	}
 	return nil
 }
 
@@ -146,6 +149,14 @@ func (m *Manager) AddRafsInstance(r *rafs.Rafs) error {
 	return m.store.AddRafsInstance(r)
 }
 
+// UpdateRafsInstance persists changes of an instance added before, e.g. annotations.
+func (m *Manager) UpdateRafsInstance(r *rafs.Rafs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.store.UpdateRafsInstance(r)
+}
+
 func (m *Manager) RemoveRafsInstance(snapshotID string) error {
 	return m.store.DeleteRafsInstance(snapshotID)
 }
@@ -248,6 +259,46 @@ func (m *Manager) DeleteDaemon(daemon *daemon.Daemon) error {
 	return nil
 }
 
+// CheckHealth tells whether records of daemons can be read from the store, and every
+// daemon answers its API, so that a hung or dead nydusd is reported. Daemons still
+// starting up are taken as healthy.
+func (m *Manager) CheckHealth(ctx context.Context) error {
+	m.mu.Lock()
+	err := m.store.WalkDaemons(ctx, func(_ *daemon.ConfigState) error { return nil })
+	m.mu.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, "walk %s daemons in store", m.FsDriver)
+	}
+
+	for _, d := range m.ListDaemons() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		state, err := d.GetState()
+		if err != nil {
+			return errors.Wrapf(err, "query state of daemon %s", d.ID())
+		}
+		switch state {
+		case types.DaemonStateInit, types.DaemonStateReady, types.DaemonStateRunning:
+		default:
+			return errors.Errorf("daemon %s is %s", d.ID(), state)
+		}
+	}
+	return nil
+}
+
+// DaemonRecords returns states of daemons persisted in the store.
+func (m *Manager) DaemonRecords(ctx context.Context) ([]*daemon.ConfigState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []*daemon.ConfigState
+	err := m.store.WalkDaemons(ctx, func(s *daemon.ConfigState) error {
+		records = append(records, s)
+		return nil
+	})
+	return records, errors.Wrapf(err, "walk %s daemons in store", m.FsDriver)
+}
+
 func (m *Manager) GetByDaemonID(id string) *daemon.Daemon {
 	return m.daemonCache.GetByDaemonID(id, nil)
 }
@@ -292,6 +343,7 @@ func (m *Manager) DestroyDaemon(d *daemon.Daemon) error {
 	}
 
 	collector.NewDaemonEventCollector(types.DaemonStateDestroyed).Collect()
+	events.Publish(events.TopicDaemon, map[string]string{"daemon_id": d.ID(), "state": string(types.DaemonStateDestroyed)})
 	d.Lock()
 	collector.NewDaemonInfoCollector(&d.Version, -1).Collect()
 	d.Unlock()
@@ -302,10 +354,16 @@ func (m *Manager) DestroyDaemon(d *daemon.Daemon) error {
 func (m *Manager) cleanUpDaemonResources(d *daemon.Daemon) {
 	// TODO: use recycle bin to stage directories/files to be deleted.
 	resource := []string{d.States.ConfigDir, d.States.LogDir}
-	if !d.IsSharedDaemon() {
+	if !d.IsSharedDaemon() || d.States.SandboxID != "" {
 		socketDir := path.Dir(d.GetAPISock())
 		resource = append(resource, socketDir)
 	}
+	if d.States.SandboxID != "" {
+		// Only removed if it's not mounted anymore.
+		if err := os.Remove(d.HostMountpoint()); err != nil && !os.IsNotExist(err) {
+			log.L.WithError(err).Warnf("failed to remove mountpoint of daemon %s", d.ID())
+		}
+	}
 
 	for _, dir := range resource {
 		if err := os.RemoveAll(dir); err != nil {
@@ -345,44 +403,24 @@ func (m *Manager) recoverDaemons(ctx context.Context,
 		state, err := d.GetState()
 		if err != nil {
 			log.L.Warnf("Daemon %s died somehow. Clean up its vestige!, %s", d.ID(), err)
+			// Not to run a duplicate of the hung process on the same mountpoint.
+			killDaemonProcess(d)
 			(*recoveringDaemons)[d.ID()] = d
 			//nolint:nilerr
 			return nil
 		}
 
 		if state != types.DaemonStateRunning {
-			log.L.Warnf("daemon %s is not running: %s", d.ID(), state)
+			log.L.Warnf("daemon %s is not running: %s, restart it", d.ID(), state)
+			killDaemonProcess(d)
+			(*recoveringDaemons)[d.ID()] = d
 			return nil
 		}
 
-		// FIXME: Should put the a daemon back file system shared damon field.
+		// It's adopted after validated against its RAFS instances.
 		log.L.Infof("found RUNNING daemon %s during reconnecting", d.ID())
 		(*liveDaemons)[d.ID()] = d
 
-		if m.CgroupMgr != nil {
-			if err := m.CgroupMgr.AddProc(d.States.ProcessID); err != nil {
-				return errors.Wrapf(err, "add daemon %s to cgroup failed", d.ID())
-			}
-		}
-		d.Lock()
-		collector.NewDaemonInfoCollector(&d.Version, 1).Collect()
-		d.Unlock()
-
-		go func() {
-			if err := daemon.WaitUntilSocketExisted(d.GetAPISock(), d.Pid()); err != nil {
-				log.L.Errorf("Nydusd %s probably not started", d.ID())
-				return
-			}
-
-			if err = m.SubscribeDaemonEvent(d); err != nil {
-				log.L.Errorf("Nydusd %s probably not started", d.ID())
-				return
-			}
-
-			// Snapshotter's lost the daemons' states after exit, refetch them.
-			d.SendStates()
-		}()
-
 		return nil
 	}); err != nil {
 		return errors.Wrapf(err, "walk daemons to reconnect")
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+type emptyStore struct {
+	Store
+}
+
+func (emptyStore) WalkDaemons(context.Context, func(*daemon.ConfigState) error) error {
+	return nil
+}
+
+// Serve the daemon info API of nydusd in state `state` on socket `sock`.
+func serveDaemonInfo(t *testing.T, sock string, state types.DaemonState) func() {
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(types.DaemonInfo{State: state})
+	})}
+	go func() { _ = srv.Serve(l) }()
+	return func() { srv.Close() }
+}
+
+func TestCheckHealth(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manager{store: emptyStore{}, daemonCache: newDaemonCache()}
+	require.NoError(t, m.CheckHealth(context.Background()))
+
+	running := &daemon.Daemon{States: daemon.ConfigState{ID: "running", APISocket: filepath.Join(dir, "running.sock")}}
+	stop := serveDaemonInfo(t, running.GetAPISock(), types.DaemonStateRunning)
+	defer stop()
+	m.daemonCache.Add(running)
+	require.NoError(t, m.CheckHealth(context.Background()))
+
+	died := &daemon.Daemon{States: daemon.ConfigState{ID: "died", APISocket: filepath.Join(dir, "died.sock")}}
+	stopDied := serveDaemonInfo(t, died.GetAPISock(), types.DaemonStateDied)
+	defer stopDied()
+	m.daemonCache.Add(died)
+	require.ErrorContains(t, m.CheckHealth(context.Background()), "daemon died is DIED")
+	m.daemonCache.Remove(died)
+
+	// A daemon not answering its API is unhealthy.
+	gone := &daemon.Daemon{States: daemon.ConfigState{ID: "gone", APISocket: filepath.Join(dir, "gone.sock")}}
+	makeStaleSocket(t, gone.GetAPISock())
+	m.daemonCache.Add(gone)
+	require.ErrorContains(t, m.CheckHealth(context.Background()), "query state of daemon gone")
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+)
+
+// How long to wait for a killed nydusd to exit before restarting it.
+const killWaitTimeout = 3 * time.Second
+
+// reconcileLiveDaemons checks that daemons found running after restart still serve
+// their persisted RAFS instances before adopting them. Dedicated daemons serving a
+// different bootstrap are killed and moved to `recoveringDaemons` to be restarted,
+// while instances missing from shared daemons are mounted again.
+func (m *Manager) reconcileLiveDaemons(recoveringDaemons *map[string]*daemon.Daemon,
+	liveDaemons *map[string]*daemon.Daemon) error {
+	for id, d := range *liveDaemons {
+		if err := m.validateLiveDaemon(d); err != nil {
+			log.L.WithError(err).Warnf("daemon %s doesn't serve the expected instances, restart it", id)
+			killDaemonProcess(d)
+			delete(*liveDaemons, id)
+			(*recoveringDaemons)[id] = d
+			continue
+		}
+		if err := m.adoptDaemon(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) validateLiveDaemon(d *daemon.Daemon) error {
+	if d.States.FsDriver != config.FsDriverFusedev {
+		return nil
+	}
+
+	client, err := d.GetClient()
+	if err != nil {
+		return err
+	}
+	info, err := client.GetDaemonInfo()
+	if err != nil {
+		return errors.Wrap(err, "get daemon info")
+	}
+
+	if !d.IsSharedDaemon() {
+		r := d.RafsCache.Head()
+		if r == nil {
+			return errors.New("no RAFS instance is recorded")
+		}
+		expected, err := r.BootstrapFile()
+		if err != nil {
+			return errors.Wrapf(err, "find bootstrap of instance %s", r.SnapshotID)
+		}
+		// Daemons taking over others are started without bootstrap.
+		if actual := processParam(d.Pid(), "bootstrap"); actual != "" && actual != expected {
+			return errors.Errorf("serving bootstrap %s instead of %s", actual, expected)
+		}
+		return nil
+	}
+
+	for _, r := range d.RafsCache.List() {
+		if _, ok := info.BackendCollection[r.RelaMountpoint()]; ok {
+			continue
+		}
+		log.L.Warnf("instance %s is not mounted by daemon %s, mount it again", r.SnapshotID, d.ID())
+		if err := d.SharedMount(r); err != nil {
+			return errors.Wrapf(err, "mount instance %s", r.SnapshotID)
+		}
+	}
+	return nil
+}
+
+// adoptDaemon resumes managing a running daemon started by the previous snapshotter.
+func (m *Manager) adoptDaemon(d *daemon.Daemon) error {
+	if m.CgroupMgr != nil {
+		if err := m.CgroupMgr.AddProc(d.States.ProcessID); err != nil {
+			return errors.Wrapf(err, "add daemon %s to cgroup failed", d.ID())
+		}
+	}
+	d.Lock()
+	collector.NewDaemonInfoCollector(&d.Version, 1).Collect()
+	d.Unlock()
+
+	go func() {
+		if err := daemon.WaitUntilSocketExisted(d.GetAPISock(), d.Pid()); err != nil {
+			log.L.Errorf("Nydusd %s probably not started", d.ID())
+			return
+		}
+
+		if err := m.SubscribeDaemonEvent(d); err != nil {
+			log.L.Errorf("Nydusd %s probably not started", d.ID())
+			return
+		}
+
+		// Snapshotter's lost the daemons' states after exit, refetch them.
+		d.SendStates()
+	}()
+
+	return nil
+}
+
+// processArgs returns arguments of process `pid`, or nil if it has exited.
+func processArgs(pid int) []string {
+	if pid <= 0 {
+		return nil
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || len(cmdline) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+}
+
+// processParam returns value of command line parameter `--<name>` of process `pid`.
+func processParam(pid int, name string) string {
+	args := processArgs(pid)
+	for i, arg := range args {
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if value, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// killDaemonProcess kills the persisted process of `d` if it's still the nydusd
+// serving the API socket of `d`, as the PID may have been reused by then.
+func killDaemonProcess(d *daemon.Daemon) {
+	pid := d.Pid()
+	if processParam(pid, "apisock") != d.GetAPISock() {
+		return
+	}
+
+	log.L.Warnf("kill process %d of daemon %s", pid, d.ID())
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		log.L.WithError(err).Warnf("failed to kill process %d of daemon %s", pid, d.ID())
+		return
+	}
+	for deadline := time.Now().Add(killWaitTimeout); time.Now().Before(deadline); {
+		if processArgs(pid) == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	log.L.Warnf("process %d of daemon %s doesn't exit in %s", pid, d.ID(), killWaitTimeout)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+)
+
+func TestKillDaemonProcess(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not found")
+	}
+	// The trailing command keeps the shell from replacing itself with sleep.
+	cmd := exec.Command(sh, "-c", "sleep 10; true", "--", "--bootstrap", "/image.boot", "--apisock=/api.sock")
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	// The command line is the one of the test until the child execs.
+	require.Eventually(t, func() bool {
+		return processParam(pid, "bootstrap") == "/image.boot"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "/api.sock", processParam(pid, "apisock"))
+	require.Empty(t, processParam(pid, "mountpoint"))
+	require.Nil(t, processArgs(0))
+
+	// The PID is taken by another process.
+	d := &daemon.Daemon{States: daemon.ConfigState{ProcessID: pid, APISocket: "/other.sock"}}
+	killDaemonProcess(d)
+	require.NotNil(t, processArgs(pid))
+
+	d.States.APISocket = "/api.sock"
+	killDaemonProcess(d)
+	require.Nil(t, processArgs(pid))
+	require.Error(t, cmd.Wait())
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+const socketProbeTimeout = 200 * time.Millisecond
+
+// CleanupStaleResources sweeps leftovers of nydusd daemons which are no longer
+// recorded by snapshotter. Each directory in `roots` is expected to host
+// per-daemon entries named after the daemon ID, e.g. `<socket_root>/<id>/api.sock`
+// or `<supervisor_root>/<id>.sock`. Entries of daemons for which `isKnown`
+// returns true are never touched.
+//
+// Only unix sockets nobody is listening on, pid files of dead processes and
+// directories left empty are removed, so it is safe against live state.
+func CleanupStaleResources(isKnown func(id string) bool, roots ...string) {
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.L.Warnf("failed to read directory %s, %v", root, err)
+			}
+			continue
+		}
+
+		for _, e := range entries {
+			id := strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".sock"), ".pid")
+			if isKnown(id) {
+				continue
+			}
+
+			p := filepath.Join(root, e.Name())
+			if e.IsDir() {
+				cleanupStaleDir(p)
+			} else {
+				removeIfStale(p)
+			}
+		}
+	}
+}
+
+func cleanupStaleDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.L.Warnf("failed to read directory %s, %v", dir, err)
+		return
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			removeIfStale(filepath.Join(dir, e.Name()))
+		}
+	}
+
+	// os.Remove refuses to delete a non-empty directory.
+	if err := os.Remove(dir); err == nil {
+		log.L.Infof("removed stale daemon directory %s", dir)
+	}
+}
+
+func removeIfStale(p string) {
+	stale, err := isStaleFile(p)
+	if err != nil {
+		log.L.Warnf("failed to check file %s, %v", p, err)
+		return
+	}
+	if !stale {
+		return
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		log.L.Warnf("failed to remove stale file %s, %v", p, err)
+		return
+	}
+	log.L.Infof("removed stale file %s", p)
+}
+
+// isStaleFile reports whether `p` is a unix socket nobody is listening on
+// or a pid file recording a dead process. Other files are never stale.
+func isStaleFile(p string) (bool, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return false, err
+	}
+
+	if info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", p, socketProbeTimeout)
+		if err != nil {
+			return true, nil
+		}
+		conn.Close()
+		return false, nil
+	}
+
+	if info.Mode().IsRegular() && filepath.Ext(p) == ".pid" {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return false, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return false, errors.Wrapf(err, "parse pid file %s", p)
+		}
+		return !isProcessAlive(pid), nil
+	}
+
+	return false, nil
+}
+
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeStaleSocket(t *testing.T, p string) {
+	l, err := net.Listen("unix", p)
+	require.NoError(t, err)
+	// Keep the socket file on disk after closing the listener.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+}
+
+func TestCleanupStaleResources(t *testing.T) {
+	root := t.TempDir()
+	mkdir := func(id string) string {
+		d := filepath.Join(root, id)
+		require.NoError(t, os.Mkdir(d, 0755))
+		return d
+	}
+
+	known := mkdir("known")
+	makeStaleSocket(t, filepath.Join(known, "api.sock"))
+
+	stale := mkdir("stale")
+	makeStaleSocket(t, filepath.Join(stale, "api.sock"))
+	require.NoError(t, os.WriteFile(filepath.Join(stale, "nydusd.pid"), []byte("-1"), 0644))
+
+	live := mkdir("live")
+	l, err := net.Listen("unix", filepath.Join(live, "api.sock"))
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, os.WriteFile(filepath.Join(live, "nydusd.pid"),
+		[]byte(strconv.Itoa(os.Getpid())), 0644))
+
+	withConfig := mkdir("config")
+	require.NoError(t, os.WriteFile(filepath.Join(withConfig, "config.json"), []byte("{}"), 0644))
+
+	makeStaleSocket(t, filepath.Join(root, "supervised.sock"))
+
+	CleanupStaleResources(func(id string) bool { return id == "known" }, root, filepath.Join(root, "missing"))
+
+	require.FileExists(t, filepath.Join(known, "api.sock"))
+	require.NoDirExists(t, stale)
+	require.FileExists(t, filepath.Join(live, "api.sock"))
+	require.FileExists(t, filepath.Join(live, "nydusd.pid"))
+	require.FileExists(t, filepath.Join(withConfig, "config.json"))
+	require.NoFileExists(t, filepath.Join(root, "supervised.sock"))
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"time"
+
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
+)
+
+type ColdStartPhase string
+
+const (
+	// From preparing container snapshot to its rootfs mounted.
+	ColdStartPhaseMount ColdStartPhase = "MOUNT"
+	// From container rootfs mounted to the first data read served.
+	ColdStartPhaseFirstRead ColdStartPhase = "FIRST_READ"
+)
+
+type ColdStartCollector struct {
+	phase    ColdStartPhase
+	imageRef string
+	elapsed  time.Duration
+}
+
+func (c *ColdStartCollector) Collect() {
+	h := data.ContainerTimeToMount
+	if c.phase == ColdStartPhaseFirstRead {
+		h = data.ContainerTimeToFirstRead
+	}
+	h.WithLabelValues(c.imageRef).Observe(tool.FormatFloat64(float64(c.elapsed)/float64(time.Millisecond), 6))
+}
@@ -28,7 +28,7 @@ func NewDaemonEventCollector(ev types.DaemonState) *DaemonEventCollector {
 }
 
 func NewFsMetricsCollector(m *types.FsMetrics, imageRef string) *FsMetricsCollector {
-	return &FsMetricsCollector{m, imageRef}
+	return &FsMetricsCollector{Metrics: m, ImageRef: imageRef}
 }
 
 func NewFsMetricsVecCollector() *FsMetricsVecCollector {
@@ -41,6 +41,10 @@ func NewInflightMetricsVecCollector(hungIOInterval time.Duration) *InflightMetri
 	}
 }
 
+func NewMountpointProbeCollector(mountpoint, imageRef string, timeout time.Duration) *MountpointProbeCollector {
+	return &MountpointProbeCollector{Mountpoint: mountpoint, ImageRef: imageRef, Timeout: timeout}
+}
+
 func NewDaemonInfoCollector(version *types.BuildTimeInfo, value float64) *DaemonInfoCollector {
 	return &DaemonInfoCollector{version, value}
 }
@@ -53,6 +57,10 @@ func NewSnapshotterMetricsCollector(ctx context.Context, cacheDir string, pid in
 	return &SnapshotterMetricsCollector{ctx, cacheDir, pid, currentStat}, nil
 }
 
+func NewColdStartCollector(phase ColdStartPhase, imageRef string, elapsed time.Duration) *ColdStartCollector {
+	return &ColdStartCollector{phase, imageRef, elapsed}
+}
+
 func NewSnapshotMetricsTimer(method SnapshotMethod) *prometheus.Timer {
 	return CollectSnapshotMetricsTimer(data.SnapshotEventElapsedHists, method)
 }
@@ -7,12 +7,16 @@
 package collector
 
 import (
+	"os"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
 	mtypes "github.com/containerd/nydus-snapshotter/pkg/metrics/types"
+	"github.com/pkg/errors"
 )
 
 var OPCodeMap = map[uint32]string{
@@ -20,7 +24,9 @@ var OPCodeMap = map[uint32]string{
 }
 
 type FsMetricsCollector struct {
-	Metrics  *types.FsMetrics
+	Metrics *types.FsMetrics
+	// Not collected if nil.
+	Backend  *types.BackendMetrics
 	ImageRef string
 }
 
@@ -29,10 +35,23 @@ type FsMetricsVecCollector struct {
 }
 
 type InflightMetricsVecCollector struct {
-	MetricsVec     []*types.InflightMetrics
+	// Indexed by daemon ID.
+	MetricsVec     map[string]*types.InflightMetrics
 	HungIOInterval time.Duration
 }
 
+// Probes the mountpoint of a RAFS instance, whose daemon is disconnected if the probe
+// fails with ENOTCONN.
+type MountpointProbeCollector struct {
+	Mountpoint string
+	ImageRef   string
+	Timeout    time.Duration
+}
+
+// Mountpoints being probed. A probe against a hung daemon may never return, so it's
+// abandoned after timeout, and the mountpoint isn't probed again until it returns.
+var probing sync.Map
+
 func (f *FsMetricsCollector) Collect() {
 	if f.Metrics == nil {
 		log.L.Warnf("can not collect FS metrics: Metrics is nil")
@@ -41,6 +60,13 @@ func (f *FsMetricsCollector) Collect() {
 	data.FsTotalRead.WithLabelValues(f.ImageRef).Set(float64(f.Metrics.DataRead))
 	data.FsReadHit.WithLabelValues(f.ImageRef).Set(float64(f.Metrics.FopHits[mtypes.Read]))
 	data.FsReadError.WithLabelValues(f.ImageRef).Set(float64(f.Metrics.FopErrors[mtypes.Read]))
+	// Errors are counted per operation only. Nydusd replies EIO to reads of file data
+	// it fails to fetch or cache, while failures of other operations are mostly regular
+	// ones like ENOENT of lookups.
+	data.FsEIOErrors.WithLabelValues(f.ImageRef).Set(float64(f.Metrics.FopErrors[mtypes.Read]))
+	if f.Backend != nil {
+		data.BackendReadErrors.WithLabelValues(f.ImageRef).Set(float64(f.Backend.ReadErrors))
+	}
 
 	for _, h := range data.MetricHists {
 		o, err := h.ToConstHistogram(f.Metrics, f.ImageRef)
@@ -63,7 +89,8 @@ func (i *InflightMetricsVecCollector) Collect() {
 	// The inflight IOs which have a longer elapsed time than the HungIOInterval (default 10 seconds) are hung IOs.
 	totalHungIOMap := 0
 	nowTime := time.Now()
-	for _, daemonInflightIOMetrics := range i.MetricsVec {
+	for daemonID, daemonInflightIOMetrics := range i.MetricsVec {
+		data.FsInflightRequests.WithLabelValues(daemonID).Set(float64(len(daemonInflightIOMetrics.Values)))
 		for _, inflightIOMetric := range daemonInflightIOMetrics.Values {
 			elapsed := nowTime.Sub(time.Unix(int64(inflightIOMetric.TimestampSecs), 0))
 			if elapsed >= i.HungIOInterval {
@@ -76,6 +103,30 @@ func (i *InflightMetricsVecCollector) Collect() {
 	data.TotalHungIO.Set(float64(totalHungIOMap))
 }
 
+func (p *MountpointProbeCollector) Collect() {
+	if _, loaded := probing.LoadOrStore(p.Mountpoint, struct{}{}); loaded {
+		log.L.Warnf("probe of mountpoint %s is still pending", p.Mountpoint)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer probing.Delete(p.Mountpoint)
+		_, err := os.Stat(p.Mountpoint)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, syscall.ENOTCONN) {
+			log.L.Warnf("mountpoint %s of image %s is disconnected", p.Mountpoint, p.ImageRef)
+			data.FsENOTCONNErrors.WithLabelValues(p.ImageRef).Inc()
+		}
+	case <-time.After(p.Timeout):
+		log.L.Warnf("probe of mountpoint %s timed out", p.Mountpoint)
+	}
+}
+
 func (f *FsMetricsVecCollector) Clear() {
 	for _, h := range data.MetricHists {
 		h.Clear()
@@ -44,6 +44,39 @@ var (
 		[]string{imageRefLabel},
 		ttl.DefaultTTL,
 	)
+	// Failed reads of file data are replied with EIO by nydusd.
+	FsEIOErrors = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_eio_errors",
+			Help: "Total number of FUSE reads failed with EIO.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	// Counted by probing mountpoints, as nydusd can't see requests once it's gone.
+	FsENOTCONNErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nydusd_enotconn_errors",
+			Help: "Total number of mountpoint probes failed with ENOTCONN, i.e. the daemon is disconnected.",
+		},
+		[]string{imageRefLabel},
+	)
+	FsInflightRequests = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_inflight_fuse_requests",
+			Help: "Number of FUSE requests being handled by nydus daemon.",
+		},
+		[]string{daemonIDLabel},
+		ttl.DefaultTTL,
+	)
+	BackendReadErrors = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_backend_read_errors",
+			Help: "Total number of failed reads from the storage backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
 	TotalHungIO = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "nydusd_hung_io_counts",
@@ -12,7 +12,9 @@ import (
 
 var (
 	defaultDurationBuckets = []float64{.5, 1, 5, 10, 50, 100, 150, 200, 250, 300, 350, 400, 600, 1000}
-	snapshotEventLabel     = "snapshot_operation"
+	// Lazily loaded containers start in sub-seconds, while fully pulled ones may take minutes.
+	coldStartDurationBuckets = []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000}
+	snapshotEventLabel       = "snapshot_operation"
 )
 
 var (
@@ -25,6 +27,24 @@ var (
 		[]string{snapshotEventLabel},
 	)
 
+	ContainerTimeToMount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "snapshotter_container_time_to_mount_milliseconds",
+			Help:    "The elapsed time from preparing container snapshot to its rootfs being mounted.",
+			Buckets: coldStartDurationBuckets,
+		},
+		[]string{imageRefLabel},
+	)
+
+	ContainerTimeToFirstRead = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "snapshotter_container_time_to_first_read_milliseconds",
+			Help:    "The elapsed time from mounting container rootfs to the first data read served by nydusd.",
+			Buckets: coldStartDurationBuckets,
+		},
+		[]string{imageRefLabel},
+	)
+
 	CacheUsage = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "snapshotter_cache_usage_kilobytes",
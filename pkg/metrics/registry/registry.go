@@ -20,11 +20,17 @@ func init() {
 		data.FsTotalRead,
 		data.FsReadHit,
 		data.FsReadError,
+		data.FsEIOErrors,
+		data.FsENOTCONNErrors,
+		data.FsInflightRequests,
+		data.BackendReadErrors,
 		data.TotalHungIO,
 		data.NydusdEventCount,
 		data.NydusdCount,
 		data.NydusdRSS,
 		data.SnapshotEventElapsedHists,
+		data.ContainerTimeToMount,
+		data.ContainerTimeToFirstRead,
 		data.CacheUsage,
 		data.CPUUsage,
 		data.MemoryUsage,
@@ -10,6 +10,7 @@ package metrics
 import (
 	"context"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -25,6 +26,9 @@ import (
 // Default interval to determine a hung IO.
 const defaultHungIOInterval = 10 * time.Second
 
+// Mountpoints not responding in time are left to the hung IO metrics.
+const mountpointProbeTimeout = 5 * time.Second
+
 type ServerOpt func(*Server) error
 
 type Server struct {
@@ -111,9 +115,15 @@ func (s *Server) CollectFsMetrics(ctx context.Context) {
 					log.G(ctx).Errorf("failed to get fs metric: %v", err)
 					continue
 				}
+				// Older nydusd may not serve backend metrics.
+				backendMetrics, err := d.GetBackendMetrics(sid)
+				if err != nil {
+					log.G(ctx).Debugf("failed to get backend metric: %v", err)
+				}
 
 				fsMetricsVec = append(fsMetricsVec, collector.FsMetricsCollector{
 					Metrics:  fsMetrics,
+					Backend:  backendMetrics,
 					ImageRef: i.ImageID,
 				})
 			}
@@ -126,8 +136,34 @@ func (s *Server) CollectFsMetrics(ctx context.Context) {
 	}
 }
 
+// ProbeMountpoints probes mountpoints of all RAFS instances served by FUSE in parallel.
+// Dying daemons are found by their mountpoints whatever their states are. It returns
+// in mountpointProbeTimeout even if some probes hang.
+func (s *Server) ProbeMountpoints(_ context.Context) {
+	var wg sync.WaitGroup
+	for _, pm := range s.managers {
+		if pm.FsDriver != config.FsDriverFusedev {
+			continue
+		}
+		for _, d := range pm.ListDaemons() {
+			for _, i := range d.RafsCache.List() {
+				mp := i.GetMountpoint()
+				if mp == "" {
+					continue
+				}
+				wg.Add(1)
+				go func(imageRef string) {
+					defer wg.Done()
+					collector.NewMountpointProbeCollector(mp, imageRef, mountpointProbeTimeout).Collect()
+				}(i.ImageID)
+			}
+		}
+	}
+	wg.Wait()
+}
+
 func (s *Server) CollectInflightMetrics(ctx context.Context) {
-	inflightMetricsVec := make([]*types.InflightMetrics, 0, 16)
+	inflightMetricsVec := make(map[string]*types.InflightMetrics, 16)
 	for _, pm := range s.managers {
 		// Collect inflight metrics from fusedev daemons.
 		if pm.FsDriver != config.FsDriverFusedev {
@@ -147,7 +183,7 @@ func (s *Server) CollectInflightMetrics(ctx context.Context) {
 				log.G(ctx).Errorf("failed to get inflight metric: %v", err)
 				continue
 			}
-			inflightMetricsVec = append(inflightMetricsVec, inflightMetrics)
+			inflightMetricsVec[d.ID()] = inflightMetrics
 		}
 	}
 
@@ -171,6 +207,8 @@ outer:
 	for {
 		select {
 		case <-timer.C:
+			// Probes may take long, they don't hold up other metrics.
+			go s.ProbeMountpoints(ctx)
 			s.CollectFsMetrics(ctx)
 			s.CollectDaemonResourceMetrics(ctx)
 			// Collect snapshotter metrics.
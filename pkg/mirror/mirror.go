@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package mirror copies blobs completely downloaded to the node into a node-local or
+// rack-local registry, so that nearby nodes configured to pull through it by
+// `mirrors_config` never fetch the same blobs from upstream again. Blobs are pushed
+// from local files, never fetched from upstream once more for the mirror.
+package mirror
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+)
+
+const defaultMaxConcurrent = 2
+
+type Opt struct {
+	// Host of the registry blobs are copied into, e.g. "localhost:5000".
+	Host string
+	// Request the mirror registry using http instead of https.
+	PlainHTTP bool
+	// Skip verifying TLS certificates of the mirror registry.
+	Insecure bool
+	// Maximum number of blobs copied in parallel, 0 means 2.
+	MaxConcurrent int
+}
+
+// Mirror copies each blob of a repository once per snapshotter process, blobs
+// existing in the mirror registry already are not pushed again.
+type Mirror struct {
+	host      string
+	plainHTTP bool
+	insecure  bool
+	sem       chan struct{}
+
+	mutex sync.Mutex
+	// Blobs being or having been copied, indexed by reference in the mirror registry.
+	blobs map[string]struct{}
+	wg    sync.WaitGroup
+}
+
+func New(opt Opt) (*Mirror, error) {
+	if opt.Host == "" {
+		return nil, errors.New("mirror registry host is required")
+	}
+	if strings.Contains(opt.Host, "/") {
+		return nil, errors.Errorf("invalid mirror registry host %q", opt.Host)
+	}
+	maxConcurrent := opt.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrent
+	}
+
+	return &Mirror{
+		host:      opt.Host,
+		plainHTTP: opt.PlainHTTP,
+		insecure:  opt.Insecure,
+		sem:       make(chan struct{}, maxConcurrent),
+		blobs:     make(map[string]struct{}),
+	}, nil
+}
+
+// mirrorRepo returns the repository of image `ref` in the mirror registry, which keeps
+// the repository path, or an empty string if `ref` is in the mirror registry already.
+func (m *Mirror) mirrorRepo(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse image reference %s", ref)
+	}
+	if reference.Domain(named) == m.host {
+		return "", nil
+	}
+	return m.host + "/" + reference.Path(named), nil
+}
+
+// Copy pushes blob `blobDigest` of image `ref` from local file `file` into the mirror
+// registry in background. It never blocks, failed copies are retried on next call.
+// The file must hold the whole blob, e.g. one in the shared blob store.
+func (m *Mirror) Copy(ref string, blobDigest digest.Digest, file string) {
+	repo, err := m.mirrorRepo(ref)
+	if err != nil {
+		log.L.WithError(err).Warnf("skip mirroring blob %s", blobDigest)
+		return
+	}
+	if repo == "" {
+		return
+	}
+
+	mref := repo + "@" + blobDigest.String()
+	m.mutex.Lock()
+	if _, ok := m.blobs[mref]; ok {
+		m.mutex.Unlock()
+		return
+	}
+	m.blobs[mref] = struct{}{}
+	m.mutex.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.sem <- struct{}{}
+		defer func() { <-m.sem }()
+
+		ctx := context.Background()
+		if err := m.copy(ctx, mref, blobDigest, file); err != nil {
+			log.L.WithError(err).Warnf("failed to mirror blob %s of image %s to %s", blobDigest, ref, m.host)
+			m.mutex.Lock()
+			delete(m.blobs, mref)
+			m.mutex.Unlock()
+			return
+		}
+		log.L.Infof("mirrored blob %s of image %s to %s", blobDigest, ref, m.host)
+	}()
+}
+
+// Wait waits until copies in progress end.
+func (m *Mirror) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Mirror) copy(ctx context.Context, mref string, blobDigest digest.Digest, file string) error {
+	// Opened blobs stay readable even if removed from the blob store meanwhile.
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", blobDigest)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat blob %s", blobDigest)
+	}
+
+	// The mirror registry is trusted and takes no credentials.
+	mirror := remote.New(nil, m.insecure)
+	if m.plainHTTP {
+		mirror.UsePlainHTTP()
+	}
+	pusher, err := mirror.Pusher(ctx, mref)
+	if err != nil {
+		return err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    blobDigest,
+		Size:      info.Size(),
+	}
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			log.L.Debugf("blob %s exists in mirror %s already", blobDigest, m.host)
+			return nil
+		}
+		return errors.Wrapf(err, "push blob %s to %s", blobDigest, mref)
+	}
+	defer w.Close()
+
+	// The digest is verified on commit, corrupted local blobs are not mirrored.
+	if err := content.Copy(ctx, w, f, desc.Size, desc.Digest); err != nil {
+		return errors.Wrapf(err, "copy blob %s to %s", blobDigest, mref)
+	}
+	return nil
+}
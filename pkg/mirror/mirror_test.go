@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// A registry serving blobs of any repository, which supports monolithic uploads.
+type fakeRegistry struct {
+	mutex sync.Mutex
+	blobs map[string][]byte
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/upload/"+strings.TrimSuffix(path, "/blobs/uploads/"))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/upload/"):
+		repo := strings.TrimPrefix(req.URL.Path, "/upload/")
+		data, err := io.ReadAll(req.Body)
+		if err != nil || digest.FromBytes(data).String() != req.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[repo+"@"+digest.FromBytes(data).String()] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		data, ok := r.blobs[parts[0]+"@"+parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) get(key string) ([]byte, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, ok := r.blobs[key]
+	return data, ok
+}
+
+func TestMirrorRepo(t *testing.T) {
+	m, err := New(Opt{Host: "localhost:5000"})
+	require.NoError(t, err)
+
+	repo, err := m.mirrorRepo("docker.io/library/busybox:latest")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/library/busybox", repo)
+
+	repo, err = m.mirrorRepo("registry.example.com/a/b@sha256:" + strings.Repeat("0", 64))
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/a/b", repo)
+
+	repo, err = m.mirrorRepo("localhost:5000/a/b:v1")
+	require.NoError(t, err)
+	require.Empty(t, repo)
+
+	_, err = New(Opt{Host: "localhost:5000/path"})
+	require.Error(t, err)
+}
+
+func TestCopy(t *testing.T) {
+	data := []byte("nydus blob data")
+	blobDigest := digest.FromBytes(data)
+	dir := t.TempDir()
+	file := filepath.Join(dir, blobDigest.Encoded())
+	require.NoError(t, os.WriteFile(file, data, 0644))
+
+	local := &fakeRegistry{blobs: map[string][]byte{}}
+	localServer := httptest.NewServer(local)
+	defer localServer.Close()
+
+	host := strings.TrimPrefix(localServer.URL, "http://")
+	ref := "registry.example.com/library/app:latest"
+	m, err := New(Opt{Host: host, PlainHTTP: true})
+	require.NoError(t, err)
+
+	m.Copy(ref, blobDigest, file)
+	m.Wait()
+	mirrored, ok := local.get("library/app@" + blobDigest.String())
+	require.True(t, ok)
+	require.Equal(t, data, mirrored)
+
+	// Copied blobs are skipped, even if the local file is gone.
+	require.NoError(t, os.Remove(file))
+	m.Copy(ref, blobDigest, file)
+	m.Wait()
+
+	// Failed copies are retried.
+	missing := digest.FromString("missing")
+	m.Copy(ref, missing, filepath.Join(dir, missing.Encoded()))
+	m.Wait()
+	m.mutex.Lock()
+	require.NotContains(t, m.blobs, host+"/library/app@"+missing.String())
+	m.mutex.Unlock()
+
+	// Corrupted local blobs are not mirrored.
+	corrupted := digest.FromString("other data")
+	corruptedFile := filepath.Join(dir, corrupted.Encoded())
+	require.NoError(t, os.WriteFile(corruptedFile, data, 0644))
+	m.Copy(ref, corrupted, corruptedFile)
+	m.Wait()
+	_, ok = local.get("library/app@" + corrupted.String())
+	require.False(t, ok)
+}
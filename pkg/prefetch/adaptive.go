@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLowHitRatio  = 0.6
+	defaultHighHitRatio = 0.95
+	defaultMinRequests  = 1024
+	// Statistics of images served by no source for this long are forgotten.
+	imageTTL = 24 * time.Hour
+)
+
+// Level of prefetch applied to the next mounts of an image.
+type Level int
+
+const (
+	// Prefetch as configured by the daemon configuration.
+	LevelDefault Level = iota
+	// Images fault frequently, prefetch all of their data eagerly.
+	LevelAggressive
+	// Images rarely fault, prefetch is not worth its bandwidth.
+	LevelThrottled
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelAggressive:
+		return "aggressive"
+	case LevelThrottled:
+		return "throttled"
+	default:
+		return "default"
+	}
+}
+
+type hitStats struct {
+	hits  uint64
+	total uint64
+	// When a source of the image was seen alive lately.
+	seen time.Time
+}
+
+// AdaptivePolicy decides prefetch level per image from its blob cache hit ratio.
+// Cache metrics reported by nydusd are cumulative, so only the increment since
+// the previous sample of the same source, e.g. a RAFS instance, is accounted.
+type AdaptivePolicy struct {
+	mutex        sync.Mutex
+	lowHitRatio  float64
+	highHitRatio float64
+	minRequests  uint64
+	images       map[string]*hitStats
+	// Latest counters and image of each source
+	sources map[string]hitStats
+	owners  map[string]string
+}
+
+// NewAdaptivePolicy creates an adaptive policy, zero values takes defaults.
+func NewAdaptivePolicy(lowHitRatio, highHitRatio float64, minRequests uint64) *AdaptivePolicy {
+	if lowHitRatio <= 0 {
+		lowHitRatio = defaultLowHitRatio
+	}
+	if highHitRatio <= 0 {
+		highHitRatio = defaultHighHitRatio
+	}
+	if minRequests == 0 {
+		minRequests = defaultMinRequests
+	}
+
+	return &AdaptivePolicy{
+		lowHitRatio:  lowHitRatio,
+		highHitRatio: highHitRatio,
+		minRequests:  minRequests,
+		images:       make(map[string]*hitStats),
+		sources:      make(map[string]hitStats),
+		owners:       make(map[string]string),
+	}
+}
+
+// Record cumulative cache hits and requests of `source` serving `image`.
+func (p *AdaptivePolicy) Record(image, source string, hits, total uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	last, ok := p.sources[source]
+	if !ok || p.owners[source] != image || total < last.total || hits < last.hits {
+		// New source or the counters reset after nydusd restarted.
+		last = hitStats{}
+	}
+	p.sources[source] = hitStats{hits: hits, total: total}
+	p.owners[source] = image
+
+	s, ok := p.images[image]
+	if !ok {
+		s = &hitStats{}
+		p.images[image] = s
+	}
+	s.hits += hits - last.hits
+	s.total += total - last.total
+	s.seen = time.Now()
+}
+
+// Retain forgets counters of sources not in `alive`. Statistics of images are kept for
+// their next mounts, until no source has served them for a day.
+func (p *AdaptivePolicy) Retain(alive map[string]bool) {
+	p.retain(alive, time.Now())
+}
+
+func (p *AdaptivePolicy) retain(alive map[string]bool, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for source, image := range p.owners {
+		if !alive[source] {
+			delete(p.sources, source)
+			delete(p.owners, source)
+		} else if s, ok := p.images[image]; ok {
+			s.seen = now
+		}
+	}
+	for image, s := range p.images {
+		if now.Sub(s.seen) > imageTTL {
+			delete(p.images, image)
+		}
+	}
+}
+
+// HitRatio returns the accumulated hit ratio and number of requests of `image`.
+func (p *AdaptivePolicy) HitRatio(image string) (float64, uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.images[image]
+	if !ok || s.total == 0 {
+		return 0, 0
+	}
+	return float64(s.hits) / float64(s.total), s.total
+}
+
+func (p *AdaptivePolicy) Level(image string) Level {
+	ratio, total := p.HitRatio(image)
+	switch {
+	case total < p.minRequests:
+		return LevelDefault
+	case ratio < p.lowHitRatio:
+		return LevelAggressive
+	case ratio >= p.highHitRatio:
+		return LevelThrottled
+	default:
+		return LevelDefault
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptivePolicy(t *testing.T) {
+	p := NewAdaptivePolicy(0.5, 0.9, 100)
+
+	// Not enough requests to judge.
+	p.Record("cold", "d1/s1", 10, 50)
+	require.Equal(t, LevelDefault, p.Level("cold"))
+	require.Equal(t, LevelDefault, p.Level("unknown"))
+
+	// Only increments of cumulative counters are accounted.
+	p.Record("cold", "d1/s1", 20, 100)
+	ratio, total := p.HitRatio("cold")
+	require.Equal(t, uint64(100), total)
+	require.InDelta(t, 0.2, ratio, 1e-9)
+	require.Equal(t, LevelAggressive, p.Level("cold"))
+
+	p.Record("hot", "d1/s2", 95, 100)
+	require.Equal(t, LevelThrottled, p.Level("hot"))
+	p.Record("warm", "d2/s3", 70, 100)
+	require.Equal(t, LevelDefault, p.Level("warm"))
+
+	// Counters reset after nydusd restarted, and statistics survive the source gone.
+	p.Record("hot", "d1/s2", 0, 50)
+	_, total = p.HitRatio("hot")
+	require.Equal(t, uint64(150), total)
+	require.Equal(t, LevelDefault, p.Level("hot"))
+
+	p.Retain(map[string]bool{"d2/s3": true})
+	p.Record("cold", "d1/s1", 20, 100)
+	_, total = p.HitRatio("cold")
+	require.Equal(t, uint64(200), total)
+}
+
+func TestAdaptivePolicyExpiry(t *testing.T) {
+	p := NewAdaptivePolicy(0.5, 0.9, 100)
+	p.Record("running", "d1/s1", 10, 100)
+	p.Record("stopped", "d1/s2", 10, 100)
+
+	// Images with live sources stay however long they run.
+	now := time.Now()
+	p.retain(map[string]bool{"d1/s1": true}, now.Add(imageTTL/2))
+	p.retain(map[string]bool{"d1/s1": true}, now.Add(imageTTL+time.Minute))
+	_, total := p.HitRatio("running")
+	require.Equal(t, uint64(100), total)
+	_, total = p.HitRatio("stopped")
+	require.Zero(t, total)
+	require.Len(t, p.images, 1)
+}
@@ -42,6 +42,16 @@ func (p *prefetchInfo) SetPrefetchFiles(body []byte) error {
 	return nil
 }
 
+func (p *prefetchInfo) SetImagePrefetchFiles(image, prefetchfiles string) {
+	p.prefetchMutex.Lock()
+	defer p.prefetchMutex.Unlock()
+
+	if p.prefetchMap == nil {
+		p.prefetchMap = make(map[string]string)
+	}
+	p.prefetchMap[image] = prefetchfiles
+}
+
 func (p *prefetchInfo) GetPrefetchInfo(image string) string {
 	p.prefetchMutex.Lock()
 	defer p.prefetchMutex.Unlock()
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Header of the csv file persisted by optimizer-nri-plugin.
+var traceCSVHeader = []string{"path", "size", "elapsed"}
+
+// A file access recorded while a container runs.
+type TraceEntry struct {
+	Path string `json:"path"`
+	// Elapsed time when the file is accessed since container starts, in microseconds.
+	// Zero if unknown, then the entry order is used.
+	Elapsed uint64 `json:"elapsed"`
+}
+
+// Trace is the file access recording of a container on one node.
+type Trace struct {
+	Files []TraceEntry `json:"files"`
+}
+
+// ParseTrace parses an access recording persisted by optimizer-nri-plugin, which
+// is either the csv file with `path,size,elapsed` header or a plain list file
+// with one path per line in access order.
+func ParseTrace(r io.Reader) (*Trace, error) {
+	br := bufio.NewReader(r)
+	firstLine, err := br.Peek(len(strings.Join(traceCSVHeader, ",")))
+	if err == nil && string(firstLine) == strings.Join(traceCSVHeader, ",") {
+		return parseCSVTrace(br)
+	}
+
+	trace := &Trace{}
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path != "" {
+			trace.Files = append(trace.Files, TraceEntry{Path: path})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read trace list")
+	}
+
+	return trace, nil
+}
+
+func parseCSVTrace(r io.Reader) (*Trace, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "read trace csv")
+	}
+
+	trace := &Trace{}
+	for _, record := range records[1:] {
+		if len(record) != len(traceCSVHeader) {
+			return nil, errors.Errorf("invalid trace record %v", record)
+		}
+		// Elapsed time of human readable csv can't be parsed, fallback to entry order.
+		elapsed, _ := strconv.ParseUint(record[2], 10, 64)
+		trace.Files = append(trace.Files, TraceEntry{Path: record[0], Elapsed: elapsed})
+	}
+
+	return trace, nil
+}
+
+// MergeTraces merges recordings from multiple nodes into a prefetch file list ranked
+// by priority. Files accessed by more containers come first, ties are broken by how
+// early they are accessed relative to other files of the same recording.
+// At most `limit` files are returned, zero means no limit.
+func MergeTraces(traces []*Trace, limit int) []string {
+	type score struct {
+		hits    int
+		rankSum float64
+	}
+	scores := map[string]*score{}
+
+	for _, t := range traces {
+		files := make([]TraceEntry, len(t.Files))
+		copy(files, t.Files)
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Elapsed < files[j].Elapsed
+		})
+
+		seen := map[string]bool{}
+		for idx, f := range files {
+			if seen[f.Path] {
+				continue
+			}
+			seen[f.Path] = true
+
+			s, ok := scores[f.Path]
+			if !ok {
+				s = &score{}
+				scores[f.Path] = s
+			}
+			s.hits++
+			s.rankSum += float64(idx) / float64(len(files))
+		}
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for path := range scores {
+		ranked = append(ranked, path)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i]], scores[ranked[j]]
+		if si.hits != sj.hits {
+			return si.hits > sj.hits
+		}
+		ri, rj := si.rankSum/float64(si.hits), sj.rankSum/float64(sj.hits)
+		if ri != rj {
+			return ri < rj
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked
+}
+
+// Patterns formats the prefetch file list as the prefetch patterns accepted by
+// nydus-image, e.g. `converter.PackOption.PrefetchPatterns`.
+func Patterns(files []string) string {
+	return strings.Join(files, "\n")
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrace(t *testing.T) {
+	trace, err := ParseTrace(strings.NewReader("path,size,elapsed\n/bin/sh,1024,30\n/etc/hosts,12,10\n"))
+	require.NoError(t, err)
+	require.Equal(t, []TraceEntry{{"/bin/sh", 30}, {"/etc/hosts", 10}}, trace.Files)
+
+	trace, err = ParseTrace(strings.NewReader("/bin/sh\n\n/etc/hosts\n"))
+	require.NoError(t, err)
+	require.Equal(t, []TraceEntry{{"/bin/sh", 0}, {"/etc/hosts", 0}}, trace.Files)
+
+	_, err = ParseTrace(strings.NewReader("path,size,elapsed\n/bin/sh\n"))
+	require.Error(t, err)
+}
+
+func TestMergeTraces(t *testing.T) {
+	traces := []*Trace{
+		{Files: []TraceEntry{{"/a", 30}, {"/b", 10}, {"/c", 20}}},
+		{Files: []TraceEntry{{"/b", 0}, {"/a", 0}}},
+		{Files: []TraceEntry{{"/d", 0}, {"/a", 0}, {"/b", 0}, {"/a", 0}}},
+	}
+
+	require.Equal(t, []string{"/b", "/a", "/d", "/c"}, MergeTraces(traces, 0))
+	require.Equal(t, []string{"/b", "/a"}, MergeTraces(traces, 2))
+	require.Empty(t, MergeTraces(nil, 0))
+	require.Equal(t, "/b\n/a", Patterns(MergeTraces(traces, 2)))
+}
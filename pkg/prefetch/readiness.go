@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Critical files read in parallel.
+	warmUpWorkers    = 4
+	warmUpBufferSize = 1 << 20
+)
+
+// WarmUp reads critical `files` of the image mounted at `root`, so that their data is
+// cached, and waits until `threshold` of their bytes are cached or `timeout` expires.
+// It returns the fraction of bytes cached then, while files not read yet go on being
+// read in background. Files missing in the image are ignored.
+func WarmUp(ctx context.Context, root string, files []string, threshold float64, timeout time.Duration) (float64, error) {
+	type entry struct {
+		path string
+		size int64
+	}
+	var entries []entry
+	var total int64
+	for _, f := range files {
+		// Files are relative to the image root, even if given as absolute paths.
+		p := filepath.Join(root, filepath.Clean("/"+f))
+		st, err := os.Stat(p)
+		if err != nil || !st.Mode().IsRegular() {
+			log.L.Debugf("skip warming up critical file %s: %v", f, err)
+			continue
+		}
+		entries = append(entries, entry{path: p, size: st.Size()})
+		total += st.Size()
+	}
+	if total == 0 {
+		return 1, nil
+	}
+	target := int64(math.Ceil(math.Min(threshold, 1) * float64(total)))
+
+	var read atomic.Int64
+	progress := make(chan struct{}, 1)
+	done := make(chan struct{})
+	jobs := make(chan entry)
+
+	var wg sync.WaitGroup
+	for i := 0; i < warmUpWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, warmUpBufferSize)
+			for e := range jobs {
+				n, err := readFile(e.path, buf, func(n int) {
+					read.Add(int64(n))
+					select {
+					case progress <- struct{}{}:
+					default:
+					}
+				})
+				if err != nil {
+					log.L.WithError(err).Warnf("failed to warm up critical file %s", e.path)
+				}
+				// Unreadable bytes are taken as cached, not to block containers in vain.
+				read.Add(e.size - n)
+			}
+		}()
+	}
+	go func() {
+		for _, e := range entries {
+			jobs <- e
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	fraction := func() float64 {
+		return math.Min(float64(read.Load())/float64(total), 1)
+	}
+	for {
+		if read.Load() >= target {
+			return fraction(), nil
+		}
+		select {
+		case <-progress:
+		case <-done:
+			return fraction(), nil
+		case <-timer.C:
+			return fraction(), errors.Errorf("only %.2f of critical files cached after %v", fraction(), timeout)
+		case <-ctx.Done():
+			return fraction(), ctx.Err()
+		}
+	}
+}
+
+// Reads the whole file, reporting bytes read by `report`.
+func readFile(path string, buf []byte, report func(n int)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var total int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			report(n)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUp(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "bin/app"), make([]byte, 3<<20), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config"), []byte("config"), 0644))
+
+	fraction, err := WarmUp(ctx, root, []string{"/bin/app", "config", "/missing", "/bin"}, 1, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, fraction)
+
+	fraction, err = WarmUp(ctx, root, []string{"/bin/app"}, 0.5, time.Minute)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, fraction, 0.5)
+
+	// Paths don't escape the image root.
+	fraction, err = WarmUp(ctx, filepath.Join(root, "bin"), []string{"../config"}, 1, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, fraction)
+
+	// Nothing to wait for.
+	fraction, err = WarmUp(ctx, root, nil, 1, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, fraction)
+}
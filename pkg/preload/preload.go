@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package preload warms up nodes by pulling images through containerd with the
+// snapshotter and starting nydusd for them in advance, so that blob data is
+// prefetched before traffic arrives.
+package preload
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/containerd/nydus-snapshotter/pkg/conversion"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+const (
+	DefaultContainerdAddress = "/run/containerd/containerd.sock"
+	// Images are pulled by kubelet in the CRI namespace.
+	DefaultNamespace   = "k8s.io"
+	DefaultSnapshotter = "nydus"
+	defaultConcurrency = 2
+	// Finished jobs kept for querying progress.
+	maxFinishedJobs = 64
+)
+
+type State string
+
+const (
+	StatePending  State = "pending"
+	StatePulling  State = "pulling"
+	StateMounting State = "mounting"
+	StateDone     State = "done"
+	StateFailed   State = "failed"
+)
+
+type ImageProgress struct {
+	Image string `json:"image"`
+	State State  `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+type Job struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Images    []ImageProgress `json:"images"`
+	Completed int             `json:"completed"`
+	Failed    int             `json:"failed"`
+	Done      bool            `json:"done"`
+}
+
+type Opt struct {
+	ContainerdAddress string
+	Namespace         string
+	// Name of the snapshotter registered in containerd.
+	Snapshotter string
+	// Maximum number of images preloaded in parallel per job.
+	Concurrency int
+}
+
+// Warm up `ref` and report its progress by `setState`.
+type preloadFunc func(ctx context.Context, ref string, setState func(State)) error
+
+type Manager struct {
+	opt     Opt
+	preload preloadFunc
+
+	clientMutex sync.Mutex
+	client      *client.Client
+
+	mutex sync.Mutex
+	jobs  map[string]*Job
+}
+
+func NewManager(opt Opt) *Manager {
+	if opt.ContainerdAddress == "" {
+		opt.ContainerdAddress = DefaultContainerdAddress
+	}
+	if opt.Namespace == "" {
+		opt.Namespace = DefaultNamespace
+	}
+	if opt.Snapshotter == "" {
+		opt.Snapshotter = DefaultSnapshotter
+	}
+	if opt.Concurrency <= 0 {
+		opt.Concurrency = defaultConcurrency
+	}
+
+	m := &Manager{
+		opt:  opt,
+		jobs: make(map[string]*Job),
+	}
+	m.preload = m.pullAndMount
+	return m
+}
+
+// Submit starts a job preloading `refs` in background and returns it immediately.
+func (m *Manager) Submit(refs []string) (*Job, error) {
+	if len(refs) == 0 {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "no image to preload")
+	}
+
+	job := &Job{
+		ID:        xid.New().String(),
+		CreatedAt: time.Now(),
+	}
+	for _, ref := range refs {
+		named, err := reference.ParseDockerRef(ref)
+		if err != nil {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid image reference %s: %v", ref, err)
+		}
+		job.Images = append(job.Images, ImageProgress{Image: named.String(), State: StatePending})
+	}
+
+	m.mutex.Lock()
+	m.jobs[job.ID] = job
+	m.pruneLocked()
+	snapshot := copyJob(job)
+	m.mutex.Unlock()
+
+	go m.run(job)
+
+	return snapshot, nil
+}
+
+// Get returns a copy of the job `id` with the latest progress.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "preload job %s", id)
+	}
+	return copyJob(job), nil
+}
+
+func (m *Manager) run(job *Job) {
+	ctx := namespaces.WithNamespace(context.Background(), m.opt.Namespace)
+	start := time.Now()
+
+	var eg errgroup.Group
+	eg.SetLimit(m.opt.Concurrency)
+	for idx := range job.Images {
+		idx := idx
+		ref := job.Images[idx].Image
+		eg.Go(func() error {
+			err := m.preload(ctx, ref, func(s State) { m.setState(job, idx, s, nil) })
+			if err != nil {
+				log.L.WithError(err).Warnf("failed to preload image %s in job %s", ref, job.ID)
+				m.setState(job, idx, StateFailed, err)
+			} else {
+				m.setState(job, idx, StateDone, nil)
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	m.mutex.Lock()
+	job.Done = true
+	log.L.Infof("preload job %s finished in %v, %d of %d images failed",
+		job.ID, time.Since(start), job.Failed, len(job.Images))
+	failed := job.Failed
+	m.mutex.Unlock()
+
+	events.Publish(events.TopicPreload, map[string]string{
+		"job_id": job.ID,
+		"state":  string(StateDone),
+		"failed": strconv.Itoa(failed),
+	})
+}
+
+func (m *Manager) setState(job *Job, idx int, s State, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job.Images[idx].State = s
+	switch s {
+	case StateDone:
+		job.Completed++
+	case StateFailed:
+		job.Failed++
+		job.Images[idx].Error = err.Error()
+	}
+
+	events.Publish(events.TopicPreload, map[string]string{
+		"job_id": job.ID,
+		"image":  job.Images[idx].Image,
+		"state":  string(s),
+		"error":  job.Images[idx].Error,
+	})
+}
+
+// Forget the oldest finished jobs beyond the limit.
+func (m *Manager) pruneLocked() {
+	var finished []*Job
+	for _, j := range m.jobs {
+		if j.Done {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].CreatedAt.Before(finished[j].CreatedAt) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.ID)
+	}
+}
+
+func copyJob(job *Job) *Job {
+	c := *job
+	c.Images = append([]ImageProgress(nil), job.Images...)
+	return &c
+}
+
+func (m *Manager) getClient() (*client.Client, error) {
+	m.clientMutex.Lock()
+	defer m.clientMutex.Unlock()
+
+	if m.client == nil {
+		c, err := client.New(m.opt.ContainerdAddress, client.WithDefaultNamespace(m.opt.Namespace))
+		if err != nil {
+			return nil, errors.Wrapf(err, "connect to containerd %s", m.opt.ContainerdAddress)
+		}
+		m.client = c
+	}
+	return m.client, nil
+}
+
+// Pull the image with the snapshotter as kubelet does, so only metadata of nydus images
+// is fetched, or OCI images are converted if local conversion is enabled. Then viewing
+// the image rootfs makes the snapshotter start nydusd, which prefetches blob data.
+func (m *Manager) pullAndMount(ctx context.Context, ref string, setState func(State)) error {
+	c, err := m.getClient()
+	if err != nil {
+		return err
+	}
+
+	setState(StatePulling)
+	img, err := c.Pull(ctx, ref,
+		client.WithPullUnpack,
+		client.WithPullSnapshotter(m.opt.Snapshotter),
+		client.WithImageHandlerWrapper(backgroundHandlerWrapper(ref)))
+	if err != nil {
+		return errors.Wrapf(err, "pull image %s", ref)
+	}
+
+	setState(StateMounting)
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "get rootfs of image %s", ref)
+	}
+	sn := c.SnapshotService(m.opt.Snapshotter)
+	key := fmt.Sprintf("preload-%s", xid.New().String())
+	if _, err := sn.View(ctx, key, identity.ChainID(diffIDs).String()); err != nil {
+		return errors.Wrapf(err, "view rootfs of image %s", ref)
+	}
+	// The image stays mounted after the view is removed, until its meta layer is removed.
+	if err := sn.Remove(ctx, key); err != nil {
+		log.L.WithError(err).Warnf("failed to remove preload view %s", key)
+	}
+
+	return nil
+}
+
+// Wrap the handler like kubelet does, additionally marking layers to be converted locally
+// in background, so preloading never delays conversion for containers about to start.
+func backgroundHandlerWrapper(ref string) func(f images.Handler) images.Handler {
+	return func(f images.Handler) images.Handler {
+		h := snpkg.AppendInfoHandlerWrapper(ref)(f)
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			children, err := h.Handle(ctx, desc)
+			if err != nil {
+				return nil, err
+			}
+			if images.IsManifestType(desc.MediaType) {
+				for i := range children {
+					if c := &children[i]; images.IsLayerType(c.MediaType) {
+						c.Annotations[label.NydusConversionPriority] = conversion.PriorityBackgroundValue
+					}
+				}
+			}
+			return children, nil
+		})
+	}
+}
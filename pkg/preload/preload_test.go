@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package preload
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerPreload(t *testing.T) {
+	m := NewManager(Opt{Concurrency: 2})
+
+	var running, maxRunning int32
+	m.preload = func(_ context.Context, ref string, setState func(State)) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&maxRunning)
+			if n <= old || atomic.CompareAndSwapInt32(&maxRunning, old, n) {
+				break
+			}
+		}
+
+		setState(StatePulling)
+		time.Sleep(10 * time.Millisecond)
+		if ref == "docker.io/library/broken:latest" {
+			return errors.New("not found")
+		}
+		setState(StateMounting)
+		return nil
+	}
+
+	_, err := m.Submit(nil)
+	require.Error(t, err)
+	_, err = m.Submit([]string{"Invalid:Ref"})
+	require.Error(t, err)
+
+	job, err := m.Submit([]string{"nginx", "busybox:1.36", "broken", "redis"})
+	require.NoError(t, err)
+	require.Len(t, job.Images, 4)
+	require.Equal(t, "docker.io/library/nginx:latest", job.Images[0].Image)
+	require.Equal(t, StatePending, job.Images[0].State)
+
+	require.Eventually(t, func() bool {
+		j, err := m.Get(job.ID)
+		require.NoError(t, err)
+		return j.Done
+	}, 5*time.Second, 10*time.Millisecond)
+
+	j, err := m.Get(job.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, j.Completed)
+	require.Equal(t, 1, j.Failed)
+	require.Equal(t, StateFailed, j.Images[2].State)
+	require.Equal(t, "not found", j.Images[2].Error)
+	require.Equal(t, StateDone, j.Images[3].State)
+	require.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(2))
+
+	_, err = m.Get("unknown")
+	require.Error(t, err)
+}
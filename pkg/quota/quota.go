@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package quota limits disk usage of directories with project quotas, which are
+// supported by XFS and ext4 mounted with the `prjquota` option. Other filesystems
+// have no fallback.
+package quota
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Block device file created in the base directory for quotactl to address the filesystem.
+	backingFsBlockDev = "backingFsBlockDev"
+
+	qGetQuota  = 0x800007
+	qSetQuota  = 0x800008
+	prjQuota   = 2
+	qifBLimits = 1
+	// Quota block limits are in units of 1KiB.
+	quotaBlockSize = 1024
+
+	fsXFlagProjInherit = 0x200
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+)
+
+// struct fsxattr
+type fsXattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// struct if_dqblk
+type dqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+	_          uint32
+}
+
+// Control assigns each directory under the base directory a project ID, whose
+// quota limits the disk usage of the whole directory tree.
+type Control struct {
+	mutex         sync.Mutex
+	backingDev    string
+	nextProjectID uint32
+	quotas        map[string]uint32
+}
+
+// NewControl checks that the filesystem hosting `basePath` supports project quotas.
+// Project IDs already assigned to directories right under `basePath` are not reused.
+func NewControl(basePath string) (*Control, error) {
+	backingDev, err := makeBackingFsDev(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	baseID, err := getProjectID(basePath)
+	if err != nil {
+		return nil, err
+	}
+	q := Control{
+		backingDev:    backingDev,
+		nextProjectID: baseID + 1,
+		quotas:        make(map[string]uint32),
+	}
+
+	// Test if project quota is enabled by setting an unlimited quota on the first free project ID.
+	if err := setProjectQuota(backingDev, q.nextProjectID, 0); err != nil {
+		return nil, errors.Wrapf(err, "project quota is not supported on %s", basePath)
+	}
+
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "scan %s", basePath)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(basePath, e.Name())
+		id, err := getProjectID(dir)
+		if err != nil || id <= baseID {
+			continue
+		}
+		q.quotas[dir] = id
+		if id >= q.nextProjectID {
+			q.nextProjectID = id + 1
+		}
+	}
+
+	return &q, nil
+}
+
+// SetQuota limits disk usage of `targetPath` to `size` bytes, zero means no limit.
+// Existing directories and files under `targetPath` are accounted too, which should
+// be few since renaming across projects fails with EXDEV.
+func (q *Control) SetQuota(targetPath string, size uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	id, ok := q.quotas[targetPath]
+	if !ok {
+		id = q.nextProjectID
+		err := filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+			return setProjectID(path, id)
+		})
+		if err != nil {
+			return err
+		}
+		q.quotas[targetPath] = id
+		q.nextProjectID++
+	}
+
+	log.L.Debugf("set quota of %s with project ID %d to %d bytes", targetPath, id, size)
+	return setProjectQuota(q.backingDev, id, size)
+}
+
+// GetUsage returns bytes and inodes consumed by `targetPath`.
+func (q *Control) GetUsage(targetPath string) (int64, int64, error) {
+	q.mutex.Lock()
+	id, ok := q.quotas[targetPath]
+	q.mutex.Unlock()
+	if !ok {
+		return 0, 0, errors.Errorf("no quota is set for %s", targetPath)
+	}
+
+	var d dqblk
+	if err := quotactl(qGetQuota, q.backingDev, id, &d); err != nil {
+		return 0, 0, errors.Wrapf(err, "get quota of %s", targetPath)
+	}
+
+	return int64(d.curspace), int64(d.curinodes), nil
+}
+
+// ClearQuota lifts the limit of `targetPath` if any.
+func (q *Control) ClearQuota(targetPath string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	id, ok := q.quotas[targetPath]
+	if !ok {
+		return nil
+	}
+	return setProjectQuota(q.backingDev, id, 0)
+}
+
+// Forget the project ID of `targetPath`, which is going to be removed.
+func (q *Control) Forget(targetPath string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.quotas, targetPath)
+}
+
+func makeBackingFsDev(basePath string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(basePath, &st); err != nil {
+		return "", errors.Wrapf(err, "stat %s", basePath)
+	}
+
+	dev := filepath.Join(basePath, backingFsBlockDev)
+	// Recreate it since the backing device may change across reboot.
+	if err := os.Remove(dev); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "remove %s", dev)
+	}
+	if err := unix.Mknod(dev, unix.S_IFBLK|0600, int(st.Dev)); err != nil {
+		return "", errors.Wrapf(err, "create backing block device %s", dev)
+	}
+
+	return dev, nil
+}
+
+func getProjectID(path string) (uint32, error) {
+	var attr fsXattr
+	if err := fsxattrIoctl(path, fsIocFsGetXattr, &attr); err != nil {
+		return 0, errors.Wrapf(err, "get project ID of %s", path)
+	}
+	return attr.projid, nil
+}
+
+// Files created under `path` inherit its project ID.
+func setProjectID(path string, id uint32) error {
+	var attr fsXattr
+	if err := fsxattrIoctl(path, fsIocFsGetXattr, &attr); err != nil {
+		return errors.Wrapf(err, "get project ID of %s", path)
+	}
+	attr.projid = id
+	attr.xflags |= fsXFlagProjInherit
+	if err := fsxattrIoctl(path, fsIocFsSetXattr, &attr); err != nil {
+		return errors.Wrapf(err, "set project ID of %s", path)
+	}
+	return nil
+}
+
+func fsxattrIoctl(path string, req uintptr, attr *fsXattr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(attr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setProjectQuota(backingDev string, id uint32, size uint64) error {
+	limit := (size + quotaBlockSize - 1) / quotaBlockSize
+	d := dqblk{
+		bhardlimit: limit,
+		bsoftlimit: limit,
+		valid:      qifBLimits,
+	}
+	if err := quotactl(qSetQuota, backingDev, id, &d); err != nil {
+		return errors.Wrapf(err, "set quota of project ID %d", id)
+	}
+	return nil
+}
+
+func quotactl(cmd int, backingDev string, id uint32, d *dqblk) error {
+	dev, err := unix.BytePtrFromString(backingDev)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota),
+		uintptr(unsafe.Pointer(dev)), uintptr(id), uintptr(unsafe.Pointer(d)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
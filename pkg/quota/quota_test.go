@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectQuota(t *testing.T) {
+	base := t.TempDir()
+	q, err := NewControl(base)
+	if err != nil {
+		t.Skipf("project quota is not available, %v", err)
+	}
+
+	dir := filepath.Join(base, "1")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fs"), 0755))
+	require.NoError(t, q.SetQuota(dir, 1<<20))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fs", "small"), make([]byte, 4096), 0644))
+	size, inodes, err := q.GetUsage(dir)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, size, int64(4096))
+	require.GreaterOrEqual(t, inodes, int64(3))
+
+	require.Error(t, os.WriteFile(filepath.Join(dir, "fs", "large"), make([]byte, 2<<20), 0644))
+
+	require.NoError(t, q.ClearQuota(dir))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fs", "large"), make([]byte, 2<<20), 0644))
+
+	// Project IDs are recovered after restart.
+	q2, err := NewControl(base)
+	require.NoError(t, err)
+	require.Equal(t, q.quotas[dir], q2.quotas[dir])
+	require.Equal(t, q.nextProjectID, q2.nextProjectID)
+
+	q2.Forget(dir)
+	_, _, err = q2.GetUsage(dir)
+	require.Error(t, err)
+}
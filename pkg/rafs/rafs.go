@@ -23,6 +23,12 @@ import (
 const (
 	AnnoFsCacheDomainID string = "fscache.domainid"
 	AnnoFsCacheID       string = "fscache.id"
+	// Bootstrap located out of the snapshot directory, e.g. merged from locally converted layers.
+	AnnoBootstrapPath string = "nydus.bootstrap"
+	// Served from fully downloaded blobs, no longer depending on the registry.
+	AnnoDetached string = "nydus.detached"
+	// Reads blobs held in the shared blob store since mounted.
+	AnnoSharedBlobs string = "nydus.shared_blobs"
 )
 
 type NewRafsOpt func(r *Rafs) error
@@ -185,6 +191,13 @@ func (r *Rafs) RelaMountpoint() string {
 }
 
 func (r *Rafs) BootstrapFile() (string, error) {
+	if bootstrap, ok := r.Annotations[AnnoBootstrapPath]; ok {
+		if _, err := os.Stat(bootstrap); err != nil {
+			return "", errors.Wrapf(err, "bootstrap %s", bootstrap)
+		}
+		return bootstrap, nil
+	}
+
 	// meta files are stored at <snapshot_id>/fs/image/image.boot
 	bootstrap := filepath.Join(r.SnapshotDir, "fs", "image", "image.boot")
 	_, err := os.Stat(bootstrap)
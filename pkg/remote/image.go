@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+const maxManifestSize = 8 << 20
+
+// FetchImage fetches the manifest of image `ref` for the platform of the node and
+// its config from registry.
+func (remote *Remote) FetchImage(ctx context.Context, ref string) (*ocispec.Manifest, *ocispec.Image, error) {
+	var manifest ocispec.Manifest
+	var imageConfig ocispec.Image
+	handle := func() error {
+		resolver := remote.Resolve(ctx, ref)
+		name, desc, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return errors.Wrap(err, "resolve image")
+		}
+		fetcher, err := resolver.Fetcher(ctx, name)
+		if err != nil {
+			return errors.Wrap(err, "get fetcher")
+		}
+
+		if images.IsIndexType(desc.MediaType) {
+			var index ocispec.Index
+			if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+				return errors.Wrap(err, "fetch image index")
+			}
+			matcher := platforms.Default()
+			found := false
+			for _, m := range index.Manifests {
+				if m.Platform != nil && matcher.Match(*m.Platform) {
+					desc, found = m, true
+					break
+				}
+			}
+			if !found {
+				return errors.Wrapf(errdefs.ErrNotFound, "manifest for platform %s", platforms.DefaultString())
+			}
+		}
+		if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+			return errors.Wrap(err, "fetch image manifest")
+		}
+		if err := fetchJSON(ctx, fetcher, manifest.Config, &imageConfig); err != nil {
+			return errors.Wrap(err, "fetch image config")
+		}
+		return nil
+	}
+
+	err := handle()
+	if err != nil && remote.RetryWithPlainHTTP(ref, err) {
+		err = handle()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &manifest, &imageConfig, nil
+}
+
+// Path of the bootstrap in the meta layer of nydus images.
+const bootstrapNameInLayer = "image/image.boot"
+
+// FetchBootstrap fetches the meta layer of nydus image `ref` for the platform of the
+// node and extracts the bootstrap in it to `target`, once the layer matches its digest.
+func (remote *Remote) FetchBootstrap(ctx context.Context, ref, target string) error {
+	manifest, _, err := remote.FetchImage(ctx, ref)
+	if err != nil {
+		return err
+	}
+	var meta *ocispec.Descriptor
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		if manifest.Layers[i].Annotations[label.NydusMetaLayer] == "true" {
+			meta = &manifest.Layers[i]
+			break
+		}
+	}
+	if meta == nil {
+		return errors.Errorf("no meta layer in image %s, not a nydus image", ref)
+	}
+
+	fetcher, err := remote.Fetcher(ctx, ref)
+	if err != nil {
+		return errors.Wrap(err, "get fetcher")
+	}
+	rc, err := fetcher.Fetch(ctx, *meta)
+	if err != nil {
+		return errors.Wrapf(err, "fetch meta layer %s", meta.Digest)
+	}
+	defer rc.Close()
+
+	verifier := meta.Digest.Verifier()
+	reader := io.TeeReader(rc, verifier)
+	tmp := target + ".tmp"
+	defer os.Remove(tmp)
+	if err := Unpack(reader, bootstrapNameInLayer, tmp); err != nil {
+		return errors.Wrapf(err, "unpack bootstrap from meta layer %s", meta.Digest)
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return errors.Wrapf(err, "read meta layer %s", meta.Digest)
+	}
+	if !verifier.Verified() {
+		return errors.Errorf("digest of meta layer mismatches %s", meta.Digest)
+	}
+	return errors.Wrapf(os.Rename(tmp, target), "rename file %s", tmp)
+}
+
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, v interface{}) error {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+	if err != nil {
+		return err
+	}
+	if desc.Digest != "" && digest.FromBytes(data) != desc.Digest {
+		return errors.Errorf("digest of %s mismatches", desc.Digest)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package remote
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func descriptorOf(mediaType string, data []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+}
+
+// A registry serving image `latest` of any repository.
+func newFakeImageRegistry(t *testing.T, manifest []byte, contents ...[]byte) *httptest.Server {
+	blobs := map[string][]byte{}
+	for _, data := range contents {
+		blobs[digest.FromBytes(data).String()] = data
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var data []byte
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/manifests/latest"),
+			strings.HasSuffix(req.URL.Path, "/manifests/"+digest.FromBytes(manifest).String()):
+			data = manifest
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		case strings.Contains(req.URL.Path, "/blobs/"):
+			var ok bool
+			if data, ok = blobs[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if req.Method == http.MethodGet {
+			_, err := w.Write(data)
+			require.NoError(t, err)
+		}
+	}))
+}
+
+func TestFetchBootstrap(t *testing.T) {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: bootstrapNameInLayer, Mode: 0644, Size: 9}))
+	_, err := tw.Write([]byte("bootstrap"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	config := []byte("{}")
+	meta := descriptorOf(ocispec.MediaTypeImageLayer, layer.Bytes())
+	meta.Annotations = map[string]string{label.NydusMetaLayer: "true"}
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    descriptorOf(ocispec.MediaTypeImageConfig, config),
+		Layers:    []ocispec.Descriptor{meta},
+	})
+	require.NoError(t, err)
+	registry := newFakeImageRegistry(t, manifest, config, layer.Bytes())
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+
+	target := filepath.Join(t.TempDir(), "image.boot")
+	require.NoError(t, New(nil, false).FetchBootstrap(context.TODO(), ref, target))
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "bootstrap", string(data))
+
+	// Images without meta layer are not nydus images.
+	manifest, err = json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    descriptorOf(ocispec.MediaTypeImageConfig, config),
+		Layers:    []ocispec.Descriptor{descriptorOf(ocispec.MediaTypeImageLayer, layer.Bytes())},
+	})
+	require.NoError(t, err)
+	registry = newFakeImageRegistry(t, manifest, config, layer.Bytes())
+	defer registry.Close()
+	ref = strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+	require.Error(t, New(nil, false).FetchBootstrap(context.TODO(), ref, filepath.Join(t.TempDir(), "image.boot")))
+}
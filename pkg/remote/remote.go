@@ -15,6 +15,7 @@ import (
 
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
 	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
 	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes/docker"
 	"github.com/distribution/reference"
@@ -118,6 +119,9 @@ func (remote *Remote) Resolve(_ context.Context, _ string) remotes.Resolver {
 }
 
 func (remote *Remote) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	if err := fault.Inject(fault.PointBlobFetch, ref); err != nil {
+		return nil, err
+	}
 	resolver := remote.Resolve(ctx, ref)
 	fetcher, err := resolver.Fetcher(ctx, ref)
 	if err != nil {
@@ -125,3 +129,18 @@ func (remote *Remote) Fetcher(ctx context.Context, ref string) (remotes.Fetcher,
 	}
 	return fetcher, nil
 }
+
+// UsePlainHTTP requests the registry using http from the start, e.g. a node-local one.
+func (remote *Remote) UsePlainHTTP() *Remote {
+	remote.withPlainHTTP = true
+	return remote
+}
+
+func (remote *Remote) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	resolver := remote.Resolve(ctx, ref)
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pusher")
+	}
+	return pusher, nil
+}
@@ -335,6 +335,19 @@ func (db *Database) AddRafsInstance(_ context.Context, instance *rafs.Rafs) erro
 	})
 }
 
+func (db *Database) UpdateRafsInstance(_ context.Context, instance *rafs.Rafs) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getInstancesBucket(tx)
+
+		var existing rafs.Rafs
+		if err := getObject(bucket, instance.SnapshotID, &existing); err != nil {
+			return err
+		}
+
+		return updateObject(bucket, instance.SnapshotID, instance)
+	})
+}
+
 func (db *Database) DeleteRafsInstance(_ context.Context, snapshotID string) error {
 	return db.db.Update(func(tx *bolt.Tx) error {
 		bucket := getInstancesBucket(tx)
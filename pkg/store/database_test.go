@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -67,6 +69,27 @@ func Test_daemon(t *testing.T) {
 	require.Equal(t, len(ids2), 0)
 }
 
+func TestUpdateRafsInstance(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.TODO()
+
+	r := &rafs.Rafs{SnapshotID: "1", Annotations: map[string]string{}}
+	require.ErrorIs(t, db.UpdateRafsInstance(ctx, r), errdefs.ErrNotFound)
+	require.NoError(t, db.AddRafsInstance(ctx, r))
+	require.Error(t, db.AddRafsInstance(ctx, r))
+
+	r.Annotations["key"] = "value"
+	require.NoError(t, db.UpdateRafsInstance(ctx, r))
+	var instances []*rafs.Rafs
+	require.NoError(t, db.WalkRafsInstances(ctx, func(r *rafs.Rafs) error {
+		instances = append(instances, r)
+		return nil
+	}))
+	require.Len(t, instances, 1)
+	require.Equal(t, "value", instances[0].Annotations["key"])
+}
+
 func TestLegacyRecordsMultipleDaemonModes(t *testing.T) {
 	src, _ := os.Open("testdata/nydus_multiple_compat.db")
 
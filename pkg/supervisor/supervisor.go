@@ -364,6 +364,11 @@ func NewSupervisorSet(root string) (*SupervisorsSet, error) {
 		root: root}, nil
 }
 
+// Directory where the supervisor sockets reside.
+func (ss *SupervisorsSet) Root() string {
+	return ss.root
+}
+
 func (ss *SupervisorsSet) NewSupervisor(id string) *Supervisor {
 	sockPath := filepath.Join(ss.root, fmt.Sprintf("%s.sock", id))
 
@@ -414,5 +419,9 @@ func (ss *SupervisorsSet) DestroySupervisor(id string) error {
 		}
 	}
 
+	if err := os.Remove(supervisor.path); err != nil && !os.IsNotExist(err) {
+		log.L.Warnf("Fail to remove supervisor socket %s, %s", supervisor.path, err)
+	}
+
 	return nil
 }
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package api defines the gRPC variant of the system API of nydus-snapshotter, as
+// described by system.proto, with a Go client of it.
+package api
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type Client struct {
+	conn   *grpc.ClientConn
+	client SystemClient
+}
+
+// NewClient connects to the gRPC system API at `address`, e.g.
+// "unix:///run/containerd-nydus/system-grpc.sock". Connection is established lazily.
+func NewClient(address string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to system API %s", address)
+	}
+	return &Client{conn: conn, client: NewSystemClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) ListDaemons(ctx context.Context) ([]*Daemon, error) {
+	resp, err := c.client.ListDaemons(ctx, &ListDaemonsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Daemons, nil
+}
+
+func (c *Client) GetDaemonBackend(ctx context.Context, id string) (*DaemonBackend, error) {
+	return c.client.GetDaemonBackend(ctx, &GetDaemonBackendRequest{Id: id})
+}
+
+func (c *Client) UpgradeDaemons(ctx context.Context, req *UpgradeDaemonsRequest) error {
+	_, err := c.client.UpgradeDaemons(ctx, req)
+	return err
+}
+
+func (c *Client) BuildPrefetchProfile(ctx context.Context, req *BuildPrefetchProfileRequest) (*PrefetchProfile, error) {
+	return c.client.BuildPrefetchProfile(ctx, req)
+}
+
+// ExportImage writes the OCI image archive of the nydus image to `w`.
+func (c *Client) ExportImage(ctx context.Context, req *ExportImageRequest, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.ExportImage(ctx, req)
+	if err != nil {
+		return err
+	}
+	return receive(stream.Recv, func(resp *ExportImageResponse) error {
+		_, err := w.Write(resp.Data)
+		return err
+	})
+}
+
+func (c *Client) Checkpoint(ctx context.Context, req *CheckpointRequest) (*CheckpointRecord, error) {
+	return c.client.Checkpoint(ctx, req)
+}
+
+// Restore returns true if the image is mounted and served.
+func (c *Client) Restore(ctx context.Context, record *CheckpointRecord) (bool, error) {
+	resp, err := c.client.Restore(ctx, record)
+	if err != nil {
+		return false, err
+	}
+	return resp.Ready, nil
+}
+
+func (c *Client) Preload(ctx context.Context, images []string) (*PreloadJob, error) {
+	return c.client.Preload(ctx, &PreloadRequest{Images: images})
+}
+
+func (c *Client) GetPreloadJob(ctx context.Context, id string) (*PreloadJob, error) {
+	return c.client.GetPreloadJob(ctx, &GetPreloadJobRequest{Id: id})
+}
+
+// WatchPreloadJob calls `fn` with progress of the preload job whenever it changes, until
+// the job is done, `fn` fails or `ctx` is canceled.
+func (c *Client) WatchPreloadJob(ctx context.Context, id string, fn func(*PreloadJob) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.WatchPreloadJob(ctx, &GetPreloadJobRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	return receive(stream.Recv, fn)
+}
+
+// Subscribe calls `fn` with events of `topics`, or all the events if no topic is
+// specified, until `fn` fails or `ctx` is canceled.
+func (c *Client) Subscribe(ctx context.Context, topics []string, fn func(*Event) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.Subscribe(ctx, &SubscribeRequest{Topics: topics})
+	if err != nil {
+		return err
+	}
+	return receive(stream.Recv, fn)
+}
+
+// Handle responses of a server stream until it ends.
+func receive[T any](recv func() (T, error), handle func(T) error) error {
+	for {
+		resp, err := recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := handle(resp); err != nil {
+			return err
+		}
+	}
+}
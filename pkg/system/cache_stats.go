@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+// Effectiveness of blob cache and prefetch of a RAFS instance since it's mounted.
+type instanceCacheStats struct {
+	SnapshotID string `json:"snapshot_id"`
+	ImageID    string `json:"image_id"`
+	// Reads of blob data, and the fraction of them served by blob cache wholly or partially.
+	Reads    uint64  `json:"reads"`
+	HitRatio float64 `json:"hit_ratio"`
+	// Bytes fetched from backend by prefetch.
+	PrefetchBytes uint64 `json:"prefetch_bytes"`
+	// Bytes fetched from backend on demand because they were not prefetched in time, and
+	// the fraction of the bytes fetched from backend that prefetch covers. Both are absent
+	// if nydusd does not serve backend metrics.
+	OnDemandBytes    *uint64  `json:"on_demand_bytes,omitempty"`
+	PrefetchCoverage *float64 `json:"prefetch_coverage,omitempty"`
+	// Why metrics of the instance are not available, e.g. nydusd is restarting.
+	Error string `json:"error,omitempty"`
+}
+
+type daemonCacheStats struct {
+	DaemonID  string               `json:"daemon_id"`
+	Instances []instanceCacheStats `json:"instances"`
+}
+
+// Backend metrics count bytes fetched for both prefetch and reads missing blob cache.
+func computeCacheStats(stats *instanceCacheStats, cache *types.CacheMetrics, backend *types.BackendMetrics) {
+	stats.Reads = cache.Total
+	if cache.Total > 0 {
+		stats.HitRatio = float64(cache.PartialHits+cache.WholeHits) / float64(cache.Total)
+	}
+	stats.PrefetchBytes = cache.PrefetchDataAmount
+	if backend == nil {
+		return
+	}
+
+	var onDemand uint64
+	if backend.ReadAmountTotal > cache.PrefetchDataAmount {
+		onDemand = backend.ReadAmountTotal - cache.PrefetchDataAmount
+	}
+	coverage := float64(0)
+	if fetched := cache.PrefetchDataAmount + onDemand; fetched > 0 {
+		coverage = float64(cache.PrefetchDataAmount) / float64(fetched)
+	}
+	stats.OnDemandBytes = &onDemand
+	stats.PrefetchCoverage = &coverage
+}
+
+func (sc *Controller) findDaemon(id string) *daemon.Daemon {
+	for _, ma := range sc.managers {
+		ma.Lock()
+		d := ma.GetByDaemonID(id)
+		ma.Unlock()
+		if d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func (sc *Controller) daemonCacheStats(id string) (*daemonCacheStats, error) {
+	d := sc.findDaemon(id)
+	if d == nil {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "daemon %s", id)
+	}
+
+	stats := daemonCacheStats{DaemonID: id, Instances: []instanceCacheStats{}}
+	for _, i := range d.RafsCache.List() {
+		s := instanceCacheStats{SnapshotID: i.SnapshotID, ImageID: i.ImageID}
+		var sid string
+		if d.IsSharedDaemon() {
+			sid = i.SnapshotID
+		}
+
+		if d.State() != types.DaemonStateRunning {
+			s.Error = "daemon is " + string(d.State())
+		} else if cache, err := d.GetCacheMetrics(sid); err != nil {
+			s.Error = err.Error()
+		} else {
+			// Older nydusd may not serve backend metrics.
+			backend, _ := d.GetBackendMetrics(sid)
+			computeCacheStats(&s, cache, backend)
+		}
+		stats.Instances = append(stats.Instances, s)
+	}
+	return &stats, nil
+}
+
+func (sc *Controller) getDaemonCacheStats() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := sc.daemonCacheStats(mux.Vars(r)["id"])
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), http.StatusNotFound)
+			return
+		}
+		jsonResponse(w, stats)
+	}
+}
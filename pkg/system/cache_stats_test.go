@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func TestComputeCacheStats(t *testing.T) {
+	cache := &types.CacheMetrics{PartialHits: 10, WholeHits: 70, Total: 100, PrefetchDataAmount: 3 << 20}
+
+	var stats instanceCacheStats
+	computeCacheStats(&stats, cache, &types.BackendMetrics{ReadAmountTotal: 4 << 20})
+	require.Equal(t, uint64(100), stats.Reads)
+	require.InDelta(t, 0.8, stats.HitRatio, 1e-9)
+	require.Equal(t, uint64(3<<20), stats.PrefetchBytes)
+	require.Equal(t, uint64(1<<20), *stats.OnDemandBytes)
+	require.InDelta(t, 0.75, *stats.PrefetchCoverage, 1e-9)
+
+	// Without backend metrics from older nydusd.
+	stats = instanceCacheStats{}
+	computeCacheStats(&stats, cache, nil)
+	require.InDelta(t, 0.8, stats.HitRatio, 1e-9)
+	require.Nil(t, stats.OnDemandBytes)
+	require.Nil(t, stats.PrefetchCoverage)
+
+	// Nothing is read or fetched yet.
+	stats = instanceCacheStats{}
+	computeCacheStats(&stats, &types.CacheMetrics{}, &types.BackendMetrics{})
+	require.Zero(t, stats.HitRatio)
+	require.Zero(t, *stats.OnDemandBytes)
+	require.Zero(t, *stats.PrefetchCoverage)
+}
+
+func TestDaemonCacheStatsNotFound(t *testing.T) {
+	sc := &Controller{}
+	_, err := sc.daemonCacheStats("unknown")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/filesystem"
+	"github.com/containerd/nydus-snapshotter/pkg/preload"
+	"github.com/containerd/nydus-snapshotter/pkg/system/api"
+)
+
+const (
+	// Size of archive data carried by a single message, below the default gRPC limit of 4MiB.
+	exportChunkSize = 1 << 20
+	// Interval to check progress of watched preload jobs.
+	preloadWatchInterval = 500 * time.Millisecond
+)
+
+// Serves the system API over gRPC, as defined by api/system.proto.
+type grpcServer struct {
+	api.UnimplementedSystemServer
+	sc *Controller
+}
+
+// ServeGRPC serves the gRPC variant of the system API on unix socket `sock`, in addition
+// to the HTTP one, until the listener fails.
+func (sc *Controller) ServeGRPC(sock string) error {
+	if err := os.MkdirAll(filepath.Dir(sock), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return errors.Wrapf(err, "listen to socket %s", sock)
+	}
+
+	s := grpc.NewServer()
+	api.RegisterSystemServer(s, &grpcServer{sc: sc})
+	log.L.Infof("Start system controller gRPC server on %s", sock)
+	return errors.Wrap(s.Serve(listener), "system management serving over gRPC")
+}
+
+// Map errors to gRPC status like status codes of the HTTP API.
+func toStatus(err error, code codes.Code) error {
+	switch {
+	case errdefs.IsNotFound(err):
+		code = codes.NotFound
+	case errors.Is(err, errdefs.ErrInvalidArgument):
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, err.Error())
+}
+
+func (s *grpcServer) ListDaemons(context.Context, *api.ListDaemonsRequest) (*api.ListDaemonsResponse, error) {
+	var resp api.ListDaemonsResponse
+	for _, d := range s.sc.listDaemons() {
+		daemon := &api.Daemon{
+			Id:                    d.ID,
+			Pid:                   int64(d.Pid),
+			ApiSocket:             d.APISock,
+			SupervisorPath:        d.SupervisorPath,
+			Reference:             int64(d.Reference),
+			Mountpoint:            d.HostMountpoint,
+			StartupCpuUtilization: d.StartupCPUUtilization,
+			MemoryRssKb:           d.MemoryRSS,
+			ReadDataKb:            float64(d.ReadData),
+		}
+		for _, i := range d.Instances {
+			daemon.Instances = append(daemon.Instances, &api.RafsInstance{
+				SnapshotId:  i.SnapshotID,
+				SnapshotDir: i.SnapshotDir,
+				Mountpoint:  i.Mountpoint,
+				ImageId:     i.ImageID,
+			})
+		}
+		slices.SortFunc(daemon.Instances, func(a, b *api.RafsInstance) int {
+			return compareSnapshotID(a.SnapshotId, b.SnapshotId)
+		})
+		resp.Daemons = append(resp.Daemons, daemon)
+	}
+	return &resp, nil
+}
+
+// Snapshot IDs are sequence numbers.
+func compareSnapshotID(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (s *grpcServer) GetDaemonBackend(_ context.Context, req *api.GetDaemonBackendRequest) (*api.DaemonBackend, error) {
+	backendType, backendConfig, err := s.sc.daemonBackend(req.Id)
+	if err != nil {
+		return nil, toStatus(err, codes.Internal)
+	}
+	config, err := json.Marshal(backendConfig)
+	if err != nil {
+		return nil, toStatus(errors.Wrap(err, "marshal backend configuration"), codes.Internal)
+	}
+	return &api.DaemonBackend{Type: backendType, Config: string(config)}, nil
+}
+
+func (s *grpcServer) UpgradeDaemons(_ context.Context, req *api.UpgradeDaemonsRequest) (*api.UpgradeDaemonsResponse, error) {
+	err := s.sc.upgrade(upgradeRequest{
+		NydusdPath: req.NydusdPath,
+		Version:    req.Version,
+		Policy:     req.Policy,
+	})
+	if err != nil {
+		return nil, toStatus(err, codes.Internal)
+	}
+	return &api.UpgradeDaemonsResponse{}, nil
+}
+
+func (s *grpcServer) BuildPrefetchProfile(_ context.Context, req *api.BuildPrefetchProfileRequest) (*api.PrefetchProfile, error) {
+	profile, err := buildPrefetchProfile(prefetchProfileRequest{
+		Image:  req.Image,
+		Traces: req.Traces,
+		Limit:  int(req.Limit),
+		Apply:  req.Apply,
+	})
+	if err != nil {
+		return nil, toStatus(err, codes.Internal)
+	}
+	return &api.PrefetchProfile{Image: profile.Image, Files: profile.Files, Patterns: profile.Patterns}, nil
+}
+
+func (s *grpcServer) ExportImage(req *api.ExportImageRequest, stream api.System_ExportImageServer) error {
+	if req.SnapshotId == "" && req.Image == "" {
+		return status.Error(codes.InvalidArgument, "either snapshot_id or image is required")
+	}
+
+	w := &chunkWriter{send: func(data []byte) error {
+		return stream.Send(&api.ExportImageResponse{Data: data})
+	}}
+	if _, err := s.sc.fs.ExportImage(stream.Context(), req.SnapshotId, req.Image, w); err != nil {
+		log.L.WithError(err).Errorf("export image %s", req.Image)
+		return toStatus(err, codes.Internal)
+	}
+	return w.flush()
+}
+
+// Buffers written data into messages of exportChunkSize.
+type chunkWriter struct {
+	buf  []byte
+	send func(data []byte) error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		size := min(exportChunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:size]...)
+		p = p[size:]
+		if len(w.buf) == exportChunkSize {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	// Sent messages may be used lazily by gRPC, so the buffer is not reused.
+	err := w.send(w.buf)
+	w.buf = make([]byte, 0, exportChunkSize)
+	return err
+}
+
+func (s *grpcServer) Checkpoint(ctx context.Context, req *api.CheckpointRequest) (*api.CheckpointRecord, error) {
+	record, err := s.sc.fs.Checkpoint(ctx, req.SnapshotId, req.Image, time.Duration(req.Timeout)*time.Second)
+	if err != nil {
+		log.L.WithError(err).Errorf("checkpoint image %s", req.Image)
+		return nil, toStatus(err, codes.Internal)
+	}
+	return &api.CheckpointRecord{
+		ImageId:        record.ImageID,
+		SnapshotId:     record.SnapshotID,
+		FsDriver:       record.FsDriver,
+		LocalBootstrap: record.LocalBootstrap,
+	}, nil
+}
+
+func (s *grpcServer) Restore(ctx context.Context, req *api.CheckpointRecord) (*api.RestoreResponse, error) {
+	record := filesystem.CheckpointRecord{
+		ImageID:        req.ImageId,
+		SnapshotID:     req.SnapshotId,
+		FsDriver:       req.FsDriver,
+		LocalBootstrap: req.LocalBootstrap,
+	}
+	ready, err := s.sc.fs.Restore(ctx, &record)
+	if err != nil {
+		log.L.WithError(err).Errorf("restore image %s", req.ImageId)
+		return nil, toStatus(err, codes.Internal)
+	}
+	return &api.RestoreResponse{Ready: ready}, nil
+}
+
+func (s *grpcServer) Preload(_ context.Context, req *api.PreloadRequest) (*api.PreloadJob, error) {
+	job, err := s.sc.preloader.Submit(req.Images)
+	if err != nil {
+		return nil, toStatus(err, codes.InvalidArgument)
+	}
+	return toPreloadJob(job), nil
+}
+
+func (s *grpcServer) GetPreloadJob(_ context.Context, req *api.GetPreloadJobRequest) (*api.PreloadJob, error) {
+	job, err := s.sc.preloader.Get(req.Id)
+	if err != nil {
+		return nil, toStatus(err, codes.NotFound)
+	}
+	return toPreloadJob(job), nil
+}
+
+func (s *grpcServer) WatchPreloadJob(req *api.GetPreloadJobRequest, stream api.System_WatchPreloadJobServer) error {
+	ticker := time.NewTicker(preloadWatchInterval)
+	defer ticker.Stop()
+
+	var last *preload.Job
+	for {
+		job, err := s.sc.preloader.Get(req.Id)
+		if err != nil {
+			return toStatus(err, codes.NotFound)
+		}
+		if last == nil || !reflect.DeepEqual(job, last) {
+			if err := stream.Send(toPreloadJob(job)); err != nil {
+				return err
+			}
+			last = job
+		}
+		if job.Done {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *grpcServer) Subscribe(req *api.SubscribeRequest, stream api.System_SubscribeServer) error {
+	var topics []events.Topic
+	for _, t := range req.Topics {
+		if !events.Valid(events.Topic(t)) {
+			return status.Errorf(codes.InvalidArgument, "unknown topic %q", t)
+		}
+		topics = append(topics, events.Topic(t))
+	}
+
+	ctx := stream.Context()
+	for ev := range events.Subscribe(ctx, topics...) {
+		if err := stream.Send(&api.Event{Topic: string(ev.Topic), Time: timestamppb.New(ev.Time), Attributes: ev.Attributes}); err != nil {
+			return err
+		}
+	}
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+func toPreloadJob(job *preload.Job) *api.PreloadJob {
+	resp := &api.PreloadJob{
+		Id:        job.ID,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		Completed: int64(job.Completed),
+		Failed:    int64(job.Failed),
+		Done:      job.Done,
+	}
+	for _, i := range job.Images {
+		resp.Images = append(resp.Images, &api.ImageProgress{Image: i.Image, State: string(i.State), Error: i.Error})
+	}
+	return resp
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/preload"
+	"github.com/containerd/nydus-snapshotter/pkg/system/api"
+)
+
+func TestGRPCServer(t *testing.T) {
+	ctx := context.Background()
+	sc := &Controller{preloader: preload.NewManager(preload.Opt{})}
+	sock := filepath.Join(t.TempDir(), "system-grpc.sock")
+	go func() { _ = sc.ServeGRPC(sock) }()
+
+	c, err := api.NewClient("unix://" + sock)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Eventually(t, func() bool {
+		_, err := c.ListDaemons(ctx)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	profile, err := c.BuildPrefetchProfile(ctx, &api.BuildPrefetchProfileRequest{Image: "img"})
+	require.NoError(t, err)
+	require.Equal(t, "img", profile.Image)
+	_, err = c.BuildPrefetchProfile(ctx, &api.BuildPrefetchProfileRequest{Apply: true})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = c.Preload(ctx, nil)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = c.GetPreloadJob(ctx, "missing")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	err = c.WatchPreloadJob(ctx, "missing", func(*api.PreloadJob) error { return nil })
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	err = c.ExportImage(ctx, &api.ExportImageRequest{}, &bytes.Buffer{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = c.Subscribe(ctx, []string{"unknown"}, func(*api.Event) error { return nil })
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	done := make(chan error)
+	go func() {
+		done <- c.Subscribe(ctx, []string{string(events.TopicMount)}, func(ev *api.Event) error {
+			if ev.Attributes["snapshot_id"] != "1" {
+				return errors.Errorf("unexpected event %v", ev)
+			}
+			return context.Canceled
+		})
+	}()
+	// The subscription is set up asynchronously, keep publishing until it's received.
+	require.Eventually(t, func() bool {
+		events.Publish(events.TopicGC, map[string]string{"action": "cleanup_snapshots"})
+		events.Publish(events.TopicMount, map[string]string{"action": "mount", "snapshot_id": "1"})
+		select {
+		case err := <-done:
+			require.ErrorIs(t, err, context.Canceled)
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestChunkWriter(t *testing.T) {
+	var chunks [][]byte
+	w := &chunkWriter{send: func(data []byte) error {
+		chunks = append(chunks, data)
+		return nil
+	}}
+
+	data := bytes.Repeat([]byte("x"), exportChunkSize*2+10)
+	n, err := w.Write(data[:10])
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	_, err = w.Write(data[10:])
+	require.NoError(t, err)
+	require.NoError(t, w.flush())
+
+	require.Len(t, chunks, 3)
+	require.Len(t, chunks[0], exportChunkSize)
+	require.Len(t, chunks[2], 10)
+	require.Equal(t, data, bytes.Join(chunks, nil))
+}
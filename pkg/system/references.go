@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+// SnapshotWalker walks all snapshots of the snapshotter.
+type SnapshotWalker func(ctx context.Context, fn snapshots.WalkFunc) error
+
+type snapshotReference struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+}
+
+// Everything depending on a blob. Snapshots are the layer snapshot of the blob and
+// snapshots on top of it, images are referenced by those snapshots, containers are
+// named by keys of active snapshots, which are container IDs with CRI.
+type blobReferences struct {
+	BlobID     string              `json:"blob_id"`
+	Snapshots  []snapshotReference `json:"snapshots"`
+	Images     []string            `json:"images"`
+	Containers []string            `json:"containers"`
+	// Holders of the blob in the shared blob store.
+	Holders []string `json:"holders,omitempty"`
+}
+
+// Blob IDs are taken with or without the `sha256:` prefix.
+func parseBlobID(id string) (string, error) {
+	if !strings.Contains(id, ":") {
+		id = digest.SHA256.String() + ":" + id
+	}
+	d := digest.Digest(id)
+	if err := d.Validate(); err != nil {
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "blob ID %q", id)
+	}
+	return d.Encoded(), nil
+}
+
+// Keys from containerd are in form of `<namespace>/<sequence>/<key>`.
+func userKey(name string) string {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) != 3 {
+		return name
+	}
+	return parts[2]
+}
+
+func findBlobReferences(blobID string, infos []snapshots.Info) *blobReferences {
+	refs := blobReferences{
+		BlobID:     blobID,
+		Snapshots:  []snapshotReference{},
+		Images:     []string{},
+		Containers: []string{},
+	}
+
+	parents := make(map[string]string, len(infos))
+	for _, info := range infos {
+		parents[info.Name] = info.Parent
+	}
+	layers := map[string]bool{}
+	for _, info := range infos {
+		if d, err := digest.Parse(info.Labels[snpkg.TargetLayerDigestLabel]); err == nil && d.Encoded() == blobID {
+			layers[info.Name] = true
+		}
+	}
+
+	for _, info := range infos {
+		depends := false
+		for name := info.Name; name != "" && !depends; name = parents[name] {
+			depends = layers[name]
+		}
+		if !depends {
+			continue
+		}
+
+		refs.Snapshots = append(refs.Snapshots, snapshotReference{Key: info.Name, Kind: info.Kind.String()})
+		if ref := info.Labels[snpkg.TargetRefLabel]; ref != "" && !slices.Contains(refs.Images, ref) {
+			refs.Images = append(refs.Images, ref)
+		}
+		if info.Kind == snapshots.KindActive {
+			refs.Containers = append(refs.Containers, userKey(info.Name))
+		}
+	}
+
+	slices.SortFunc(refs.Snapshots, func(a, b snapshotReference) int { return strings.Compare(a.Key, b.Key) })
+	slices.Sort(refs.Images)
+	slices.Sort(refs.Containers)
+	return &refs
+}
+
+func (sc *Controller) blobReferences(ctx context.Context, id string) (*blobReferences, error) {
+	blobID, err := parseBlobID(id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.walkSnapshots == nil {
+		return nil, errors.New("snapshots are not available")
+	}
+
+	var infos []snapshots.Info
+	if err := sc.walkSnapshots(ctx, func(_ context.Context, info snapshots.Info) error {
+		infos = append(infos, info)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "walk snapshots")
+	}
+
+	refs := findBlobReferences(blobID, infos)
+	if refs.Holders, err = sc.fs.BlobHolders(blobID); err != nil {
+		return nil, errors.Wrap(err, "get holders of blob")
+	}
+	return refs, nil
+}
+
+func (sc *Controller) getBlobReferences() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refs, err := sc.blobReferences(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if errors.Is(err, errdefs.ErrInvalidArgument) {
+				statusCode = http.StatusBadRequest
+			}
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), statusCode)
+			return
+		}
+		jsonResponse(w, refs)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func TestParseBlobID(t *testing.T) {
+	blobDigest := digest.FromString("blob")
+
+	blobID, err := parseBlobID(blobDigest.String())
+	require.NoError(t, err)
+	require.Equal(t, blobDigest.Encoded(), blobID)
+
+	blobID, err = parseBlobID(blobDigest.Encoded())
+	require.NoError(t, err)
+	require.Equal(t, blobDigest.Encoded(), blobID)
+
+	_, err = parseBlobID("../blob")
+	require.ErrorIs(t, err, errdefs.ErrInvalidArgument)
+}
+
+func TestFindBlobReferences(t *testing.T) {
+	shared := digest.FromString("shared")
+	other := digest.FromString("other")
+	layer := func(name, parent string, d digest.Digest, ref string) snapshots.Info {
+		return snapshots.Info{
+			Kind:   snapshots.KindCommitted,
+			Name:   name,
+			Parent: parent,
+			Labels: map[string]string{snpkg.TargetLayerDigestLabel: d.String(), snpkg.TargetRefLabel: ref},
+		}
+	}
+	infos := []snapshots.Info{
+		layer("k8s.io/1/base", "", shared, "docker.io/library/a:latest"),
+		layer("k8s.io/2/meta", "k8s.io/1/base", other, "docker.io/library/a:latest"),
+		layer("k8s.io/3/other", "", other, "docker.io/library/b:latest"),
+		{Kind: snapshots.KindActive, Name: "k8s.io/4/container-a", Parent: "k8s.io/2/meta"},
+		{Kind: snapshots.KindActive, Name: "k8s.io/5/container-b", Parent: "k8s.io/3/other"},
+		{Kind: snapshots.KindView, Name: "k8s.io/6/view", Parent: "k8s.io/1/base"},
+	}
+
+	refs := findBlobReferences(shared.Encoded(), infos)
+	require.Equal(t, shared.Encoded(), refs.BlobID)
+	require.Equal(t, []snapshotReference{
+		{Key: "k8s.io/1/base", Kind: "Committed"},
+		{Key: "k8s.io/2/meta", Kind: "Committed"},
+		{Key: "k8s.io/4/container-a", Kind: "Active"},
+		{Key: "k8s.io/6/view", Kind: "View"},
+	}, refs.Snapshots)
+	require.Equal(t, []string{"docker.io/library/a:latest"}, refs.Images)
+	require.Equal(t, []string{"container-a"}, refs.Containers)
+
+	refs = findBlobReferences(digest.FromString("unknown").Encoded(), infos)
+	require.Empty(t, refs.Snapshots)
+	require.Empty(t, refs.Images)
+	require.Empty(t, refs.Containers)
+}
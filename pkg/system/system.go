@@ -27,10 +27,14 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
 	"github.com/containerd/nydus-snapshotter/pkg/filesystem"
+	"github.com/containerd/nydus-snapshotter/pkg/health"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
 	metrics "github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
 	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	"github.com/containerd/nydus-snapshotter/pkg/preload"
 )
 
 const (
@@ -42,10 +46,34 @@ const (
 	endpointDaemonRecords  string = "/api/v1/daemons/records"
 	endpointDaemonsUpgrade string = "/api/v1/daemons/upgrade"
 	endpointPrefetch       string = "/api/v1/prefetch"
+	// Merge access recordings of an image into a ranked prefetch list
+	endpointPrefetchProfile string = "/api/v1/prefetch/profile"
 	// Provide backend information
 	endpointGetBackend string = "/api/v1/daemons/{id}/backend"
+	// Cache hit ratio and prefetch coverage of RAFS instances of a daemon
+	endpointDaemonCacheStats string = "/api/v1/daemons/{id}/cache"
+	// Reassemble a nydus image into an OCI image archive
+	endpointImageExport string = "/api/v1/images/export"
+	// Drain and re-establish images around CRIU checkpoint and restore of containers
+	endpointCheckpoint string = "/api/v1/checkpoint"
+	endpointRestore    string = "/api/v1/restore"
+	// Warm up images on the node and query progress of the preload job
+	endpointPreload    string = "/api/v1/preload"
+	endpointPreloadJob string = "/api/v1/preload/{id}"
+	// Inject faults for failover rehearsal, enabled by configuration
+	endpointFaults string = "/api/v1/faults"
+	// Stream state changes as Server-Sent Events, optionally filtered by `topic` queries
+	endpointEvents string = "/api/v1/events"
+	// Liveness and readiness probes, the latter checks components the snapshotter depends on
+	endpointLiveness  string = "/api/v1/health/live"
+	endpointReadiness string = "/api/v1/health/ready"
+	// Snapshots, images and containers depending on a blob
+	endpointBlobReferences string = "/api/v1/blobs/{id}/references"
 )
 
+// Comment lines sent to idle event streams, keeping them from being closed by proxies.
+const eventsKeepAliveInterval = 15 * time.Second
+
 const defaultErrorCode string = "Unknown"
 
 // Nydus-snapshotter might manage dozens of running nydus daemons, each daemon may have multiple
@@ -57,8 +85,11 @@ const defaultErrorCode string = "Unknown"
 // 3. Rolling update
 // 4. Daemons failures record as metrics
 type Controller struct {
-	fs       *filesystem.Filesystem
-	managers []*manager.Manager
+	fs        *filesystem.Filesystem
+	managers  []*manager.Manager
+	preloader *preload.Manager
+	// Snapshots to look up references of blobs in
+	walkSnapshots SnapshotWalker
 	// httpSever *http.Server
 	addr   *net.UnixAddr
 	router *mux.Router
@@ -70,6 +101,45 @@ type upgradeRequest struct {
 	Policy     string `json:"policy"`
 }
 
+type prefetchProfileRequest struct {
+	Image string `json:"image"`
+	// Access recordings persisted by optimizer-nri-plugin, one per container.
+	Traces []string `json:"traces"`
+	// Maximum number of files in the profile, 0 means no limit.
+	Limit int `json:"limit"`
+	// Use the profile as prefetch list for nydusd serving the image afterwards.
+	Apply bool `json:"apply"`
+}
+
+type imageExportRequest struct {
+	// Meta layer snapshot of the image, optional if the image is mounted.
+	SnapshotID string `json:"snapshot_id"`
+	Image      string `json:"image"`
+}
+
+type checkpointRequest struct {
+	SnapshotID string `json:"snapshot_id"`
+	Image      string `json:"image"`
+	// Seconds to wait for in-flight FUSE requests to drain, 0 takes the default.
+	Timeout int `json:"timeout"`
+}
+
+type preloadRequest struct {
+	Images []string `json:"images"`
+}
+
+type restoreResponse struct {
+	// The image is mounted and served, otherwise it is warmed up when mounted.
+	Ready bool `json:"ready"`
+}
+
+type prefetchProfile struct {
+	Image string   `json:"image"`
+	Files []string `json:"files"`
+	// Prefetch patterns for nydus image builder.
+	Patterns string `json:"patterns"`
+}
+
 type errorMessage struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -114,6 +184,7 @@ type daemonInfo struct {
 	StartupCPUUtilization float64 `json:"startup_cpu_utilization"`
 	MemoryRSS             float64 `json:"memory_rss_kb"`
 	ReadData              float32 `json:"read_data_kb"`
+	SandboxID             string  `json:"sandbox_id,omitempty"`
 
 	Instances map[string]rafsInstanceInfo `json:"instances"`
 }
@@ -125,7 +196,8 @@ type rafsInstanceInfo struct {
 	ImageID     string `json:"image_id"`
 }
 
-func NewSystemController(fs *filesystem.Filesystem, managers []*manager.Manager, sock string) (*Controller, error) {
+func NewSystemController(fs *filesystem.Filesystem, managers []*manager.Manager, preloader *preload.Manager,
+	walkSnapshots SnapshotWalker, sock string) (*Controller, error) {
 	if err := os.MkdirAll(filepath.Dir(sock), os.ModePerm); err != nil {
 		return nil, err
 	}
@@ -142,10 +214,12 @@ func NewSystemController(fs *filesystem.Filesystem, managers []*manager.Manager,
 	}
 
 	sc := Controller{
-		fs:       fs,
-		managers: managers,
-		addr:     addr,
-		router:   mux.NewRouter(),
+		fs:            fs,
+		managers:      managers,
+		preloader:     preloader,
+		walkSnapshots: walkSnapshots,
+		addr:          addr,
+		router:        mux.NewRouter(),
 	}
 
 	sc.registerRouter()
@@ -173,7 +247,22 @@ func (sc *Controller) registerRouter() {
 	sc.router.HandleFunc(endpointDaemonsUpgrade, sc.upgradeDaemons()).Methods(http.MethodPut)
 	sc.router.HandleFunc(endpointDaemonRecords, sc.getDaemonRecords()).Methods(http.MethodGet)
 	sc.router.HandleFunc(endpointPrefetch, sc.setPrefetchConfiguration()).Methods(http.MethodPut)
+	sc.router.HandleFunc(endpointPrefetchProfile, sc.buildPrefetchProfile()).Methods(http.MethodPost)
 	sc.router.HandleFunc(endpointGetBackend, sc.getBackend()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointDaemonCacheStats, sc.getDaemonCacheStats()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointImageExport, sc.exportImage()).Methods(http.MethodPost)
+	sc.router.HandleFunc(endpointCheckpoint, sc.checkpoint()).Methods(http.MethodPost)
+	sc.router.HandleFunc(endpointRestore, sc.restore()).Methods(http.MethodPost)
+	sc.router.HandleFunc(endpointPreload, sc.preloadImages()).Methods(http.MethodPost)
+	sc.router.HandleFunc(endpointPreloadJob, sc.getPreloadJob()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointEvents, sc.subscribeEvents()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointBlobReferences, sc.getBlobReferences()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointLiveness, sc.checkLiveness()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointReadiness, sc.checkReadiness()).Methods(http.MethodGet)
+	if fault.Enabled() {
+		sc.router.HandleFunc(endpointFaults, sc.getFaults()).Methods(http.MethodGet)
+		sc.router.HandleFunc(endpointFaults, sc.setFaults()).Methods(http.MethodPut)
+	}
 }
 
 func (sc *Controller) getBackend() func(w http.ResponseWriter, r *http.Request) {
@@ -189,33 +278,40 @@ func (sc *Controller) getBackend() func(w http.ResponseWriter, r *http.Request)
 		}()
 
 		vars := mux.Vars(r)
-		id := vars["id"]
-
-		for _, ma := range sc.managers {
-			ma.Lock()
-			d := ma.GetByDaemonID(id)
-
-			if d != nil {
-				backendType, backendConfig := d.Config.StorageBackend()
-				backend := struct {
-					BackendType string      `json:"type"`
-					Config      interface{} `json:"config"`
-				}{
-					backendType,
-					backendConfig,
-				}
-				jsonResponse(w, backend)
-				ma.Unlock()
-				return
-			}
-			ma.Unlock()
+		var backendType string
+		var backendConfig interface{}
+		backendType, backendConfig, err = sc.daemonBackend(vars["id"])
+		if err != nil {
+			statusCode = http.StatusNotFound
+			return
 		}
 
-		err = errdefs.ErrNotFound
-		statusCode = http.StatusNotFound
+		backend := struct {
+			BackendType string      `json:"type"`
+			Config      interface{} `json:"config"`
+		}{
+			backendType,
+			backendConfig,
+		}
+		jsonResponse(w, backend)
 	}
 }
 
+func (sc *Controller) daemonBackend(id string) (string, interface{}, error) {
+	for _, ma := range sc.managers {
+		ma.Lock()
+		d := ma.GetByDaemonID(id)
+		if d != nil {
+			backendType, backendConfig := d.Config.StorageBackend()
+			ma.Unlock()
+			return backendType, backendConfig, nil
+		}
+		ma.Unlock()
+	}
+
+	return "", nil, errors.Wrapf(errdefs.ErrNotFound, "daemon %s", id)
+}
+
 func (sc *Controller) setPrefetchConfiguration() func(w http.ResponseWriter, r *http.Request) {
 	return func(_ http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
@@ -230,61 +326,419 @@ func (sc *Controller) setPrefetchConfiguration() func(w http.ResponseWriter, r *
 	}
 }
 
-func (sc *Controller) describeDaemons() func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		info := make([]daemonInfo, 0, 10)
+func (sc *Controller) buildPrefetchProfile() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req prefetchProfileRequest
+		var err error
+		var statusCode int
 
-		for _, manager := range sc.managers {
-			daemons := manager.ListDaemons()
-
-			for _, d := range daemons {
-				instances := make(map[string]rafsInstanceInfo)
-				for _, i := range d.RafsCache.List() {
-					instances[i.SnapshotID] = rafsInstanceInfo{
-						SnapshotID:  i.SnapshotID,
-						SnapshotDir: i.SnapshotDir,
-						Mountpoint:  i.GetMountpoint(),
-						ImageID:     i.ImageID,
-					}
-				}
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
+			}
+		}()
 
-				memRSS, err := metrics.GetProcessMemoryRSSKiloBytes(d.Pid())
-				if err != nil {
-					log.L.Warnf("Failed to get daemon %s RSS memory", d.ID())
-				}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
+		}
 
-				var readData float32
-				fsMetrics, err := d.GetFsMetrics("")
-				if err != nil {
-					log.L.Warnf("Failed to get file system metrics")
-				} else {
-					readData = float32(fsMetrics.DataRead) / 1024
-				}
+		var profile *prefetchProfile
+		if profile, err = buildPrefetchProfile(req); err != nil {
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		jsonResponse(w, profile)
+	}
+}
+
+func buildPrefetchProfile(req prefetchProfileRequest) (*prefetchProfile, error) {
+	traces := make([]*prefetch.Trace, 0, len(req.Traces))
+	for _, t := range req.Traces {
+		trace, err := prefetch.ParseTrace(strings.NewReader(t))
+		if err != nil {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "parse trace: %v", err)
+		}
+		traces = append(traces, trace)
+	}
 
-				i := daemonInfo{
-					ID:                    d.ID(),
-					Pid:                   d.Pid(),
-					HostMountpoint:        d.HostMountpoint(),
-					Reference:             int(d.GetRef()),
-					Instances:             instances,
-					StartupCPUUtilization: d.StartupCPUUtilization,
-					MemoryRSS:             memRSS,
-					ReadData:              readData,
+	files := prefetch.MergeTraces(traces, req.Limit)
+	profile := prefetchProfile{
+		Image:    req.Image,
+		Files:    files,
+		Patterns: prefetch.Patterns(files),
+	}
+
+	if req.Apply {
+		if req.Image == "" {
+			return nil, errors.Wrap(errdefs.ErrInvalidArgument, "image is required to apply prefetch profile")
+		}
+		prefetch.Pm.SetImagePrefetchFiles(req.Image, profile.Patterns)
+	}
+
+	return &profile, nil
+}
+
+// The archive is streamed in the response body, e.g. `curl -X POST --unix-socket <system.sock>
+// -d '{"image": "..."}' http://localhost/api/v1/images/export | nerdctl load`.
+func (sc *Controller) exportImage() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req imageExportRequest
+		var err error
+		var statusCode int
+
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
+			}
+		}()
+
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
+		}
+		if req.SnapshotID == "" && req.Image == "" {
+			err = errors.New("either snapshot_id or image is required")
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		aw := &archiveResponseWriter{w: w}
+		if _, err = sc.fs.ExportImage(r.Context(), req.SnapshotID, req.Image, aw); err != nil {
+			log.L.WithError(err).Errorf("export image %s", req.Image)
+			if aw.started {
+				// Too late to report the error with status code, the client sees a truncated archive.
+				err = nil
+				return
+			}
+			statusCode = http.StatusInternalServerError
+			if errdefs.IsNotFound(err) {
+				statusCode = http.StatusNotFound
+			}
+		}
+	}
+}
+
+// Send the response header once the archive starts being written, so that
+// errors before that, e.g. failing to unpack, are still reported with status code.
+type archiveResponseWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (aw *archiveResponseWriter) Write(p []byte) (int, error) {
+	if !aw.started {
+		aw.w.Header().Set("Content-Type", "application/x-tar")
+		aw.w.WriteHeader(http.StatusOK)
+		aw.started = true
+	}
+	return aw.w.Write(p)
+}
+
+// The returned record should be saved with the CRIU images and posted to restore endpoint
+// before the container is restored.
+func (sc *Controller) checkpoint() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req checkpointRequest
+		var err error
+		var statusCode int
+
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
+			}
+		}()
+
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		var record *filesystem.CheckpointRecord
+		record, err = sc.fs.Checkpoint(r.Context(), req.SnapshotID, req.Image, time.Duration(req.Timeout)*time.Second)
+		if err != nil {
+			log.L.WithError(err).Errorf("checkpoint image %s", req.Image)
+			statusCode = http.StatusInternalServerError
+			if errdefs.IsNotFound(err) {
+				statusCode = http.StatusNotFound
+			}
+			return
+		}
+
+		jsonResponse(w, record)
+	}
+}
+
+func (sc *Controller) restore() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var record filesystem.CheckpointRecord
+		var err error
+		var statusCode int
+
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
+			}
+		}()
+
+		if err = json.NewDecoder(r.Body).Decode(&record); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		var ready bool
+		ready, err = sc.fs.Restore(r.Context(), &record)
+		if err != nil {
+			log.L.WithError(err).Errorf("restore image %s", record.ImageID)
+			statusCode = http.StatusInternalServerError
+			if errors.Is(err, errdefs.ErrInvalidArgument) {
+				statusCode = http.StatusBadRequest
+			}
+			return
+		}
+
+		jsonResponse(w, restoreResponse{Ready: ready})
+	}
+}
+
+// Respond with the preload job running in background, whose progress is queried by its ID.
+func (sc *Controller) preloadImages() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req preloadRequest
+		var err error
+		var statusCode int
+
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
+			}
+		}()
+
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		var job *preload.Job
+		job, err = sc.preloader.Submit(req.Images)
+		if err != nil {
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		jsonResponse(w, job)
+	}
+}
+
+func (sc *Controller) getPreloadJob() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		job, err := sc.preloader.Get(vars["id"])
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), http.StatusNotFound)
+			return
+		}
+
+		jsonResponse(w, job)
+	}
+}
+
+// The stream lasts until the client disconnects, e.g. `curl -N --unix-socket <system.sock>
+// 'http://localhost/api/v1/events?topic=daemon&topic=mount'`.
+func (sc *Controller) subscribeEvents() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			m := newErrorMessage("streaming is not supported")
+			http.Error(w, m.encode(), http.StatusInternalServerError)
+			return
+		}
+
+		var topics []events.Topic
+		for _, t := range r.URL.Query()["topic"] {
+			if !events.Valid(events.Topic(t)) {
+				m := newErrorMessage(fmt.Sprintf("unknown topic %q", t))
+				http.Error(w, m.encode(), http.StatusBadRequest)
+				return
+			}
+			topics = append(topics, events.Topic(t))
+		}
+		ch := events.Subscribe(r.Context(), topics...)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(eventsKeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, merr := json.Marshal(ev)
+				if merr != nil {
+					log.L.WithError(merr).Warnf("marshal %s event", ev.Topic)
+					continue
 				}
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Topic, data)
+			case <-ticker.C:
+				_, err = io.WriteString(w, ": keepalive\n\n")
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (sc *Controller) checkLiveness() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Responds 503 with the failed components if the snapshotter is not ready.
+func (sc *Controller) checkReadiness() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := health.Check(r.Context())
+		if report.Ready {
+			jsonResponse(w, report)
+			return
+		}
+		body, err := json.Marshal(report)
+		if err != nil {
+			log.L.Errorf("marshal error, %s", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := w.Write(body); err != nil {
+			log.L.Errorf("write body %s", err)
+		}
+	}
+}
 
-				info = append(info, i)
+func (sc *Controller) getFaults() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		jsonResponse(w, fault.Rules())
+	}
+}
+
+// Replace all fault injection rules, an empty list stops injecting.
+func (sc *Controller) setFaults() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rules []fault.Rule
+		var err error
+		var statusCode int
+
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), statusCode)
 			}
+		}()
+
+		if err = json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			log.L.Errorf("request %v, decode error %s", r, err)
+			statusCode = http.StatusBadRequest
+			return
 		}
 
+		if err = fault.SetRules(rules); err != nil {
+			statusCode = http.StatusBadRequest
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (sc *Controller) describeDaemons() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		info := sc.listDaemons()
 		jsonResponse(w, &info)
 	}
 }
 
-// TODO: Implement me!
+func (sc *Controller) listDaemons() []daemonInfo {
+	info := make([]daemonInfo, 0, 10)
+
+	for _, manager := range sc.managers {
+		daemons := manager.ListDaemons()
+
+		for _, d := range daemons {
+			instances := make(map[string]rafsInstanceInfo)
+			for _, i := range d.RafsCache.List() {
+				instances[i.SnapshotID] = rafsInstanceInfo{
+					SnapshotID:  i.SnapshotID,
+					SnapshotDir: i.SnapshotDir,
+					Mountpoint:  i.GetMountpoint(),
+					ImageID:     i.ImageID,
+				}
+			}
+
+			memRSS, err := metrics.GetProcessMemoryRSSKiloBytes(d.Pid())
+			if err != nil {
+				log.L.Warnf("Failed to get daemon %s RSS memory", d.ID())
+			}
+
+			var readData float32
+			fsMetrics, err := d.GetFsMetrics("")
+			if err != nil {
+				log.L.Warnf("Failed to get file system metrics")
+			} else {
+				readData = float32(fsMetrics.DataRead) / 1024
+			}
+
+			i := daemonInfo{
+				ID:                    d.ID(),
+				Pid:                   d.Pid(),
+				HostMountpoint:        d.HostMountpoint(),
+				Reference:             int(d.GetRef()),
+				Instances:             instances,
+				StartupCPUUtilization: d.StartupCPUUtilization,
+				MemoryRSS:             memRSS,
+				ReadData:              readData,
+				SandboxID:             d.States.SandboxID,
+			}
+
+			info = append(info, i)
+		}
+	}
+
+	return info
+}
+
+// Persisted states of daemons of all managers, as recorded in the database.
 func (sc *Controller) getDaemonRecords() func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		m := newErrorMessage("not implemented")
-		http.Error(w, m.encode(), http.StatusNotImplemented)
+	return func(w http.ResponseWriter, r *http.Request) {
+		records := make([]*daemon.ConfigState, 0, 10)
+		for _, manager := range sc.managers {
+			rs, err := manager.DaemonRecords(r.Context())
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), http.StatusInternalServerError)
+				return
+			}
+			records = append(records, rs...)
+		}
+		jsonResponse(w, records)
 	}
 }
 
@@ -326,35 +780,39 @@ func (sc *Controller) upgradeDaemons() func(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		for _, manager := range sc.managers {
-			manager.Lock()
-			defer manager.Unlock()
-
-			daemons := manager.ListDaemons()
-
-			// TODO: Keep the nydusd executive path in Daemon state and persis it since nydusd
-			// can run on both versions.
-			// Create a dedicated directory storing nydusd of various versions?
-			// TODO: daemon client has a method to query daemon version and information.
-			for _, d := range daemons {
-				err = sc.upgradeNydusDaemon(d, c, manager)
-				if err != nil {
-					log.L.Errorf("Upgrade daemon %s failed, %s", d.ID(), err)
-					statusCode = http.StatusInternalServerError
-					return
-				}
-			}
+		if err = sc.upgrade(c); err != nil {
+			statusCode = http.StatusInternalServerError
+		}
+	}
+}
 
-			// TODO: why renaming?
-			err = os.Rename(c.NydusdPath, manager.NydusdBinaryPath)
-			if err != nil {
-				log.L.Errorf("Rename nydusd binary from %s to  %s failed, %v",
-					c.NydusdPath, manager.NydusdBinaryPath, err)
-				statusCode = http.StatusInternalServerError
-				return
+func (sc *Controller) upgrade(c upgradeRequest) error {
+	for _, manager := range sc.managers {
+		manager.Lock()
+		defer manager.Unlock()
+
+		daemons := manager.ListDaemons()
+
+		// TODO: Keep the nydusd executive path in Daemon state and persis it since nydusd
+		// can run on both versions.
+		// Create a dedicated directory storing nydusd of various versions?
+		// TODO: daemon client has a method to query daemon version and information.
+		for _, d := range daemons {
+			if err := sc.upgradeNydusDaemon(d, c, manager); err != nil {
+				log.L.Errorf("Upgrade daemon %s failed, %s", d.ID(), err)
+				return err
 			}
 		}
+
+		// TODO: why renaming?
+		if err := os.Rename(c.NydusdPath, manager.NydusdBinaryPath); err != nil {
+			log.L.Errorf("Rename nydusd binary from %s to  %s failed, %v",
+				c.NydusdPath, manager.NydusdBinaryPath, err)
+			return err
+		}
 	}
+
+	return nil
 }
 
 // Provide minimal parameters since most of it can be recovered by nydusd states.
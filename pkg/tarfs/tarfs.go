@@ -80,8 +80,11 @@ type snapshotStatus struct {
 	erofsMountPoint string
 	dataLoopdev     *losetup.Device
 	metaLoopdev     *losetup.Device
-	wg              *sync.WaitGroup
-	cancel          context.CancelFunc
+	// Loop device of the exported image disk and dm-verity target over it
+	diskLoopdev  *losetup.Device
+	verityDevice string
+	wg           *sync.WaitGroup
+	cancel       context.CancelFunc
 }
 
 func NewManager(insecure, checkTarfsHint bool, cacheDirPath, nydusImagePath string, maxConcurrentProcess int64) *Manager {
@@ -584,6 +587,14 @@ func (t *Manager) MountTarErofs(snapshotID string, s *storage.Snapshot, labels m
 		return nil
 	}
 
+	// Enforce integrity of the image on host with dm-verity when the hash tree is exported.
+	if wholeImage, exportDisk, withVerity := config.GetTarfsExportFlags(); wholeImage && exportDisk && withVerity {
+		if blockInfo := labels[label.NydusImageBlockInfo]; blockInfo != "" {
+			return t.mountVerityImage(snapshotID, labels[label.NydusTarfsLayer], blockInfo, rafs)
+		}
+		return errors.Errorf("missing dm-verity information of tarfs snapshot %s", snapshotID)
+	}
+
 	mergedBootstrap := t.imageMetaFilePath(upperDirPath)
 	blobInfo, err := t.getImageBlobInfo(mergedBootstrap)
 	if err != nil {
@@ -704,6 +715,23 @@ func (t *Manager) DetachLayer(snapshotID string) error {
 		st.metaLoopdev = nil
 	}
 
+	if st.verityDevice != "" {
+		if err := closeVerityDevice(st.verityDevice); err != nil {
+			st.mutex.Unlock()
+			return errors.Wrapf(err, "close dm-verity device for tarfs snapshot %s", snapshotID)
+		}
+		st.verityDevice = ""
+	}
+
+	if st.diskLoopdev != nil {
+		err := st.diskLoopdev.Detach()
+		if err != nil {
+			st.mutex.Unlock()
+			return errors.Wrapf(err, "detach image disk loopdev for tarfs snapshot %s", snapshotID)
+		}
+		st.diskLoopdev = nil
+	}
+
 	if st.dataLoopdev != nil {
 		err := st.dataLoopdev.Detach()
 		if err != nil {
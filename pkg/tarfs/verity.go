@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tarfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const (
+	veritysetupBinary = "veritysetup"
+	verityMapperDir   = "/dev/mapper"
+	// Parameters of hash trees generated by `nydus-image export --verity`.
+	verityDataBlockSize = 512
+	verityHashBlockSize = 4096
+)
+
+type verityInfo struct {
+	dataBlocks uint64
+	hashOffset uint64
+	rootHash   string
+}
+
+// Parse dm-verity information in block info labels, formatted as `<data blocks>,<hash offset>,sha256:<root hash>`.
+func parseVerityInfo(info string) (*verityInfo, error) {
+	var v verityInfo
+	if count, err := fmt.Sscanf(info, "%d,%d,sha256:%s", &v.dataBlocks, &v.hashOffset, &v.rootHash); err != nil || count != 3 {
+		return nil, errors.Errorf("invalid dm-verity information: %s", info)
+	}
+	if v.dataBlocks == 0 || v.hashOffset < v.dataBlocks*verityDataBlockSize || len(v.rootHash) != 64 {
+		return nil, errors.Errorf("invalid dm-verity information: %s", info)
+	}
+	return &v, nil
+}
+
+func verityDeviceName(snapshotID string) string {
+	return "nydus-tarfs-" + snapshotID
+}
+
+// Create a dm-verity target over `dev`, which holds both data and hash tree. Reading any
+// block not matching the root hash fails with EIO, instead of returning tampered contents.
+func openVerityDevice(name, dev string, v *verityInfo) error {
+	args := []string{
+		"open", dev, name, dev, v.rootHash,
+		"--no-superblock",
+		"--format=1",
+		"--hash=sha256",
+		"-s", "",
+		"--data-block-size=" + strconv.Itoa(verityDataBlockSize),
+		"--hash-block-size=" + strconv.Itoa(verityHashBlockSize),
+		"--data-blocks=" + strconv.FormatUint(v.dataBlocks, 10),
+		"--hash-offset=" + strconv.FormatUint(v.hashOffset, 10),
+	}
+	log.L.Debugf("veritysetup command %v", args)
+
+	var errb bytes.Buffer
+	cmd := exec.Command(veritysetupBinary, args...)
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "open dm-verity device %s on %s, stderr: %s", name, dev, errb.String())
+	}
+	return nil
+}
+
+func closeVerityDevice(name string) error {
+	var errb bytes.Buffer
+	cmd := exec.Command(veritysetupBinary, "close", name)
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "close dm-verity device %s, stderr: %s", name, errb.String())
+	}
+	return nil
+}
+
+// Mount the exported image block device of snapshot `snapshotID` through dm-verity, so that
+// the image contents are verified against the root hash in `blockInfo` on every read.
+func (t *Manager) mountVerityImage(snapshotID, blobID, blockInfo string, rafs *rafs.Rafs) error {
+	v, err := parseVerityInfo(blockInfo)
+	if err != nil {
+		return err
+	}
+
+	st, err := t.getSnapshotStatus(snapshotID, true)
+	if err != nil {
+		return err
+	}
+	defer st.mutex.Unlock()
+
+	mountPoint := path.Join(rafs.GetSnapshotDir(), "mnt")
+	if len(st.erofsMountPoint) > 0 {
+		if st.erofsMountPoint == mountPoint {
+			log.L.Debugf("tarfs for snapshot %s has already been mounted at %s", snapshotID, mountPoint)
+			return nil
+		}
+		return errors.Errorf("tarfs for snapshot %s has already been mounted at %s", snapshotID, st.erofsMountPoint)
+	}
+
+	if st.diskLoopdev == nil {
+		disk := t.ImageDiskFilePath(blobID)
+		loopdev, err := t.attachLoopdev(disk)
+		if err != nil {
+			return errors.Wrapf(err, "attach image disk %s to loopdev", disk)
+		}
+		st.diskLoopdev = loopdev
+	}
+	if st.verityDevice == "" {
+		name := verityDeviceName(snapshotID)
+		if err := openVerityDevice(name, st.diskLoopdev.Path(), v); err != nil {
+			return err
+		}
+		st.verityDevice = name
+	}
+
+	if err = os.MkdirAll(mountPoint, 0750); err != nil {
+		return errors.Wrapf(err, "create tarfs mount dir %s", mountPoint)
+	}
+	devName := filepath.Join(verityMapperDir, st.verityDevice)
+	if err := unix.Mount(devName, mountPoint, "erofs", unix.MS_RDONLY, ""); err != nil {
+		return errors.Wrapf(err, "mount erofs at %s from dm-verity device %s", mountPoint, devName)
+	}
+	st.erofsMountPoint = mountPoint
+	rafs.SetMountpoint(mountPoint)
+
+	log.L.Infof("mounted tarfs for snapshot %s with dm-verity root hash %s", snapshotID, v.rootHash)
+	return nil
+}
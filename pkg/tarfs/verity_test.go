@@ -0,0 +1,37 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tarfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVerityInfo(t *testing.T) {
+	rootHash := strings.Repeat("ab", 32)
+
+	v, err := parseVerityInfo("2048,1048576,sha256:" + rootHash)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2048), v.dataBlocks)
+	require.Equal(t, uint64(1048576), v.hashOffset)
+	require.Equal(t, rootHash, v.rootHash)
+
+	for _, info := range []string{
+		"",
+		"2048,1048576",
+		"2048,1048576,sha512:" + rootHash,
+		"2048,1048576,sha256:abcd",
+		"0,1048576,sha256:" + rootHash,
+		// Hash tree overlaps with data blocks.
+		"2048,4096,sha256:" + rootHash,
+	} {
+		_, err := parseVerityInfo(info)
+		require.Error(t, err, info)
+	}
+}
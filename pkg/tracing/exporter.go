@@ -0,0 +1,437 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+const (
+	defaultServiceName  = "nydus-snapshotter"
+	defaultExportPeriod = 5 * time.Second
+	// Spans beyond it are dropped until exported, e.g. when the collector is unreachable.
+	maxQueuedSpans = 2048
+)
+
+type ExporterOpt struct {
+	// OTLP/HTTP endpoint of traces, e.g. "http://localhost:4318/v1/traces".
+	Endpoint    string
+	ServiceName string
+	// How often to export ended spans in a batch.
+	Period time.Duration
+}
+
+// Provider records spans and exports them to an OpenTelemetry collector by OTLP over HTTP,
+// in JSON encoding.
+type Provider struct {
+	embedded.TracerProvider
+
+	opt    ExporterOpt
+	client *http.Client
+
+	mutex sync.Mutex
+	spans []*span
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// Register creates a Provider exporting spans by `opt` and registers it globally, so
+// that spans of StartSpan are recorded.
+func Register(opt ExporterOpt) (*Provider, error) {
+	p, err := NewProvider(opt)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(p)
+	otel.SetTextMapPropagator(propagator)
+	log.L.Infof("export traces to %s", opt.Endpoint)
+	return p, nil
+}
+
+func NewProvider(opt ExporterOpt) (*Provider, error) {
+	u, err := url.Parse(opt.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("invalid OTLP endpoint %q", opt.Endpoint)
+	}
+	if opt.ServiceName == "" {
+		opt.ServiceName = defaultServiceName
+	}
+	if opt.Period <= 0 {
+		opt.Period = defaultExportPeriod
+	}
+
+	p := &Provider{
+		opt:    opt,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *Provider) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	return &tracer{provider: p, scope: name}
+}
+
+// Shutdown stops exporting after sending spans ended so far.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stopCh) })
+	select {
+	case <-p.doneCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.export(ctx)
+}
+
+func (p *Provider) run() {
+	defer close(p.doneCh)
+
+	ticker := time.NewTicker(p.opt.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.export(context.Background()); err != nil {
+				log.L.WithError(err).Warn("failed to export traces")
+			}
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+func (p *Provider) enqueue(s *span) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.spans) < maxQueuedSpans {
+		p.spans = append(p.spans, s)
+	}
+}
+
+func (p *Provider) export(ctx context.Context) error {
+	p.mutex.Lock()
+	spans := p.spans
+	p.spans = nil
+	p.mutex.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(p.encode(spans))
+	if err != nil {
+		return errors.Wrap(err, "encode spans")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opt.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "send %d spans", len(spans))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("collector answered %d spans with status %s", len(spans), resp.Status)
+	}
+	return nil
+}
+
+// Encode `spans` in the ExportTraceServiceRequest message of OTLP, grouped by tracers.
+func (p *Provider) encode(spans []*span) map[string]any {
+	var scopes []map[string]any
+	indexes := map[string]int{}
+	for _, s := range spans {
+		i, ok := indexes[s.scope]
+		if !ok {
+			i = len(scopes)
+			indexes[s.scope] = i
+			scopes = append(scopes, map[string]any{
+				"scope": map[string]any{"name": s.scope},
+				"spans": []map[string]any{},
+			})
+		}
+		scopes[i]["spans"] = append(scopes[i]["spans"].([]map[string]any), s.encode())
+	}
+
+	return map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": encodeAttributes([]attribute.KeyValue{attribute.String("service.name", p.opt.ServiceName)}),
+			},
+			"scopeSpans": scopes,
+		}},
+	}
+}
+
+type tracer struct {
+	embedded.Tracer
+
+	provider *Provider
+	scope    string
+}
+
+func (t *tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	if cfg.NewRoot() {
+		parent = trace.SpanContext{}
+	}
+
+	scc := trace.SpanContextConfig{TraceFlags: trace.FlagsSampled}
+	if parent.IsValid() {
+		scc.TraceID = parent.TraceID()
+		scc.TraceFlags = parent.TraceFlags()
+		scc.TraceState = parent.TraceState()
+	} else {
+		_, _ = rand.Read(scc.TraceID[:])
+	}
+	_, _ = rand.Read(scc.SpanID[:])
+
+	start := cfg.Timestamp()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &span{
+		provider: t.provider,
+		scope:    t.scope,
+		sc:       trace.NewSpanContext(scc),
+		parent:   parent.SpanID(),
+		name:     name,
+		kind:     cfg.SpanKind(),
+		start:    start,
+		attrs:    cfg.Attributes(),
+	}
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type event struct {
+	name  string
+	time  time.Time
+	attrs []attribute.KeyValue
+}
+
+// Spans of traces not sampled by the parent are not recorded. Links are not exported.
+type span struct {
+	embedded.Span
+
+	provider *Provider
+	scope    string
+	sc       trace.SpanContext
+	parent   trace.SpanID
+	kind     trace.SpanKind
+
+	mutex       sync.Mutex
+	name        string
+	start, end  time.Time
+	attrs       []attribute.KeyValue
+	events      []event
+	status      codes.Code
+	description string
+	ended       bool
+}
+
+func (s *span) End(options ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(options...)
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = cfg.Timestamp()
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+	s.mutex.Unlock()
+
+	if s.sc.IsSampled() {
+		s.provider.enqueue(s)
+	}
+}
+
+func (s *span) AddEvent(name string, options ...trace.EventOption) {
+	cfg := trace.NewEventConfig(options...)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.events = append(s.events, event{name: name, time: cfg.Timestamp(), attrs: cfg.Attributes()})
+	}
+}
+
+func (s *span) AddLink(_ trace.Link) {}
+
+func (s *span) IsRecording() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sc.IsSampled() && !s.ended
+}
+
+func (s *span) RecordError(err error, options ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	options = append(options, trace.WithAttributes(
+		attribute.String("exception.type", fmt.Sprintf("%T", err)),
+		attribute.String("exception.message", err.Error()),
+	))
+	s.AddEvent("exception", options...)
+}
+
+func (s *span) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Ok is final, and descriptions only come with errors.
+	if s.ended || s.status == codes.Ok {
+		return
+	}
+	s.status = code
+	if code == codes.Error {
+		s.description = description
+	}
+}
+
+func (s *span) SetName(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.name = name
+	}
+}
+
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.ended {
+		s.attrs = append(s.attrs, kv...)
+	}
+}
+
+func (s *span) TracerProvider() trace.TracerProvider {
+	return s.provider
+}
+
+func (s *span) encode() map[string]any {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Kinds are numbered alike in OTLP, which has no unspecified kind for spans.
+	kind := s.kind
+	if kind == trace.SpanKindUnspecified {
+		kind = trace.SpanKindInternal
+	}
+	// Unlike OpenTelemetry API, OTLP numbers Ok before Error.
+	status := map[string]any{}
+	switch s.status {
+	case codes.Ok:
+		status["code"] = 1
+	case codes.Error:
+		status["code"] = 2
+		status["message"] = s.description
+	}
+
+	events := make([]map[string]any, 0, len(s.events))
+	for _, e := range s.events {
+		events = append(events, map[string]any{
+			"name":         e.name,
+			"timeUnixNano": strconv.FormatInt(e.time.UnixNano(), 10),
+			"attributes":   encodeAttributes(e.attrs),
+		})
+	}
+
+	encoded := map[string]any{
+		"traceId":           s.sc.TraceID().String(),
+		"spanId":            s.sc.SpanID().String(),
+		"name":              s.name,
+		"kind":              int(kind),
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        encodeAttributes(s.attrs),
+		"events":            events,
+		"status":            status,
+	}
+	if s.parent.IsValid() {
+		encoded["parentSpanId"] = s.parent.String()
+	}
+	return encoded
+}
+
+func encodeAttributes(attrs []attribute.KeyValue) []map[string]any {
+	encoded := make([]map[string]any, 0, len(attrs))
+	for _, kv := range attrs {
+		encoded = append(encoded, map[string]any{"key": string(kv.Key), "value": encodeValue(kv.Value)})
+	}
+	return encoded
+}
+
+// OTLP encodes 64 bit integers by strings in JSON.
+func encodeValue(v attribute.Value) map[string]any {
+	array := func(values []map[string]any) map[string]any {
+		return map[string]any{"arrayValue": map[string]any{"values": values}}
+	}
+	switch v.Type() {
+	case attribute.BOOL:
+		return map[string]any{"boolValue": v.AsBool()}
+	case attribute.INT64:
+		return map[string]any{"intValue": strconv.FormatInt(v.AsInt64(), 10)}
+	case attribute.FLOAT64:
+		return map[string]any{"doubleValue": v.AsFloat64()}
+	case attribute.BOOLSLICE:
+		var values []map[string]any
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, encodeValue(attribute.BoolValue(b)))
+		}
+		return array(values)
+	case attribute.INT64SLICE:
+		var values []map[string]any
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, encodeValue(attribute.Int64Value(i)))
+		}
+		return array(values)
+	case attribute.FLOAT64SLICE:
+		var values []map[string]any
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, encodeValue(attribute.Float64Value(f)))
+		}
+		return array(values)
+	case attribute.STRINGSLICE:
+		var values []map[string]any
+		for _, str := range v.AsStringSlice() {
+			values = append(values, encodeValue(attribute.StringValue(str)))
+		}
+		return array(values)
+	default:
+		return map[string]any{"stringValue": v.Emit()}
+	}
+}
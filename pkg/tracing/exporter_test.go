@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestProviderExport(t *testing.T) {
+	var requests []map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/v1/traces", req.URL.Path)
+		require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		requests = append(requests, body)
+	}))
+	defer collector.Close()
+
+	_, err := NewProvider(ExporterOpt{Endpoint: "localhost:4318"})
+	require.Error(t, err)
+	p, err := NewProvider(ExporterOpt{Endpoint: collector.URL + "/v1/traces", Period: time.Hour})
+	require.NoError(t, err)
+
+	ctx := Extract(metadata.NewIncomingContext(context.Background(), metadata.Pairs(TraceparentHeader, traceparent)))
+	ctx, parent := p.Tracer(tracerName).Start(ctx, "Mount")
+	_, child := p.Tracer(tracerName).Start(ctx, "MountByDaemon")
+	child.SetAttributes(attribute.String("daemon.id", "d1"))
+	EndSpan(child, errors.New("failed"))
+	EndSpan(parent, nil)
+	require.False(t, parent.IsRecording())
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.Len(t, requests, 1)
+
+	resource := requests[0]["resourceSpans"].([]any)[0].(map[string]any)
+	require.Equal(t, "nydus-snapshotter", resource["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)["value"].(map[string]any)["stringValue"])
+	spans := resource["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	require.Len(t, spans, 2)
+	exported := spans[0].(map[string]any)
+	require.Equal(t, "MountByDaemon", exported["name"])
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exported["traceId"])
+	require.Equal(t, parent.SpanContext().SpanID().String(), exported["parentSpanId"])
+	require.Equal(t, float64(2), exported["status"].(map[string]any)["code"])
+	require.Equal(t, "exception", exported["events"].([]any)[0].(map[string]any)["name"])
+	require.Equal(t, "00f067aa0ba902b7", spans[1].(map[string]any)["parentSpanId"])
+
+	// Traces not sampled by containerd are not exported.
+	ctx = Extract(metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")))
+	p, err = NewProvider(ExporterOpt{Endpoint: collector.URL + "/v1/traces", Period: time.Hour})
+	require.NoError(t, err)
+	_, span := p.Tracer(tracerName).Start(ctx, "Mount")
+	require.False(t, span.IsRecording())
+	span.End()
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.Len(t, requests, 1)
+}
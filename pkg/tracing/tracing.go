@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package tracing continues W3C trace context of snapshot operations, sent by
+// containerd along with its gRPC requests, with spans of mounting images and
+// the daemon calls involved, so that a trace of pulling or starting a container
+// covers mounting the image too. Spans are exported by the Provider registered by
+// Register, and only carried into logs otherwise. Nydusd takes no trace context, so
+// its own work is not traced, but logs and spans of mounts pair the trace ID with
+// the daemon ID and the instance ID nydusd serves the image by.
+package tracing
+
+import (
+	"context"
+
+	"github.com/containerd/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header of W3C trace context in gRPC metadata.
+	TraceparentHeader = "traceparent"
+
+	tracerName = "github.com/containerd/nydus-snapshotter"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Adapts gRPC metadata to the carrier of propagators, keys of metadata are lower case.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Extract returns context carrying the trace context in incoming gRPC metadata, whose
+// logger has fields of trace and span IDs.
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
+		"trace_id": sc.TraceID().String(),
+		"span_id":  sc.SpanID().String(),
+	}))
+}
+
+// UnaryServerInterceptor extracts trace context of every request served.
+func UnaryServerInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(Extract(ctx), req)
+}
+
+// TraceID returns ID of the trace `ctx` is in, empty if `ctx` is not traced.
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// StartSpan starts span `name` as a child of the span of `ctx`, which must be ended by
+// EndSpan.
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// EndSpan ends `span`, marking it failed by `err` if not nil.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
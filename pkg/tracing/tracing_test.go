@@ -0,0 +1,34 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestExtract(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TraceparentHeader, traceparent))
+	ctx = Extract(ctx)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(ctx))
+
+	// Spans stay in the trace.
+	spanCtx, span := StartSpan(ctx, "Mount")
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(spanCtx))
+	EndSpan(span, errors.New("failed"))
+
+	// Requests not traced.
+	require.Empty(t, TraceID(Extract(context.Background())))
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(TraceparentHeader, "invalid"))
+	require.Empty(t, TraceID(Extract(ctx)))
+}
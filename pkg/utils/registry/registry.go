@@ -49,6 +49,27 @@ func ParseImage(imageID string) (Image, error) {
 	}, nil
 }
 
+// MatchRegistry checks if the registry host of `imageRef` is one of `registries`,
+// a registry prefixed with "*." matches all of its sub-domains.
+func MatchRegistry(imageRef string, registries []string) bool {
+	image, err := ParseImage(imageRef)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range registries {
+		if suffix, ok := strings.CutPrefix(r, "*"); ok && strings.HasPrefix(suffix, ".") {
+			if strings.HasSuffix(image.Host, suffix) {
+				return true
+			}
+		} else if image.Host == r {
+			return true
+		}
+	}
+
+	return false
+}
+
 func ParseLabels(labels map[string]string) (rRef, rDigest string) {
 	if ref, ok := labels[snpkg.TargetRefLabel]; ok {
 		rRef = ref
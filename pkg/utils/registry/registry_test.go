@@ -112,3 +112,26 @@ func TestParseImage(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchRegistry(t *testing.T) {
+	registries := []string{"localhost:5000", "*.example.com"}
+	tests := []struct {
+		imageRef string
+		want     bool
+	}{
+		{"localhost:5000/foo/bar:latest", true},
+		{"registry.example.com/foo:latest", true},
+		{"a.b.example.com/foo@sha256:6a2b3d6e8b0f1cd1a4db1338b59bbb1af56a4ee6ccd9a43a1f4a5d6d4ee32a2b", true},
+		{"example.com/foo:latest", false},
+		{"busybox:latest", false},
+		{"docker.io/library/busybox:latest", false},
+		{"INVALID", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.imageRef, func(t *testing.T) {
+			if got := MatchRegistry(tt.imageRef, registries); got != tt.want {
+				t.Errorf("MatchRegistry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+// ReleaseImages unmounts RAFS instances of meta snapshots that no snapshot is based on,
+// whose meta layers are not in `referencedLayers`, i.e. used by no image left in any
+// namespace of containerd. They're mounted again by preparing snapshots on them.
+func (o *snapshotter) ReleaseImages(ctx context.Context, referencedLayers map[digest.Digest]struct{}) error {
+	// Snapshots can't be prepared on the meta snapshots until they're unmounted.
+	ctx, t, err := o.ms.TransactionContext(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := t.Rollback(); err != nil {
+			log.L.WithError(err).Warn("failed to rollback transaction")
+		}
+	}()
+
+	ids, err := releasableImages(ctx, referencedLayers)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		log.L.Infof("[ReleaseImages] umount snapshot %s of deleted image", id)
+		if err := o.fs.Umount(ctx, id); err != nil {
+			return errors.Wrapf(err, "umount snapshot %s", id)
+		}
+	}
+	return nil
+}
+
+// releasableImages returns IDs of meta snapshots which ReleaseImages unmounts, in the
+// transaction of `ctx`.
+func releasableImages(ctx context.Context, referencedLayers map[digest.Digest]struct{}) ([]string, error) {
+	ids, err := storage.IDMap(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get snapshot IDs")
+	}
+	keys := make(map[string]string, len(ids))
+	for id, key := range ids {
+		keys[key] = id
+	}
+
+	var candidates []string
+	parents := make(map[string]struct{})
+	if err := storage.WalkInfo(ctx, func(_ context.Context, info snapshots.Info) error {
+		if info.Parent != "" {
+			parents[info.Parent] = struct{}{}
+		}
+		if info.Kind != snapshots.KindCommitted || !label.IsNydusMetaLayer(info.Labels) {
+			return nil
+		}
+		// Images of unknown layers may be still there.
+		layer, err := digest.Parse(info.Labels[snpkg.TargetLayerDigestLabel])
+		if err != nil {
+			return nil
+		}
+		if _, ok := referencedLayers[layer]; !ok {
+			candidates = append(candidates, info.Name)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "walk snapshots")
+	}
+
+	var released []string
+	for _, key := range candidates {
+		if _, ok := parents[key]; ok {
+			continue
+		}
+		if id, ok := keys[key]; ok {
+			released = append(released, id)
+		}
+	}
+	return released, nil
+}
+
+// RemoveLayer removes blob caches of the layer once neither snapshots nor mounted images
+// use its blob. Blobs are read by images of other layers through chunk dictionaries and
+// deduplicated chunks, and contents other than layers have no blob cache.
+func (o *snapshotter) RemoveLayer(ctx context.Context, layer digest.Digest) error {
+	if err := layer.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid layer digest %s", layer)
+	}
+
+	usage, err := o.fs.CacheUsage(ctx, layer.String())
+	if err != nil {
+		return errors.Wrapf(err, "get cache usage of layer %s", layer)
+	}
+	if usage.Size == 0 {
+		return nil
+	}
+
+	inUse := false
+	if err := o.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		inUse = inUse || snapshotUsesBlob(info, layer)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "walk snapshots")
+	}
+	if inUse {
+		return nil
+	}
+	if inUse, err = o.fs.BlobInUse(layer.Encoded()); err != nil || inUse {
+		return errors.Wrapf(err, "check usage of blob %s", layer)
+	}
+
+	return o.fs.RemoveCache(layer.String())
+}
+
+func snapshotUsesBlob(info snapshots.Info, blob digest.Digest) bool {
+	return info.Labels[snpkg.TargetLayerDigestLabel] == blob.String() ||
+		(label.IsNydusGzipWrapped(info.Labels) && info.Labels[label.NydusBlobDigest] == blob.String())
+}
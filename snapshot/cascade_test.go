@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func TestReleasableImages(t *testing.T) {
+	ms, err := storage.NewMetaStore(filepath.Join(t.TempDir(), "metadata.db"))
+	require.NoError(t, err)
+	defer ms.Close()
+
+	ctx, tx, err := ms.TransactionContext(context.TODO(), true)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	ids := map[string]string{}
+	commit := func(name, parent string, labels map[string]string) {
+		s, err := storage.CreateSnapshot(ctx, snapshots.KindActive, name+"-active", parent, snapshots.WithLabels(labels))
+		require.NoError(t, err)
+		_, err = storage.CommitActive(ctx, name+"-active", name, snapshots.Usage{}, snapshots.WithLabels(labels))
+		require.NoError(t, err)
+		ids[name] = s.ID
+	}
+	meta := func(layer digest.Digest) map[string]string {
+		return map[string]string{label.NydusMetaLayer: "true", snpkg.TargetLayerDigestLabel: layer.String()}
+	}
+
+	deleted, shared, busy := digest.FromString("deleted"), digest.FromString("shared"), digest.FromString("busy")
+	commit("deleted", "", meta(deleted))
+	// Another tag or namespace still has the image.
+	commit("shared", "", meta(shared))
+	commit("busy", "", meta(busy))
+	_, err = storage.CreateSnapshot(ctx, snapshots.KindActive, "container", "busy")
+	require.NoError(t, err)
+	commit("unknown", "", map[string]string{label.NydusMetaLayer: "true"})
+	commit("data", "", map[string]string{label.NydusDataLayer: "true", snpkg.TargetLayerDigestLabel: digest.FromString("data").String()})
+
+	released, err := releasableImages(ctx, map[digest.Digest]struct{}{shared: {}})
+	require.NoError(t, err)
+	require.Equal(t, []string{ids["deleted"]}, released)
+}
+
+func TestSnapshotUsesBlob(t *testing.T) {
+	layer, blob := digest.FromString("layer"), digest.FromString("blob")
+	info := snapshots.Info{Labels: map[string]string{
+		snpkg.TargetLayerDigestLabel: layer.String(),
+		label.NydusBlobDigest:        blob.String(),
+	}}
+	require.True(t, snapshotUsesBlob(info, layer))
+	require.False(t, snapshotUsesBlob(info, blob))
+
+	info.Labels[label.NydusGzipWrapped] = "true"
+	require.True(t, snapshotUsesBlob(info, blob))
+	require.False(t, snapshotUsesBlob(info, digest.FromString("other")))
+}
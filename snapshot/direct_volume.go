@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// For VM runtimes, the image of a container is published like a kata direct assigned
+// volume, i.e. what `kata-runtime direct-volume add` writes, so that the kata agent can
+// mount it inside the guest instead of going through the overlay mount on the host.
+// Kata looks volumes up at `<root>/<base64url(volume path)>/mountInfo.json`, the volume
+// path of a container's image is its rootfs in the containerd task bundle, named by the
+// snapshot key which is the container ID with CRI.
+
+const (
+	kataMountInfoFile = "mountInfo.json"
+
+	DirectVolumeBlockType    = "block"
+	DirectVolumeVirtiofsType = "virtiofs"
+
+	// Metadata of the published volume, dm-verity information is encoded as DmVerityInfo in JSON.
+	DirectVolumeMetadataImageID    = "image_id"
+	DirectVolumeMetadataSnapshotID = "snapshot_id"
+	DirectVolumeMetadataDmVerity   = "dm_verity"
+)
+
+// DirectVolumeMountInfo is the mount information of a kata direct assigned volume.
+type DirectVolumeMountInfo struct {
+	VolumeType string            `json:"volume-type"`
+	Device     string            `json:"device"`
+	FsType     string            `json:"fstype"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Options    []string          `json:"options,omitempty"`
+}
+
+const defaultContainerdStateDir = "/run/containerd"
+
+// containerRootfsPath returns where containerd mounts the rootfs of container `id`.
+func containerRootfsPath(stateDir, namespace, id string) string {
+	if stateDir == "" {
+		stateDir = defaultContainerdStateDir
+	}
+	return filepath.Join(stateDir, "io.containerd.runtime.v2.task", namespace, id, "rootfs")
+}
+
+func directVolumePath(root, volumePath string) string {
+	return filepath.Join(root, base64.URLEncoding.EncodeToString([]byte(volumePath)))
+}
+
+func publishDirectVolume(root, volumePath string, info *DirectVolumeMountInfo) error {
+	dir := directVolumePath(root, volumePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "create direct volume directory %s", dir)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "marshal direct volume mount info")
+	}
+
+	// The kata runtime may be reading it, replace the file atomically.
+	path := filepath.Join(dir, kataMountInfoFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrapf(err, "write direct volume mount info %s", tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "rename direct volume mount info to %s", path)
+	}
+	return nil
+}
+
+func removeDirectVolume(root, volumePath string) error {
+	if err := os.RemoveAll(directVolumePath(root, volumePath)); err != nil {
+		return errors.Wrap(err, "remove direct volume")
+	}
+	return nil
+}
+
+// Returns nil if the RAFS instance can't be mounted inside the guest directly.
+func (o *snapshotter) directVolumeMountInfo(r *rafs.Rafs) (*DirectVolumeMountInfo, error) {
+	metadata := map[string]string{
+		DirectVolumeMetadataImageID:    r.ImageID,
+		DirectVolumeMetadataSnapshotID: r.SnapshotID,
+	}
+
+	if blobID, ok := r.Annotations[label.NydusTarfsLayer]; ok {
+		// Only the merged image disk can be assigned as a single block device.
+		info, ok := r.Annotations[label.NydusImageBlockInfo]
+		if !ok {
+			return nil, nil
+		}
+		device, err := o.fs.GetTarfsImageDiskFilePath(blobID)
+		if err != nil {
+			return nil, errors.Wrap(err, "get tarfs image disk file path")
+		}
+		if info != "" {
+			dmverity, err := parseTarfsDmVerityInfo(info)
+			if err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(dmverity)
+			if err != nil {
+				return nil, errors.Wrap(err, "marshal dm-verity information")
+			}
+			metadata[DirectVolumeMetadataDmVerity] = string(data)
+		}
+		return &DirectVolumeMountInfo{
+			VolumeType: DirectVolumeBlockType,
+			Device:     device,
+			FsType:     "erofs",
+			Metadata:   metadata,
+			Options:    []string{"ro"},
+		}, nil
+	}
+
+	// The FUSE mountpoint on host is shared into the guest by virtiofs.
+	if r.GetFsDriver() == config.FsDriverFusedev && r.GetMountpoint() != "" {
+		return &DirectVolumeMountInfo{
+			VolumeType: DirectVolumeVirtiofsType,
+			Device:     r.GetMountpoint(),
+			FsType:     DirectVolumeVirtiofsType,
+			Metadata:   metadata,
+			Options:    []string{"ro"},
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// Publish the RAFS instance `id` as a direct volume for the snapshot `key` of a
+// container in the sandbox labeled on the snapshot.
+func (o *snapshotter) publishKataDirectVolume(ctx context.Context, labels map[string]string, id, key string) error {
+	sandboxID := labels[label.NydusSandboxID]
+	if o.kataDirectVolumeDir == "" || sandboxID == "" {
+		return nil
+	}
+
+	r := rafs.RafsGlobalCache.Get(id)
+	if r == nil {
+		return errors.Errorf("failed to find RAFS instance for snapshot %s", id)
+	}
+	info, err := o.directVolumeMountInfo(r)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		log.G(ctx).Debugf("RAFS instance %s can't be published as direct volume", id)
+		return nil
+	}
+
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return err
+	}
+	volumePath := containerRootfsPath(o.containerdStateDir, ns, key)
+	if err := publishDirectVolume(o.kataDirectVolumeDir, volumePath, info); err != nil {
+		return errors.Wrapf(err, "publish direct volume for snapshot %s", key)
+	}
+	log.G(ctx).Infof("published direct volume %s of sandbox %s at %s", info.Device, sandboxID, volumePath)
+	return nil
+}
+
+// Remove the direct volume published for the snapshot `key`.
+func (o *snapshotter) removeKataDirectVolume(ctx context.Context, key string) error {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return err
+	}
+	return removeDirectVolume(o.kataDirectVolumeDir, containerRootfsPath(o.containerdStateDir, ns, key))
+}
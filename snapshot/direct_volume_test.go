@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+func TestPublishDirectVolume(t *testing.T) {
+	root := t.TempDir()
+	info := &DirectVolumeMountInfo{
+		VolumeType: DirectVolumeBlockType,
+		Device:     "/var/lib/nydus/tarfs/image.disk",
+		FsType:     "erofs",
+		Metadata:   map[string]string{DirectVolumeMetadataImageID: "docker.io/library/busybox:latest"},
+		Options:    []string{"ro"},
+	}
+
+	volumePath := containerRootfsPath("", "k8s.io", "container1")
+	require.Equal(t, "/run/containerd/io.containerd.runtime.v2.task/k8s.io/container1/rootfs", volumePath)
+	require.NoError(t, publishDirectVolume(root, volumePath, info))
+	// Where kata-runtime looks up the volume mounted at `volumePath`.
+	path := filepath.Join(root, base64.URLEncoding.EncodeToString([]byte(volumePath)), kataMountInfoFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(data, &m))
+	require.Equal(t, "block", m["volume-type"])
+	require.Equal(t, "/var/lib/nydus/tarfs/image.disk", m["device"])
+	require.Equal(t, "erofs", m["fstype"])
+
+	other := containerRootfsPath("/run/k3s/containerd", "k8s.io", "container2")
+	require.NoError(t, publishDirectVolume(root, other, info))
+	require.NoError(t, removeDirectVolume(root, volumePath))
+	require.NoFileExists(t, path)
+	require.DirExists(t, directVolumePath(root, other))
+	require.NoError(t, removeDirectVolume(root, other))
+	require.NoDirExists(t, directVolumePath(root, other))
+}
+
+func TestDirectVolumeMountInfo(t *testing.T) {
+	o := &snapshotter{}
+
+	r := &rafs.Rafs{
+		SnapshotID:  "10",
+		ImageID:     "docker.io/library/busybox:latest",
+		FsDriver:    config.FsDriverFusedev,
+		Mountpoint:  "/var/lib/nydus/mnt/10",
+		Annotations: map[string]string{},
+	}
+	info, err := o.directVolumeMountInfo(r)
+	require.NoError(t, err)
+	require.Equal(t, DirectVolumeVirtiofsType, info.VolumeType)
+	require.Equal(t, "/var/lib/nydus/mnt/10", info.Device)
+	require.Equal(t, "10", info.Metadata[DirectVolumeMetadataSnapshotID])
+
+	r.FsDriver = config.FsDriverFscache
+	info, err = o.directVolumeMountInfo(r)
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
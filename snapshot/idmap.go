@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/pkg/errors"
+)
+
+// Containerd asks for ID mapped rootfs of containers in user namespaces by labels
+// `containerd.io/snapshot/uidmapping` and `containerd.io/snapshot/gidmapping`, in the
+// form of "0:<host id>:<size>". Like the overlayfs snapshotter of containerd, the
+// mappings are passed back as `uidmap` and `gidmap` mount options, with which containerd
+// mounts ID mapped lowerdirs, which may be served by nydusd, for the overlay mount.
+
+// Parse the host ID which container root is mapped to. Only mappings of container ID 0
+// are supported, as containerd does.
+func hostID(mapping string) (int, error) {
+	var ctrID, hostID, size int
+	if _, err := fmt.Sscanf(mapping, "%d:%d:%d", &ctrID, &hostID, &size); err != nil {
+		return -1, errors.Wrapf(err, "parse ID mapping %q", mapping)
+	}
+	if ctrID != 0 || hostID < 0 || size <= 0 {
+		return -1, errors.Errorf("invalid ID mapping %q, only mapping of container ID 0 is supported", mapping)
+	}
+	return hostID, nil
+}
+
+// Host UID and GID owning the rootfs of the snapshot, ok is false if no ID mapping is requested.
+func (o *snapshotter) mappedHostIDs(labels map[string]string) (uid, gid int, ok bool, err error) {
+	if !o.enableIDMappedMounts {
+		return -1, -1, false, nil
+	}
+	uidMapping, hasUID := labels[snapshots.LabelSnapshotUIDMapping]
+	gidMapping, hasGID := labels[snapshots.LabelSnapshotGIDMapping]
+	if !hasUID || !hasGID {
+		return -1, -1, false, nil
+	}
+
+	if uid, err = hostID(uidMapping); err != nil {
+		return -1, -1, false, errors.Wrap(err, "UID mapping")
+	}
+	if gid, err = hostID(gidMapping); err != nil {
+		return -1, -1, false, errors.Wrap(err, "GID mapping")
+	}
+	return uid, gid, true, nil
+}
+
+// Append ID mapping options to mounts of active snapshots, so that containerd mounts
+// them ID mapped. Mounts other than overlay and bind ones, e.g. nydus-overlayfs or
+// Kata volumes, are handled by mount helpers taking no ID mappings, so they're left alone.
+func (o *snapshotter) withIDMapping(labels map[string]string, s storage.Snapshot, mounts []mount.Mount) []mount.Mount {
+	if !o.enableIDMappedMounts || s.Kind != snapshots.KindActive {
+		return mounts
+	}
+	uidMapping, hasUID := labels[snapshots.LabelSnapshotUIDMapping]
+	gidMapping, hasGID := labels[snapshots.LabelSnapshotGIDMapping]
+	if !hasUID || !hasGID {
+		return mounts
+	}
+
+	for i := range mounts {
+		if mounts[i].Type == "overlay" || mounts[i].Type == "bind" {
+			mounts[i].Options = append(mounts[i].Options,
+				fmt.Sprintf("uidmap=%s", uidMapping),
+				fmt.Sprintf("gidmap=%s", gidMapping),
+			)
+		}
+	}
+	return mounts
+}
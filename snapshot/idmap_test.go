@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostID(t *testing.T) {
+	id, err := hostID("0:65536:65536")
+	require.NoError(t, err)
+	require.Equal(t, 65536, id)
+
+	for _, mapping := range []string{"", "65536", "1:65536:65536", "0:-1:65536", "0:65536:0"} {
+		_, err := hostID(mapping)
+		require.Error(t, err, mapping)
+	}
+}
+
+func TestWithIDMapping(t *testing.T) {
+	labels := map[string]string{
+		snapshots.LabelSnapshotUIDMapping: "0:65536:65536",
+		snapshots.LabelSnapshotGIDMapping: "0:100000:65536",
+	}
+	active := storage.Snapshot{Kind: snapshots.KindActive}
+	o := &snapshotter{enableIDMappedMounts: true}
+
+	mounts := o.withIDMapping(labels, active, overlayMount([]string{"lowerdir=/lower"}))
+	require.Equal(t, []string{"lowerdir=/lower", "uidmap=0:65536:65536", "gidmap=0:100000:65536"}, mounts[0].Options)
+
+	uid, gid, ok, err := o.mappedHostIDs(labels)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 65536, uid)
+	require.Equal(t, 100000, gid)
+
+	// Views are not mounted ID mapped.
+	mounts = o.withIDMapping(labels, storage.Snapshot{Kind: snapshots.KindView}, bindMount("/upper", "ro"))
+	require.Equal(t, []string{"ro", "rbind"}, mounts[0].Options)
+
+	// Mount helpers are left alone.
+	helperMount := []mount.Mount{{Type: "fuse.nydus-overlayfs", Options: []string{"lowerdir=/lower"}}}
+	mounts = o.withIDMapping(labels, active, helperMount)
+	require.Equal(t, []string{"lowerdir=/lower"}, mounts[0].Options)
+
+	// Mappings are ignored unless enabled.
+	o.enableIDMappedMounts = false
+	mounts = o.withIDMapping(labels, active, overlayMount([]string{"lowerdir=/lower"}))
+	require.Equal(t, []string{"lowerdir=/lower"}, mounts[0].Options)
+	_, _, ok, err = o.mappedHostIDs(labels)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
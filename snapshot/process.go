@@ -8,7 +8,9 @@ package snapshot
 
 import (
 	"context"
+	"maps"
 	"path"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -27,6 +29,8 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 	sn *snapshotter, s storage.Snapshot, key, parent string, labels map[string]string,
 	storageLocater func() string) (_ func() (bool, []mount.Mount, error), target string, err error) {
 	var handler func() (bool, []mount.Mount, error)
+	// Cold start of containers is measured from here.
+	prepareStart := time.Now()
 
 	// Handler to prepare a directory for containerd to download and unpacking layer.
 	defaultHandler := func() (bool, []mount.Mount, error) {
@@ -39,10 +43,19 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 		return true, nil, nil
 	}
 
+	// Sandbox of the container is set on its writable layer, but images are mounted
+	// with labels of their meta layers.
+	sandboxID := labels[label.NydusSandboxID]
+
 	remoteHandler := func(id string, labels map[string]string) func() (bool, []mount.Mount, error) {
 		return func() (bool, []mount.Mount, error) {
 			logger.Debugf("Prepare remote snapshot %s", id)
-			if err := sn.fs.Mount(ctx, id, labels, &s); err != nil {
+			mountLabels := labels
+			if sandboxID != "" {
+				mountLabels = maps.Clone(labels)
+				mountLabels[label.NydusSandboxID] = sandboxID
+			}
+			if err := sn.fs.Mount(ctx, id, mountLabels, &s); err != nil {
 				return false, nil, err
 			}
 
@@ -53,6 +66,9 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 
 			logger.Infof("Nydus remote snapshot %s is ready", id)
 			mounts, err := sn.mountRemote(ctx, labels, s, id, key)
+			if err == nil {
+				sn.fs.ObserveColdStart(id, prepareStart)
+			}
 			return false, mounts, err
 		}
 	}
@@ -68,6 +84,9 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 	if isRoLayer {
 		// Containerd won't consume mount slice for below snapshots
 		switch {
+		case !config.IsImageClaimed(labels[snpkg.TargetRefLabel]):
+			logger.Debugf("image %s is not claimed, unpack it natively", labels[snpkg.TargetRefLabel])
+			handler = defaultHandler
 		case config.GetFsDriver() == config.FsDriverProxy:
 			logger.Debugf("proxy image pull request to other agents")
 			if ref := labels[label.CRILayerDigest]; len(ref) > 0 {
@@ -79,8 +98,19 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 		case label.IsNydusMetaLayer(labels):
 			logger.Debugf("found nydus meta layer")
 			handler = defaultHandler
+			if config.IsLazyBootstrapEnabled() && labels[snpkg.TargetLayerDigestLabel] != "" {
+				// Bootstrap is fetched on mount, the snapshot stays as the meta layer.
+				labels[label.NydusLazyBootstrap] = "true"
+				handler = skipHandler
+			}
 		case label.IsNydusDataLayer(labels):
 			logger.Debugf("found nydus data layer")
+			if label.IsNydusGzipWrapped(labels) {
+				// Nydusd can't fetch the blob from registries holding the wrapped layer only.
+				if err := sn.fs.UnwrapLayer(ctx, s.ID, labels); err != nil {
+					return nil, "", errors.Wrapf(err, "unwrap nydus data layer of snapshot %s", s.ID)
+				}
+			}
 			handler = skipHandler
 		case sn.fs.CheckReferrer(ctx, labels):
 			logger.Debugf("found referenced nydus manifest")
@@ -117,17 +147,28 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 					handler = skipHandler
 				}
 			}
+
+			if handler == nil && sn.fs.LocalConversionEnabled() {
+				// Containerd still unpacks the layer, the converted copy serves later containers.
+				if err := sn.fs.ScheduleLocalConversion(labels); err != nil {
+					logger.Warnf("snapshot ID %s can't be converted locally, err: %v", s.ID, err)
+				}
+			}
 		}
 	} else {
 		// Container writable layer comes into this branch.
 		// It should not be committed during this Prepare() operation.
 
 		pID, pInfo, _, pErr := snapshot.GetSnapshotInfo(ctx, sn.ms, parent)
-		if treatAsProxyDriver(pInfo.Labels) {
+		if pErr == nil && !config.IsImageClaimed(pInfo.Labels[snpkg.TargetRefLabel]) {
+			logger.Debugf("image %s is not claimed, mount snapshot %s natively", pInfo.Labels[snpkg.TargetRefLabel], key)
+			handler = defaultHandler
+		}
+		if handler == nil && treatAsProxyDriver(pInfo.Labels) {
 			logger.Warnf("treat as proxy mode for the prepared snapshot by other snapshotter possibly: id = %s, labels = %v", pID, pInfo.Labels)
 			handler = proxyHandler
 		}
-		if pErr == nil && label.IsNydusProxyMode(pInfo.Labels) {
+		if handler == nil && pErr == nil && label.IsNydusProxyMode(pInfo.Labels) {
 			logger.Infof("Prepare active snapshot %s in proxy mode", key)
 			handler = remoteHandler(pID, pInfo.Labels)
 		}
@@ -173,6 +214,15 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 			logger.Infof("Prepared active snapshot %s in Nydus tarfs mode", key)
 			handler = remoteHandler(pID, pInfo.Labels)
 		}
+
+		if handler == nil && pErr == nil && sn.fs.LocalConversionEnabled() {
+			if err := sn.prepareLocalConversion(ctx, parent, pID, &pInfo); err != nil {
+				logger.Debugf("snapshot %s is not served from local conversion, err: %v", key, err)
+			} else {
+				logger.Infof("Prepare active snapshot %s from locally converted image", key)
+				handler = remoteHandler(pID, pInfo.Labels)
+			}
+		}
 	}
 
 	if handler == nil {
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/containerd/log"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
+)
+
+// Upper bound of nydusd worker threads accepted by configuration validation.
+const maxDaemonThreads = 1024
+
+// Size unset concurrency of snapshotter and nydusd by CPUs available to the cgroup of
+// snapshotter, values set explicitly in configuration are kept.
+func sizeByCgroupLimits(cfg *config.SnapshotterConfig, limits cgroup.Limits) {
+	cpus := limits.AvailableCPUs()
+	log.L.Infof("size by cgroup limits of snapshotter, CPUs %.2f, memory %d bytes, available CPUs %d",
+		limits.CPUs, limits.MemoryBytes, cpus)
+
+	// Go runtime takes all CPUs of the host otherwise.
+	if os.Getenv("GOMAXPROCS") == "" {
+		runtime.GOMAXPROCS(cpus)
+	}
+	if cfg.DaemonConfig.ThreadsNumber == 0 {
+		cfg.DaemonConfig.ThreadsNumber = min(cpus, maxDaemonThreads)
+	}
+	if cfg.Experimental.TarfsConfig.MaxConcurrentProc == 0 {
+		cfg.Experimental.TarfsConfig.MaxConcurrentProc = cpus
+	}
+	// Converting layers is CPU intensive, leave CPUs for serving images.
+	if cfg.Experimental.LocalConversionConfig.MaxConcurrentProc == 0 {
+		cfg.Experimental.LocalConversionConfig.MaxConcurrentProc = max(cpus/2, 1)
+	}
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package snapshot
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
+)
+
+func TestSizeByCgroupLimits(t *testing.T) {
+	t.Setenv("GOMAXPROCS", "")
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	var cfg config.SnapshotterConfig
+	cfg.Experimental.TarfsConfig.MaxConcurrentProc = 8
+	sizeByCgroupLimits(&cfg, cgroup.Limits{CPUs: 1})
+
+	require.Equal(t, 1, runtime.GOMAXPROCS(0))
+	require.Equal(t, 1, cfg.DaemonConfig.ThreadsNumber)
+	require.Equal(t, 8, cfg.Experimental.TarfsConfig.MaxConcurrentProc)
+	require.Equal(t, 1, cfg.Experimental.LocalConversionConfig.MaxConcurrentProc)
+}
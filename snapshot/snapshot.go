@@ -12,14 +12,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/containerd/v2/plugins/snapshots/overlay/overlayutils"
 	"github.com/containerd/continuity/fs"
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/config"
@@ -29,14 +33,27 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
 	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
 	v2 "github.com/containerd/nydus-snapshotter/pkg/cgroup/v2"
+	"github.com/containerd/nydus-snapshotter/pkg/conversion"
+	"github.com/containerd/nydus-snapshotter/pkg/conversion/worker"
+	"github.com/containerd/nydus-snapshotter/pkg/dedup"
+	"github.com/containerd/nydus-snapshotter/pkg/detach"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/fault"
+	"github.com/containerd/nydus-snapshotter/pkg/gc"
+	"github.com/containerd/nydus-snapshotter/pkg/health"
 	mgr "github.com/containerd/nydus-snapshotter/pkg/manager"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+	"github.com/containerd/nydus-snapshotter/pkg/mirror"
 	"github.com/containerd/nydus-snapshotter/pkg/pprof"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	"github.com/containerd/nydus-snapshotter/pkg/preload"
+	"github.com/containerd/nydus-snapshotter/pkg/quota"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/system"
 	"github.com/containerd/nydus-snapshotter/pkg/tarfs"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/parser"
 
 	"github.com/containerd/nydus-snapshotter/pkg/store"
 
@@ -57,8 +74,14 @@ type snapshotter struct {
 	enableNydusOverlayFS bool
 	nydusOverlayFSPath   string
 	enableKataVolume     bool
+	kataDirectVolumeDir  string
+	containerdStateDir   string
 	syncRemove           bool
 	cleanupOnClose       bool
+	quota                *quota.Control
+	writableLayerQuota   uint64
+	enableIDMappedMounts bool
+	stopCascade          context.CancelFunc
 }
 
 func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapshots.Snapshotter, error) {
@@ -67,7 +90,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		return nil, errors.Wrap(err, "initialize image verifier")
 	}
 
-	db, err := store.NewDatabase(cfg.Root)
+	db, err := store.NewDatabase(config.GetStateDir())
 	if err != nil {
 		return nil, errors.Wrap(err, "create database")
 	}
@@ -77,6 +100,15 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		return nil, errors.Wrap(err, "parse recover policy")
 	}
 
+	if cfg.CgroupConfig.AutoSizing {
+		limits, err := cgroup.DetectLimits()
+		if err != nil {
+			log.L.WithError(err).Warn("failed to detect cgroup limits of snapshotter")
+		} else {
+			sizeByCgroupLimits(cfg, limits)
+		}
+	}
+
 	var cgroupMgr *cgroup.Manager
 	if cfg.CgroupConfig.Enable {
 		cgroupConfig, err := config.ParseCgroupConfig(cfg.CgroupConfig)
@@ -102,6 +134,15 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		if err != nil {
 			return nil, errors.Wrap(err, "load daemon configuration")
 		}
+		if encryption := cfg.CacheManagerConfig.Encryption; encryption.Enable {
+			key, err := cache.LoadEncryptionKey(encryption.KeyFile, encryption.KeyCommand)
+			if err != nil {
+				return nil, errors.Wrap(err, "load cache encryption key")
+			}
+			if err := daemonconfig.EnableCacheEncryption(config, key); err != nil {
+				return nil, errors.Wrap(err, "enable cache encryption")
+			}
+		}
 		daemonConfig = &config
 		_, backendConfig := config.StorageBackend()
 		skipSSLVerify = backendConfig.SkipVerify
@@ -115,7 +156,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 			NydusdBinaryPath: "",
 			Database:         db,
 			CacheDir:         cfg.CacheManagerConfig.CacheDir,
-			RootDir:          cfg.Root,
+			SupervisorDir:    config.GetSupervisorDir(),
 			RecoverPolicy:    rp,
 			FsDriver:         config.FsDriverBlockdev,
 			DaemonConfig:     nil,
@@ -132,7 +173,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 			NydusdBinaryPath: cfg.DaemonConfig.NydusdPath,
 			Database:         db,
 			CacheDir:         cfg.CacheManagerConfig.CacheDir,
-			RootDir:          cfg.Root,
+			SupervisorDir:    config.GetSupervisorDir(),
 			RecoverPolicy:    rp,
 			FsDriver:         config.FsDriverFscache,
 			DaemonConfig:     daemonConfig,
@@ -149,7 +190,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 			NydusdBinaryPath: cfg.DaemonConfig.NydusdPath,
 			Database:         db,
 			CacheDir:         cfg.CacheManagerConfig.CacheDir,
-			RootDir:          cfg.Root,
+			SupervisorDir:    config.GetSupervisorDir(),
 			RecoverPolicy:    rp,
 			FsDriver:         config.FsDriverFusedev,
 			DaemonConfig:     daemonConfig,
@@ -166,7 +207,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 			NydusdBinaryPath: "",
 			Database:         db,
 			CacheDir:         cfg.CacheManagerConfig.CacheDir,
-			RootDir:          cfg.Root,
+			SupervisorDir:    config.GetSupervisorDir(),
 			RecoverPolicy:    rp,
 			FsDriver:         config.FsDriverProxy,
 			DaemonConfig:     nil,
@@ -208,12 +249,58 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		filesystem.WithEnableStargz(cfg.Experimental.EnableStargz),
 	}
 
+	supportsDType, err := getSupportsDType(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+	if !supportsDType {
+		return nil, fmt.Errorf("%s does not support d_type. If the backing filesystem is xfs, please reformat with ftype=1 to enable d_type support", cfg.Root)
+	}
+
+	ms, err := storage.NewMetaStore(filepath.Join(cfg.Root, "metadata.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Mkdir(filepath.Join(cfg.Root, "snapshots"), 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	walkSnapshots := func(ctx context.Context, fn snapshots.WalkFunc) error {
+		ctx, t, err := ms.TransactionContext(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := t.Rollback(); err != nil {
+				log.L.WithError(err).Warn("failed to rollback transaction")
+			}
+		}()
+		return storage.WalkInfo(ctx, fn)
+	}
+
 	cacheConfig := &cfg.CacheManagerConfig
+	var tierOpt *cache.TierOpt
+	if cacheConfig.Tier.Enable {
+		tierOpt, err = parseCacheTierConfig(cacheConfig.Tier)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse tiered cache configuration")
+		}
+	}
 	cacheMgr, err := cache.NewManager(cache.Opt{
 		Database: db,
 		Period:   config.GetCacheGCPeriod(),
 		CacheDir: cacheConfig.CacheDir,
 		Disabled: cacheConfig.Disable,
+		Tier:     tierOpt,
+		Retention: &cache.RetentionPolicy{
+			KeepLabels:    cacheConfig.Retention.KeepLabels,
+			KeepLastTags:  cacheConfig.Retention.KeepLastTags,
+			WalkSnapshots: walkSnapshots,
+		},
+		// Blobs are shared only if there is a producer of them.
+		SharedBlobStore: (cacheConfig.SharedBlobStore && cfg.Experimental.LocalConversionConfig.EnableLocalConversion) ||
+			cfg.Experimental.DownloadDetach.Enable,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "create cache manager")
@@ -232,13 +319,114 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		opts = append(opts, filesystem.WithTarfsManager(tarfsMgr))
 	}
 
+	// Shared by conversion publishing converted blobs and detached downloads
+	// fetching blobs from peers.
+	var dedupClient *dedup.Client
+	if di := cfg.Experimental.DedupIndex; di.Address != "" {
+		dedupClient = dedup.NewClient(di.Address, di.NodeAddress)
+	}
+
+	if lc := cfg.Experimental.LocalConversionConfig; lc.EnableLocalConversion {
+		opt := conversion.Opt{
+			Insecure:             skipSSLVerify,
+			WorkDir:              filepath.Join(config.GetWorkDir(), "conversion"),
+			NydusImagePath:       cfg.DaemonConfig.NydusImagePath,
+			MaxConcurrentProcess: lc.MaxConcurrentProc,
+			WorkerAddress:        lc.WorkerAddress,
+			WorkerTLS: worker.TLSOpt{
+				CAFile:   lc.WorkerTLSCA,
+				CertFile: lc.WorkerTLSCert,
+				KeyFile:  lc.WorkerTLSKey,
+			},
+			ContainerdAddress: lc.ContentStoreAddress,
+			ContentNamespace:  lc.ContentStoreNamespace,
+			ContentRoot:       lc.ContentStoreRoot,
+			BlobStore:         cacheMgr.BlobStore(),
+		}
+		if dedupClient != nil {
+			opt.ChunkIndex = dedupClient
+		}
+		if lc.JobTimeout != "" {
+			if opt.JobTimeout, err = time.ParseDuration(lc.JobTimeout); err != nil {
+				return nil, errors.Wrapf(err, "parse local conversion job timeout %q", lc.JobTimeout)
+			}
+		}
+		if lc.MaxLayerSize != "" {
+			if opt.MaxLayerSize, err = parser.MemoryConfigToBytes(lc.MaxLayerSize, 0); err != nil {
+				return nil, errors.Wrapf(err, "parse local conversion max layer size %q", lc.MaxLayerSize)
+			}
+		}
+		conversionMgr, err := conversion.NewManager(opt)
+		if err != nil {
+			return nil, errors.Wrap(err, "create local conversion manager")
+		}
+		opts = append(opts, filesystem.WithConversionManager(conversionMgr))
+	}
+
+	if wm := cfg.RemoteConfig.WriteThroughMirror; wm.Host != "" {
+		m, err := mirror.New(mirror.Opt{
+			Host:          wm.Host,
+			PlainHTTP:     wm.PlainHTTP,
+			Insecure:      config.GetSkipSSLVerify(),
+			MaxConcurrent: wm.MaxConcurrent,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "create write-through mirror")
+		}
+		opts = append(opts, filesystem.WithWriteThroughMirror(m))
+	}
+
+	if dd := cfg.Experimental.DownloadDetach; dd.Enable {
+		detachOpt := detach.Opt{
+			BlobStore:     cacheMgr.BlobStore(),
+			Insecure:      config.GetSkipSSLVerify(),
+			MaxConcurrent: dd.MaxConcurrent,
+			CacheDir:      cacheMgr.CacheDir(),
+		}
+		if dedupClient != nil {
+			detachOpt.ChunkIndex = dedupClient
+		}
+		d, err := detach.New(detachOpt)
+		if err != nil {
+			return nil, errors.Wrap(err, "create detacher")
+		}
+		opts = append(opts, filesystem.WithDetacher(d))
+	}
+
+	if ap := cfg.Experimental.AdaptivePrefetch; ap.EnableAdaptivePrefetch {
+		var period time.Duration
+		if ap.SamplePeriod != "" {
+			if period, err = time.ParseDuration(ap.SamplePeriod); err != nil {
+				return nil, errors.Wrapf(err, "parse adaptive prefetch sample period %q", ap.SamplePeriod)
+			}
+		}
+		policy := prefetch.NewAdaptivePolicy(ap.LowHitRatio, ap.HighHitRatio, ap.MinRequests)
+		opts = append(opts, filesystem.WithAdaptivePrefetch(policy, period))
+	}
+
+	if cfg.MetricsConfig.Address != "" {
+		opts = append(opts, filesystem.WithColdStartMetrics())
+	}
+
 	nydusFs, err := filesystem.NewFileSystem(ctx, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize filesystem thin layer")
 	}
 
+	registerHealthChecks(ms, fsManagers, cacheMgr)
+
 	if config.IsSystemControllerEnabled() {
-		systemController, err := system.NewSystemController(nydusFs, fsManagers, config.SystemControllerAddress())
+		if cfg.Experimental.EnableFaultInjection {
+			fault.Enable()
+		}
+		preloadCfg := config.GetPreloadConfig()
+		preloader := preload.NewManager(preload.Opt{
+			ContainerdAddress: preloadCfg.ContainerdAddress,
+			Namespace:         preloadCfg.Namespace,
+			Snapshotter:       preloadCfg.Snapshotter,
+			Concurrency:       preloadCfg.MaxConcurrentImages,
+		})
+		systemController, err := system.NewSystemController(nydusFs, fsManagers, preloader, walkSnapshots, config.SystemControllerAddress())
 		if err != nil {
 			return nil, errors.Wrap(err, "create system controller")
 		}
@@ -251,6 +439,14 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 
 		log.L.Infof("Started system controller on %q", config.SystemControllerAddress())
 
+		if grpcAddress := config.SystemControllerGRPCAddress(); grpcAddress != "" {
+			go func() {
+				if err := systemController.ServeGRPC(grpcAddress); err != nil {
+					log.L.WithError(err).Error("Failed to serve system controller over gRPC")
+				}
+			}()
+		}
+
 		pprofAddress := config.SystemControllerPprofAddress()
 		if pprofAddress != "" {
 			if err := pprof.NewPprofHTTPListener(pprofAddress); err != nil {
@@ -261,21 +457,25 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		}
 	}
 
-	supportsDType, err := getSupportsDType(cfg.Root)
-	if err != nil {
-		return nil, err
-	}
-	if !supportsDType {
-		return nil, fmt.Errorf("%s does not support d_type. If the backing filesystem is xfs, please reformat with ftype=1 to enable d_type support", cfg.Root)
-	}
-
-	ms, err := storage.NewMetaStore(filepath.Join(cfg.Root, "metadata.db"))
-	if err != nil {
-		return nil, err
+	var quotaCtl *quota.Control
+	var writableLayerQuota uint64
+	if cfg.SnapshotsConfig.WritableLayerQuota != "" {
+		size, err := parser.MemoryConfigToBytes(cfg.SnapshotsConfig.WritableLayerQuota, 0)
+		if err != nil || size <= 0 {
+			return nil, errors.Errorf("invalid writable layer quota %q", cfg.SnapshotsConfig.WritableLayerQuota)
+		}
+		writableLayerQuota = uint64(size)
+		if quotaCtl, err = quota.NewControl(filepath.Join(cfg.Root, "snapshots")); err != nil {
+			return nil, errors.Wrap(err, "enable writable layer quota, which needs root on XFS or ext4 with project quota")
+		}
 	}
 
-	if err := os.Mkdir(filepath.Join(cfg.Root, "snapshots"), 0700); err != nil && !os.IsExist(err) {
-		return nil, err
+	if cfg.SnapshotsConfig.EnableIDMappedMounts {
+		if ok, err := overlayutils.SupportsIDMappedMounts(); err != nil {
+			return nil, errors.Wrap(err, "check support of ID mapped mounts")
+		} else if !ok {
+			return nil, errors.New("kernel doesn't support ID mapped mounts")
+		}
 	}
 
 	syncRemove := cfg.SnapshotsConfig.SyncRemove
@@ -284,7 +484,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		syncRemove = true
 	}
 
-	return &snapshotter{
+	o := &snapshotter{
 		root:                 cfg.Root,
 		nydusdPath:           cfg.DaemonConfig.NydusdPath,
 		ms:                   ms,
@@ -294,7 +494,78 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		enableNydusOverlayFS: cfg.SnapshotsConfig.EnableNydusOverlayFS,
 		nydusOverlayFSPath:   cfg.SnapshotsConfig.NydusOverlayFSPath,
 		enableKataVolume:     cfg.SnapshotsConfig.EnableKataVolume,
+		kataDirectVolumeDir:  cfg.SnapshotsConfig.KataDirectVolumeDir,
+		containerdStateDir:   cfg.SnapshotsConfig.ContainerdStateDir,
 		cleanupOnClose:       cfg.CleanupOnClose,
+		quota:                quotaCtl,
+		writableLayerQuota:   writableLayerQuota,
+		enableIDMappedMounts: cfg.SnapshotsConfig.EnableIDMappedMounts,
+	}
+
+	if cc := cfg.Experimental.CascadeCleanup; cc.Enable {
+		cascade := gc.NewCascade(gc.CascadeOpt{
+			ContainerdAddress: cc.ContainerdAddress,
+			Snapshotter:       cc.Snapshotter,
+		}, o)
+		var cascadeCtx context.Context
+		cascadeCtx, o.stopCascade = context.WithCancel(context.Background())
+		go cascade.Run(cascadeCtx)
+		log.L.Info("Started cascade cleanup driven by containerd events")
+	}
+
+	return o, nil
+}
+
+// Checks backing readiness probes of the snapshotter.
+func registerHealthChecks(ms *storage.MetaStore, managers []*mgr.Manager, cacheMgr *cache.Manager) {
+	health.Register("metadata", func(ctx context.Context) error {
+		ctx, t, err := ms.TransactionContext(ctx, false)
+		if err != nil {
+			return errors.Wrap(err, "open metadata store transaction")
+		}
+		defer func() {
+			if err := t.Rollback(); err != nil {
+				log.L.WithError(err).Warn("failed to rollback transaction")
+			}
+		}()
+		_, err = storage.IDMap(ctx)
+		return errors.Wrap(err, "read metadata store")
+	})
+	health.Register("daemons", func(ctx context.Context) error {
+		for _, m := range managers {
+			if err := m.CheckHealth(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	health.Register("cache", func(context.Context) error {
+		return cacheMgr.CheckHealth()
+	})
+}
+
+func parseCacheTierConfig(c config.CacheTierConfig) (*cache.TierOpt, error) {
+	fastLimit, err := parser.MemoryConfigToBytes(c.FastTierSize, 0)
+	if err != nil || fastLimit <= 0 {
+		return nil, errors.Errorf("invalid fast tier size %q", c.FastTierSize)
+	}
+	slowLimit, err := parser.MemoryConfigToBytes(c.SlowTierSize, 0)
+	if err != nil {
+		return nil, errors.Errorf("invalid slow tier size %q", c.SlowTierSize)
+	}
+
+	var period time.Duration
+	if c.RebalancePeriod != "" {
+		if period, err = time.ParseDuration(c.RebalancePeriod); err != nil {
+			return nil, errors.Errorf("invalid rebalance period %q", c.RebalancePeriod)
+		}
+	}
+
+	return &cache.TierOpt{
+		SlowDir:   c.SlowTierDir,
+		FastLimit: fastLimit,
+		SlowLimit: slowLimit,
+		Period:    period,
 	}, nil
 }
 
@@ -316,6 +587,22 @@ func (o *snapshotter) Cleanup(ctx context.Context) error {
 			log.L.WithError(err).Warnf("failed to remove directory %s", dir)
 		}
 	}
+	events.Publish(events.TopicGC, map[string]string{"action": "cleanup_snapshots", "count": strconv.Itoa(len(cleanup))})
+
+	// Layers of remaining snapshots keep their bootstraps and blobs converted locally.
+	layers := make(map[digest.Digest]struct{})
+	if err := o.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		if d, err := digest.Parse(info.Labels[snpkg.TargetLayerDigestLabel]); err == nil {
+			layers[d] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "walk snapshots for sweeping")
+	}
+	if err := o.fs.Sweep(ctx, layers); err != nil {
+		log.L.WithError(err).Warn("failed to sweep stale files")
+	}
+
 	return nil
 }
 
@@ -336,6 +623,13 @@ func (o *snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, e
 
 	switch info.Kind {
 	case snapshots.KindActive:
+		if o.quota != nil {
+			// Project quota accounts the usage without walking through the directory.
+			if size, inodes, err := o.quota.GetUsage(o.snapshotDir(id)); err == nil {
+				usage = snapshots.Usage{Size: size, Inodes: inodes}
+				break
+			}
+		}
 		upperPath := o.upperPath(id)
 		du, err := fs.DiskUsage(ctx, upperPath)
 		if err != nil {
@@ -408,9 +702,13 @@ func (o *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, er
 					if err = o.fs.WaitUntilReady(pID); err != nil {
 						return nil, errors.Wrapf(err, "mounts: snapshot %s is not ready, err: %v", pID, err)
 					}
+					if err = o.fs.WaitUntilWarm(ctx, pID, pInfo.Labels); err != nil {
+						return nil, errors.Wrapf(err, "mounts: warm up snapshot %s", pID)
+					}
 					needRemoteMounts = true
 					metaSnapshotID = pID
-				} else if (o.fs.TarfsEnabled() && label.IsTarfsDataLayer(pInfo.Labels)) || label.IsNydusProxyMode(pInfo.Labels) {
+				} else if (o.fs.TarfsEnabled() && label.IsTarfsDataLayer(pInfo.Labels)) || label.IsNydusProxyMode(pInfo.Labels) ||
+					(o.fs.LocalConversionEnabled() && label.IsNydusLocalConversion(pInfo.Labels)) {
 					needRemoteMounts = true
 					metaSnapshotID = pID
 				}
@@ -546,6 +844,13 @@ func (o *snapshotter) View(ctx context.Context, key, parent string, opts ...snap
 func (o *snapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
 	log.L.Debugf("[Commit] snapshot with key %s", key)
 
+	// Pack the layer before entering the write transaction since it may take long.
+	if commitOpt, err := o.commitNydusLayer(ctx, key, opts); err != nil {
+		return errors.Wrapf(err, "commit snapshot %s as nydus layer", key)
+	} else if commitOpt != nil {
+		opts = append(opts, commitOpt)
+	}
+
 	ctx, t, err := o.ms.TransactionContext(ctx, true)
 	if err != nil {
 		return err
@@ -578,6 +883,13 @@ func (o *snapshotter) Commit(ctx context.Context, name, key string, opts ...snap
 		return errors.Wrapf(err, "commit active snapshot %s", key)
 	}
 
+	if o.quota != nil {
+		// Committed snapshots are read-only, so they are never limited.
+		if err = o.quota.ClearQuota(o.snapshotDir(id)); err != nil {
+			return errors.Wrapf(err, "clear quota of snapshot %s", id)
+		}
+	}
+
 	// Let rollback catch the commit error
 	err = t.Commit()
 	if err != nil {
@@ -622,11 +934,38 @@ func (o *snapshotter) Remove(ctx context.Context, key string) error {
 		log.L.Infof("[Remove] snapshot with key %s snapshot id %s", key, id)
 	}
 
+	if info.Labels[label.NydusSandboxID] != "" && o.kataDirectVolumeDir != "" {
+		defer func() {
+			if err == nil {
+				if err := o.removeKataDirectVolume(ctx, key); err != nil {
+					log.G(ctx).WithError(err).Warnf("failed to remove direct volume for snapshot %s", key)
+				}
+			}
+		}()
+	}
+
+	if label.IsNydusGzipWrapped(info.Labels) {
+		defer func() {
+			if err == nil {
+				o.fs.ReleaseUnwrappedLayer(id)
+			}
+		}()
+	}
+
 	if info.Kind == snapshots.KindCommitted {
 		blobDigest := info.Labels[snpkg.TargetLayerDigestLabel]
-		go func() {
-			if err := o.fs.RemoveCache(blobDigest); err != nil {
-				log.L.WithError(err).Errorf("Failed to remove cache %s", blobDigest)
+		if label.IsNydusGzipWrapped(info.Labels) {
+			// Blob caches are named after the unwrapped blob.
+			blobDigest = info.Labels[label.NydusBlobDigest]
+		}
+		// The retention policy is evaluated without the removed snapshot once committed.
+		defer func() {
+			if err == nil {
+				go func() {
+					if err := o.fs.RemoveCache(blobDigest); err != nil {
+						log.L.WithError(err).Errorf("Failed to remove cache %s", blobDigest)
+					}
+				}()
 			}
 		}()
 	}
@@ -657,7 +996,8 @@ func (o *snapshotter) Remove(ctx context.Context, key string) error {
 		}()
 	}
 
-	return t.Commit()
+	err = t.Commit()
+	return err
 }
 
 func (o *snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...string) error {
@@ -677,6 +1017,10 @@ func (o *snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...str
 func (o *snapshotter) Close() error {
 	log.L.Info("[Close] shutdown snapshotter")
 
+	if o.stopCascade != nil {
+		o.stopCascade()
+	}
+
 	if o.cleanupOnClose {
 		err := o.fs.Teardown(context.Background())
 		if err != nil {
@@ -685,6 +1029,9 @@ func (o *snapshotter) Close() error {
 	}
 
 	o.fs.TryStopSharedDaemon()
+	o.fs.StopLocalConversion()
+	o.fs.StopCacheTiering()
+	o.fs.WaitMirroring()
 
 	if o.cgroupManager != nil {
 		if err := o.cgroupManager.Delete(); err != nil {
@@ -775,8 +1122,18 @@ func (o *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 		return nil, storage.Snapshot{}, errors.Wrap(err, "create snapshot")
 	}
 
-	// Try to keep the whole stack having the same UID and GID
-	if len(s.ParentIDs) > 0 {
+	// Let the container root in user namespace own its rootfs, which is bind mounted
+	// without parents, or the upperdir of overlay otherwise.
+	uid, gid, mapped, err := o.mappedHostIDs(base.Labels)
+	if err != nil {
+		return nil, storage.Snapshot{}, err
+	}
+	if mapped {
+		if err := os.Lchown(filepath.Join(td, "fs"), uid, gid); err != nil {
+			return nil, storage.Snapshot{}, errors.Wrap(err, "perform chown")
+		}
+	} else if len(s.ParentIDs) > 0 {
+		// Try to keep the whole stack having the same UID and GID
 		st, err := os.Stat(o.upperPath(s.ParentIDs[0]))
 		if err != nil {
 			return nil, storage.Snapshot{}, errors.Wrap(err, "stat parent")
@@ -793,6 +1150,10 @@ func (o *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 	}
 	td = ""
 
+	if err = o.applyWritableLayerQuota(kind, base.Labels, path); err != nil {
+		return nil, storage.Snapshot{}, err
+	}
+
 	rollback = false
 	if err = t.Commit(); err != nil {
 		return nil, storage.Snapshot{}, errors.Wrap(err, "perform commit")
@@ -802,6 +1163,90 @@ func (o *snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 	return &base, s, nil
 }
 
+// Pack the writable layer into a nydus layer appended to the image bootstrap if requested
+// by label `containerd.io/snapshot/nydus-commit`. The returned option labels the committed
+// snapshot with the digest of the nydus layer blob.
+func (o *snapshotter) commitNydusLayer(ctx context.Context, key string, opts []snapshots.Opt) (snapshots.Opt, error) {
+	var base snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&base); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := base.Labels[label.NydusCommit]; !ok || v != "true" {
+		return nil, nil
+	}
+
+	id, info, _, err := snapshot.GetSnapshotInfo(ctx, o.ms, key)
+	if err != nil {
+		return nil, err
+	}
+	if info.Kind != snapshots.KindActive {
+		return nil, errors.Errorf("snapshot %s is not active", key)
+	}
+
+	// The nearest nydus image bootstrap, which may be from a previous commit.
+	pID, pInfo, err := snapshot.IterateParentSnapshots(ctx, o.ms, info.Parent, func(_ string, i snapshots.Info) bool {
+		return label.IsNydusMetaLayer(i.Labels) || i.Labels[label.NydusCommitBlob] != ""
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find nydus image of the writable layer")
+	}
+	var parentBootstrap string
+	if pInfo.Labels[label.NydusCommitBlob] != "" {
+		parentBootstrap = filepath.Join(o.commitDir(pID), filesystem.CommitImageBootstrap)
+	} else if r := rafs.RafsGlobalCache.Get(pID); r != nil {
+		if parentBootstrap, err = r.BootstrapFile(); err != nil {
+			return nil, err
+		}
+	} else {
+		parentBootstrap = filepath.Join(o.snapshotDir(pID), "fs", "image", "image.boot")
+	}
+
+	blobDigest, err := o.fs.CommitNydusLayer(ctx, o.upperPath(id), parentBootstrap, o.commitDir(id))
+	if err != nil {
+		return nil, err
+	}
+	log.L.Infof("[Commit] packed snapshot %s into nydus layer %s", id, blobDigest)
+
+	return func(info *snapshots.Info) error {
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+		info.Labels[label.NydusCommitBlob] = blobDigest.String()
+		return nil
+	}, nil
+}
+
+// Directory holding nydus layer artifacts packed from a committed writable layer.
+func (o *snapshotter) commitDir(id string) string {
+	return filepath.Join(o.snapshotDir(id), "nydus")
+}
+
+// Limit size of container writable layers, while layers being unpacked are not limited.
+func (o *snapshotter) applyWritableLayerQuota(kind snapshots.Kind, labels map[string]string, dir string) error {
+	if o.quota == nil || kind != snapshots.KindActive {
+		return nil
+	}
+	if _, ok := labels[label.TargetSnapshotRef]; ok {
+		return nil
+	}
+
+	size := o.writableLayerQuota
+	if v, ok := labels[label.NydusWritableLayerQuota]; ok {
+		s, err := parser.MemoryConfigToBytes(v, 0)
+		if err != nil || s <= 0 {
+			return errors.Errorf("invalid writable layer quota %q", v)
+		}
+		size = uint64(s)
+	}
+
+	if err := o.quota.SetQuota(dir, size); err != nil {
+		return errors.Wrapf(err, "set writable layer quota of %s", dir)
+	}
+	return nil
+}
+
 func (o *snapshotter) mergeTarfs(ctx context.Context, s storage.Snapshot, pID string, pInfo snapshots.Info) error {
 	if err := o.fs.MergeTarfsLayers(s, func(id string) string { return o.upperPath(id) }); err != nil {
 		return errors.Wrapf(err, "tarfs merge fail %s", pID)
@@ -822,6 +1267,49 @@ func (o *snapshotter) mergeTarfs(ctx context.Context, s storage.Snapshot, pID st
 	return nil
 }
 
+// Serve the image from its locally converted copy if all of its layers are
+// converted. The merged bootstrap is recorded in the uppermost parent's labels.
+func (o *snapshotter) prepareLocalConversion(ctx context.Context, parent, pID string, pInfo *snapshots.Info) error {
+	if label.IsNydusLocalConversion(pInfo.Labels) {
+		return nil
+	}
+
+	var layers []digest.Digest
+	var layerErr error
+	_, _, err := snapshot.IterateParentSnapshots(ctx, o.ms, parent, func(_ string, info snapshots.Info) bool {
+		layerDigest := digest.Digest(info.Labels[snpkg.TargetLayerDigestLabel])
+		if layerErr = layerDigest.Validate(); layerErr != nil {
+			return true
+		}
+		// Iterated from the uppermost layer to the lowest one.
+		layers = append([]digest.Digest{layerDigest}, layers...)
+		return false
+	})
+	if layerErr != nil {
+		return errors.Wrapf(layerErr, "find layer digest")
+	}
+	if err != nil && !errors.Is(err, errdefs.ErrNotFound) {
+		return err
+	}
+
+	bootstrap := filepath.Join(o.snapshotDir(pID), "conversion", "image.boot")
+	if err := o.fs.PrepareLocalConversion(layers, bootstrap); err != nil {
+		return err
+	}
+
+	if pInfo.Labels == nil {
+		pInfo.Labels = map[string]string{}
+	}
+	pInfo.Labels[label.NydusLocalConversion] = bootstrap
+	updated, err := o.Update(ctx, *pInfo, "labels."+label.NydusLocalConversion)
+	if err != nil {
+		return errors.Wrapf(err, "update snapshot label information")
+	}
+	*pInfo = updated
+
+	return nil
+}
+
 func bindMount(source, roFlag string) []mount.Mount {
 	return []mount.Mount{
 		{
@@ -928,6 +1416,12 @@ func (o *snapshotter) mountRemote(ctx context.Context, labels map[string]string,
 	overlayOptions = append(overlayOptions, lowerDirOption)
 	log.G(ctx).Infof("remote mount options %v", overlayOptions)
 
+	if s.Kind == snapshots.KindActive {
+		if err := o.publishKataDirectVolume(ctx, labels, id, key); err != nil {
+			return nil, err
+		}
+	}
+
 	if o.enableKataVolume {
 		return o.mountWithKataVolume(ctx, id, overlayOptions, key)
 	}
@@ -935,7 +1429,7 @@ func (o *snapshotter) mountRemote(ctx context.Context, labels map[string]string,
 	if o.enableNydusOverlayFS || config.GetDaemonMode() == config.DaemonModeNone {
 		return o.remoteMountWithExtraOptions(ctx, s, id, overlayOptions)
 	}
-	return overlayMount(overlayOptions), nil
+	return o.withIDMapping(labels, s, overlayMount(overlayOptions)), nil
 }
 
 func (o *snapshotter) mountNative(ctx context.Context, labels map[string]string, s storage.Snapshot) ([]mount.Mount, error) {
@@ -945,7 +1439,7 @@ func (o *snapshotter) mountNative(ctx context.Context, labels map[string]string,
 		if s.Kind == snapshots.KindView {
 			roFlag = "ro"
 		}
-		return bindMount(o.upperPath(s.ID), roFlag), nil
+		return o.withIDMapping(labels, s, bindMount(o.upperPath(s.ID), roFlag)), nil
 	}
 
 	var options []string
@@ -968,7 +1462,7 @@ func (o *snapshotter) mountNative(ctx context.Context, labels map[string]string,
 	options = append(options, fmt.Sprintf("lowerdir=%s", strings.Join(parentPaths, ":")))
 
 	log.G(ctx).Debugf("overlayfs mount options %s", options)
-	return overlayMount(options), nil
+	return o.withIDMapping(labels, s, overlayMount(options)), nil
 }
 
 func (o *snapshotter) prepareDirectory(snapshotDir string, kind snapshots.Kind) (string, error) {
@@ -1017,6 +1511,11 @@ func (o *snapshotter) getCleanupDirectories(ctx context.Context) ([]string, erro
 		if _, ok := ids[d]; ok {
 			continue
 		}
+		// Snapshot directories are named by numeric IDs, leave anything else alone
+		// since it may belong to other programs sharing the directory.
+		if _, err := strconv.ParseUint(d, 10, 64); err != nil {
+			continue
+		}
 		// When it quits, there will be nothing inside
 		// TODO: try to clean up config/sockets/logs directories
 		cleanup = append(cleanup, o.snapshotDir(d))
@@ -1045,6 +1544,9 @@ func (o *snapshotter) cleanupSnapshotDirectory(ctx context.Context, dir string)
 	// For example: cleanupSnapshotDirectory /var/lib/containerd/io.containerd.snapshotter.v1.nydus/snapshots/34" dir=/var/lib/containerd/io.containerd.snapshotter.v1.nydus/snapshots/34
 
 	snapshotID := filepath.Base(dir)
+	if o.quota != nil {
+		o.quota.Forget(dir)
+	}
 	if err := o.fs.Umount(ctx, snapshotID); err != nil && !os.IsNotExist(err) {
 		log.G(ctx).WithError(err).WithField("dir", dir).Error("failed to unmount")
 	}
@@ -148,6 +148,16 @@ func writeFileToTar(t *testing.T, tw *tar.Writer, name string, data string) {
 	require.NoError(t, err)
 }
 
+func writeHardlinkToTar(t *testing.T, tw *tar.Writer, name, target string) {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Linkname: target,
+		Mode:     0444,
+		Typeflag: tar.TypeLink,
+	})
+	require.NoError(t, err)
+}
+
 func writeDirToTar(t *testing.T, tw *tar.Writer, name string) {
 	u, err := user.Current()
 	require.NoError(t, err)
@@ -355,7 +365,16 @@ func unpackLayer(t *testing.T, workDir string, ra content.ReaderAt, stream bool)
 	return tarPath, digest
 }
 
-func verify(t *testing.T, workDir string, expectedFileTree map[string]string) {
+// Verify the image through FUSE, and also through erofs over fscache if the kernel
+// supports it.
+func verify(t *testing.T, workDir string, expectedFileTree map[string]string, checks ...func(mountDir string)) {
+	verifyWith(t, workDir, FsDriverFusedev, expectedFileTree, checks...)
+	if FscacheSupported() {
+		verifyWith(t, workDir, FsDriverFscache, expectedFileTree, checks...)
+	}
+}
+
+func verifyWith(t *testing.T, workDir, fsDriver string, expectedFileTree map[string]string, checks ...func(mountDir string)) {
 	mountDir := filepath.Join(workDir, "mnt")
 	blobDir := filepath.Join(workDir, "blobs")
 	nydusdPath := os.Getenv(envNydusdPath)
@@ -363,10 +382,11 @@ func verify(t *testing.T, workDir string, expectedFileTree map[string]string) {
 		nydusdPath = "nydusd"
 	}
 	config := NydusdConfig{
+		FsDriver:       fsDriver,
 		EnablePrefetch: false,
 		NydusdPath:     nydusdPath,
 		BootstrapPath:  filepath.Join(workDir, "bootstrap"),
-		ConfigPath:     filepath.Join(workDir, "nydusd-config.fusedev.json"),
+		ConfigPath:     filepath.Join(workDir, fmt.Sprintf("nydusd-config.%s.json", fsDriver)),
 		BackendType:    "localfs",
 		BackendConfig:  fmt.Sprintf(`{"dir": "%s"}`, blobDir),
 		BlobCacheDir:   filepath.Join(workDir, "cache"),
@@ -376,6 +396,11 @@ func verify(t *testing.T, workDir string, expectedFileTree map[string]string) {
 		DigestValidate: false,
 	}
 
+	if fsDriver == FsDriverFscache {
+		// Cachefiles manages its own layout in the directory.
+		config.BlobCacheDir = filepath.Join(workDir, "fscache")
+	}
+
 	nydusd, err := NewNydusd(config)
 	require.NoError(t, err)
 	err = nydusd.Mount()
@@ -415,6 +440,9 @@ func verify(t *testing.T, workDir string, expectedFileTree map[string]string) {
 	require.NoError(t, err)
 
 	require.Equal(t, expectedFileTree, actualFileTree)
+	for _, check := range checks {
+		check(mountDir)
+	}
 }
 
 func buildChunkDict(t *testing.T, workDir, fsVersion string, n int) (string, string) {
@@ -525,6 +553,146 @@ func testPack(t *testing.T, fsVersion string) {
 	ensureFile(t, filepath.Join(cacheDir, chunkDictBlobHash)+".blob.data.chunk_map")
 	ensureNoFile(t, filepath.Join(cacheDir, lowerNydusBlobDigest.Hex())+".blob.data.chunk_map")
 	ensureFile(t, filepath.Join(cacheDir, upperNydusBlobDigest.Hex())+".blob.data.chunk_map")
+
+	// Files are backed by the blobs of the merged image only.
+	if fsVersion != "6" {
+		return
+	}
+	report, err := converter.Provenance(bootstrapPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Files)
+	for _, file := range report.Files {
+		for _, blob := range file.Blobs {
+			require.Contains(t, expectedBlobDigests, blob.Digest, file.Path)
+			require.NotEmpty(t, blob.Ranges)
+		}
+	}
+}
+
+// sudo go test -v -count=1 -run TestPackHardlinks ./tests
+func TestPackHardlinks(t *testing.T) {
+	testPackHardlinks(t, "5")
+	testPackHardlinks(t, "6")
+}
+
+// Layers of conda or nix trees link a few files from many places, the links must be
+// kept as they are instead of being copied.
+func testPackHardlinks(t *testing.T, fsVersion string) {
+	workDir, err := os.MkdirTemp("", "nydus-converter-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	const targets, links = 10, 1000
+	fileTree := map[string]string{"dir-1": "", "dir-2": ""}
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		tw := tar.NewWriter(pw)
+		writeDirToTar(t, tw, "dir-1")
+		writeDirToTar(t, tw, "dir-2")
+		for i := 0; i < targets; i++ {
+			name := fmt.Sprintf("dir-1/file-%d", i)
+			writeFileToTar(t, tw, name, name)
+			fileTree[name] = name
+		}
+		for i := 0; i < links; i++ {
+			name, target := fmt.Sprintf("dir-2/link-%d", i), fmt.Sprintf("dir-1/file-%d", i%targets)
+			writeHardlinkToTar(t, tw, name, target)
+			fileTree[name] = target
+		}
+		require.NoError(t, tw.Close())
+	}()
+
+	blobDir := filepath.Join(workDir, "blobs")
+	require.NoError(t, os.MkdirAll(blobDir, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "mnt"), 0755))
+
+	nydusTarPath, nydusBlobDigest := packLayer(t, pr, "", blobDir, fsVersion)
+	ra, err := local.OpenReader(nydusTarPath)
+	require.NoError(t, err)
+	defer ra.Close()
+
+	bootstrap, err := os.Create(filepath.Join(workDir, "bootstrap"))
+	require.NoError(t, err)
+	defer bootstrap.Close()
+	_, err = converter.Merge(context.TODO(), []converter.Layer{{Digest: nydusBlobDigest, ReaderAt: ra}}, bootstrap, converter.MergeOption{})
+	require.NoError(t, err)
+
+	verify(t, workDir, fileTree, func(mountDir string) {
+		for i := 0; i < links; i++ {
+			link, err := os.Stat(filepath.Join(mountDir, fmt.Sprintf("dir-2/link-%d", i)))
+			require.NoError(t, err)
+			target, err := os.Stat(filepath.Join(mountDir, fmt.Sprintf("dir-1/file-%d", i%targets)))
+			require.NoError(t, err)
+			require.True(t, os.SameFile(link, target), link.Name())
+		}
+	})
+}
+
+func TestPackReproducible(t *testing.T) {
+	testPackReproducible(t, "5")
+	testPackReproducible(t, "6")
+}
+
+// The same layer built at different times is converted to the same blob and bootstrap.
+func testPackReproducible(t *testing.T, fsVersion string) {
+	workDir, err := os.MkdirTemp("", "nydus-converter-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	buildLayer := func(mtime time.Time) io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range []string{"dir-1", "dir-1/file-1", "dir-1/file-2"} {
+			hdr := &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir, ModTime: mtime, AccessTime: mtime, ChangeTime: mtime, Format: tar.FormatPAX}
+			data := ""
+			if name != "dir-1" {
+				data = hugeString(1) + name
+				hdr.Mode, hdr.Typeflag, hdr.Size = 0444, tar.TypeReg, int64(len(data))
+			}
+			require.NoError(t, tw.WriteHeader(hdr))
+			_, err := io.WriteString(tw, data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	convert := func(name string, mtime time.Time) (digest.Digest, digest.Digest) {
+		var blob bytes.Buffer
+		twc, err := converter.Pack(context.TODO(), &blob, converter.PackOption{
+			FsVersion:       fsVersion,
+			Reproducible:    true,
+			SourceDateEpoch: time.Unix(1700000000, 0),
+		})
+		require.NoError(t, err)
+		_, err = io.Copy(twc, buildLayer(mtime))
+		require.NoError(t, err)
+		require.NoError(t, twc.Close())
+		blobDigest := digest.FromBytes(blob.Bytes())
+
+		var bootstrap bytes.Buffer
+		_, err = converter.Merge(context.TODO(), []converter.Layer{{
+			Digest:   blobDigest,
+			ReaderAt: bytesReaderAt{bytes.NewReader(blob.Bytes())},
+		}}, &bootstrap, converter.MergeOption{WorkDir: filepath.Join(workDir, name)})
+		require.NoError(t, err)
+		return blobDigest, digest.FromBytes(bootstrap.Bytes())
+	}
+
+	blob1, bootstrap1 := convert("first", time.Unix(1600000000, 0))
+	blob2, bootstrap2 := convert("second", time.Now())
+	require.Equal(t, blob1, blob2)
+	require.Equal(t, bootstrap1, bootstrap2)
+}
+
+// content.ReaderAt of converted blobs held in memory.
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (r bytesReaderAt) Close() error {
+	return nil
 }
 
 // sudo go test -v -count=1 -run TestPackRef ./tests
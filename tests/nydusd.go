@@ -20,9 +20,19 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/utils/erofs"
+)
+
+const (
+	FsDriverFusedev = "fusedev"
+	// Nydusd serves erofs in kernel through fscache, which requires /dev/cachefiles.
+	FsDriverFscache = "fscache"
 )
 
 type NydusdConfig struct {
+	// FsDriverFusedev by default.
+	FsDriver       string
 	EnablePrefetch bool
 	NydusdPath     string
 	BootstrapPath  string
@@ -39,6 +49,8 @@ type NydusdConfig struct {
 // Nydusd runs nydusd binary.
 type Nydusd struct {
 	NydusdConfig
+	// Nydusd serving fscache keeps running after erofs is unmounted.
+	cmd *exec.Cmd
 }
 
 type daemonInfo struct {
@@ -72,8 +84,47 @@ var configTpl = `
 }
 `
 
+// Blob entry bound to nydusd in fscache mode, like misc/snapshotter/nydusd-config.fscache.json.
+var fscacheConfigTpl = `
+{
+	"type": "bootstrap",
+	"id": "{{.FscacheID}}",
+	"domain_id": "{{.FscacheID}}",
+	"config": {
+		"id": "{{.FscacheID}}",
+		"backend_type": "{{.BackendType}}",
+		"backend_config": {{.BackendConfig}},
+		"cache_type": "fscache",
+		"cache_config": {
+			"work_dir": "{{.BlobCacheDir}}"
+		},
+		"prefetch_config": {
+			"enable": {{.EnablePrefetch}},
+			"threads_count": 10,
+			"merging_size": 131072
+		},
+		"metadata_path": "{{.BootstrapPath}}"
+	}
+}
+`
+
+// FscacheSupported tells whether the kernel is able to serve erofs through fscache.
+func FscacheSupported() bool {
+	_, err := os.Stat("/dev/cachefiles")
+	return err == nil
+}
+
+// The fscache ID is unique per mountpoint, so that images verified in turn don't
+// collide in the cache.
+func (conf NydusdConfig) FscacheID() string {
+	return erofs.FscacheID(conf.MountPath)
+}
+
 func makeConfig(conf NydusdConfig) error {
 	tpl := template.Must(template.New("").Parse(configTpl))
+	if conf.FsDriver == FsDriverFscache {
+		tpl = template.Must(template.New("").Parse(fscacheConfigTpl))
+	}
 
 	var ret bytes.Buffer
 	if err := tpl.Execute(&ret, conf); err != nil {
@@ -87,10 +138,7 @@ func makeConfig(conf NydusdConfig) error {
 	return nil
 }
 
-// Wait until Nydusd ready by checking daemon state RUNNING
-func checkReady(ctx context.Context, sock string) <-chan bool {
-	ready := make(chan bool)
-
+func newAPIClient(sock string) *http.Client {
 	transport := &http.Transport{
 		MaxIdleConns:          10,
 		IdleConnTimeout:       10 * time.Second,
@@ -104,10 +152,16 @@ func checkReady(ctx context.Context, sock string) <-chan bool {
 		},
 	}
 
-	client := &http.Client{
+	return &http.Client{
 		Timeout:   30 * time.Second,
 		Transport: transport,
 	}
+}
+
+// Wait until Nydusd ready by checking daemon state RUNNING
+func checkReady(ctx context.Context, sock string) <-chan bool {
+	ready := make(chan bool)
+	client := newAPIClient(sock)
 
 	go func() {
 		for {
@@ -144,6 +198,12 @@ func checkReady(ctx context.Context, sock string) <-chan bool {
 }
 
 func NewNydusd(conf NydusdConfig) (*Nydusd, error) {
+	if conf.FsDriver == "" {
+		conf.FsDriver = FsDriverFusedev
+	}
+	if conf.FsDriver != FsDriverFusedev && conf.FsDriver != FsDriverFscache {
+		return nil, errors.Errorf("unsupported fs driver %s", conf.FsDriver)
+	}
 	if err := makeConfig(conf); err != nil {
 		return nil, errors.New("create config file for Nydusd")
 	}
@@ -156,6 +216,10 @@ func (nydusd *Nydusd) Mount() error {
 	// Ignore the error since the nydusd may not ever start
 	_ = nydusd.Umount()
 
+	if nydusd.FsDriver == FsDriverFscache {
+		return nydusd.mountErofs()
+	}
+
 	args := []string{
 		"--config",
 		nydusd.ConfigPath,
@@ -170,6 +234,56 @@ func (nydusd *Nydusd) Mount() error {
 	}
 
 	cmd := exec.Command(nydusd.NydusdPath, args...)
+	return nydusd.run(cmd)
+}
+
+// Start nydusd in fscache mode, bind the bootstrap to it and mount erofs on top.
+func (nydusd *Nydusd) mountErofs() error {
+	if err := os.MkdirAll(nydusd.BlobCacheDir, 0755); err != nil {
+		return errors.Wrapf(err, "create fscache work dir %s", nydusd.BlobCacheDir)
+	}
+	if err := os.MkdirAll(nydusd.MountPath, 0755); err != nil {
+		return errors.Wrapf(err, "create mountpoint %s", nydusd.MountPath)
+	}
+
+	args := []string{
+		"singleton",
+		"--fscache",
+		nydusd.BlobCacheDir,
+		"--apisock",
+		nydusd.APISockPath,
+		"--log-level",
+		"error",
+	}
+	nydusd.cmd = exec.Command(nydusd.NydusdPath, args...)
+	if err := nydusd.run(nydusd.cmd); err != nil {
+		return err
+	}
+
+	config, err := os.ReadFile(nydusd.ConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "read fscache blob config")
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://unix/api/v2/blobs", bytes.NewReader(config))
+	if err != nil {
+		return err
+	}
+	resp, err := newAPIClient(nydusd.APISockPath).Do(req)
+	if err != nil {
+		return errors.Wrap(err, "bind fscache blob")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("bind fscache blob: %d, %s", resp.StatusCode, body)
+	}
+
+	id := nydusd.FscacheID()
+	return erofs.Mount(id, id, nydusd.MountPath)
+}
+
+// Run nydusd until it's ready to serve.
+func (nydusd *Nydusd) run(cmd *exec.Cmd) error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -198,6 +312,17 @@ func (nydusd *Nydusd) Mount() error {
 }
 
 func (nydusd *Nydusd) Umount() error {
+	if nydusd.FsDriver == FsDriverFscache {
+		if nydusd.cmd == nil {
+			return nil
+		}
+		// Nothing may be mounted if binding the blob failed.
+		_ = erofs.Umount(nydusd.MountPath)
+		err := nydusd.cmd.Process.Kill()
+		nydusd.cmd = nil
+		return err
+	}
+
 	if _, err := os.Stat(nydusd.MountPath); err == nil {
 		cmd := exec.Command("umount", nydusd.MountPath)
 		cmd.Stdout = os.Stdout